@@ -3,6 +3,7 @@ module github.com/kodega2016/femapi
 go 1.24.4
 
 require (
+	github.com/coder/websocket v1.8.15
 	github.com/go-chi/chi/v5 v5.2.2
 	github.com/jackc/pgx/v4 v4.18.3
 	github.com/joho/godotenv v1.5.1
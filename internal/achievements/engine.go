@@ -0,0 +1,163 @@
+package achievements
+
+import (
+	"context"
+	"log"
+	"math"
+	"time"
+
+	"github.com/kodega2016/femapi/internal/store"
+)
+
+// Engine evaluates the rules for a user and grants the achievements they reached. Granting is
+// idempotent, so evaluating again after every workout change or in a backfill is safe.
+type Engine struct {
+	achievementStore store.AchievementStore
+	settingsStore    store.SettingsStore
+	rules            []Rule
+	logger           *log.Logger
+	now              func() time.Time
+}
+
+func NewEngine(achievementStore store.AchievementStore, settingsStore store.SettingsStore, rules []Rule, logger *log.Logger) *Engine {
+	return &Engine{
+		achievementStore: achievementStore,
+		settingsStore:    settingsStore,
+		rules:            rules,
+		logger:           logger,
+		now:              time.Now,
+	}
+}
+
+// Achievement is a rule as shown to the user, with the award time once it is earned
+type Achievement struct {
+	Rule
+	Value     float64    `json:"value"`
+	Progress  float64    `json:"progress"`
+	AwardedAt *time.Time `json:"awarded_at"`
+}
+
+// Summary is everything GET /users/me/achievements returns
+type Summary struct {
+	Achievements []Achievement `json:"achievements"`
+	Streaks      Streaks       `json:"streaks"`
+}
+
+// WorkoutChanged implements store.WorkoutListener
+func (e *Engine) WorkoutChanged(event store.WorkoutEvent) {
+	_, err := e.Evaluate(event.UserID)
+	if err != nil {
+		e.logger.Printf("ERROR: evaluating achievements of user %d: %v", event.UserID, err)
+	}
+}
+
+// results evaluates the rules on the calendar of the user, their timezone and first weekday
+func (e *Engine) results(userID int) ([]Result, []store.WorkoutFact, *store.Settings, error) {
+	settings, err := e.settingsStore.GetSettings(userID)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	facts, err := e.achievementStore.ListWorkoutFacts(userID)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return Evaluate(e.rules, facts, settings.Location(), settings.FirstWeekday()), facts, settings, nil
+}
+
+// Evaluate grants every achievement the user reached and returns the ids of the new ones.
+// The award time is when the threshold was crossed, not when it was evaluated.
+func (e *Engine) Evaluate(userID int) ([]string, error) {
+	results, _, _, err := e.results(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	granted := []string{}
+	for _, result := range results {
+		if result.AchievedAt == nil {
+			continue
+		}
+		isNew, err := e.achievementStore.GrantAward(userID, result.ID, *result.AchievedAt)
+		if err != nil {
+			return nil, err
+		}
+		if isNew {
+			granted = append(granted, result.ID)
+		}
+	}
+	return granted, nil
+}
+
+// Summary lists every achievement with the progress towards it and the current streaks.
+// Earned achievements stay earned even when the workouts behind them are deleted.
+func (e *Engine) Summary(userID int) (*Summary, error) {
+	results, facts, settings, err := e.results(userID)
+	if err != nil {
+		return nil, err
+	}
+	awards, err := e.achievementStore.ListAwards(userID)
+	if err != nil {
+		return nil, err
+	}
+	awardedAt := make(map[string]time.Time, len(awards))
+	for _, award := range awards {
+		awardedAt[award.AchievementID] = award.AwardedAt
+	}
+
+	summary := &Summary{
+		Achievements: make([]Achievement, 0, len(results)),
+		Streaks:      CurrentStreaks(facts, settings.Location(), settings.FirstWeekday(), e.now()),
+	}
+	for _, result := range results {
+		achievement := Achievement{
+			Rule:     result.Rule,
+			Value:    result.Value,
+			Progress: math.Round(min(result.Value/result.Threshold, 1)*10000) / 100,
+		}
+		if at, ok := awardedAt[result.ID]; ok {
+			achievement.AwardedAt = &at
+			achievement.Progress = 100
+		}
+		summary.Achievements = append(summary.Achievements, achievement)
+	}
+	return summary, nil
+}
+
+// Backfill evaluates every user with workouts, for data logged before achievements existed
+// or after rules were added. It returns how many achievements were granted.
+func (e *Engine) Backfill(ctx context.Context) (int, error) {
+	userIDs, err := e.achievementStore.ListUserIDsWithWorkouts()
+	if err != nil {
+		return 0, err
+	}
+
+	granted := 0
+	for _, userID := range userIDs {
+		if ctx.Err() != nil {
+			return granted, ctx.Err()
+		}
+		awards, err := e.Evaluate(userID)
+		if err != nil {
+			return granted, err
+		}
+		granted += len(awards)
+	}
+	return granted, nil
+}
+
+// BackfillArgs are the arguments of the background job that runs Backfill
+type BackfillArgs struct{}
+
+func (BackfillArgs) Kind() string {
+	return "achievements.backfill"
+}
+
+// RunBackfillJob is the job handler for BackfillArgs
+func (e *Engine) RunBackfillJob(ctx context.Context, args BackfillArgs) error {
+	granted, err := e.Backfill(ctx)
+	if err != nil {
+		return err
+	}
+	e.logger.Printf("backfill granted %d achievements", granted)
+	return nil
+}
@@ -0,0 +1,182 @@
+// Package achievements awards badges for streaks and milestones based on rules declared in an
+// embedded config
+package achievements
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/kodega2016/femapi/internal/store"
+)
+
+const (
+	RuleWorkoutCount = "workout_count"
+	RuleDailyStreak  = "daily_streak"
+	RuleWeeklyStreak = "weekly_streak"
+	RuleVolume       = "volume"
+	RuleDistance     = "distance"
+	RulePRCount      = "pr_count"
+)
+
+//go:embed rules.json
+var defaultRules []byte
+
+// Rule declares an achievement: it is awarded once the metric of its type reaches the
+// threshold. Volume is in kg and distance in meters. A workout type limits the rule to
+// workouts of that type.
+type Rule struct {
+	ID          string  `json:"id"`
+	Name        string  `json:"name"`
+	Description string  `json:"description"`
+	Type        string  `json:"type"`
+	WorkoutType string  `json:"workout_type,omitempty"`
+	Threshold   float64 `json:"threshold"`
+}
+
+// DefaultRules returns the rules of the embedded config
+func DefaultRules() ([]Rule, error) {
+	return ParseRules(defaultRules)
+}
+
+// ParseRules decodes and validates a rules config
+func ParseRules(data []byte) ([]Rule, error) {
+	var rules []Rule
+	err := json.Unmarshal(data, &rules)
+	if err != nil {
+		return nil, fmt.Errorf("invalid achievement rules: %w", err)
+	}
+
+	seen := make(map[string]bool, len(rules))
+	for _, rule := range rules {
+		if rule.ID == "" || len(rule.ID) > 64 || seen[rule.ID] {
+			return nil, fmt.Errorf("achievement rule id %q is empty, too long or duplicated", rule.ID)
+		}
+		seen[rule.ID] = true
+
+		switch rule.Type {
+		case RuleWorkoutCount, RuleDailyStreak, RuleWeeklyStreak, RuleVolume, RuleDistance, RulePRCount:
+		default:
+			return nil, fmt.Errorf("achievement rule %s has unknown type %q", rule.ID, rule.Type)
+		}
+		if rule.WorkoutType != "" && !store.IsValidWorkoutType(rule.WorkoutType) {
+			return nil, fmt.Errorf("achievement rule %s has unknown workout type %q", rule.ID, rule.WorkoutType)
+		}
+		if rule.Threshold <= 0 {
+			return nil, fmt.Errorf("achievement rule %s needs a positive threshold", rule.ID)
+		}
+	}
+	return rules, nil
+}
+
+// Result is the state of a rule for one user
+type Result struct {
+	Rule
+	Value      float64
+	AchievedAt *time.Time
+}
+
+// Evaluate replays the workouts oldest first and returns for every rule the value reached and
+// when the threshold was first crossed. Streaks count calendar days and weeks (starting on
+// firstDay) in loc, their value is the longest streak.
+func Evaluate(rules []Rule, facts []store.WorkoutFact, loc *time.Location, firstDay time.Weekday) []Result {
+	results := make([]Result, 0, len(rules))
+	for _, rule := range rules {
+		result := Result{Rule: rule}
+		var streak streakCounter
+		for _, fact := range facts {
+			if rule.WorkoutType != "" && fact.Type != rule.WorkoutType {
+				continue
+			}
+
+			switch rule.Type {
+			case RuleWorkoutCount:
+				result.Value++
+			case RuleVolume:
+				result.Value += fact.Volume
+			case RuleDistance:
+				result.Value += fact.DistanceMeters
+			case RulePRCount:
+				result.Value += float64(fact.PersonalRecords)
+			case RuleDailyStreak:
+				streak.add(localDay(fact.PerformedAt, loc), 1)
+				result.Value = float64(streak.longest)
+			case RuleWeeklyStreak:
+				streak.add(weekStart(localDay(fact.PerformedAt, loc), firstDay), 7)
+				result.Value = float64(streak.longest)
+			}
+
+			if result.AchievedAt == nil && result.Value >= rule.Threshold {
+				achievedAt := fact.PerformedAt
+				result.AchievedAt = &achievedAt
+			}
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+// Streaks are the current and longest runs of consecutive active days and weeks
+type Streaks struct {
+	CurrentDaily  int `json:"current_daily"`
+	LongestDaily  int `json:"longest_daily"`
+	CurrentWeekly int `json:"current_weekly"`
+	LongestWeekly int `json:"longest_weekly"`
+}
+
+// CurrentStreaks computes the streaks as of now. A daily streak is still alive when the last
+// workout was yesterday, a weekly one when it was last week.
+func CurrentStreaks(facts []store.WorkoutFact, loc *time.Location, firstDay time.Weekday, now time.Time) Streaks {
+	var daily, weekly streakCounter
+	for _, fact := range facts {
+		day := localDay(fact.PerformedAt, loc)
+		daily.add(day, 1)
+		weekly.add(weekStart(day, firstDay), 7)
+	}
+
+	today := localDay(now, loc)
+	streaks := Streaks{LongestDaily: daily.longest, LongestWeekly: weekly.longest}
+	if daily.current > 0 && !daily.last.Before(today.AddDate(0, 0, -1)) {
+		streaks.CurrentDaily = daily.current
+	}
+	if weekly.current > 0 && !weekly.last.Before(weekStart(today, firstDay).AddDate(0, 0, -7)) {
+		streaks.CurrentWeekly = weekly.current
+	}
+	return streaks
+}
+
+// streakCounter counts consecutive periods, periods are given as midnight UTC of their first day
+type streakCounter struct {
+	last    time.Time
+	current int
+	longest int
+}
+
+func (s *streakCounter) add(period time.Time, days int) {
+	switch {
+	case s.current > 0 && period.Equal(s.last):
+		return
+	case s.current > 0 && period.Equal(s.last.AddDate(0, 0, days)):
+		s.current++
+	case s.current > 0 && period.Before(s.last):
+		// workouts arrive oldest first, anything older already counted
+		return
+	default:
+		s.current = 1
+	}
+	s.last = period
+	s.longest = max(s.longest, s.current)
+}
+
+// localDay returns the calendar day of t in loc, as midnight UTC so days can be compared
+// and added without daylight saving shifts
+func localDay(t time.Time, loc *time.Location) time.Time {
+	local := t.In(loc)
+	return time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// weekStart returns the first day of the week containing day
+func weekStart(day time.Time, firstDay time.Weekday) time.Time {
+	return day.AddDate(0, 0, -((int(day.Weekday()) - int(firstDay) + 7) % 7))
+}
@@ -0,0 +1,120 @@
+package achievements
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kodega2016/femapi/internal/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDefaultRulesAreValid(t *testing.T) {
+	rules, err := DefaultRules()
+	require.NoError(t, err)
+	assert.NotEmpty(t, rules)
+}
+
+func TestParseRulesRejectsInvalidRules(t *testing.T) {
+	tests := map[string]string{
+		"duplicate id":   `[{"id":"a","type":"volume","threshold":1},{"id":"a","type":"volume","threshold":2}]`,
+		"unknown type":   `[{"id":"a","type":"laps","threshold":1}]`,
+		"no threshold":   `[{"id":"a","type":"volume"}]`,
+		"bad workout":    `[{"id":"a","type":"distance","workout_type":"ski","threshold":1}]`,
+		"malformed json": `[{"id":`,
+	}
+	for name, config := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := ParseRules([]byte(config))
+			assert.Error(t, err)
+		})
+	}
+}
+
+func result(results []Result, id string) Result {
+	for _, r := range results {
+		if r.ID == id {
+			return r
+		}
+	}
+	return Result{}
+}
+
+func TestEvaluateMilestones(t *testing.T) {
+	rules := []Rule{
+		{ID: "two_runs", Type: RuleWorkoutCount, WorkoutType: store.WorkoutTypeRun, Threshold: 2},
+		{ID: "volume", Type: RuleVolume, Threshold: 1000},
+		{ID: "prs", Type: RulePRCount, Threshold: 3},
+	}
+	at := func(day int) time.Time { return time.Date(2024, 5, day, 12, 0, 0, 0, time.UTC) }
+	facts := []store.WorkoutFact{
+		{PerformedAt: at(1), Type: store.WorkoutTypeStrength, Volume: 600, PersonalRecords: 1},
+		{PerformedAt: at(2), Type: store.WorkoutTypeRun, DistanceMeters: 5000},
+		{PerformedAt: at(3), Type: store.WorkoutTypeStrength, Volume: 600, PersonalRecords: 1},
+		{PerformedAt: at(5), Type: store.WorkoutTypeRun, DistanceMeters: 8000},
+	}
+
+	results := Evaluate(rules, facts, time.UTC, time.Monday)
+
+	runs := result(results, "two_runs")
+	require.NotNil(t, runs.AchievedAt)
+	assert.Equal(t, at(5), *runs.AchievedAt)
+
+	volume := result(results, "volume")
+	assert.Equal(t, 1200.0, volume.Value)
+	require.NotNil(t, volume.AchievedAt)
+	assert.Equal(t, at(3), *volume.AchievedAt)
+
+	prs := result(results, "prs")
+	assert.Equal(t, 2.0, prs.Value)
+	assert.Nil(t, prs.AchievedAt)
+}
+
+func TestStreaksUseTheUserTimezone(t *testing.T) {
+	rules := []Rule{{ID: "streak", Type: RuleDailyStreak, Threshold: 3}}
+	// evening workouts in New York fall on the next day in UTC
+	facts := []store.WorkoutFact{
+		{PerformedAt: time.Date(2024, 5, 1, 23, 30, 0, 0, time.UTC)},
+		{PerformedAt: time.Date(2024, 5, 3, 1, 0, 0, 0, time.UTC)},
+		{PerformedAt: time.Date(2024, 5, 4, 0, 30, 0, 0, time.UTC)},
+	}
+
+	utc := Evaluate(rules, facts, time.UTC, time.Monday)[0]
+	assert.Equal(t, 2.0, utc.Value)
+	assert.Nil(t, utc.AchievedAt)
+
+	newYork, err := time.LoadLocation("America/New_York")
+	require.NoError(t, err)
+	local := Evaluate(rules, facts, newYork, time.Monday)[0]
+	assert.Equal(t, 3.0, local.Value)
+	require.NotNil(t, local.AchievedAt)
+	assert.Equal(t, facts[2].PerformedAt, *local.AchievedAt)
+}
+
+func TestCurrentStreaks(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2024, 5, d, 9, 0, 0, 0, time.UTC) }
+	facts := []store.WorkoutFact{
+		{PerformedAt: day(1)}, {PerformedAt: day(2)}, {PerformedAt: day(3)}, {PerformedAt: day(3)},
+		{PerformedAt: day(13)}, {PerformedAt: day(14)},
+	}
+
+	streaks := CurrentStreaks(facts, time.UTC, time.Monday, day(15))
+	assert.Equal(t, Streaks{CurrentDaily: 2, LongestDaily: 3, CurrentWeekly: 1, LongestWeekly: 1}, streaks)
+
+	// a day without a workout breaks the daily streak, the week is still alive
+	streaks = CurrentStreaks(facts, time.UTC, time.Monday, day(16))
+	assert.Equal(t, 0, streaks.CurrentDaily)
+	assert.Equal(t, 1, streaks.CurrentWeekly)
+}
+
+func TestWeeklyStreaksFollowTheFirstWeekday(t *testing.T) {
+	// 2024-05-04 is a saturday, the next day starts a new week only when weeks start on sunday
+	facts := []store.WorkoutFact{
+		{PerformedAt: time.Date(2024, 5, 4, 9, 0, 0, 0, time.UTC)},
+		{PerformedAt: time.Date(2024, 5, 5, 9, 0, 0, 0, time.UTC)},
+	}
+	now := time.Date(2024, 5, 6, 9, 0, 0, 0, time.UTC)
+
+	assert.Equal(t, 1, CurrentStreaks(facts, time.UTC, time.Monday, now).LongestWeekly)
+	assert.Equal(t, 2, CurrentStreaks(facts, time.UTC, time.Sunday, now).LongestWeekly)
+}
@@ -0,0 +1,293 @@
+// Package activity parses device recordings (FIT, TCX and GPX) into cardio workouts
+package activity
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/kodega2016/femapi/internal/store"
+)
+
+const (
+	FormatFIT = "fit"
+	FormatTCX = "tcx"
+	FormatGPX = "gpx"
+
+	earthRadiusMeters = 6371000
+	// elevationThreshold filters gps altitude noise out of the elevation gain
+	elevationThreshold = 1.0
+	// stoppedSpeed is the speed in m/s below which the athlete is considered stopped
+	stoppedSpeed = 0.5
+)
+
+var (
+	ErrUnknownFormat = errors.New("unknown activity format, expected fit, tcx or gpx")
+	ErrNoSamples     = errors.New("activity file has no recorded samples")
+)
+
+// File is a parsed recording. MovingTime and Calories are only set when the device recorded them.
+type File struct {
+	Sport      string
+	Name       string
+	MovingTime time.Duration
+	Calories   int
+	Points     []store.TrackPoint
+}
+
+// DetectFormat sniffs the format from the file content
+func DetectFormat(data []byte) string {
+	if len(data) >= 12 && string(data[8:12]) == ".FIT" {
+		return FormatFIT
+	}
+	head := data[:min(len(data), 1024)]
+	if bytes.Contains(head, []byte("<TrainingCenterDatabase")) {
+		return FormatTCX
+	}
+	if bytes.Contains(head, []byte("<gpx")) {
+		return FormatGPX
+	}
+	return ""
+}
+
+// Parse decodes data in the given format, an empty format is detected from the content
+func Parse(data []byte, format string) (*File, error) {
+	if format == "" {
+		format = DetectFormat(data)
+	}
+
+	var file *File
+	var err error
+	switch strings.ToLower(format) {
+	case FormatFIT:
+		file, err = parseFIT(data)
+	case FormatTCX:
+		file, err = parseTCX(data)
+	case FormatGPX:
+		file, err = parseGPX(data)
+	default:
+		return nil, ErrUnknownFormat
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s file: %w", strings.ToLower(format), err)
+	}
+	if len(file.Points) == 0 {
+		return nil, ErrNoSamples
+	}
+	return file, nil
+}
+
+// normalizeSport maps the sport names used by the different formats onto ours
+func normalizeSport(sport string) string {
+	switch strings.ToLower(strings.TrimSpace(sport)) {
+	case "running", "run", "trail_running", "treadmill_running":
+		return store.SportRunning
+	case "biking", "cycling", "bike", "ride", "road_biking", "mountain_biking":
+		return store.SportCycling
+	case "walking", "walk":
+		return store.SportWalking
+	case "hiking", "hike":
+		return store.SportHiking
+	case "swimming", "swim", "open_water_swimming", "lap_swimming":
+		return store.SportSwimming
+	case "rowing", "row", "indoor_rowing":
+		return store.SportRowing
+	}
+	return store.SportOther
+}
+
+// Summary computes the metrics stored with the workout. The device timer wins over the moving
+// time derived from the samples, since devices know when they were paused.
+func (f *File) Summary() *store.Activity {
+	points := f.Points
+	startedAt := points[0].Time
+	activity := &store.Activity{
+		Sport:     f.Sport,
+		StartedAt: &startedAt,
+		Track:     points,
+	}
+	if activity.Sport == "" {
+		activity.Sport = store.SportOther
+	}
+
+	distances := cumulativeDistances(points)
+	activity.DurationSeconds = seconds(points[len(points)-1].Time.Sub(startedAt))
+	activity.MovingTimeSeconds = seconds(f.MovingTime)
+	if activity.MovingTimeSeconds <= 0 {
+		activity.MovingTimeSeconds = seconds(movingTime(points, distances))
+	}
+
+	activity.DistanceMeters = math.Round(distances[len(distances)-1]*10) / 10
+	activity.ElevationGainMeters = math.Round(elevationGain(points)*10) / 10
+	activity.AvgHeartRate, activity.MaxHeartRate = heartRateStats(points)
+
+	splitLength := 1000.0
+	if activity.Sport == store.SportSwimming {
+		splitLength = 100
+	}
+	activity.Splits = splits(points, distances, splitLength)
+	activity.ComputeDerived()
+	return activity
+}
+
+// Workout builds the workout to create for the recording. Calories are flagged for estimation
+// when the device did not record them.
+func (f *File) Workout() *store.Workout {
+	summary := f.Summary()
+	title := f.Name
+	if title == "" {
+		title = strings.ToUpper(summary.Sport[:1]) + summary.Sport[1:] + " on " + summary.StartedAt.Format("2 Jan 2006")
+	}
+
+	return &store.Workout{
+		Title:             title,
+		Type:              store.WorkoutTypeForSport(summary.Sport),
+		DurationInMinutes: int(math.Round(float64(summary.DurationSeconds) / 60)),
+		CaloriesBurned:    f.Calories,
+		CaloriesEstimated: f.Calories == 0,
+		Visibility:        store.VisibilityPrivate,
+		Activity:          summary,
+		Entries:           []store.WorkoutEntry{},
+	}
+}
+
+func seconds(d time.Duration) int {
+	return int(d.Round(time.Second).Seconds())
+}
+
+func heartRateStats(points []store.TrackPoint) (*int, *int) {
+	sum, count, maxRate := 0, 0, 0
+	for _, point := range points {
+		if point.HeartRate == nil {
+			continue
+		}
+		sum += *point.HeartRate
+		count++
+		maxRate = max(maxRate, *point.HeartRate)
+	}
+	if count == 0 {
+		return nil, nil
+	}
+	avg := int(math.Round(float64(sum) / float64(count)))
+	return &avg, &maxRate
+}
+
+// cumulativeDistances returns the distance covered at every sample. The distance recorded by
+// the device is preferred, otherwise the length of the gps track is used.
+func cumulativeDistances(points []store.TrackPoint) []float64 {
+	recorded := false
+	for _, point := range points {
+		if point.Distance != nil {
+			recorded = true
+			break
+		}
+	}
+
+	distances := make([]float64, len(points))
+	var previous *store.TrackPoint
+	for i := range points {
+		if i > 0 {
+			distances[i] = distances[i-1]
+		}
+		switch {
+		case recorded:
+			if points[i].Distance != nil && *points[i].Distance > distances[i] {
+				distances[i] = *points[i].Distance
+			}
+		case points[i].HasPosition():
+			if previous != nil {
+				distances[i] += haversine(*previous.Latitude, *previous.Longitude, *points[i].Latitude, *points[i].Longitude)
+			}
+			previous = &points[i]
+		}
+	}
+	return distances
+}
+
+// movingTime adds up the intervals between samples where the athlete was not stopped
+func movingTime(points []store.TrackPoint, distances []float64) time.Duration {
+	var moving time.Duration
+	for i := 1; i < len(points); i++ {
+		interval := points[i].Time.Sub(points[i-1].Time)
+		if interval > 0 && (distances[i]-distances[i-1])/interval.Seconds() >= stoppedSpeed {
+			moving += interval
+		}
+	}
+	return moving
+}
+
+// splits cuts the recording every length meters, interpolating the time the boundary was crossed.
+// A shorter final split holds the remaining distance.
+func splits(points []store.TrackPoint, distances []float64, length float64) []store.Split {
+	result := []store.Split{}
+	splitStart := points[0].Time
+	boundary := length
+	heartRateSum, heartRateCount := 0, 0
+
+	closeSplit := func(distance float64, end time.Time) {
+		split := store.Split{DistanceMeters: math.Round(distance*10) / 10, DurationSeconds: seconds(end.Sub(splitStart))}
+		if heartRateCount > 0 {
+			avg := int(math.Round(float64(heartRateSum) / float64(heartRateCount)))
+			split.AvgHeartRate = &avg
+		}
+		result = append(result, split)
+		splitStart = end
+		heartRateSum, heartRateCount = 0, 0
+	}
+
+	for i, point := range points {
+		if point.HeartRate != nil {
+			heartRateSum += *point.HeartRate
+			heartRateCount++
+		}
+		if i == 0 {
+			continue
+		}
+		for distances[i] >= boundary {
+			covered := distances[i] - distances[i-1]
+			fraction := (boundary - distances[i-1]) / covered
+			interval := point.Time.Sub(points[i-1].Time)
+			crossedAt := points[i-1].Time.Add(time.Duration(fraction * float64(interval)))
+			closeSplit(length, crossedAt)
+			boundary += length
+		}
+	}
+
+	remaining := distances[len(distances)-1] - (boundary - length)
+	if remaining >= 1 {
+		closeSplit(remaining, points[len(points)-1].Time)
+	}
+	return result
+}
+
+func haversine(lat1, lon1, lat2, lon2 float64) float64 {
+	toRadians := func(deg float64) float64 { return deg * math.Pi / 180 }
+	dLat := toRadians(lat2 - lat1)
+	dLon := toRadians(lon2 - lon1)
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(toRadians(lat1))*math.Cos(toRadians(lat2))*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadiusMeters * math.Asin(math.Sqrt(a))
+}
+
+// elevationGain only counts a climb once it exceeds elevationThreshold above the last low point
+func elevationGain(points []store.TrackPoint) float64 {
+	gain := 0.0
+	var reference *float64
+	for _, point := range points {
+		if point.Elevation == nil {
+			continue
+		}
+		elevation := *point.Elevation
+		switch {
+		case reference == nil || elevation < *reference:
+			reference = &elevation
+		case elevation-*reference >= elevationThreshold:
+			gain += elevation - *reference
+			reference = &elevation
+		}
+	}
+	return gain
+}
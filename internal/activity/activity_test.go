@@ -0,0 +1,149 @@
+package activity
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/kodega2016/femapi/internal/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func intPtr(i int) *int {
+	return &i
+}
+
+func floatPtr(f float64) *float64 {
+	return &f
+}
+
+func parseFixture(t *testing.T, name string) *File {
+	data, err := os.ReadFile("testdata/" + name)
+	require.NoError(t, err)
+
+	file, err := Parse(data, "")
+	require.NoError(t, err)
+	return file
+}
+
+func TestParseGPX(t *testing.T) {
+	file := parseFixture(t, "morning_run.gpx")
+	assert.Equal(t, store.SportRunning, file.Sport)
+	assert.Equal(t, "Morning Run", file.Name)
+	require.Len(t, file.Points, 5)
+	assert.Equal(t, 130, *file.Points[1].HeartRate)
+
+	summary := file.Summary()
+	assert.Equal(t, time.Date(2024, 3, 8, 6, 30, 0, 0, time.UTC), *summary.StartedAt)
+	assert.Equal(t, 240, summary.DurationSeconds)
+	assert.Equal(t, 240, summary.MovingTimeSeconds)
+	assert.InDelta(t, 444.8, summary.DistanceMeters, 0.5)
+	assert.Equal(t, 5.0, summary.ElevationGainMeters)
+	require.NotNil(t, summary.PaceSecondsPerKm)
+	assert.InDelta(t, 539.6, *summary.PaceSecondsPerKm, 0.5)
+	assert.Equal(t, 140, *summary.AvgHeartRate)
+	assert.Equal(t, 160, *summary.MaxHeartRate)
+
+	// shorter than a kilometre, so a single partial split
+	require.Len(t, summary.Splits, 1)
+	assert.InDelta(t, 444.8, summary.Splits[0].DistanceMeters, 0.5)
+}
+
+func TestParseTCX(t *testing.T) {
+	file := parseFixture(t, "intervals.tcx")
+	assert.Equal(t, store.SportRunning, file.Sport)
+	assert.Equal(t, 85, file.Calories)
+	require.Len(t, file.Points, 5)
+	assert.False(t, file.Points[3].HasPosition())
+
+	summary := file.Summary()
+	assert.Equal(t, 660, summary.DurationSeconds)
+	assert.Equal(t, 600, summary.MovingTimeSeconds)
+	assert.Equal(t, 2000.0, summary.DistanceMeters)
+	assert.Equal(t, 300.0, *summary.PaceSecondsPerKm)
+	assert.Equal(t, 12.0, *summary.AvgSpeedKph)
+	assert.Equal(t, 3.0, summary.ElevationGainMeters)
+	assert.Equal(t, 146, *summary.AvgHeartRate)
+	assert.Equal(t, 165, *summary.MaxHeartRate)
+
+	require.Len(t, summary.Splits, 2)
+	assert.Equal(t, store.Split{Number: 1, DistanceMeters: 1000, DurationSeconds: 300, PaceSecondsPerKm: floatPtr(300), AvgHeartRate: intPtr(147)}, summary.Splits[0])
+	assert.Equal(t, store.Split{Number: 2, DistanceMeters: 1000, DurationSeconds: 360, PaceSecondsPerKm: floatPtr(360), AvgHeartRate: intPtr(145)}, summary.Splits[1])
+
+	workout := file.Workout()
+	assert.Equal(t, "Running on 9 Mar 2024", workout.Title)
+	assert.Equal(t, store.WorkoutTypeRun, workout.Type)
+	assert.Equal(t, 11, workout.DurationInMinutes)
+	assert.Equal(t, 85, workout.CaloriesBurned)
+}
+
+func TestParseFIT(t *testing.T) {
+	file := parseFixture(t, "ride.fit")
+	assert.Equal(t, store.SportCycling, file.Sport)
+	assert.Equal(t, 30, file.Calories)
+	assert.Equal(t, 115*time.Second, file.MovingTime)
+	require.Len(t, file.Points, 4)
+
+	start := time.Date(2024, 3, 10, 8, 0, 0, 0, time.UTC)
+	assert.Equal(t, start, file.Points[0].Time)
+	assert.InDelta(t, 48.8566, *file.Points[0].Latitude, 0.00001)
+	assert.InDelta(t, 2.3522, *file.Points[0].Longitude, 0.00001)
+
+	// the third record uses a compressed timestamp header and invalid altitude and heart rate
+	compressed := file.Points[2]
+	assert.Equal(t, start.Add(70*time.Second), compressed.Time)
+	assert.Nil(t, compressed.Elevation)
+	assert.Nil(t, compressed.HeartRate)
+	assert.Equal(t, 490.0, *compressed.Distance)
+
+	summary := file.Summary()
+	assert.Equal(t, 120, summary.DurationSeconds)
+	assert.Equal(t, 115, summary.MovingTimeSeconds)
+	assert.Equal(t, 950.0, summary.DistanceMeters)
+	assert.Equal(t, 5.0, summary.ElevationGainMeters)
+	assert.Equal(t, 125, *summary.AvgHeartRate)
+	assert.Equal(t, 140, *summary.MaxHeartRate)
+}
+
+func TestParseFITRejectsCorruptFile(t *testing.T) {
+	data, err := os.ReadFile("testdata/ride.fit")
+	require.NoError(t, err)
+	data[20] ^= 0xFF
+
+	_, err = Parse(data, FormatFIT)
+	assert.ErrorContains(t, err, "checksum mismatch")
+}
+
+func TestParseUnknownFormat(t *testing.T) {
+	_, err := Parse([]byte("date,exercise\n"), "")
+	assert.ErrorIs(t, err, ErrUnknownFormat)
+}
+
+func TestGeoJSONSkipsPointsWithoutPosition(t *testing.T) {
+	file := parseFixture(t, "intervals.tcx")
+
+	feature := GeoJSON(file.Points)
+	assert.Equal(t, "LineString", feature.Geometry.Type)
+	require.Len(t, feature.Geometry.Coordinates, 3)
+	assert.Equal(t, []float64{-73.97, 40.78, 20}, feature.Geometry.Coordinates[0])
+	assert.Len(t, feature.Properties["times"], 3)
+}
+
+func TestSplitsInterpolateBoundary(t *testing.T) {
+	start := time.Date(2024, 1, 1, 7, 0, 0, 0, time.UTC)
+	points := []store.TrackPoint{
+		{Time: start},
+		{Time: start.Add(400 * time.Second)},
+		{Time: start.Add(1000 * time.Second)},
+	}
+	distances := []float64{0, 800, 2500}
+
+	result := splits(points, distances, 1000)
+	require.Len(t, result, 3)
+	// 1000m is reached 200m into the 1700m second interval of 600s
+	assert.Equal(t, 471, result[0].DurationSeconds)
+	assert.Equal(t, 353, result[1].DurationSeconds)
+	assert.Equal(t, 500.0, result[2].DistanceMeters)
+	assert.Equal(t, 176, result[2].DurationSeconds)
+}
@@ -0,0 +1,287 @@
+package activity
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/kodega2016/femapi/internal/store"
+)
+
+// FIT global message numbers and field numbers we read, from the FIT SDK profile
+const (
+	fitMesgSession = 18
+	fitMesgRecord  = 20
+
+	fitFieldTimestamp = 253
+
+	fitRecordLat         = 0
+	fitRecordLong        = 1
+	fitRecordAltitude    = 2
+	fitRecordHeartRate   = 3
+	fitRecordDistance    = 5
+	fitRecordEnhancedAlt = 78
+
+	fitSessionSport          = 5
+	fitSessionTotalTimerTime = 8
+	fitSessionTotalCalories  = 11
+)
+
+// fitEpoch is the FIT time origin, 1989-12-31T00:00:00Z
+var fitEpoch = time.Date(1989, 12, 31, 0, 0, 0, 0, time.UTC)
+
+var fitSports = map[int64]string{
+	1:  store.SportRunning,
+	2:  store.SportCycling,
+	5:  store.SportSwimming,
+	11: store.SportWalking,
+	15: store.SportRowing,
+	17: store.SportHiking,
+}
+
+type fitFieldDef struct {
+	num      byte
+	size     int
+	baseType byte
+}
+
+type fitDefinition struct {
+	global    uint16
+	order     binary.ByteOrder
+	fields    []fitFieldDef
+	devFields int // total size of the developer fields, which we skip
+}
+
+// fitCRC is the crc16 variant defined by the FIT protocol
+func fitCRC(data []byte) uint16 {
+	table := [16]uint16{
+		0x0000, 0xCC01, 0xD801, 0x1400, 0xF001, 0x3C00, 0x2800, 0xE401,
+		0xA001, 0x6C00, 0x7800, 0xB401, 0x5000, 0x9C01, 0x8801, 0x4400,
+	}
+	var crc uint16
+	for _, b := range data {
+		tmp := table[crc&0xF]
+		crc = (crc >> 4) & 0x0FFF
+		crc = crc ^ tmp ^ table[b&0xF]
+		tmp = table[crc&0xF]
+		crc = (crc >> 4) & 0x0FFF
+		crc = crc ^ tmp ^ table[(b>>4)&0xF]
+	}
+	return crc
+}
+
+// fitValue decodes a numeric field, ok is false for the base type's invalid value
+func fitValue(raw []byte, baseType byte, order binary.ByteOrder) (int64, bool) {
+	switch baseType & 0x1F {
+	case 0x00, 0x02, 0x0D: // enum, uint8, byte
+		return int64(raw[0]), raw[0] != 0xFF
+	case 0x0A: // uint8z
+		return int64(raw[0]), raw[0] != 0
+	case 0x01: // sint8
+		return int64(int8(raw[0])), raw[0] != 0x7F
+	case 0x03: // sint16
+		v := order.Uint16(raw)
+		return int64(int16(v)), v != 0x7FFF
+	case 0x04: // uint16
+		v := order.Uint16(raw)
+		return int64(v), v != 0xFFFF
+	case 0x0B: // uint16z
+		v := order.Uint16(raw)
+		return int64(v), v != 0
+	case 0x05: // sint32
+		v := order.Uint32(raw)
+		return int64(int32(v)), v != 0x7FFFFFFF
+	case 0x06: // uint32
+		v := order.Uint32(raw)
+		return int64(v), v != 0xFFFFFFFF
+	case 0x0C: // uint32z
+		v := order.Uint32(raw)
+		return int64(v), v != 0
+	}
+	return 0, false
+}
+
+func fitBaseSize(baseType byte) int {
+	switch baseType & 0x1F {
+	case 0x00, 0x01, 0x02, 0x0A, 0x0D:
+		return 1
+	case 0x03, 0x04, 0x0B:
+		return 2
+	case 0x05, 0x06, 0x0C:
+		return 4
+	}
+	return 0
+}
+
+func semicirclesToDegrees(v int64) float64 {
+	return float64(v) * 180 / math.Pow(2, 31)
+}
+
+// parseFIT reads the record and session messages of an activity file. Other messages,
+// array fields and developer fields are skipped.
+func parseFIT(data []byte) (*File, error) {
+	if len(data) < 12 {
+		return nil, errors.New("file too short")
+	}
+	headerSize := int(data[0])
+	if headerSize < 12 || len(data) < headerSize || string(data[8:12]) != ".FIT" {
+		return nil, errors.New("missing FIT header")
+	}
+	dataSize := int(binary.LittleEndian.Uint32(data[4:8]))
+	end := headerSize + dataSize
+	if len(data) < end+2 {
+		return nil, errors.New("file is truncated")
+	}
+	if fitCRC(data[:end]) != binary.LittleEndian.Uint16(data[end:end+2]) {
+		return nil, errors.New("checksum mismatch")
+	}
+
+	file := &File{Sport: store.SportOther}
+	definitions := make(map[byte]*fitDefinition)
+	var lastTimestamp uint32
+
+	pos := headerSize
+	for pos < end {
+		header := data[pos]
+		pos++
+
+		// compressed timestamp header: a data message with a 5 bit offset to the last timestamp
+		compressed := header&0x80 != 0
+		var local byte
+		if compressed {
+			local = (header >> 5) & 0x03
+			offset := uint32(header & 0x1F)
+			timestamp := (lastTimestamp &^ 0x1F) + offset
+			if offset < lastTimestamp&0x1F {
+				timestamp += 0x20
+			}
+			lastTimestamp = timestamp
+		} else {
+			local = header & 0x0F
+		}
+
+		if !compressed && header&0x40 != 0 {
+			def, size, err := readFITDefinition(data[pos:end], header&0x20 != 0)
+			if err != nil {
+				return nil, err
+			}
+			definitions[local] = def
+			pos += size
+			continue
+		}
+
+		def, ok := definitions[local]
+		if !ok {
+			return nil, fmt.Errorf("data message for undefined local type %d", local)
+		}
+
+		values := make(map[byte]int64, len(def.fields))
+		for _, field := range def.fields {
+			if pos+field.size > end {
+				return nil, errors.New("message is truncated")
+			}
+			raw := data[pos : pos+field.size]
+			pos += field.size
+			if fitBaseSize(field.baseType) != field.size {
+				continue
+			}
+			v, valid := fitValue(raw, field.baseType, def.order)
+			if valid {
+				values[field.num] = v
+			}
+		}
+		pos += def.devFields
+		if pos > end {
+			return nil, errors.New("message is truncated")
+		}
+
+		if ts, ok := values[fitFieldTimestamp]; ok {
+			lastTimestamp = uint32(ts)
+		}
+
+		switch def.global {
+		case fitMesgRecord:
+			file.Points = append(file.Points, fitTrackPoint(values, lastTimestamp))
+		case fitMesgSession:
+			if sport, ok := values[fitSessionSport]; ok && fitSports[sport] != "" {
+				file.Sport = fitSports[sport]
+			}
+			if timer, ok := values[fitSessionTotalTimerTime]; ok {
+				file.MovingTime += time.Duration(timer) * time.Millisecond
+			}
+			if calories, ok := values[fitSessionTotalCalories]; ok {
+				file.Calories += int(calories)
+			}
+		}
+	}
+	return file, nil
+}
+
+func readFITDefinition(data []byte, hasDevFields bool) (*fitDefinition, int, error) {
+	if len(data) < 5 {
+		return nil, 0, errors.New("definition is truncated")
+	}
+	def := &fitDefinition{order: binary.LittleEndian}
+	if data[1] == 1 {
+		def.order = binary.BigEndian
+	}
+	def.global = def.order.Uint16(data[2:4])
+	count := int(data[4])
+	size := 5 + count*3
+	if len(data) < size {
+		return nil, 0, errors.New("definition is truncated")
+	}
+	for i := 0; i < count; i++ {
+		field := data[5+i*3 : 8+i*3]
+		def.fields = append(def.fields, fitFieldDef{num: field[0], size: int(field[1]), baseType: field[2]})
+	}
+
+	if hasDevFields {
+		if len(data) < size+1 {
+			return nil, 0, errors.New("definition is truncated")
+		}
+		devCount := int(data[size])
+		size++
+		if len(data) < size+devCount*3 {
+			return nil, 0, errors.New("definition is truncated")
+		}
+		for i := 0; i < devCount; i++ {
+			def.devFields += int(data[size+i*3+1])
+		}
+		size += devCount * 3
+	}
+	return def, size, nil
+}
+
+func fitTrackPoint(values map[byte]int64, timestamp uint32) store.TrackPoint {
+	point := store.TrackPoint{Time: fitEpoch.Add(time.Duration(timestamp) * time.Second)}
+
+	lat, hasLat := values[fitRecordLat]
+	long, hasLong := values[fitRecordLong]
+	if hasLat && hasLong {
+		latitude, longitude := semicirclesToDegrees(lat), semicirclesToDegrees(long)
+		point.Latitude = &latitude
+		point.Longitude = &longitude
+	}
+
+	altitude, ok := values[fitRecordEnhancedAlt]
+	if !ok {
+		altitude, ok = values[fitRecordAltitude]
+	}
+	if ok {
+		elevation := float64(altitude)/5 - 500
+		point.Elevation = &elevation
+	}
+
+	if distance, ok := values[fitRecordDistance]; ok {
+		meters := float64(distance) / 100
+		point.Distance = &meters
+	}
+	if rate, ok := values[fitRecordHeartRate]; ok {
+		heartRate := int(rate)
+		point.HeartRate = &heartRate
+	}
+	return point
+}
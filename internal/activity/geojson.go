@@ -0,0 +1,51 @@
+package activity
+
+import (
+	"time"
+
+	"github.com/kodega2016/femapi/internal/store"
+)
+
+const GeoJSONContentType = "application/geo+json"
+
+type Feature struct {
+	Type       string         `json:"type"`
+	Geometry   Geometry       `json:"geometry"`
+	Properties map[string]any `json:"properties"`
+}
+
+type Geometry struct {
+	Type        string      `json:"type"`
+	Coordinates [][]float64 `json:"coordinates"`
+}
+
+// GeoJSON returns the positioned samples as a LineString feature. Coordinates are
+// [longitude, latitude, elevation] as required by RFC 7946, per point times and heart
+// rates are kept in properties arrays parallel to the coordinates.
+func GeoJSON(points []store.TrackPoint) Feature {
+	coordinates := [][]float64{}
+	times := []time.Time{}
+	heartRates := []*int{}
+
+	for _, point := range points {
+		if !point.HasPosition() {
+			continue
+		}
+		coordinate := []float64{*point.Longitude, *point.Latitude}
+		if point.Elevation != nil {
+			coordinate = append(coordinate, *point.Elevation)
+		}
+		coordinates = append(coordinates, coordinate)
+		times = append(times, point.Time)
+		heartRates = append(heartRates, point.HeartRate)
+	}
+
+	return Feature{
+		Type:     "Feature",
+		Geometry: Geometry{Type: "LineString", Coordinates: coordinates},
+		Properties: map[string]any{
+			"times":       times,
+			"heart_rates": heartRates,
+		},
+	}
+}
@@ -0,0 +1,123 @@
+package activity
+
+import (
+	"encoding/xml"
+	"errors"
+	"math"
+	"time"
+
+	"github.com/kodega2016/femapi/internal/store"
+)
+
+// gpx elements are matched on their local name, so both GPX 1.0 and 1.1 as well as the
+// Garmin TrackPointExtension namespaces are accepted
+type gpxDocument struct {
+	Metadata struct {
+		Time string `xml:"time"`
+	} `xml:"metadata"`
+	Tracks []struct {
+		Name     string `xml:"name"`
+		Type     string `xml:"type"`
+		Segments []struct {
+			Points []struct {
+				Lat       float64  `xml:"lat,attr"`
+				Lon       float64  `xml:"lon,attr"`
+				Elevation *float64 `xml:"ele"`
+				Time      string   `xml:"time"`
+				HeartRate *int     `xml:"extensions>TrackPointExtension>hr"`
+			} `xml:"trkpt"`
+		} `xml:"trkseg"`
+	} `xml:"trk"`
+}
+
+func parseGPX(data []byte) (*File, error) {
+	var doc gpxDocument
+	err := xml.Unmarshal(data, &doc)
+	if err != nil {
+		return nil, err
+	}
+
+	file := &File{Sport: store.SportOther}
+	for _, track := range doc.Tracks {
+		if file.Name == "" {
+			file.Name = track.Name
+		}
+		if track.Type != "" && file.Sport == store.SportOther {
+			file.Sport = normalizeSport(track.Type)
+		}
+		for _, segment := range track.Segments {
+			for _, p := range segment.Points {
+				if p.Time == "" {
+					return nil, errors.New("track point without time")
+				}
+				recordedAt, err := time.Parse(time.RFC3339, p.Time)
+				if err != nil {
+					return nil, err
+				}
+				lat, lon := p.Lat, p.Lon
+				file.Points = append(file.Points, store.TrackPoint{
+					Time:      recordedAt.UTC(),
+					Latitude:  &lat,
+					Longitude: &lon,
+					Elevation: p.Elevation,
+					HeartRate: p.HeartRate,
+				})
+			}
+		}
+	}
+	return file, nil
+}
+
+type tcxDocument struct {
+	Activities []struct {
+		Sport string `xml:"Sport,attr"`
+		Laps  []struct {
+			TotalTimeSeconds float64 `xml:"TotalTimeSeconds"`
+			Calories         int     `xml:"Calories"`
+			Points           []struct {
+				Time      string   `xml:"Time"`
+				Latitude  *float64 `xml:"Position>LatitudeDegrees"`
+				Longitude *float64 `xml:"Position>LongitudeDegrees"`
+				Altitude  *float64 `xml:"AltitudeMeters"`
+				Distance  *float64 `xml:"DistanceMeters"`
+				HeartRate *int     `xml:"HeartRateBpm>Value"`
+			} `xml:"Track>Trackpoint"`
+		} `xml:"Lap"`
+	} `xml:"Activities>Activity"`
+}
+
+func parseTCX(data []byte) (*File, error) {
+	var doc tcxDocument
+	err := xml.Unmarshal(data, &doc)
+	if err != nil {
+		return nil, err
+	}
+
+	file := &File{Sport: store.SportOther}
+	totalSeconds := 0.0
+	for _, activity := range doc.Activities {
+		if file.Sport == store.SportOther {
+			file.Sport = normalizeSport(activity.Sport)
+		}
+		for _, lap := range activity.Laps {
+			totalSeconds += lap.TotalTimeSeconds
+			file.Calories += lap.Calories
+			for _, p := range lap.Points {
+				recordedAt, err := time.Parse(time.RFC3339, p.Time)
+				if err != nil {
+					return nil, err
+				}
+				file.Points = append(file.Points, store.TrackPoint{
+					Time:      recordedAt.UTC(),
+					Latitude:  p.Latitude,
+					Longitude: p.Longitude,
+					Elevation: p.Altitude,
+					Distance:  p.Distance,
+					HeartRate: p.HeartRate,
+				})
+			}
+		}
+	}
+	file.MovingTime = time.Duration(math.Round(totalSeconds)) * time.Second
+	return file, nil
+}
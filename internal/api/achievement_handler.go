@@ -0,0 +1,34 @@
+package api
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/kodega2016/femapi/internal/achievements"
+	"github.com/kodega2016/femapi/internal/middleware"
+	"github.com/kodega2016/femapi/internal/utils"
+)
+
+type AchievementHandler struct {
+	engine *achievements.Engine
+	logger *log.Logger
+}
+
+func NewAchievementHandler(engine *achievements.Engine, logger *log.Logger) *AchievementHandler {
+	return &AchievementHandler{
+		engine: engine,
+		logger: logger,
+	}
+}
+
+// HandleListAchievements returns every achievement with its progress and award time, and the
+// current workout streaks
+func (ah *AchievementHandler) HandleListAchievements(w http.ResponseWriter, r *http.Request) {
+	summary, err := ah.engine.Summary(middleware.GetUser(r).ID)
+	if err != nil {
+		ah.logger.Printf("ERROR: achievements summary: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"achievements": summary.Achievements, "streaks": summary.Streaks})
+}
@@ -0,0 +1,105 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/kodega2016/femapi/internal/activity"
+	"github.com/kodega2016/femapi/internal/middleware"
+	"github.com/kodega2016/femapi/internal/utils"
+)
+
+const maxActivityBytes = 25 << 20
+
+// HandleImportActivity creates a cardio workout from a FIT, TCX or GPX upload sent as the raw
+// request body. The format is taken from ?format= or detected from the content, the workout
+// type follows the recorded sport unless ?type= is given.
+func (h *TransferHandler) HandleImportActivity(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxActivityBytes)
+	data, err := io.ReadAll(r.Body)
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		utils.WriteJSON(w, http.StatusRequestEntityTooLarge, utils.Envelope{"error": "activity file is too large"})
+		return
+	}
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid request payload"})
+		return
+	}
+
+	file, err := activity.Parse(data, r.URL.Query().Get("format"))
+	if errors.Is(err, activity.ErrUnknownFormat) {
+		utils.WriteJSON(w, http.StatusUnsupportedMediaType, utils.Envelope{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": err.Error()})
+		return
+	}
+
+	workout := file.Workout()
+	if title := r.URL.Query().Get("title"); title != "" {
+		workout.Title = title
+	}
+	if workoutType := r.URL.Query().Get("type"); workoutType != "" {
+		workout.Type = workoutType
+	}
+	if visibility := r.URL.Query().Get("visibility"); visibility != "" {
+		workout.Visibility = visibility
+	}
+	normalizeWorkout(workout)
+	err = validateWorkout(workout)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": err.Error()})
+		return
+	}
+	workout.UserID = middleware.GetUser(r).ID
+	err = applyCalories(h.bodyMeasurementStore, workout, workout.UserID, nil)
+	if err != nil {
+		h.logger.Printf("ERROR: applyCalories: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "failed to create workout"})
+		return
+	}
+
+	createdWorkout, err := h.workoutStore.CreateWorkout(workout)
+	if err != nil {
+		h.logger.Printf("ERROR: createWorkout: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "failed to create workout"})
+		return
+	}
+
+	w.Header().Set("ETag", workoutETag(createdWorkout))
+	utils.WriteJSON(w, http.StatusCreated, utils.Envelope{"workout": createdWorkout})
+}
+
+// HandleGetWorkoutTrack returns the recorded gps track of a workout as GeoJSON
+func (wh *WorkoutHandler) HandleGetWorkoutTrack(w http.ResponseWriter, r *http.Request) {
+	workout, ok := wh.readViewableWorkout(w, r)
+	if !ok {
+		return
+	}
+	if workout.Activity == nil {
+		utils.WriteJSON(w, http.StatusNotFound, utils.Envelope{"error": "workout has no recorded track"})
+		return
+	}
+
+	points, err := wh.workoutStore.GetWorkoutTrack(int64(workout.ID))
+	if err != nil {
+		wh.logger.Printf("ERROR: getWorkoutTrack: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	feature := activity.GeoJSON(points)
+	feature.Properties["workout_id"] = workout.PublicID
+	feature.Properties["sport"] = workout.Activity.Sport
+
+	w.Header().Set("Content-Type", activity.GeoJSONContentType)
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(feature)
+	if err != nil {
+		wh.logger.Printf("ERROR: encoding track: %v", err)
+	}
+}
@@ -0,0 +1,195 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"github.com/kodega2016/femapi/internal/utils"
+)
+
+const (
+	maxBatchRequests = 50
+	// bounds the whole batch, streaming endpoints would otherwise never return. It runs out
+	// before the write timeout of the server.
+	batchTimeout = 20 * time.Second
+)
+
+// TxRouter returns the routes with every store on tx, and the func publishing what they held
+// back until tx committed
+type TxRouter func(tx *sql.Tx) (router http.Handler, afterCommit func(ctx context.Context) error)
+
+// BatchHandler runs several requests through the router in one round trip. Transactional
+// batches run on the router newTxRouter builds over their transaction.
+type BatchHandler struct {
+	router      http.Handler
+	db          *sql.DB
+	newTxRouter TxRouter
+	logger      *log.Logger
+}
+
+func NewBatchHandler(router http.Handler, db *sql.DB, newTxRouter TxRouter, logger *log.Logger) *BatchHandler {
+	return &BatchHandler{
+		router:      router,
+		db:          db,
+		newTxRouter: newTxRouter,
+		logger:      logger,
+	}
+}
+
+type batchItem struct {
+	Method  string            `json:"method"`
+	Path    string            `json:"path"`
+	Headers map[string]string `json:"headers"`
+	Body    json.RawMessage   `json:"body"`
+}
+
+type batchResponse struct {
+	Status  int               `json:"status"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    json.RawMessage   `json:"body,omitempty"`
+}
+
+func validateBatchItem(item *batchItem) error {
+	item.Method = strings.ToUpper(item.Method)
+	switch item.Method {
+	case http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+	default:
+		return errors.New("method must be one of GET, POST, PUT, PATCH or DELETE")
+	}
+	if !strings.HasPrefix(item.Path, "/") {
+		return errors.New("path must start with /")
+	}
+	if item.Path == "/batch" || strings.HasPrefix(item.Path, "/batch?") {
+		return errors.New("batches cannot be nested")
+	}
+	return nil
+}
+
+// dispatch runs one request of the batch as the user of the batch and records its response
+func (bh *BatchHandler) dispatch(ctx context.Context, router http.Handler, r *http.Request, item batchItem) batchResponse {
+	req, err := http.NewRequestWithContext(ctx, item.Method, item.Path, bytes.NewReader(item.Body))
+	if err != nil {
+		return batchResponse{Status: http.StatusBadRequest, Body: json.RawMessage(`{"error":"invalid path"}`)}
+	}
+	req.Host = r.Host
+	req.RemoteAddr = r.RemoteAddr
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		req.Header.Set("Authorization", auth)
+	}
+	if len(item.Body) > 0 {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for name, value := range item.Headers {
+		req.Header.Set(name, value)
+	}
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, req)
+
+	response := batchResponse{Status: recorder.Code, Headers: make(map[string]string)}
+	for name := range recorder.Header() {
+		response.Headers[name] = recorder.Header().Get(name)
+	}
+	body := bytes.TrimSpace(recorder.Body.Bytes())
+	switch {
+	case len(body) == 0:
+	case json.Valid(body):
+		response.Body = body
+	default:
+		// calendars and other plain text bodies are passed as a JSON string
+		response.Body, _ = json.Marshal(string(body))
+	}
+	return response
+}
+
+// HandleBatch runs up to 50 requests in order and returns their responses. Requests are
+// independent unless the batch is transactional: then the first failed request stops the
+// batch and rolls back what the requests before it wrote, and what they would have published
+// is only published once the batch committed.
+func (bh *BatchHandler) HandleBatch(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Transactional bool        `json:"transactional"`
+		Requests      []batchItem `json:"requests"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		bh.logger.Printf("ERROR: decodingBatchRequest: %v", err)
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid request sent"})
+		return
+	}
+	if len(req.Requests) == 0 || len(req.Requests) > maxBatchRequests {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "a batch must hold between 1 and 50 requests"})
+		return
+	}
+	for i := range req.Requests {
+		err = validateBatchItem(&req.Requests[i])
+		if err != nil {
+			utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": err.Error(), "index": i})
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), batchTimeout)
+	defer cancel()
+	responses := make([]batchResponse, len(req.Requests))
+	if !req.Transactional {
+		for i, item := range req.Requests {
+			responses[i] = bh.dispatch(ctx, bh.router, r, item)
+		}
+		utils.WriteJSON(w, http.StatusOK, utils.Envelope{"responses": responses})
+		return
+	}
+
+	tx, err := bh.db.BeginTx(ctx, nil)
+	if err != nil {
+		bh.logger.Printf("ERROR: beginningBatch: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+	defer tx.Rollback()
+
+	router, afterCommit := bh.newTxRouter(tx)
+	ran, failed := 0, false
+	for i, item := range req.Requests {
+		responses[i] = bh.dispatch(ctx, router, r, item)
+		ran++
+		if responses[i].Status >= http.StatusBadRequest {
+			failed = true
+			break
+		}
+	}
+
+	if !failed {
+		err = tx.Commit()
+		if err != nil && ctx.Err() != nil {
+			utils.WriteJSON(w, http.StatusGatewayTimeout, utils.Envelope{"error": "the batch did not finish in time"})
+			return
+		}
+		if err != nil {
+			bh.logger.Printf("ERROR: committingBatch: %v", err)
+			utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+			return
+		}
+		// subscribers that miss an event catch up from the store
+		err = afterCommit(r.Context())
+		if err != nil {
+			bh.logger.Printf("ERROR: publishingBatchEvents: %v", err)
+		}
+	}
+
+	for i := ran; i < len(responses); i++ {
+		responses[i] = batchResponse{
+			Status: http.StatusFailedDependency,
+			Body:   json.RawMessage(`{"error":"not run, an earlier request of the batch failed"}`),
+		}
+	}
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"committed": !failed, "responses": responses})
+}
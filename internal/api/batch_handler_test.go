@@ -0,0 +1,151 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/kodega2016/femapi/internal/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setupTestDB(t *testing.T) *sql.DB {
+	db, err := sql.Open("pgx", "host=localhost user=postgres password=postgres dbname=postgres port=5433 sslmode=disable")
+	require.NoError(t, err)
+	require.NoError(t, store.Migrate(db, "../../migrations/"))
+	_, err = db.Exec("TRUNCATE users CASCADE")
+	require.NoError(t, err)
+	return db
+}
+
+// usersRouter registers users on q, a taken username is answered with 409
+func usersRouter(q store.Querier) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /users", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Username string `json:"username"`
+		}
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		_, err = q.Exec(`INSERT INTO users(username,email,password_hash) VALUES($1,$2,'x')`, req.Username, req.Username+"@example.com")
+		if err != nil {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	})
+	return mux
+}
+
+type testBatch struct {
+	handler      *BatchHandler
+	afterCommits int
+}
+
+func newTestBatch(db *sql.DB) *testBatch {
+	tb := &testBatch{}
+	tb.handler = NewBatchHandler(usersRouter(db), db, func(tx *sql.Tx) (http.Handler, func(ctx context.Context) error) {
+		return usersRouter(tx), func(ctx context.Context) error {
+			tb.afterCommits++
+			return nil
+		}
+	}, log.New(io.Discard, "", 0))
+	return tb
+}
+
+type testBatchResult struct {
+	Committed bool            `json:"committed"`
+	Responses []batchResponse `json:"responses"`
+}
+
+func (tb *testBatch) run(t *testing.T, transactional bool, usernames ...string) testBatchResult {
+	t.Helper()
+	items := make([]batchItem, len(usernames))
+	for i, username := range usernames {
+		items[i] = batchItem{Method: http.MethodPost, Path: "/users", Body: json.RawMessage(`{"username":"` + username + `"}`)}
+	}
+	body, err := json.Marshal(map[string]any{"transactional": transactional, "requests": items})
+	require.NoError(t, err)
+
+	rr := httptest.NewRecorder()
+	tb.handler.HandleBatch(rr, httptest.NewRequest(http.MethodPost, "/batch", strings.NewReader(string(body))))
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	var result testBatchResult
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&result))
+	require.Len(t, result.Responses, len(usernames))
+	return result
+}
+
+func statuses(result testBatchResult) []int {
+	codes := make([]int, len(result.Responses))
+	for i, response := range result.Responses {
+		codes[i] = response.Status
+	}
+	return codes
+}
+
+func usernames(t *testing.T, db *sql.DB) []string {
+	t.Helper()
+	rows, err := db.Query(`SELECT username FROM users ORDER BY username`)
+	require.NoError(t, err)
+	defer rows.Close()
+	names := []string{}
+	for rows.Next() {
+		var name string
+		require.NoError(t, rows.Scan(&name))
+		names = append(names, name)
+	}
+	require.NoError(t, rows.Err())
+	return names
+}
+
+func TestTransactionalBatchCommits(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	tb := newTestBatch(db)
+
+	result := tb.run(t, true, "alice", "bob")
+
+	assert.True(t, result.Committed)
+	assert.Equal(t, []int{http.StatusCreated, http.StatusCreated}, statuses(result))
+	assert.Equal(t, []string{"alice", "bob"}, usernames(t, db))
+	assert.Equal(t, 1, tb.afterCommits)
+}
+
+func TestTransactionalBatchRollsBackAtTheFirstFailure(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	tb := newTestBatch(db)
+
+	result := tb.run(t, true, "alice", "alice", "bob", "carol")
+
+	assert.False(t, result.Committed)
+	// the requests after the failed one are not run
+	assert.Equal(t, []int{http.StatusCreated, http.StatusConflict, http.StatusFailedDependency, http.StatusFailedDependency}, statuses(result))
+	assert.JSONEq(t, `{"error":"not run, an earlier request of the batch failed"}`, string(result.Responses[3].Body))
+	assert.Empty(t, usernames(t, db), "the request that succeeded is rolled back too")
+	assert.Zero(t, tb.afterCommits, "nothing held back is published for a rolled back batch")
+}
+
+func TestBatchRunsEveryRequestWhenNotTransactional(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	tb := newTestBatch(db)
+
+	result := tb.run(t, false, "alice", "alice", "bob")
+
+	assert.Equal(t, []int{http.StatusCreated, http.StatusConflict, http.StatusCreated}, statuses(result))
+	assert.Equal(t, []string{"alice", "bob"}, usernames(t, db))
+	assert.Zero(t, tb.afterCommits)
+}
@@ -0,0 +1,356 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/kodega2016/femapi/internal/metrics"
+	"github.com/kodega2016/femapi/internal/middleware"
+	"github.com/kodega2016/femapi/internal/store"
+	"github.com/kodega2016/femapi/internal/utils"
+)
+
+const (
+	defaultMeasurementHistory = 90 * 24 * time.Hour
+	maxMeasurementValue       = 1000
+)
+
+type BodyMeasurementHandler struct {
+	bodyMeasurementStore store.BodyMeasurementStore
+	settingsStore        store.SettingsStore
+	logger               *log.Logger
+}
+
+func NewBodyMeasurementHandler(bodyMeasurementStore store.BodyMeasurementStore, settingsStore store.SettingsStore, logger *log.Logger) *BodyMeasurementHandler {
+	return &BodyMeasurementHandler{
+		bodyMeasurementStore: bodyMeasurementStore,
+		settingsStore:        settingsStore,
+		logger:               logger,
+	}
+}
+
+// measurementValue converts the value to the stored unit and checks it is in range
+func measurementValue(kind string, value float64, unit string) (float64, error) {
+	canonical, err := metrics.ToCanonical(kind, value, unit)
+	if err != nil {
+		return 0, err
+	}
+	canonical = math.Round(canonical*100) / 100
+	if canonical <= 0 || canonical >= maxMeasurementValue {
+		return 0, errors.New("value must be between 0 and 1000")
+	}
+	if kind == store.MeasurementBodyFat && canonical >= 100 {
+		return 0, errors.New("body fat must be a percentage below 100")
+	}
+	return canonical, nil
+}
+
+// readUnitSystem reads ?units=metric|imperial, the system values are returned in. It defaults
+// to the units in the user's settings.
+func (bh *BodyMeasurementHandler) readUnitSystem(w http.ResponseWriter, r *http.Request) (string, bool) {
+	system := r.URL.Query().Get("units")
+	switch system {
+	case "":
+		settings, ok := loadSettings(w, r, bh.settingsStore, bh.logger)
+		if !ok {
+			return "", false
+		}
+		return settings.Units, true
+	case metrics.UnitSystemMetric, metrics.UnitSystemImperial:
+		return system, true
+	}
+	utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "units must be metric or imperial"})
+	return "", false
+}
+
+// readTimeRange reads ?from=&to= as RFC 3339 timestamps, by default the last 90 days.
+// The returned range is half open, to is made inclusive for callers.
+func readTimeRange(w http.ResponseWriter, r *http.Request) (time.Time, time.Time, bool) {
+	to := time.Now().UTC()
+	if v := r.URL.Query().Get("to"); v != "" {
+		parsed, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "to must be an RFC 3339 timestamp"})
+			return time.Time{}, time.Time{}, false
+		}
+		to = parsed
+	}
+	from := to.Add(-defaultMeasurementHistory)
+	if v := r.URL.Query().Get("from"); v != "" {
+		parsed, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "from must be an RFC 3339 timestamp"})
+			return time.Time{}, time.Time{}, false
+		}
+		from = parsed
+	}
+	if !from.Before(to) {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "from must be before to"})
+		return time.Time{}, time.Time{}, false
+	}
+	return from, to.Add(time.Second), true
+}
+
+func convertMeasurement(measurement *store.BodyMeasurement, system string) {
+	measurement.Value, measurement.Unit = metrics.FromCanonical(measurement.Kind, measurement.Value, system)
+}
+
+// loadMeasurement resolves the {measurementID} url param to a measurement of the current user
+func (bh *BodyMeasurementHandler) loadMeasurement(w http.ResponseWriter, r *http.Request) (*store.BodyMeasurement, bool) {
+	ref, err := utils.ReadRefParam(r, "measurementID")
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid measurement id"})
+		return nil, false
+	}
+	// measurements never had numeric ids
+	if ref.IsLegacy() {
+		utils.WriteJSON(w, http.StatusNotFound, utils.Envelope{"error": "measurement not found"})
+		return nil, false
+	}
+
+	measurement, err := bh.bodyMeasurementStore.GetMeasurement(middleware.GetUser(r).ID, ref.PublicID)
+	if errors.Is(err, sql.ErrNoRows) {
+		utils.WriteJSON(w, http.StatusNotFound, utils.Envelope{"error": "measurement not found"})
+		return nil, false
+	}
+	if err != nil {
+		bh.logger.Printf("ERROR: getMeasurement: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return nil, false
+	}
+	return measurement, true
+}
+
+// HandleCreateMeasurement logs a measurement. The value is given in unit, which defaults to kg
+// for weight, % for body fat and cm for circumferences; lbs and in are converted.
+func (bh *BodyMeasurementHandler) HandleCreateMeasurement(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Kind       string     `json:"kind"`
+		Value      float64    `json:"value"`
+		Unit       string     `json:"unit"`
+		Note       string     `json:"note"`
+		MeasuredAt *time.Time `json:"measured_at"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid request payload"})
+		return
+	}
+
+	if !store.IsValidMeasurementKind(req.Kind) {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid measurement kind"})
+		return
+	}
+	value, err := measurementValue(req.Kind, req.Value, req.Unit)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": err.Error()})
+		return
+	}
+
+	measuredAt := time.Now().UTC()
+	if req.MeasuredAt != nil {
+		if req.MeasuredAt.After(measuredAt) {
+			utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "measured_at can not be in the future"})
+			return
+		}
+		measuredAt = req.MeasuredAt.UTC()
+	}
+
+	measurement := &store.BodyMeasurement{
+		UserID:     middleware.GetUser(r).ID,
+		Kind:       req.Kind,
+		Value:      value,
+		Note:       req.Note,
+		MeasuredAt: measuredAt,
+	}
+	err = bh.bodyMeasurementStore.CreateMeasurement(measurement)
+	if err != nil {
+		bh.logger.Printf("ERROR: createMeasurement: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+	utils.WriteJSON(w, http.StatusCreated, utils.Envelope{"measurement": measurement})
+}
+
+// HandleListMeasurements lists measurements newest first, filtered by ?kind= and ?from=&to=
+func (bh *BodyMeasurementHandler) HandleListMeasurements(w http.ResponseWriter, r *http.Request) {
+	kind := r.URL.Query().Get("kind")
+	if kind != "" && !store.IsValidMeasurementKind(kind) {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid measurement kind"})
+		return
+	}
+	system, ok := bh.readUnitSystem(w, r)
+	if !ok {
+		return
+	}
+	from, to, ok := readTimeRange(w, r)
+	if !ok {
+		return
+	}
+
+	measurements, err := bh.bodyMeasurementStore.ListMeasurements(middleware.GetUser(r).ID, kind, from, to)
+	if err != nil {
+		bh.logger.Printf("ERROR: listMeasurements: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+	for i := range measurements {
+		convertMeasurement(&measurements[i], system)
+	}
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"measurements": measurements})
+}
+
+// HandleLatestMeasurements returns the most recent value of every kind, a snapshot of the body
+func (bh *BodyMeasurementHandler) HandleLatestMeasurements(w http.ResponseWriter, r *http.Request) {
+	system, ok := bh.readUnitSystem(w, r)
+	if !ok {
+		return
+	}
+
+	measurements, err := bh.bodyMeasurementStore.LatestMeasurements(middleware.GetUser(r).ID)
+	if err != nil {
+		bh.logger.Printf("ERROR: latestMeasurements: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+	for i := range measurements {
+		convertMeasurement(&measurements[i], system)
+	}
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"measurements": measurements})
+}
+
+// HandleMeasurementTrend smooths one kind of measurement with an exponential moving average.
+// ?alpha= is the weight of every new measurement, between 0 and 1, 0.1 by default.
+func (bh *BodyMeasurementHandler) HandleMeasurementTrend(w http.ResponseWriter, r *http.Request) {
+	kind := r.URL.Query().Get("kind")
+	if kind == "" {
+		kind = store.MeasurementWeight
+	}
+	if !store.IsValidMeasurementKind(kind) {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid measurement kind"})
+		return
+	}
+
+	alpha := metrics.DefaultAlpha
+	if v := r.URL.Query().Get("alpha"); v != "" {
+		parsed, err := strconv.ParseFloat(v, 64)
+		if err != nil || parsed <= 0 || parsed > 1 {
+			utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "alpha must be between 0 and 1"})
+			return
+		}
+		alpha = parsed
+	}
+	system, ok := bh.readUnitSystem(w, r)
+	if !ok {
+		return
+	}
+	from, to, ok := readTimeRange(w, r)
+	if !ok {
+		return
+	}
+
+	measurements, err := bh.bodyMeasurementStore.ListMeasurements(middleware.GetUser(r).ID, kind, from, to)
+	if err != nil {
+		bh.logger.Printf("ERROR: listMeasurements: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	points := make([]metrics.Point, 0, len(measurements))
+	unit := store.MeasurementUnit(kind)
+	for _, measurement := range measurements {
+		convertMeasurement(&measurement, system)
+		unit = measurement.Unit
+		points = append(points, metrics.Point{Time: measurement.MeasuredAt, Value: measurement.Value})
+	}
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"kind": kind, "unit": unit, "alpha": alpha, "trend": metrics.EMA(points, alpha)})
+}
+
+func (bh *BodyMeasurementHandler) HandleGetMeasurement(w http.ResponseWriter, r *http.Request) {
+	measurement, ok := bh.loadMeasurement(w, r)
+	if !ok {
+		return
+	}
+	system, ok := bh.readUnitSystem(w, r)
+	if !ok {
+		return
+	}
+	convertMeasurement(measurement, system)
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"measurement": measurement})
+}
+
+// HandleUpdateMeasurement corrects the value, note or time of a measurement, the kind is fixed
+func (bh *BodyMeasurementHandler) HandleUpdateMeasurement(w http.ResponseWriter, r *http.Request) {
+	measurement, ok := bh.loadMeasurement(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Value      *float64   `json:"value"`
+		Unit       string     `json:"unit"`
+		Note       *string    `json:"note"`
+		MeasuredAt *time.Time `json:"measured_at"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid request payload"})
+		return
+	}
+
+	if req.Value != nil {
+		measurement.Value, err = measurementValue(measurement.Kind, *req.Value, req.Unit)
+		if err != nil {
+			utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": err.Error()})
+			return
+		}
+	}
+	if req.Note != nil {
+		measurement.Note = *req.Note
+	}
+	if req.MeasuredAt != nil {
+		if req.MeasuredAt.After(time.Now()) {
+			utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "measured_at can not be in the future"})
+			return
+		}
+		measurement.MeasuredAt = req.MeasuredAt.UTC()
+	}
+
+	err = bh.bodyMeasurementStore.UpdateMeasurement(measurement)
+	if err != nil {
+		bh.logger.Printf("ERROR: updateMeasurement: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"measurement": measurement})
+}
+
+func (bh *BodyMeasurementHandler) HandleDeleteMeasurement(w http.ResponseWriter, r *http.Request) {
+	ref, err := utils.ReadRefParam(r, "measurementID")
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid measurement id"})
+		return
+	}
+	if ref.IsLegacy() {
+		utils.WriteJSON(w, http.StatusNotFound, utils.Envelope{"error": "measurement not found"})
+		return
+	}
+
+	err = bh.bodyMeasurementStore.DeleteMeasurement(middleware.GetUser(r).ID, ref.PublicID)
+	if errors.Is(err, sql.ErrNoRows) {
+		utils.WriteJSON(w, http.StatusNotFound, utils.Envelope{"error": "measurement not found"})
+		return
+	}
+	if err != nil {
+		bh.logger.Printf("ERROR: deleteMeasurement: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,260 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/kodega2016/femapi/internal/ical"
+	"github.com/kodega2016/femapi/internal/middleware"
+	"github.com/kodega2016/femapi/internal/store"
+	"github.com/kodega2016/femapi/internal/tokens"
+	"github.com/kodega2016/femapi/internal/utils"
+)
+
+const (
+	// feed tokens are long lived, calendar apps keep polling the same url
+	calendarFeedTTL = 5 * 365 * 24 * time.Hour
+	// how far back completed workouts and ahead planned sessions the feed reaches
+	feedHistory = 365 * 24 * time.Hour
+	feedAhead   = 365 * 24 * time.Hour
+	// workouts logged without a duration still need an end in calendar apps
+	defaultEventDuration = 30 * time.Minute
+)
+
+type CalendarHandler struct {
+	workoutStore        store.WorkoutStore
+	plannedWorkoutStore store.PlannedWorkoutStore
+	tokenStore          store.TokenStore
+	userStore           store.UserStore
+	settingsStore       store.SettingsStore
+	logger              *log.Logger
+}
+
+func NewCalendarHandler(workoutStore store.WorkoutStore, plannedWorkoutStore store.PlannedWorkoutStore, tokenStore store.TokenStore, userStore store.UserStore, settingsStore store.SettingsStore, logger *log.Logger) *CalendarHandler {
+	return &CalendarHandler{
+		workoutStore:        workoutStore,
+		plannedWorkoutStore: plannedWorkoutStore,
+		tokenStore:          tokenStore,
+		userStore:           userStore,
+		settingsStore:       settingsStore,
+		logger:              logger,
+	}
+}
+
+// CalendarDay holds what was done and what is planned on one day in the user's timezone
+type CalendarDay struct {
+	Date                string                 `json:"date"`
+	Workouts            []store.WorkoutSummary `json:"workouts"`
+	Planned             []store.PlannedWorkout `json:"planned"`
+	TotalDuration       int                    `json:"total_duration"`
+	TotalCalories       int                    `json:"total_calories"`
+	TotalDistanceMeters float64                `json:"total_distance_meters"`
+}
+
+// HandleGetCalendar returns every day of ?month=YYYY-MM, the current month by default, with
+// the workouts done and the sessions planned that day. Days follow the user's timezone, the
+// first weekday tells clients how to lay out the grid.
+func (ch *CalendarHandler) HandleGetCalendar(w http.ResponseWriter, r *http.Request) {
+	user := middleware.GetUser(r)
+	settings, ok := loadSettings(w, r, ch.settingsStore, ch.logger)
+	if !ok {
+		return
+	}
+	loc := settings.Location()
+
+	now := time.Now().In(loc)
+	from := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, loc)
+	if v := r.URL.Query().Get("month"); v != "" {
+		month, err := time.ParseInLocation("2006-01", v, loc)
+		if err != nil {
+			utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "month must be formatted as YYYY-MM"})
+			return
+		}
+		from = month
+	}
+	to := from.AddDate(0, 1, 0)
+
+	workouts, err := ch.workoutStore.ListWorkoutSummaries(user.ID, from, to)
+	if err != nil {
+		ch.logger.Printf("ERROR: listWorkoutSummaries: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+	plans, err := ch.plannedWorkoutStore.ListPlannedWorkouts(user.ID, from, to)
+	if err != nil {
+		ch.logger.Printf("ERROR: listPlannedWorkouts: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	days := []CalendarDay{}
+	index := make(map[string]int)
+	for day := from; day.Before(to); day = day.AddDate(0, 0, 1) {
+		date := day.Format("2006-01-02")
+		index[date] = len(days)
+		days = append(days, CalendarDay{Date: date, Workouts: []store.WorkoutSummary{}, Planned: []store.PlannedWorkout{}})
+	}
+	for _, workout := range workouts {
+		day := &days[index[workout.PerformedAt.In(loc).Format("2006-01-02")]]
+		day.Workouts = append(day.Workouts, workout)
+		day.TotalDuration += workout.DurationInMinutes
+		day.TotalCalories += workout.CaloriesBurned
+		if workout.DistanceMeters != nil {
+			day.TotalDistanceMeters += *workout.DistanceMeters
+		}
+	}
+	for _, planned := range plans {
+		day := &days[index[planned.ScheduledAt.In(loc).Format("2006-01-02")]]
+		day.Planned = append(day.Planned, planned)
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{
+		"month":      from.Format("2006-01"),
+		"timezone":   loc.String(),
+		"week_start": settings.WeekStart,
+		"days":       days,
+	})
+}
+
+// feedURL is the absolute url calendar apps subscribe to
+func feedURL(r *http.Request, token string) string {
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return fmt.Sprintf("%s://%s/calendar/%s.ics", scheme, r.Host, token)
+}
+
+// HandleCreateCalendarFeed issues the private feed url of the current user. Creating it again
+// rotates the token, so a leaked url can be revoked by requesting a new one.
+func (ch *CalendarHandler) HandleCreateCalendarFeed(w http.ResponseWriter, r *http.Request) {
+	user := middleware.GetUser(r)
+
+	err := ch.tokenStore.DeleteAllTokensForUser(user.ID, tokens.ScopeCalendar)
+	if err != nil {
+		ch.logger.Printf("ERROR: deleting calendar tokens: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+	token, err := ch.tokenStore.CreateNewToken(user.ID, calendarFeedTTL, tokens.ScopeCalendar)
+	if err != nil {
+		ch.logger.Printf("ERROR: creating calendar token: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+	utils.WriteJSON(w, http.StatusCreated, utils.Envelope{"feed": map[string]any{
+		"url":    feedURL(r, token.Plaintext),
+		"expiry": token.Expiry,
+	}})
+}
+
+// HandleDeleteCalendarFeed revokes the feed url, subscribed apps stop receiving updates
+func (ch *CalendarHandler) HandleDeleteCalendarFeed(w http.ResponseWriter, r *http.Request) {
+	err := ch.tokenStore.DeleteAllTokensForUser(middleware.GetUser(r).ID, tokens.ScopeCalendar)
+	if err != nil {
+		ch.logger.Printf("ERROR: deleting calendar tokens: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// workoutEvent describes a completed workout, e.g. "strength, 60 min, 420 kcal"
+func workoutEvent(workout store.WorkoutSummary) ical.Event {
+	duration := time.Duration(workout.DurationInMinutes) * time.Minute
+	if duration <= 0 {
+		duration = defaultEventDuration
+	}
+
+	details := []string{workout.Type}
+	if workout.DurationInMinutes > 0 {
+		details = append(details, fmt.Sprintf("%d min", workout.DurationInMinutes))
+	}
+	if workout.DistanceMeters != nil && *workout.DistanceMeters > 0 {
+		details = append(details, fmt.Sprintf("%.2f km", *workout.DistanceMeters/metersPerKilometer))
+	}
+	if workout.CaloriesBurned > 0 {
+		details = append(details, fmt.Sprintf("%d kcal", workout.CaloriesBurned))
+	}
+
+	return ical.Event{
+		UID:         "workout-" + workout.PublicID + "@femapi",
+		Summary:     workout.Title,
+		Description: strings.Join(details, ", "),
+		Start:       workout.PerformedAt,
+		End:         workout.PerformedAt.Add(duration),
+		Status:      ical.StatusConfirmed,
+		Updated:     workout.UpdatedAt,
+	}
+}
+
+func plannedEvent(planned store.PlannedWorkout) ical.Event {
+	return ical.Event{
+		UID:         "planned-" + planned.PublicID + "@femapi",
+		Summary:     planned.Title,
+		Description: planned.Notes,
+		Start:       planned.ScheduledAt,
+		End:         planned.ScheduledAt.Add(time.Duration(planned.DurationInMinutes) * time.Minute),
+		Status:      ical.StatusTentative,
+		Updated:     planned.UpdatedAt,
+	}
+}
+
+// HandleGetCalendarFeed serves the .ics feed, the token in the url is the only credential
+// since calendar apps cannot send an Authorization header
+func (ch *CalendarHandler) HandleGetCalendarFeed(w http.ResponseWriter, r *http.Request) {
+	user, err := ch.userStore.GetUserToken(tokens.ScopeCalendar, chi.URLParam(r, "token"))
+	if err != nil {
+		ch.logger.Printf("ERROR: getUserToken: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+	if user == nil {
+		utils.WriteJSON(w, http.StatusNotFound, utils.Envelope{"error": "calendar feed not found"})
+		return
+	}
+
+	settings, err := ch.settingsStore.GetSettings(user.ID)
+	if err != nil {
+		ch.logger.Printf("ERROR: getSettings: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	now := time.Now()
+	workouts, err := ch.workoutStore.ListWorkoutSummaries(user.ID, now.Add(-feedHistory), now.Add(feedAhead))
+	if err != nil {
+		ch.logger.Printf("ERROR: listWorkoutSummaries: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+	plans, err := ch.plannedWorkoutStore.ListPlannedWorkouts(user.ID, now.Add(-feedHistory), now.Add(feedAhead))
+	if err != nil {
+		ch.logger.Printf("ERROR: listPlannedWorkouts: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	calendar := ical.Calendar{
+		Name:     user.Username + " workouts",
+		Timezone: settings.Location().String(),
+		Events:   make([]ical.Event, 0, len(workouts)+len(plans)),
+	}
+	for _, workout := range workouts {
+		calendar.Events = append(calendar.Events, workoutEvent(workout))
+	}
+	for _, planned := range plans {
+		calendar.Events = append(calendar.Events, plannedEvent(planned))
+	}
+
+	w.Header().Set("Content-Type", ical.ContentType)
+	w.Header().Set("Cache-Control", "private, max-age=900")
+	err = calendar.Write(w)
+	if err != nil {
+		ch.logger.Printf("ERROR: writing calendar feed: %v", err)
+	}
+}
@@ -0,0 +1,60 @@
+package api
+
+import (
+	"time"
+
+	"github.com/kodega2016/femapi/internal/calories"
+	"github.com/kodega2016/femapi/internal/store"
+)
+
+// weightAt returns the body weight of the user at the given time, or the default weight when
+// the user never logged one
+func weightAt(bodyMeasurementStore store.BodyMeasurementStore, userID int, at time.Time) (float64, error) {
+	weight, err := bodyMeasurementStore.GetWeightAt(userID, at)
+	if err != nil {
+		return 0, err
+	}
+	if weight == nil {
+		return calories.DefaultWeightKg, nil
+	}
+	return weight.Value, nil
+}
+
+// workoutTime is the moment used to look up the body weight for a workout
+func workoutTime(workout *store.Workout) time.Time {
+	if !workout.PerformedAt.IsZero() {
+		return workout.PerformedAt
+	}
+	if workout.Activity != nil && workout.Activity.StartedAt != nil {
+		return *workout.Activity.StartedAt
+	}
+	return time.Now()
+}
+
+// applyCalories sets calories_burned: a value supplied by the client is kept as is, otherwise
+// workouts flagged as estimated get a fresh estimate from their current content
+func applyCalories(bodyMeasurementStore store.BodyMeasurementStore, workout *store.Workout, userID int, supplied *int) error {
+	if supplied != nil {
+		workout.CaloriesBurned = *supplied
+		workout.CaloriesEstimated = false
+		return nil
+	}
+	if !workout.CaloriesEstimated {
+		return nil
+	}
+
+	weightKg, err := weightAt(bodyMeasurementStore, userID, workoutTime(workout))
+	if err != nil {
+		return err
+	}
+	estimateCalories(workout, weightKg)
+	return nil
+}
+
+func estimateCalories(workout *store.Workout, weightKg float64) {
+	if workout.Activity != nil {
+		workout.Activity.ComputeDerived()
+	}
+	workout.CaloriesBurned = calories.Estimate(workout, weightKg)
+	workout.CaloriesEstimated = true
+}
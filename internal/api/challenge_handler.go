@@ -0,0 +1,507 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kodega2016/femapi/internal/challenges"
+	"github.com/kodega2016/femapi/internal/middleware"
+	"github.com/kodega2016/femapi/internal/store"
+	"github.com/kodega2016/femapi/internal/utils"
+)
+
+const (
+	maxChallengeLength     = 366 * 24 * time.Hour
+	defaultLeaderboardSize = 50
+	maxLeaderboardSize     = 200
+)
+
+type ChallengeHandler struct {
+	challengeStore       store.ChallengeStore
+	bodyMeasurementStore store.BodyMeasurementStore
+	settingsStore        store.SettingsStore
+	leaderboard          *challenges.Leaderboard
+	logger               *log.Logger
+}
+
+func NewChallengeHandler(challengeStore store.ChallengeStore, bodyMeasurementStore store.BodyMeasurementStore, settingsStore store.SettingsStore, leaderboard *challenges.Leaderboard, logger *log.Logger) *ChallengeHandler {
+	return &ChallengeHandler{
+		challengeStore:       challengeStore,
+		bodyMeasurementStore: bodyMeasurementStore,
+		settingsStore:        settingsStore,
+		leaderboard:          leaderboard,
+		logger:               logger,
+	}
+}
+
+type createChallengeRequest struct {
+	Title       string    `json:"title"`
+	Description string    `json:"description"`
+	Metric      string    `json:"metric"`
+	WorkoutType string    `json:"workout_type"`
+	TargetValue *float64  `json:"target_value"`
+	Unit        string    `json:"unit"`
+	Bracket     string    `json:"bracket"`
+	StartsAt    time.Time `json:"starts_at"`
+	EndsAt      time.Time `json:"ends_at"`
+}
+
+// participationRequest is the body of join and update, on update only the given fields change.
+// The birth year is only used to pick the age group and is not stored.
+type participationRequest struct {
+	Team       *string `json:"team"`
+	Visibility *string `json:"visibility"`
+	BirthYear  *int    `json:"birth_year"`
+}
+
+// challengeTarget converts the target to the unit the metric is scored in: volume targets are
+// given in kg or lbs, distance targets in m, km or mi and duration targets in min or h
+func challengeTarget(metric string, value float64, unit string) (float64, error) {
+	switch metric {
+	case store.ChallengeVolume:
+		return goalTarget(store.GoalLift, value, unit)
+	case store.ChallengeDistance:
+		return goalTarget(store.GoalDistance, value, unit)
+	case store.ChallengeDuration:
+		switch unit {
+		case "", "min":
+			return value, nil
+		case "h":
+			return value * 60, nil
+		}
+		return 0, errors.New("unit of duration challenges must be min or h")
+	}
+	if unit != "" && unit != store.ChallengeUnit(metric) {
+		return 0, errors.New("workouts challenges count workouts and take no unit")
+	}
+	return value, nil
+}
+
+func (req *createChallengeRequest) challenge() (*store.Challenge, error) {
+	challenge := &store.Challenge{
+		Title:       strings.TrimSpace(req.Title),
+		Description: strings.TrimSpace(req.Description),
+		Metric:      req.Metric,
+		WorkoutType: req.WorkoutType,
+		Bracket:     req.Bracket,
+		StartsAt:    req.StartsAt.UTC(),
+		EndsAt:      req.EndsAt.UTC(),
+	}
+	if challenge.Bracket == "" {
+		challenge.Bracket = store.BracketNone
+	}
+
+	switch {
+	case challenge.Title == "":
+		return nil, errors.New("title is required")
+	case len(challenge.Title) > 255:
+		return nil, errors.New("title cannot be greater than 255 characters")
+	case !store.IsValidChallengeMetric(challenge.Metric):
+		return nil, errors.New("metric must be volume, distance, duration or workouts")
+	case challenge.WorkoutType != "" && !store.IsValidWorkoutType(challenge.WorkoutType):
+		return nil, errors.New("invalid workout type")
+	case challenge.Bracket != store.BracketNone && challenge.Bracket != store.BracketWeightClass && challenge.Bracket != store.BracketAgeGroup:
+		return nil, errors.New("bracket must be none, weight_class or age_group")
+	case req.StartsAt.IsZero() || req.EndsAt.IsZero():
+		return nil, errors.New("starts_at and ends_at are required")
+	case !challenge.EndsAt.After(challenge.StartsAt):
+		return nil, errors.New("ends_at must be after starts_at")
+	case challenge.EndsAt.Sub(challenge.StartsAt) > maxChallengeLength:
+		return nil, errors.New("challenges cannot last longer than a year")
+	case !challenge.EndsAt.After(time.Now()):
+		return nil, errors.New("ends_at must be in the future")
+	}
+	if challenge.Metric == store.ChallengeDistance && challenge.WorkoutType != "" && !store.IsCardioType(challenge.WorkoutType) {
+		return nil, errors.New("distance challenges need a workout_type of run, ride, swim or row")
+	}
+
+	if req.TargetValue != nil {
+		if *req.TargetValue <= 0 {
+			return nil, errors.New("target_value must be greater than 0")
+		}
+		target, err := challengeTarget(challenge.Metric, *req.TargetValue, req.Unit)
+		if err != nil {
+			return nil, err
+		}
+		target = math.Round(target*100) / 100
+		challenge.TargetValue = &target
+	}
+	return challenge, nil
+}
+
+func validateParticipation(participant *store.Participant) error {
+	switch participant.Visibility {
+	case store.ParticipantPublic, store.ParticipantAnonymous, store.ParticipantHidden:
+	default:
+		return errors.New("visibility must be public, anonymous or hidden")
+	}
+	if len(participant.Team) > 50 {
+		return errors.New("team cannot be greater than 50 characters")
+	}
+	return nil
+}
+
+// loadChallenge resolves the {challengeID} url param
+func (ch *ChallengeHandler) loadChallenge(w http.ResponseWriter, r *http.Request) (*store.Challenge, bool) {
+	ref, err := utils.ReadRefParam(r, "challengeID")
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid challenge id"})
+		return nil, false
+	}
+	// challenges never had numeric ids
+	if ref.IsLegacy() {
+		utils.WriteJSON(w, http.StatusNotFound, utils.Envelope{"error": "challenge not found"})
+		return nil, false
+	}
+
+	challenge, err := ch.challengeStore.GetChallenge(ref.PublicID)
+	if errors.Is(err, sql.ErrNoRows) {
+		utils.WriteJSON(w, http.StatusNotFound, utils.Envelope{"error": "challenge not found"})
+		return nil, false
+	}
+	if err != nil {
+		ch.logger.Printf("ERROR: getChallenge: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return nil, false
+	}
+	return challenge, true
+}
+
+// participation returns the standing of the current user, nil when they did not join
+func (ch *ChallengeHandler) participation(challenge *store.Challenge, userID int) (*store.Participant, error) {
+	participant, err := ch.challengeStore.GetParticipant(challenge.ID, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return participant, err
+}
+
+// anonymize hides who is behind the standings of anonymous participants, except from themselves
+func anonymize(standings []store.Participant, userID int) {
+	for i := range standings {
+		if standings[i].Visibility == store.ParticipantAnonymous && standings[i].UserID != userID {
+			standings[i].UserPublicID = ""
+			standings[i].Username = ""
+		}
+	}
+}
+
+func (ch *ChallengeHandler) HandleCreateChallenge(w http.ResponseWriter, r *http.Request) {
+	var req createChallengeRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid request payload"})
+		return
+	}
+
+	challenge, err := req.challenge()
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": err.Error()})
+		return
+	}
+	challenge.CreatedBy = middleware.GetUser(r).ID
+
+	err = ch.challengeStore.CreateChallenge(challenge)
+	if err != nil {
+		ch.logger.Printf("ERROR: createChallenge: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+	utils.WriteJSON(w, http.StatusCreated, utils.Envelope{"challenge": challenge})
+}
+
+// HandleListChallenges lists every challenge, optionally filtered by ?status=
+func (ch *ChallengeHandler) HandleListChallenges(w http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+	if status != "" && status != store.ChallengeUpcoming && status != store.ChallengeActive && status != store.ChallengeFinished {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "status must be upcoming, active or finished"})
+		return
+	}
+
+	list, err := ch.challengeStore.ListChallenges(status, time.Now())
+	if err != nil {
+		ch.logger.Printf("ERROR: listChallenges: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"challenges": list})
+}
+
+// HandleGetChallenge returns the challenge and the standing of the current user if they joined
+func (ch *ChallengeHandler) HandleGetChallenge(w http.ResponseWriter, r *http.Request) {
+	challenge, ok := ch.loadChallenge(w, r)
+	if !ok {
+		return
+	}
+
+	participant, err := ch.participation(challenge, middleware.GetUser(r).ID)
+	if err != nil {
+		ch.logger.Printf("ERROR: getParticipant: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"challenge": challenge, "participation": participant})
+}
+
+// HandleDeleteChallenge lets the creator remove a challenge
+func (ch *ChallengeHandler) HandleDeleteChallenge(w http.ResponseWriter, r *http.Request) {
+	ref, err := utils.ReadRefParam(r, "challengeID")
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid challenge id"})
+		return
+	}
+	if ref.IsLegacy() {
+		utils.WriteJSON(w, http.StatusNotFound, utils.Envelope{"error": "challenge not found"})
+		return
+	}
+
+	err = ch.challengeStore.DeleteChallenge(middleware.GetUser(r).ID, ref.PublicID)
+	if errors.Is(err, sql.ErrNoRows) {
+		utils.WriteJSON(w, http.StatusNotFound, utils.Envelope{"error": "challenge not found"})
+		return
+	}
+	if err != nil {
+		ch.logger.Printf("ERROR: deleteChallenge: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleJoinChallenge adds the current user to the challenge and scores the workouts they
+// already did in its window. The bracket is fixed when joining: weight classes use the body
+// weight logged closest to the start, age groups the birth year given in the request.
+func (ch *ChallengeHandler) HandleJoinChallenge(w http.ResponseWriter, r *http.Request) {
+	challenge, ok := ch.loadChallenge(w, r)
+	if !ok {
+		return
+	}
+	if challenge.Status == store.ChallengeFinished {
+		utils.WriteJSON(w, http.StatusConflict, utils.Envelope{"error": "the challenge is over"})
+		return
+	}
+
+	var req participationRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid request payload"})
+		return
+	}
+
+	settings, ok := loadSettings(w, r, ch.settingsStore, ch.logger)
+	if !ok {
+		return
+	}
+
+	user := middleware.GetUser(r)
+	participant := &store.Participant{
+		ChallengeID: challenge.ID,
+		UserID:      user.ID,
+		Visibility:  settings.DefaultChallengeVisibility,
+	}
+	if req.Team != nil {
+		participant.Team = strings.TrimSpace(*req.Team)
+	}
+	if req.Visibility != nil {
+		participant.Visibility = *req.Visibility
+	}
+	err = validateParticipation(participant)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": err.Error()})
+		return
+	}
+
+	switch challenge.Bracket {
+	case store.BracketWeightClass:
+		weight, err := ch.bodyMeasurementStore.GetWeightAt(user.ID, challenge.StartsAt)
+		if err != nil {
+			ch.logger.Printf("ERROR: getWeightAt: %v", err)
+			utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+			return
+		}
+		if weight == nil {
+			utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "log your body weight to join a challenge with weight classes"})
+			return
+		}
+		participant.Bracket = challenges.WeightClass(weight.Value)
+	case store.BracketAgeGroup:
+		if req.BirthYear == nil || *req.BirthYear < 1900 || *req.BirthYear > time.Now().Year() {
+			utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "a valid birth_year is required to join a challenge with age groups"})
+			return
+		}
+		participant.Bracket = challenges.AgeGroup(*req.BirthYear, challenge.StartsAt)
+	}
+
+	err = ch.challengeStore.JoinChallenge(participant)
+	if errors.Is(err, store.ErrAlreadyJoined) {
+		utils.WriteJSON(w, http.StatusConflict, utils.Envelope{"error": "you already joined this challenge"})
+		return
+	}
+	if err != nil {
+		ch.logger.Printf("ERROR: joinChallenge: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	err = ch.leaderboard.Refresh(challenge, user.ID)
+	if err != nil {
+		ch.logger.Printf("ERROR: refreshing challenge standing: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+	participant, err = ch.challengeStore.GetParticipant(challenge.ID, user.ID)
+	if err != nil {
+		ch.logger.Printf("ERROR: getParticipant: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+	utils.WriteJSON(w, http.StatusCreated, utils.Envelope{"participation": participant})
+}
+
+// HandleUpdateParticipation changes the team or the leaderboard visibility of the current user
+func (ch *ChallengeHandler) HandleUpdateParticipation(w http.ResponseWriter, r *http.Request) {
+	challenge, ok := ch.loadChallenge(w, r)
+	if !ok {
+		return
+	}
+	userID := middleware.GetUser(r).ID
+	participant, err := ch.participation(challenge, userID)
+	if err != nil {
+		ch.logger.Printf("ERROR: getParticipant: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+	if participant == nil {
+		utils.WriteJSON(w, http.StatusNotFound, utils.Envelope{"error": "you did not join this challenge"})
+		return
+	}
+
+	var req participationRequest
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid request payload"})
+		return
+	}
+	if req.BirthYear != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "the bracket cannot change after joining"})
+		return
+	}
+	if req.Team != nil {
+		participant.Team = strings.TrimSpace(*req.Team)
+	}
+	if req.Visibility != nil {
+		participant.Visibility = *req.Visibility
+	}
+	err = validateParticipation(participant)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": err.Error()})
+		return
+	}
+
+	err = ch.challengeStore.UpdateParticipant(participant)
+	if err == nil {
+		// hiding or showing a participant shifts everyone ranked below them
+		err = ch.challengeStore.RankChallenge(challenge.ID)
+	}
+	if err == nil {
+		participant, err = ch.challengeStore.GetParticipant(challenge.ID, userID)
+	}
+	if err != nil {
+		ch.logger.Printf("ERROR: updateParticipant: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"participation": participant})
+}
+
+func (ch *ChallengeHandler) HandleLeaveChallenge(w http.ResponseWriter, r *http.Request) {
+	challenge, ok := ch.loadChallenge(w, r)
+	if !ok {
+		return
+	}
+
+	err := ch.challengeStore.LeaveChallenge(challenge.ID, middleware.GetUser(r).ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		utils.WriteJSON(w, http.StatusNotFound, utils.Envelope{"error": "you did not join this challenge"})
+		return
+	}
+	if err == nil {
+		err = ch.challengeStore.RankChallenge(challenge.ID)
+	}
+	if err != nil {
+		ch.logger.Printf("ERROR: leaveChallenge: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleGetLeaderboard returns a page of the stored standings, ?bracket= limits them to one
+// bracket and ranks within it. The standing of the current user is included even when they
+// are not on the page or hidden.
+func (ch *ChallengeHandler) HandleGetLeaderboard(w http.ResponseWriter, r *http.Request) {
+	challenge, ok := ch.loadChallenge(w, r)
+	if !ok {
+		return
+	}
+
+	query := r.URL.Query()
+	limit := defaultLeaderboardSize
+	if v := query.Get("limit"); v != "" {
+		parsed, err := strconv.Atoi(v)
+		if err != nil || parsed <= 0 || parsed > maxLeaderboardSize {
+			utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "limit must be between 1 and 200"})
+			return
+		}
+		limit = parsed
+	}
+	offset := 0
+	if v := query.Get("offset"); v != "" {
+		parsed, err := strconv.Atoi(v)
+		if err != nil || parsed < 0 {
+			utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "offset must be 0 or greater"})
+			return
+		}
+		offset = parsed
+	}
+
+	userID := middleware.GetUser(r).ID
+	standings, err := ch.challengeStore.ListStandings(challenge.ID, query.Get("bracket"), limit, offset)
+	if err != nil {
+		ch.logger.Printf("ERROR: listStandings: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+	anonymize(standings, userID)
+
+	participant, err := ch.participation(challenge, userID)
+	if err != nil {
+		ch.logger.Printf("ERROR: getParticipant: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"challenge": challenge, "leaderboard": standings, "me": participant})
+}
+
+// HandleGetTeamLeaderboard ranks the teams of the challenge
+func (ch *ChallengeHandler) HandleGetTeamLeaderboard(w http.ResponseWriter, r *http.Request) {
+	challenge, ok := ch.loadChallenge(w, r)
+	if !ok {
+		return
+	}
+
+	teams, err := ch.challengeStore.ListTeamStandings(challenge.ID)
+	if err != nil {
+		ch.logger.Printf("ERROR: listTeamStandings: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"challenge": challenge, "teams": teams})
+}
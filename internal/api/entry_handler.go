@@ -0,0 +1,325 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/kodega2016/femapi/internal/middleware"
+	"github.com/kodega2016/femapi/internal/patch"
+	"github.com/kodega2016/femapi/internal/publicid"
+	"github.com/kodega2016/femapi/internal/store"
+	"github.com/kodega2016/femapi/internal/utils"
+)
+
+// loadOwnedWorkout resolves the workout from the url and makes sure the current user owns it.
+// When requireIfMatch is false a supplied If-Match header is still honoured.
+func (wh *WorkoutHandler) loadOwnedWorkout(w http.ResponseWriter, r *http.Request, requireIfMatch bool) (*store.Workout, bool) {
+	workoutID, ok := readWorkoutID(w, r, wh.workoutStore, wh.logger)
+	if !ok {
+		return nil, false
+	}
+
+	workout, err := wh.workoutStore.GetWorkoutByID(workoutID)
+	if errors.Is(err, sql.ErrNoRows) {
+		utils.WriteJSON(w, http.StatusNotFound, utils.Envelope{"error": "workout not found"})
+		return nil, false
+	}
+	if err != nil {
+		wh.logger.Printf("ERROR: getWorkoutByID: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return nil, false
+	}
+
+	// someone else's workout is reported as missing, like on GET, so its id cannot be probed
+	if workout.UserID != middleware.GetUser(r).ID {
+		utils.WriteJSON(w, http.StatusNotFound, utils.Envelope{"error": "workout not found"})
+		return nil, false
+	}
+
+	if (requireIfMatch || r.Header.Get("If-Match") != "") && !checkIfMatch(w, r, workout) {
+		return nil, false
+	}
+	return workout, true
+}
+
+// saveWorkout persists the workout and writes the updated representation
+func (wh *WorkoutHandler) saveWorkout(w http.ResponseWriter, r *http.Request, workout *store.Workout) {
+	normalizeWorkout(workout)
+	err := validateWorkout(workout)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": err.Error()})
+		return
+	}
+
+	// estimated calories follow the edited entries
+	err = applyCalories(wh.bodyMeasurementStore, workout, workout.UserID, nil)
+	if err != nil {
+		wh.logger.Printf("ERROR: applyCalories: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	err = wh.workoutStore.UpdateWorkout(workout, middleware.GetUser(r).ID)
+	if errors.Is(err, store.ErrEditConflict) {
+		utils.WriteJSON(w, http.StatusPreconditionFailed, utils.Envelope{"error": "workout has been modified, fetch the latest version and retry"})
+		return
+	}
+	if errors.Is(err, store.ErrEntryNotFound) {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "unknown entry id"})
+		return
+	}
+	if err != nil {
+		wh.logger.Printf("ERROR: updateWorkout: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	updated, err := wh.workoutStore.GetWorkoutByID(int64(workout.ID))
+	if err != nil {
+		wh.logger.Printf("ERROR: getWorkoutByID: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+	w.Header().Set("ETag", workoutETag(updated))
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"workout": updated})
+}
+
+// findEntry returns the index of the entry referenced by the {entryID} url param
+func findEntry(r *http.Request, workout *store.Workout) (int, bool) {
+	ref, err := publicid.Parse(chi.URLParam(r, "entryID"))
+	if err != nil {
+		return 0, false
+	}
+	for i, entry := range workout.Entries {
+		if (ref.IsLegacy() && int64(entry.ID) == ref.LegacyID) || (!ref.IsLegacy() && entry.PublicID == ref.PublicID) {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+func (wh *WorkoutHandler) HandleCreateEntry(w http.ResponseWriter, r *http.Request) {
+	workout, ok := wh.loadOwnedWorkout(w, r, false)
+	if !ok {
+		return
+	}
+
+	var entry store.WorkoutEntry
+	err := json.NewDecoder(r.Body).Decode(&entry)
+	if err != nil {
+		wh.logger.Printf("ERROR: decodingCreateEntry: %v", err)
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid request payload"})
+		return
+	}
+
+	// the server owns entry ids, and new entries go last unless a position was given
+	entry.PublicID = ""
+	if entry.OrderIndex == 0 {
+		for _, existing := range workout.Entries {
+			if existing.OrderIndex >= entry.OrderIndex {
+				entry.OrderIndex = existing.OrderIndex + 1
+			}
+		}
+	}
+	workout.Entries = append(workout.Entries, entry)
+
+	wh.saveWorkout(w, r, workout)
+}
+
+// HandleUpdateEntry applies a JSON merge patch to a single entry
+func (wh *WorkoutHandler) HandleUpdateEntry(w http.ResponseWriter, r *http.Request) {
+	workout, ok := wh.loadOwnedWorkout(w, r, false)
+	if !ok {
+		return
+	}
+
+	index, ok := findEntry(r, workout)
+	if !ok {
+		utils.WriteJSON(w, http.StatusNotFound, utils.Envelope{"error": "entry not found"})
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid request payload"})
+		return
+	}
+
+	current, err := json.Marshal(workout.Entries[index])
+	if err != nil {
+		wh.logger.Printf("ERROR: encodingEntry: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	patched, err := patch.MergePatch(current, body)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": err.Error()})
+		return
+	}
+
+	var entry store.WorkoutEntry
+	err = json.Unmarshal(patched, &entry)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid request payload"})
+		return
+	}
+
+	// the id is not patchable
+	entry.ID = workout.Entries[index].ID
+	entry.PublicID = workout.Entries[index].PublicID
+	workout.Entries[index] = entry
+
+	wh.saveWorkout(w, r, workout)
+}
+
+func (wh *WorkoutHandler) HandleDeleteEntry(w http.ResponseWriter, r *http.Request) {
+	workout, ok := wh.loadOwnedWorkout(w, r, false)
+	if !ok {
+		return
+	}
+
+	index, ok := findEntry(r, workout)
+	if !ok {
+		utils.WriteJSON(w, http.StatusNotFound, utils.Envelope{"error": "entry not found"})
+		return
+	}
+
+	workout.Entries = append(workout.Entries[:index], workout.Entries[index+1:]...)
+	wh.saveWorkout(w, r, workout)
+}
+
+// HandleReorderEntries takes the complete list of entry ids in their new order
+func (wh *WorkoutHandler) HandleReorderEntries(w http.ResponseWriter, r *http.Request) {
+	workout, ok := wh.loadOwnedWorkout(w, r, false)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		EntryIDs []string `json:"entry_ids"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid request payload"})
+		return
+	}
+
+	if len(req.EntryIDs) != len(workout.Entries) {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "entry_ids must list every entry of the workout exactly once"})
+		return
+	}
+
+	byID := make(map[string]store.WorkoutEntry, len(workout.Entries))
+	for _, entry := range workout.Entries {
+		byID[entry.PublicID] = entry
+	}
+
+	reordered := make([]store.WorkoutEntry, 0, len(req.EntryIDs))
+	for i, id := range req.EntryIDs {
+		entry, ok := byID[id]
+		if !ok {
+			utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "entry_ids must list every entry of the workout exactly once"})
+			return
+		}
+		delete(byID, id)
+		entry.OrderIndex = i + 1
+		reordered = append(reordered, entry)
+	}
+	workout.Entries = reordered
+
+	wh.saveWorkout(w, r, workout)
+}
+
+// HandlePatchWorkout accepts either a JSON Merge Patch or a JSON Patch document,
+// selected by the request Content-Type
+func (wh *WorkoutHandler) HandlePatchWorkout(w http.ResponseWriter, r *http.Request) {
+	contentType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if contentType != patch.MergePatchContentType && contentType != patch.JSONPatchContentType {
+		utils.WriteJSON(w, http.StatusUnsupportedMediaType, utils.Envelope{
+			"error": "content type must be " + patch.MergePatchContentType + " or " + patch.JSONPatchContentType,
+		})
+		return
+	}
+
+	workout, ok := wh.loadOwnedWorkout(w, r, true)
+	if !ok {
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid request payload"})
+		return
+	}
+
+	current, err := json.Marshal(workout)
+	if err != nil {
+		wh.logger.Printf("ERROR: encodingWorkout: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	var patched []byte
+	if contentType == patch.MergePatchContentType {
+		patched, err = patch.MergePatch(current, body)
+	} else {
+		patched, err = patch.JSONPatch(current, body)
+	}
+	if errors.Is(err, patch.ErrTestFailed) {
+		utils.WriteJSON(w, http.StatusConflict, utils.Envelope{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": err.Error()})
+		return
+	}
+
+	var result store.Workout
+	err = json.Unmarshal(patched, &result)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "patched workout is invalid"})
+		return
+	}
+
+	if !store.IsValidVisibility(result.Visibility) {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid workout visibility"})
+		return
+	}
+
+	// a changed calories_burned is user supplied, removing it or setting calories_estimated
+	// hands it back to the estimator
+	var fields map[string]json.RawMessage
+	err = json.Unmarshal(patched, &fields)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "patched workout is invalid"})
+		return
+	}
+	_, hasCalories := fields["calories_burned"]
+	switch {
+	case hasCalories && result.CaloriesBurned != workout.CaloriesBurned:
+		workout.CaloriesBurned = result.CaloriesBurned
+		workout.CaloriesEstimated = false
+	case !hasCalories || result.CaloriesEstimated:
+		workout.CaloriesEstimated = true
+	}
+
+	// only user editable fields are taken from the patched document
+	workout.Title = result.Title
+	workout.Description = result.Description
+	workout.DurationInMinutes = result.DurationInMinutes
+	workout.Type = result.Type
+	workout.Visibility = result.Visibility
+	workout.Activity = result.Activity
+	workout.Entries = result.Entries
+	if !result.PerformedAt.IsZero() {
+		workout.PerformedAt = result.PerformedAt.UTC()
+	}
+
+	wh.saveWorkout(w, r, workout)
+}
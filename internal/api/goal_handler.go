@@ -0,0 +1,349 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/kodega2016/femapi/internal/goals"
+	"github.com/kodega2016/femapi/internal/metrics"
+	"github.com/kodega2016/femapi/internal/middleware"
+	"github.com/kodega2016/femapi/internal/store"
+	"github.com/kodega2016/femapi/internal/utils"
+)
+
+const (
+	metersPerKilometer = 1000
+	metersPerMile      = 1609.344
+)
+
+type GoalHandler struct {
+	goalStore store.GoalStore
+	tracker   *goals.Tracker
+	logger    *log.Logger
+}
+
+func NewGoalHandler(goalStore store.GoalStore, tracker *goals.Tracker, logger *log.Logger) *GoalHandler {
+	return &GoalHandler{
+		goalStore: goalStore,
+		tracker:   tracker,
+		logger:    logger,
+	}
+}
+
+// goalRequest is the body of both create and update, on update only the given fields change.
+// Deadline is kept raw so an explicit null can remove it.
+type goalRequest struct {
+	Kind         string          `json:"kind"`
+	Title        *string         `json:"title"`
+	ExerciseName *string         `json:"exercise_name"`
+	WorkoutType  *string         `json:"workout_type"`
+	Period       *string         `json:"period"`
+	TargetValue  *float64        `json:"target_value"`
+	Unit         string          `json:"unit"`
+	StartsAt     *time.Time      `json:"starts_at"`
+	Deadline     json.RawMessage `json:"deadline"`
+}
+
+// goalTarget converts the target to the unit the goal kind is stored in: lift targets are
+// given in kg or lbs, distance targets in m, km or mi
+func goalTarget(kind string, value float64, unit string) (float64, error) {
+	switch kind {
+	case store.GoalLift:
+		return metrics.ToCanonical(store.MeasurementWeight, value, unit)
+	case store.GoalDistance:
+		switch unit {
+		case "", "m":
+			return value, nil
+		case "km":
+			return value * metersPerKilometer, nil
+		case "mi":
+			return value * metersPerMile, nil
+		}
+		return 0, errors.New("unit of distance goals must be m, km or mi")
+	}
+	if unit != "" && unit != store.GoalUnit(kind) {
+		return 0, errors.New("frequency goals count workouts and take no unit")
+	}
+	return value, nil
+}
+
+// apply copies the given fields of the request onto the goal
+func (req *goalRequest) apply(goal *store.Goal) error {
+	if req.Title != nil {
+		goal.Title = strings.TrimSpace(*req.Title)
+	}
+	if req.ExerciseName != nil {
+		goal.ExerciseName = strings.TrimSpace(*req.ExerciseName)
+	}
+	if req.WorkoutType != nil {
+		goal.WorkoutType = *req.WorkoutType
+	}
+	if req.Period != nil {
+		goal.Period = *req.Period
+	}
+	if req.TargetValue != nil {
+		target, err := goalTarget(goal.Kind, *req.TargetValue, req.Unit)
+		if err != nil {
+			return err
+		}
+		goal.TargetValue = math.Round(target*100) / 100
+	}
+	if req.StartsAt != nil {
+		goal.StartsAt = req.StartsAt.UTC()
+	}
+	if len(req.Deadline) > 0 {
+		var deadline *time.Time
+		err := json.Unmarshal(req.Deadline, &deadline)
+		if err != nil {
+			return errors.New("deadline must be an RFC 3339 timestamp or null")
+		}
+		if deadline != nil {
+			utc := deadline.UTC()
+			deadline = &utc
+		}
+		goal.Deadline = deadline
+	}
+	return nil
+}
+
+// validateGoal checks the goal and fills in what the kind implies, including the default title
+func validateGoal(goal *store.Goal) error {
+	if goal.TargetValue <= 0 {
+		return errors.New("target_value must be greater than 0")
+	}
+	if utf8.RuneCountInString(goal.ExerciseName) > 255 {
+		return errors.New("exercise_name cannot be greater than 255 characters")
+	}
+	if goal.Deadline != nil && !goal.Deadline.After(goal.StartsAt) {
+		return errors.New("deadline must be after starts_at")
+	}
+
+	switch goal.Kind {
+	case store.GoalLift:
+		if goal.ExerciseName == "" {
+			return errors.New("lift goals need an exercise_name")
+		}
+		goal.WorkoutType, goal.Period = "", ""
+	case store.GoalDistance:
+		if !store.IsCardioType(goal.WorkoutType) {
+			return errors.New("distance goals need a workout_type of run, ride, swim or row")
+		}
+		goal.ExerciseName, goal.Period = "", ""
+	case store.GoalFrequency:
+		if goal.Period == "" {
+			goal.Period = store.PeriodWeek
+		}
+		if goal.Period != store.PeriodWeek && goal.Period != store.PeriodMonth {
+			return errors.New("period must be week or month")
+		}
+		if goal.WorkoutType != "" && !store.IsValidWorkoutType(goal.WorkoutType) {
+			return errors.New("invalid workout type")
+		}
+		if goal.TargetValue != math.Trunc(goal.TargetValue) {
+			return errors.New("target_value of frequency goals must be a whole number of workouts")
+		}
+		goal.ExerciseName = ""
+	}
+
+	// the default title is checked too, it holds the exercise name
+	if goal.Title == "" {
+		goal.Title = defaultGoalTitle(goal)
+	}
+	if utf8.RuneCountInString(goal.Title) > 255 {
+		return errors.New("title cannot be greater than 255 characters")
+	}
+	return nil
+}
+
+// defaultGoalTitle describes the goal, e.g. "Bench Press 100 kg" or "3 workouts per week"
+func defaultGoalTitle(goal *store.Goal) string {
+	switch goal.Kind {
+	case store.GoalLift:
+		return fmt.Sprintf("%s %g kg", goal.ExerciseName, goal.TargetValue)
+	case store.GoalDistance:
+		return fmt.Sprintf("%s %g km", strings.ToUpper(goal.WorkoutType[:1])+goal.WorkoutType[1:], goal.TargetValue/metersPerKilometer)
+	}
+	workouts := "workouts"
+	if goal.WorkoutType != "" {
+		workouts = goal.WorkoutType + " workouts"
+	}
+	return fmt.Sprintf("%g %s per %s", goal.TargetValue, workouts, goal.Period)
+}
+
+// loadGoal resolves the {goalID} url param to a goal of the current user
+func (gh *GoalHandler) loadGoal(w http.ResponseWriter, r *http.Request) (*store.Goal, bool) {
+	ref, err := utils.ReadRefParam(r, "goalID")
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid goal id"})
+		return nil, false
+	}
+	// goals never had numeric ids
+	if ref.IsLegacy() {
+		utils.WriteJSON(w, http.StatusNotFound, utils.Envelope{"error": "goal not found"})
+		return nil, false
+	}
+
+	goal, err := gh.goalStore.GetGoal(middleware.GetUser(r).ID, ref.PublicID)
+	if errors.Is(err, sql.ErrNoRows) {
+		utils.WriteJSON(w, http.StatusNotFound, utils.Envelope{"error": "goal not found"})
+		return nil, false
+	}
+	if err != nil {
+		gh.logger.Printf("ERROR: getGoal: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return nil, false
+	}
+	return goal, true
+}
+
+// HandleCreateGoal creates a goal and evaluates it against the workouts logged so far
+func (gh *GoalHandler) HandleCreateGoal(w http.ResponseWriter, r *http.Request) {
+	var req goalRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid request payload"})
+		return
+	}
+	if !store.IsValidGoalKind(req.Kind) {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "kind must be lift, distance or frequency"})
+		return
+	}
+	if req.TargetValue == nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "target_value is required"})
+		return
+	}
+
+	goal := &store.Goal{
+		UserID:   middleware.GetUser(r).ID,
+		Kind:     req.Kind,
+		StartsAt: time.Now().UTC(),
+	}
+	err = req.apply(goal)
+	if err == nil {
+		err = validateGoal(goal)
+	}
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": err.Error()})
+		return
+	}
+	err = gh.goalStore.CreateGoal(goal)
+	if err != nil {
+		gh.logger.Printf("ERROR: createGoal: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+	err = gh.tracker.Evaluate(goal)
+	if err != nil {
+		gh.logger.Printf("ERROR: evaluating goal: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+	utils.WriteJSON(w, http.StatusCreated, utils.Envelope{"goal": goal})
+}
+
+// HandleListGoals lists the goals of the current user, optionally filtered by ?status=
+func (gh *GoalHandler) HandleListGoals(w http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+	if status != "" && status != store.GoalActive && status != store.GoalCompleted && status != store.GoalExpired {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "status must be active, completed or expired"})
+		return
+	}
+
+	userGoals, err := gh.goalStore.ListGoals(middleware.GetUser(r).ID)
+	if err != nil {
+		gh.logger.Printf("ERROR: listGoals: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	filtered := make([]store.Goal, 0, len(userGoals))
+	for _, goal := range userGoals {
+		if status == "" || goal.Status == status {
+			filtered = append(filtered, goal)
+		}
+	}
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"goals": filtered})
+}
+
+func (gh *GoalHandler) HandleGetGoal(w http.ResponseWriter, r *http.Request) {
+	goal, ok := gh.loadGoal(w, r)
+	if !ok {
+		return
+	}
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"goal": goal})
+}
+
+// HandleUpdateGoal changes the given fields and re-evaluates the goal, its kind is fixed
+func (gh *GoalHandler) HandleUpdateGoal(w http.ResponseWriter, r *http.Request) {
+	goal, ok := gh.loadGoal(w, r)
+	if !ok {
+		return
+	}
+
+	var req goalRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid request payload"})
+		return
+	}
+	if req.Kind != "" && req.Kind != goal.Kind {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "the kind of a goal cannot change"})
+		return
+	}
+
+	err = req.apply(goal)
+	if err == nil {
+		err = validateGoal(goal)
+	}
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": err.Error()})
+		return
+	}
+	err = gh.goalStore.UpdateGoal(goal)
+	if err != nil {
+		gh.logger.Printf("ERROR: updateGoal: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+	// a new target or time frame changes what counts as completed
+	goal.CompletedAt = nil
+	err = gh.tracker.Evaluate(goal)
+	if err != nil {
+		gh.logger.Printf("ERROR: evaluating goal: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"goal": goal})
+}
+
+func (gh *GoalHandler) HandleDeleteGoal(w http.ResponseWriter, r *http.Request) {
+	ref, err := utils.ReadRefParam(r, "goalID")
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid goal id"})
+		return
+	}
+	if ref.IsLegacy() {
+		utils.WriteJSON(w, http.StatusNotFound, utils.Envelope{"error": "goal not found"})
+		return
+	}
+
+	err = gh.goalStore.DeleteGoal(middleware.GetUser(r).ID, ref.PublicID)
+	if errors.Is(err, sql.ErrNoRows) {
+		utils.WriteJSON(w, http.StatusNotFound, utils.Envelope{"error": "goal not found"})
+		return
+	}
+	if err != nil {
+		gh.logger.Printf("ERROR: deleteGoal: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
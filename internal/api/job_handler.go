@@ -0,0 +1,147 @@
+package api
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/kodega2016/femapi/internal/achievements"
+	"github.com/kodega2016/femapi/internal/jobs"
+	"github.com/kodega2016/femapi/internal/store"
+	"github.com/kodega2016/femapi/internal/utils"
+)
+
+const (
+	defaultJobLimit = 50
+	maxJobLimit     = 200
+)
+
+// JobHandler lets admins look into the background job queue and start the jobs run on demand
+type JobHandler struct {
+	jobStore store.JobStore
+	queue    *jobs.Queue
+	logger   *log.Logger
+}
+
+func NewJobHandler(jobStore store.JobStore, queue *jobs.Queue, logger *log.Logger) *JobHandler {
+	return &JobHandler{
+		jobStore: jobStore,
+		queue:    queue,
+		logger:   logger,
+	}
+}
+
+// readJobID reads the {jobID} url param, it writes the error response itself
+func readJobID(w http.ResponseWriter, r *http.Request) (string, bool) {
+	ref, err := utils.ReadRefParam(r, "jobID")
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid job id"})
+		return "", false
+	}
+	// jobs never had numeric ids
+	if ref.IsLegacy() {
+		utils.WriteJSON(w, http.StatusNotFound, utils.Envelope{"error": "job not found"})
+		return "", false
+	}
+	return ref.PublicID, true
+}
+
+// HandleListJobs lists jobs newest first, ?status= and ?kind= filter them
+func (jh *JobHandler) HandleListJobs(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	status := query.Get("status")
+	switch status {
+	case "", store.JobQueued, store.JobRunning, store.JobSucceeded, store.JobFailed:
+	default:
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "status must be queued, running, succeeded or failed"})
+		return
+	}
+	limit := defaultJobLimit
+	if v := query.Get("limit"); v != "" {
+		parsed, err := strconv.Atoi(v)
+		if err != nil || parsed <= 0 || parsed > maxJobLimit {
+			utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "limit must be between 1 and 200"})
+			return
+		}
+		limit = parsed
+	}
+	offset := 0
+	if v := query.Get("offset"); v != "" {
+		parsed, err := strconv.Atoi(v)
+		if err != nil || parsed < 0 {
+			utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "offset must be 0 or greater"})
+			return
+		}
+		offset = parsed
+	}
+
+	jobs, err := jh.jobStore.ListJobs(status, query.Get("kind"), limit, offset)
+	if err != nil {
+		jh.logger.Printf("ERROR: listJobs: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"jobs": jobs})
+}
+
+func (jh *JobHandler) HandleGetJob(w http.ResponseWriter, r *http.Request) {
+	jobID, ok := readJobID(w, r)
+	if !ok {
+		return
+	}
+
+	job, err := jh.jobStore.GetJob(jobID)
+	if errors.Is(err, sql.ErrNoRows) {
+		utils.WriteJSON(w, http.StatusNotFound, utils.Envelope{"error": "job not found"})
+		return
+	}
+	if err != nil {
+		jh.logger.Printf("ERROR: getJob: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"job": job})
+}
+
+// HandleRetryJob queues a failed job to run again right away with all its attempts
+func (jh *JobHandler) HandleRetryJob(w http.ResponseWriter, r *http.Request) {
+	jobID, ok := readJobID(w, r)
+	if !ok {
+		return
+	}
+
+	job, err := jh.jobStore.RetryJob(jobID)
+	if errors.Is(err, sql.ErrNoRows) {
+		utils.WriteJSON(w, http.StatusNotFound, utils.Envelope{"error": "job not found"})
+		return
+	}
+	if errors.Is(err, store.ErrJobNotFailed) || errors.Is(err, store.ErrJobDuplicate) {
+		utils.WriteJSON(w, http.StatusConflict, utils.Envelope{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		jh.logger.Printf("ERROR: retryJob: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+	utils.WriteJSON(w, http.StatusAccepted, utils.Envelope{"job": job})
+}
+
+// HandleBackfillAchievements queues a job evaluating the achievements of every user. While a
+// backfill is queued or running that job is returned instead of queueing another one.
+func (jh *JobHandler) HandleBackfillAchievements(w http.ResponseWriter, r *http.Request) {
+	args := achievements.BackfillArgs{}
+	job, inserted, err := jh.queue.Enqueue(args, jobs.Unique(args.Kind()), jobs.MaxAttempts(3))
+	if err != nil {
+		jh.logger.Printf("ERROR: enqueueBackfillAchievements: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+	if !inserted {
+		utils.WriteJSON(w, http.StatusOK, utils.Envelope{"job": job})
+		return
+	}
+	utils.WriteJSON(w, http.StatusAccepted, utils.Envelope{"job": job})
+}
@@ -0,0 +1,575 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/coder/websocket/wsjson"
+	"github.com/kodega2016/femapi/internal/live"
+	"github.com/kodega2016/femapi/internal/middleware"
+	"github.com/kodega2016/femapi/internal/store"
+	"github.com/kodega2016/femapi/internal/utils"
+)
+
+const (
+	// messages of devices are single events, anything bigger is not one
+	maxLiveMessage = 4096
+	livePingPeriod = 30 * time.Second
+	liveWriteWait  = 10 * time.Second
+)
+
+type LiveSessionHandler struct {
+	liveSessionStore     store.LiveSessionStore
+	workoutStore         store.WorkoutStore
+	userStore            store.UserStore
+	settingsStore        store.SettingsStore
+	bodyMeasurementStore store.BodyMeasurementStore
+	hub                  live.Hub
+	logger               *log.Logger
+}
+
+func NewLiveSessionHandler(liveSessionStore store.LiveSessionStore, workoutStore store.WorkoutStore, userStore store.UserStore, settingsStore store.SettingsStore, bodyMeasurementStore store.BodyMeasurementStore, hub live.Hub, logger *log.Logger) *LiveSessionHandler {
+	return &LiveSessionHandler{
+		liveSessionStore:     liveSessionStore,
+		workoutStore:         workoutStore,
+		userStore:            userStore,
+		settingsStore:        settingsStore,
+		bodyMeasurementStore: bodyMeasurementStore,
+		hub:                  hub,
+		logger:               logger,
+	}
+}
+
+// liveEventRequest is an event sent by a device, over the socket or posted
+type liveEventRequest struct {
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data"`
+}
+
+// loadLiveSession resolves the {sessionID} url param to a session the current user owns or
+// watches, owner tells which one
+func (lh *LiveSessionHandler) loadLiveSession(w http.ResponseWriter, r *http.Request) (session *store.LiveSession, owner bool, ok bool) {
+	ref, err := utils.ReadRefParam(r, "sessionID")
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid live session id"})
+		return nil, false, false
+	}
+	// live sessions never had numeric ids
+	if ref.IsLegacy() {
+		utils.WriteJSON(w, http.StatusNotFound, utils.Envelope{"error": "live session not found"})
+		return nil, false, false
+	}
+
+	session, err = lh.liveSessionStore.GetLiveSession(ref.PublicID)
+	if errors.Is(err, sql.ErrNoRows) {
+		utils.WriteJSON(w, http.StatusNotFound, utils.Envelope{"error": "live session not found"})
+		return nil, false, false
+	}
+	if err != nil {
+		lh.logger.Printf("ERROR: getLiveSession: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return nil, false, false
+	}
+
+	user := middleware.GetUser(r)
+	if session.UserID == user.ID {
+		return session, true, true
+	}
+	viewer, err := lh.liveSessionStore.IsLiveSessionViewer(session.ID, user.ID)
+	if err != nil {
+		lh.logger.Printf("ERROR: isLiveSessionViewer: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return nil, false, false
+	}
+	if !viewer {
+		utils.WriteJSON(w, http.StatusNotFound, utils.Envelope{"error": "live session not found"})
+		return nil, false, false
+	}
+	return session, false, true
+}
+
+// loadOwnLiveSession is loadLiveSession for changes only the owner may make
+func (lh *LiveSessionHandler) loadOwnLiveSession(w http.ResponseWriter, r *http.Request) (*store.LiveSession, bool) {
+	session, owner, ok := lh.loadLiveSession(w, r)
+	if !ok {
+		return nil, false
+	}
+	if !owner {
+		utils.WriteJSON(w, http.StatusForbidden, utils.Envelope{"error": "only the athlete can change the live session"})
+		return nil, false
+	}
+	return session, true
+}
+
+// publish hands a stored event to the hub. Subscribers that miss it catch up from the store,
+// so a failure is only logged.
+func (lh *LiveSessionHandler) publish(ctx context.Context, event *store.LiveEvent) {
+	err := lh.hub.Publish(ctx, *event)
+	if err != nil {
+		lh.logger.Printf("ERROR: publishing live event %d: %v", event.Seq, err)
+	}
+}
+
+// record stores and publishes an event of a device, data was checked by live.ParseEvent
+func (lh *LiveSessionHandler) record(ctx context.Context, session *store.LiveSession, eventType string, data json.RawMessage) (*store.LiveEvent, error) {
+	event, err := lh.liveSessionStore.AppendLiveEvent(session, eventType, data)
+	if err != nil {
+		return nil, err
+	}
+	lh.publish(ctx, event)
+	return event, nil
+}
+
+// HandleStartLiveSession starts a session, a user runs one at a time
+func (lh *LiveSessionHandler) HandleStartLiveSession(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Title string `json:"title"`
+		Type  string `json:"type"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid request payload"})
+		return
+	}
+
+	session := &store.LiveSession{
+		UserID: middleware.GetUser(r).ID,
+		Title:  strings.TrimSpace(req.Title),
+		Type:   req.Type,
+	}
+	if session.Type == "" {
+		session.Type = store.WorkoutTypeStrength
+	}
+	switch {
+	case session.Title == "":
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "title is required"})
+		return
+	case len(session.Title) > 100:
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "title cannot be greater than 100 characters"})
+		return
+	case !store.IsValidWorkoutType(session.Type):
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid workout type"})
+		return
+	}
+
+	err = lh.liveSessionStore.CreateLiveSession(session)
+	if errors.Is(err, store.ErrSessionActive) {
+		utils.WriteJSON(w, http.StatusConflict, utils.Envelope{"error": "finish or abandon the active live session first"})
+		return
+	}
+	if err != nil {
+		lh.logger.Printf("ERROR: createLiveSession: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+	utils.WriteJSON(w, http.StatusCreated, utils.Envelope{"live_session": session})
+}
+
+// HandleListLiveSessions lists the active sessions of the user and of the athletes they watch
+func (lh *LiveSessionHandler) HandleListLiveSessions(w http.ResponseWriter, r *http.Request) {
+	sessions, err := lh.liveSessionStore.ListLiveSessions(middleware.GetUser(r).ID)
+	if err != nil {
+		lh.logger.Printf("ERROR: listLiveSessions: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"live_sessions": sessions})
+}
+
+// HandleGetLiveSession returns the session with every event so far
+func (lh *LiveSessionHandler) HandleGetLiveSession(w http.ResponseWriter, r *http.Request) {
+	session, _, ok := lh.loadLiveSession(w, r)
+	if !ok {
+		return
+	}
+	events, err := lh.liveSessionStore.ListLiveEvents(session.ID, 0)
+	if err != nil {
+		lh.logger.Printf("ERROR: listLiveEvents: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"live_session": session, "events": events})
+}
+
+// HandleRecordLiveEvent records an event for devices that post instead of keeping a socket open
+func (lh *LiveSessionHandler) HandleRecordLiveEvent(w http.ResponseWriter, r *http.Request) {
+	session, ok := lh.loadOwnLiveSession(w, r)
+	if !ok {
+		return
+	}
+
+	var req liveEventRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid request payload"})
+		return
+	}
+
+	data, err := live.ParseEvent(req.Type, req.Data)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": err.Error()})
+		return
+	}
+	event, err := lh.record(r.Context(), session, req.Type, data)
+	if errors.Is(err, store.ErrSessionEnded) {
+		utils.WriteJSON(w, http.StatusConflict, utils.Envelope{"error": "the live session has ended"})
+		return
+	}
+	if err != nil {
+		lh.logger.Printf("ERROR: recordLiveEvent: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+	utils.WriteJSON(w, http.StatusCreated, utils.Envelope{"event": event})
+}
+
+// HandleFinishLiveSession ends the session and creates the workout it describes. Cardio
+// sessions need the distance covered, the rest is taken from the recorded events.
+func (lh *LiveSessionHandler) HandleFinishLiveSession(w http.ResponseWriter, r *http.Request) {
+	session, ok := lh.loadOwnLiveSession(w, r)
+	if !ok {
+		return
+	}
+	if session.Status != store.LiveSessionActive {
+		utils.WriteJSON(w, http.StatusConflict, utils.Envelope{"error": "the live session has ended"})
+		return
+	}
+
+	var req struct {
+		Title          *string  `json:"title"`
+		Visibility     string   `json:"visibility"`
+		DistanceMeters *float64 `json:"distance_meters"`
+		CaloriesBurned *int     `json:"calories_burned"`
+	}
+	// every field is optional, an empty body finishes with what was recorded
+	if r.ContentLength != 0 {
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid request payload"})
+			return
+		}
+	}
+
+	events, err := lh.liveSessionStore.ListLiveEvents(session.ID, 0)
+	if err != nil {
+		lh.logger.Printf("ERROR: listLiveEvents: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	end := time.Now()
+	workout := live.BuildWorkout(session, events, end)
+	if req.Title != nil {
+		workout.Title = strings.TrimSpace(*req.Title)
+	}
+	if req.DistanceMeters != nil || (store.IsCardioType(workout.Type) && workout.Activity == nil) {
+		if workout.Activity == nil {
+			workout.Activity = &store.Activity{StartedAt: &session.StartedAt, DurationSeconds: int(end.Sub(session.StartedAt).Seconds())}
+		}
+		if req.DistanceMeters != nil {
+			workout.Activity.DistanceMeters = *req.DistanceMeters
+		}
+	}
+	workout.Visibility = req.Visibility
+	if workout.Visibility == "" {
+		settings, ok := loadSettings(w, r, lh.settingsStore, lh.logger)
+		if !ok {
+			return
+		}
+		workout.Visibility = settings.DefaultWorkoutVisibility
+	}
+	normalizeWorkout(workout)
+	err = validateWorkout(workout)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": err.Error()})
+		return
+	}
+	err = applyCalories(lh.bodyMeasurementStore, workout, session.UserID, req.CaloriesBurned)
+	if err != nil {
+		lh.logger.Printf("ERROR: applyCalories: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	// claiming the session first keeps a second device from creating the workout again
+	err = lh.liveSessionStore.EndLiveSession(session, store.LiveSessionFinished)
+	if errors.Is(err, store.ErrSessionEnded) {
+		utils.WriteJSON(w, http.StatusConflict, utils.Envelope{"error": "the live session has ended"})
+		return
+	}
+	if err != nil {
+		lh.logger.Printf("ERROR: endLiveSession: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	createdWorkout, err := lh.workoutStore.CreateWorkout(workout)
+	if err != nil {
+		lh.logger.Printf("ERROR: createWorkout: %v", err)
+		reopenErr := lh.liveSessionStore.ReopenLiveSession(session)
+		if reopenErr != nil {
+			lh.logger.Printf("ERROR: reopenLiveSession: %v", reopenErr)
+		}
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "failed to create workout"})
+		return
+	}
+
+	data, _ := json.Marshal(live.Finished{WorkoutID: createdWorkout.PublicID})
+	workoutID := int64(createdWorkout.ID)
+	event, err := lh.liveSessionStore.CloseLiveSession(session, &workoutID, live.EventFinished, data)
+	if err != nil {
+		// the workout exists, subscribers learn about the end when they reconnect
+		lh.logger.Printf("ERROR: closeLiveSession: %v", err)
+	} else {
+		lh.publish(r.Context(), event)
+	}
+
+	w.Header().Set("ETag", workoutETag(createdWorkout))
+	utils.WriteJSON(w, http.StatusCreated, utils.Envelope{"live_session": session, "workout": createdWorkout})
+}
+
+// HandleAbandonLiveSession ends the session without creating a workout
+func (lh *LiveSessionHandler) HandleAbandonLiveSession(w http.ResponseWriter, r *http.Request) {
+	session, ok := lh.loadOwnLiveSession(w, r)
+	if !ok {
+		return
+	}
+
+	err := lh.liveSessionStore.EndLiveSession(session, store.LiveSessionAbandoned)
+	if errors.Is(err, store.ErrSessionEnded) {
+		utils.WriteJSON(w, http.StatusConflict, utils.Envelope{"error": "the live session has ended"})
+		return
+	}
+	if err != nil {
+		lh.logger.Printf("ERROR: endLiveSession: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	event, err := lh.liveSessionStore.CloseLiveSession(session, nil, live.EventAbandoned, json.RawMessage(`{}`))
+	if err != nil {
+		lh.logger.Printf("ERROR: closeLiveSession: %v", err)
+	} else {
+		lh.publish(r.Context(), event)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleAddLiveSessionViewer lets another user, e.g. a coach, follow the session
+func (lh *LiveSessionHandler) HandleAddLiveSessionViewer(w http.ResponseWriter, r *http.Request) {
+	session, ok := lh.loadOwnLiveSession(w, r)
+	if !ok {
+		return
+	}
+	viewerID, ok := readUserID(w, r, lh.userStore, lh.logger)
+	if !ok {
+		return
+	}
+	if viewerID == session.UserID {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "you already see your own session"})
+		return
+	}
+
+	err := lh.liveSessionStore.AddLiveSessionViewer(session.ID, viewerID)
+	if err != nil {
+		lh.logger.Printf("ERROR: addLiveSessionViewer: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (lh *LiveSessionHandler) HandleRemoveLiveSessionViewer(w http.ResponseWriter, r *http.Request) {
+	session, ok := lh.loadOwnLiveSession(w, r)
+	if !ok {
+		return
+	}
+	viewerID, ok := readUserID(w, r, lh.userStore, lh.logger)
+	if !ok {
+		return
+	}
+
+	err := lh.liveSessionStore.RemoveLiveSessionViewer(session.ID, viewerID)
+	if err != nil {
+		lh.logger.Printf("ERROR: removeLiveSessionViewer: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleLiveSessionSocket streams the events of a session over a WebSocket. The events after
+// ?after= (a seq) are replayed first, so reconnecting devices catch up on what they missed.
+// The owner's devices also send events on the socket, viewers only receive. Browsers cannot set
+// headers on the handshake and pass their token as ?access_token= instead.
+func (lh *LiveSessionHandler) HandleLiveSessionSocket(w http.ResponseWriter, r *http.Request) {
+	r, ok := authenticateQueryToken(w, r, lh.userStore, lh.logger)
+	if !ok {
+		return
+	}
+
+	session, owner, ok := lh.loadLiveSession(w, r)
+	if !ok {
+		return
+	}
+	var after int64
+	if v := r.URL.Query().Get("after"); v != "" {
+		parsed, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || parsed < 0 {
+			utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "after must be the seq of an event"})
+			return
+		}
+		after = parsed
+	}
+
+	// the server timeouts are meant for plain requests, not for a socket open for an hour
+	rc := http.NewResponseController(w)
+	rc.SetReadDeadline(time.Time{})
+	rc.SetWriteDeadline(time.Time{})
+
+	conn, err := websocket.Accept(w, r, nil)
+	if err != nil {
+		// Accept already responded
+		lh.logger.Printf("ERROR: accepting live session socket: %v", err)
+		return
+	}
+	defer conn.CloseNow()
+	conn.SetReadLimit(maxLiveMessage)
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	// subscribing before reading the backlog leaves no gap, events in both are sent once
+	sub := lh.hub.Subscribe(session.PublicID)
+	defer sub.Close()
+	backlog, err := lh.liveSessionStore.ListLiveEvents(session.ID, after)
+	if err != nil {
+		lh.logger.Printf("ERROR: listLiveEvents: %v", err)
+		conn.Close(websocket.StatusInternalError, "internal server error")
+		return
+	}
+
+	if owner {
+		go lh.readLiveEvents(ctx, cancel, conn, session)
+	} else {
+		ctx = conn.CloseRead(ctx)
+	}
+	lh.writeLiveEvents(ctx, conn, session, sub, backlog, after)
+}
+
+// readLiveEvents records the events sent by a device of the owner until the socket closes.
+// Invalid events are answered with an error message, the socket stays open.
+func (lh *LiveSessionHandler) readLiveEvents(ctx context.Context, cancel context.CancelFunc, conn *websocket.Conn, session *store.LiveSession) {
+	defer cancel()
+	for {
+		var req liveEventRequest
+		err := wsjson.Read(ctx, conn, &req)
+		if err != nil {
+			return
+		}
+
+		var message string
+		data, err := live.ParseEvent(req.Type, req.Data)
+		if err != nil {
+			message = err.Error()
+		} else {
+			_, err = lh.record(ctx, session, req.Type, data)
+			switch {
+			case err == nil, errors.Is(err, store.ErrSessionEnded):
+				// the end of the session reaches the device as an event
+				continue
+			default:
+				lh.logger.Printf("ERROR: recordLiveEvent: %v", err)
+				message = "internal server error"
+			}
+		}
+		writeCtx, cancelWrite := context.WithTimeout(ctx, liveWriteWait)
+		err = wsjson.Write(writeCtx, conn, utils.Envelope{"error": message, "type": req.Type})
+		cancelWrite()
+		if err != nil {
+			return
+		}
+	}
+}
+
+// writeLiveEvents sends the backlog and then every published event in seq order, the socket is
+// closed once the session ended or when the subscriber fell behind. Events are published after
+// they commit, so one can overtake an earlier one; the gap is filled from the store.
+func (lh *LiveSessionHandler) writeLiveEvents(ctx context.Context, conn *websocket.Conn, session *store.LiveSession, sub *live.Subscription, backlog []store.LiveEvent, after int64) {
+	last := after
+	// send reports whether the socket should stay open
+	send := func(event store.LiveEvent) bool {
+		if event.Seq <= last {
+			return true
+		}
+		last = event.Seq
+		writeCtx, cancel := context.WithTimeout(ctx, liveWriteWait)
+		defer cancel()
+		err := wsjson.Write(writeCtx, conn, event)
+		if err != nil {
+			return false
+		}
+		if event.Type == live.EventFinished || event.Type == live.EventAbandoned {
+			conn.Close(websocket.StatusNormalClosure, "the live session has ended")
+			return false
+		}
+		return true
+	}
+
+	for _, event := range backlog {
+		if !send(event) {
+			return
+		}
+	}
+	if session.Status != store.LiveSessionActive {
+		conn.Close(websocket.StatusNormalClosure, "the live session has ended")
+		return
+	}
+
+	ping := time.NewTicker(livePingPeriod)
+	defer ping.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			conn.Close(websocket.StatusNormalClosure, "")
+			return
+		case event, ok := <-sub.C:
+			if !ok {
+				// the client reconnects with ?after= and catches up from the store
+				conn.Close(websocket.StatusTryAgainLater, "reconnect with after="+strconv.FormatInt(last, 10))
+				return
+			}
+			events := []store.LiveEvent{event}
+			if event.Seq > last+1 {
+				// an earlier event was published after this one, it is committed already
+				var err error
+				events, err = lh.liveSessionStore.ListLiveEvents(session.ID, last)
+				if err != nil {
+					lh.logger.Printf("ERROR: listLiveEvents: %v", err)
+					conn.Close(websocket.StatusInternalError, "internal server error")
+					return
+				}
+			}
+			for _, event := range events {
+				if !send(event) {
+					return
+				}
+			}
+		case <-ping.C:
+			pingCtx, cancel := context.WithTimeout(ctx, liveWriteWait)
+			err := conn.Ping(pingCtx)
+			cancel()
+			if err != nil {
+				return
+			}
+		}
+	}
+}
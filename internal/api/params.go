@@ -0,0 +1,101 @@
+package api
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/kodega2016/femapi/internal/middleware"
+	"github.com/kodega2016/femapi/internal/store"
+	"github.com/kodega2016/femapi/internal/tokens"
+	"github.com/kodega2016/femapi/internal/utils"
+)
+
+// readWorkoutID resolves the {id} url param into the internal workout id.
+// It writes the error response itself and reports whether the handler can continue.
+func readWorkoutID(w http.ResponseWriter, r *http.Request, workoutStore store.WorkoutStore, logger *log.Logger) (int64, bool) {
+	ref, err := utils.ReadIDParams(r)
+	if err != nil {
+		logger.Printf("ERROR: readIDParams: %v", err)
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid workout id"})
+		return 0, false
+	}
+
+	workoutID, err := workoutStore.ResolveWorkoutID(ref)
+	if errors.Is(err, sql.ErrNoRows) {
+		utils.WriteJSON(w, http.StatusNotFound, utils.Envelope{"error": "workout not found"})
+		return 0, false
+	}
+	if err != nil {
+		logger.Printf("ERROR: resolveWorkoutID: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return 0, false
+	}
+	return workoutID, true
+}
+
+// readUserID resolves the {id} url param into the internal user id
+func readUserID(w http.ResponseWriter, r *http.Request, userStore store.UserStore, logger *log.Logger) (int, bool) {
+	ref, err := utils.ReadIDParams(r)
+	if err != nil {
+		logger.Printf("ERROR: readIDParams: %v", err)
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid user id"})
+		return 0, false
+	}
+
+	userID, err := userStore.ResolveUserID(ref)
+	if errors.Is(err, sql.ErrNoRows) {
+		utils.WriteJSON(w, http.StatusNotFound, utils.Envelope{"error": "user not found"})
+		return 0, false
+	}
+	if err != nil {
+		logger.Printf("ERROR: resolveUserID: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return 0, false
+	}
+	return userID, true
+}
+
+// authenticateQueryToken authenticates anonymous requests with the ?access_token= param.
+// Browsers cannot set headers on sockets and event streams, so those routes accept the token
+// in the url instead of going through RequireUser.
+func authenticateQueryToken(w http.ResponseWriter, r *http.Request, userStore store.UserStore, logger *log.Logger) (*http.Request, bool) {
+	if !middleware.GetUser(r).IsAnonymous() {
+		return r, true
+	}
+
+	user, err := userStore.GetUserToken(tokens.ScopeAuth, r.URL.Query().Get("access_token"))
+	if err != nil {
+		logger.Printf("ERROR: getUserToken: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return r, false
+	}
+	if user == nil {
+		utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"error": "you must be logged in to access this route"})
+		return r, false
+	}
+	return middleware.SetUser(r, user), true
+}
+
+// workoutETag changes whenever the workout version is bumped
+func workoutETag(workout *store.Workout) string {
+	return fmt.Sprintf(`"%s-%d"`, workout.PublicID, workout.Version)
+}
+
+// checkIfMatch enforces optimistic concurrency: writes must carry the ETag they were based on.
+// If-Match uses the strong comparison, a weak validator never matches.
+func checkIfMatch(w http.ResponseWriter, r *http.Request, workout *store.Workout) bool {
+	ifMatch := r.Header.Get("If-Match")
+	if ifMatch == "" {
+		utils.WriteJSON(w, http.StatusPreconditionRequired, utils.Envelope{"error": "If-Match header is required"})
+		return false
+	}
+
+	if !utils.MatchStrongETag(ifMatch, workoutETag(workout)) {
+		utils.WriteJSON(w, http.StatusPreconditionFailed, utils.Envelope{"error": "workout has been modified, fetch the latest version and retry"})
+		return false
+	}
+	return true
+}
@@ -0,0 +1,204 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/kodega2016/femapi/internal/middleware"
+	"github.com/kodega2016/femapi/internal/store"
+	"github.com/kodega2016/femapi/internal/utils"
+)
+
+const defaultPlannedDuration = 60
+
+// plannedWorkoutRequest is the body of both create and update, on update only the given fields change
+type plannedWorkoutRequest struct {
+	Title             *string    `json:"title"`
+	Type              *string    `json:"type"`
+	Notes             *string    `json:"notes"`
+	ScheduledAt       *time.Time `json:"scheduled_at"`
+	DurationInMinutes *int       `json:"duration"`
+}
+
+func (req *plannedWorkoutRequest) apply(planned *store.PlannedWorkout) {
+	if req.Title != nil {
+		planned.Title = strings.TrimSpace(*req.Title)
+	}
+	if req.Type != nil {
+		planned.Type = *req.Type
+	}
+	if req.Notes != nil {
+		planned.Notes = *req.Notes
+	}
+	if req.ScheduledAt != nil {
+		planned.ScheduledAt = req.ScheduledAt.UTC()
+	}
+	if req.DurationInMinutes != nil {
+		planned.DurationInMinutes = *req.DurationInMinutes
+	}
+}
+
+func validatePlannedWorkout(planned *store.PlannedWorkout) error {
+	if planned.Title == "" {
+		return errors.New("title is required")
+	}
+	if len(planned.Title) > 100 {
+		return errors.New("title cannot be greater than 100 characters")
+	}
+	if !store.IsValidWorkoutType(planned.Type) {
+		return errors.New("invalid workout type")
+	}
+	if planned.ScheduledAt.IsZero() {
+		return errors.New("scheduled_at is required")
+	}
+	if planned.DurationInMinutes <= 0 {
+		return errors.New("duration must be greater than 0")
+	}
+	return nil
+}
+
+// loadPlannedWorkout resolves the {plannedID} url param to a session of the current user
+func (ch *CalendarHandler) loadPlannedWorkout(w http.ResponseWriter, r *http.Request) (*store.PlannedWorkout, bool) {
+	ref, err := utils.ReadRefParam(r, "plannedID")
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid planned workout id"})
+		return nil, false
+	}
+	// planned workouts never had numeric ids
+	if ref.IsLegacy() {
+		utils.WriteJSON(w, http.StatusNotFound, utils.Envelope{"error": "planned workout not found"})
+		return nil, false
+	}
+
+	planned, err := ch.plannedWorkoutStore.GetPlannedWorkout(middleware.GetUser(r).ID, ref.PublicID)
+	if errors.Is(err, sql.ErrNoRows) {
+		utils.WriteJSON(w, http.StatusNotFound, utils.Envelope{"error": "planned workout not found"})
+		return nil, false
+	}
+	if err != nil {
+		ch.logger.Printf("ERROR: getPlannedWorkout: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return nil, false
+	}
+	return planned, true
+}
+
+func (ch *CalendarHandler) HandleCreatePlannedWorkout(w http.ResponseWriter, r *http.Request) {
+	var req plannedWorkoutRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid request payload"})
+		return
+	}
+
+	planned := &store.PlannedWorkout{
+		UserID:            middleware.GetUser(r).ID,
+		Type:              store.WorkoutTypeStrength,
+		DurationInMinutes: defaultPlannedDuration,
+	}
+	req.apply(planned)
+	err = validatePlannedWorkout(planned)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": err.Error()})
+		return
+	}
+
+	err = ch.plannedWorkoutStore.CreatePlannedWorkout(planned)
+	if err != nil {
+		ch.logger.Printf("ERROR: createPlannedWorkout: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+	utils.WriteJSON(w, http.StatusCreated, utils.Envelope{"planned_workout": planned})
+}
+
+// HandleListPlannedWorkouts lists the sessions scheduled between ?from= and ?to=, by default
+// the 30 days from now
+func (ch *CalendarHandler) HandleListPlannedWorkouts(w http.ResponseWriter, r *http.Request) {
+	from := time.Now().UTC()
+	if v := r.URL.Query().Get("from"); v != "" {
+		parsed, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "from must be an RFC 3339 timestamp"})
+			return
+		}
+		from = parsed
+	}
+	to := from.AddDate(0, 0, 30)
+	if v := r.URL.Query().Get("to"); v != "" {
+		parsed, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "to must be an RFC 3339 timestamp"})
+			return
+		}
+		to = parsed
+	}
+	if !from.Before(to) {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "from must be before to"})
+		return
+	}
+
+	plans, err := ch.plannedWorkoutStore.ListPlannedWorkouts(middleware.GetUser(r).ID, from, to)
+	if err != nil {
+		ch.logger.Printf("ERROR: listPlannedWorkouts: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"planned_workouts": plans})
+}
+
+func (ch *CalendarHandler) HandleUpdatePlannedWorkout(w http.ResponseWriter, r *http.Request) {
+	planned, ok := ch.loadPlannedWorkout(w, r)
+	if !ok {
+		return
+	}
+
+	var req plannedWorkoutRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid request payload"})
+		return
+	}
+	req.apply(planned)
+	err = validatePlannedWorkout(planned)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": err.Error()})
+		return
+	}
+
+	err = ch.plannedWorkoutStore.UpdatePlannedWorkout(planned)
+	if err != nil {
+		ch.logger.Printf("ERROR: updatePlannedWorkout: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"planned_workout": planned})
+}
+
+func (ch *CalendarHandler) HandleDeletePlannedWorkout(w http.ResponseWriter, r *http.Request) {
+	ref, err := utils.ReadRefParam(r, "plannedID")
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid planned workout id"})
+		return
+	}
+	if ref.IsLegacy() {
+		utils.WriteJSON(w, http.StatusNotFound, utils.Envelope{"error": "planned workout not found"})
+		return
+	}
+
+	err = ch.plannedWorkoutStore.DeletePlannedWorkout(middleware.GetUser(r).ID, ref.PublicID)
+	if errors.Is(err, sql.ErrNoRows) {
+		utils.WriteJSON(w, http.StatusNotFound, utils.Envelope{"error": "planned workout not found"})
+		return
+	}
+	if err != nil {
+		ch.logger.Printf("ERROR: deletePlannedWorkout: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,203 @@
+package api
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/kodega2016/femapi/internal/middleware"
+	"github.com/kodega2016/femapi/internal/store"
+	"github.com/kodega2016/femapi/internal/utils"
+)
+
+// readViewableWorkout resolves the workout from the url and applies the visibility rules,
+// so revisions are exposed to exactly the same people that can read the workout itself
+func (wh *WorkoutHandler) readViewableWorkout(w http.ResponseWriter, r *http.Request) (*store.Workout, bool) {
+	workoutID, ok := readWorkoutID(w, r, wh.workoutStore, wh.logger)
+	if !ok {
+		return nil, false
+	}
+
+	workout, err := wh.workoutStore.GetWorkoutByID(workoutID)
+	if errors.Is(err, sql.ErrNoRows) {
+		utils.WriteJSON(w, http.StatusNotFound, utils.Envelope{"error": "workout not found"})
+		return nil, false
+	}
+	if err != nil {
+		wh.logger.Printf("ERROR: getWorkoutByID: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return nil, false
+	}
+
+	canView, err := wh.canViewWorkout(middleware.GetUser(r), workout)
+	if err != nil {
+		wh.logger.Printf("ERROR: canViewWorkout: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return nil, false
+	}
+	if !canView {
+		utils.WriteJSON(w, http.StatusNotFound, utils.Envelope{"error": "workout not found"})
+		return nil, false
+	}
+	return workout, true
+}
+
+func (wh *WorkoutHandler) loadRevision(w http.ResponseWriter, workoutID int64, revision int) (*store.WorkoutRevision, bool) {
+	rev, err := wh.workoutStore.GetWorkoutRevision(workoutID, revision)
+	if errors.Is(err, sql.ErrNoRows) {
+		utils.WriteJSON(w, http.StatusNotFound, utils.Envelope{"error": "revision not found"})
+		return nil, false
+	}
+	if err != nil {
+		wh.logger.Printf("ERROR: getWorkoutRevision: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return nil, false
+	}
+	return rev, true
+}
+
+func (wh *WorkoutHandler) HandleListRevisions(w http.ResponseWriter, r *http.Request) {
+	workout, ok := wh.readViewableWorkout(w, r)
+	if !ok {
+		return
+	}
+
+	revisions, err := wh.workoutStore.ListWorkoutRevisions(int64(workout.ID))
+	if err != nil {
+		wh.logger.Printf("ERROR: listWorkoutRevisions: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"revisions": revisions})
+}
+
+func (wh *WorkoutHandler) HandleGetRevision(w http.ResponseWriter, r *http.Request) {
+	workout, ok := wh.readViewableWorkout(w, r)
+	if !ok {
+		return
+	}
+
+	revision, err := utils.ReadIntParam(r, "rev")
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid revision"})
+		return
+	}
+
+	rev, ok := wh.loadRevision(w, int64(workout.ID), revision)
+	if !ok {
+		return
+	}
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"revision": rev})
+}
+
+// HandleDiffRevisions compares two revisions given as ?from=&to= query params
+func (wh *WorkoutHandler) HandleDiffRevisions(w http.ResponseWriter, r *http.Request) {
+	workout, ok := wh.readViewableWorkout(w, r)
+	if !ok {
+		return
+	}
+
+	from, errFrom := strconv.Atoi(r.URL.Query().Get("from"))
+	to, errTo := strconv.Atoi(r.URL.Query().Get("to"))
+	if errFrom != nil || errTo != nil || from <= 0 || to <= 0 {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "from and to revisions are required"})
+		return
+	}
+
+	fromRev, ok := wh.loadRevision(w, int64(workout.ID), from)
+	if !ok {
+		return
+	}
+	toRev, ok := wh.loadRevision(w, int64(workout.ID), to)
+	if !ok {
+		return
+	}
+
+	diff := store.DiffWorkouts(fromRev.Workout, toRev.Workout)
+	diff.FromRevision = fromRev.Revision
+	diff.ToRevision = toRev.Revision
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"diff": diff})
+}
+
+// HandleRevertRevision restores the workout to a previous revision, which itself becomes a new revision
+func (wh *WorkoutHandler) HandleRevertRevision(w http.ResponseWriter, r *http.Request) {
+	revision, err := utils.ReadIntParam(r, "rev")
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid revision"})
+		return
+	}
+
+	// reverting is a write, so a supplied If-Match is honoured like on PUT
+	workout, ok := wh.loadOwnedWorkout(w, r, false)
+	if !ok {
+		return
+	}
+	workoutID := int64(workout.ID)
+
+	rev, ok := wh.loadRevision(w, workoutID, revision)
+	if !ok {
+		return
+	}
+
+	workout.Title = rev.Workout.Title
+	workout.Description = rev.Workout.Description
+	workout.DurationInMinutes = rev.Workout.DurationInMinutes
+	workout.CaloriesBurned = rev.Workout.CaloriesBurned
+	workout.CaloriesEstimated = rev.Workout.CaloriesEstimated
+	workout.Visibility = rev.Workout.Visibility
+	if !rev.Workout.PerformedAt.IsZero() {
+		workout.PerformedAt = rev.Workout.PerformedAt
+	}
+	// revisions recorded before workout types existed keep the current type and cardio details
+	if rev.Workout.Type != "" {
+		workout.Type = rev.Workout.Type
+		workout.Activity = rev.Workout.Activity
+	}
+	// entries deleted since the revision are re-created, the others keep their ids
+	currentEntries := make(map[string]bool, len(workout.Entries))
+	for _, entry := range workout.Entries {
+		currentEntries[entry.PublicID] = true
+	}
+	for i := range rev.Workout.Entries {
+		if !currentEntries[rev.Workout.Entries[i].PublicID] {
+			rev.Workout.Entries[i].PublicID = ""
+		}
+	}
+	workout.Entries = rev.Workout.Entries
+
+	// the revision may predate a validation rule or an estimate from an older body weight, it
+	// is checked and estimated like any other update
+	normalizeWorkout(workout)
+	err = validateWorkout(workout)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusUnprocessableEntity, utils.Envelope{"error": "the revision is no longer a valid workout: " + err.Error()})
+		return
+	}
+	err = applyCalories(wh.bodyMeasurementStore, workout, workout.UserID, nil)
+	if err != nil {
+		wh.logger.Printf("ERROR: applyCalories: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	err = wh.workoutStore.UpdateWorkout(workout, middleware.GetUser(r).ID)
+	if errors.Is(err, store.ErrEditConflict) {
+		utils.WriteJSON(w, http.StatusPreconditionFailed, utils.Envelope{"error": "workout has been modified, fetch the latest version and retry"})
+		return
+	}
+	if err != nil {
+		wh.logger.Printf("ERROR: updateWorkout: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	updated, err := wh.workoutStore.GetWorkoutByID(workoutID)
+	if err != nil {
+		wh.logger.Printf("ERROR: getWorkoutByID: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+	w.Header().Set("ETag", workoutETag(updated))
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"workout": updated})
+}
@@ -0,0 +1,151 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/kodega2016/femapi/internal/achievements"
+	"github.com/kodega2016/femapi/internal/goals"
+	"github.com/kodega2016/femapi/internal/middleware"
+	"github.com/kodega2016/femapi/internal/store"
+	"github.com/kodega2016/femapi/internal/utils"
+)
+
+// localeRegex accepts BCP 47 tags of a language with optional script and region, e.g. de-DE
+var localeRegex = regexp.MustCompile(`^[a-z]{2,3}(-[A-Z][a-z]{3})?(-([A-Z]{2}|[0-9]{3}))?$`)
+
+type SettingsHandler struct {
+	settingsStore     store.SettingsStore
+	goalTracker       *goals.Tracker
+	achievementEngine *achievements.Engine
+	logger            *log.Logger
+}
+
+func NewSettingsHandler(settingsStore store.SettingsStore, goalTracker *goals.Tracker, achievementEngine *achievements.Engine, logger *log.Logger) *SettingsHandler {
+	return &SettingsHandler{
+		settingsStore:     settingsStore,
+		goalTracker:       goalTracker,
+		achievementEngine: achievementEngine,
+		logger:            logger,
+	}
+}
+
+// loadSettings returns the settings of the current user
+func loadSettings(w http.ResponseWriter, r *http.Request, settingsStore store.SettingsStore, logger *log.Logger) (*store.Settings, bool) {
+	settings, err := settingsStore.GetSettings(middleware.GetUser(r).ID)
+	if err != nil {
+		logger.Printf("ERROR: getSettings: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return nil, false
+	}
+	return settings, true
+}
+
+// updateSettingsRequest only changes the given fields
+type updateSettingsRequest struct {
+	Timezone                   *string `json:"timezone"`
+	Locale                     *string `json:"locale"`
+	Units                      *string `json:"units"`
+	WeekStart                  *string `json:"week_start"`
+	DefaultWorkoutVisibility   *string `json:"default_workout_visibility"`
+	DefaultChallengeVisibility *string `json:"default_challenge_visibility"`
+}
+
+func (req *updateSettingsRequest) apply(settings *store.Settings) {
+	if req.Timezone != nil {
+		settings.Timezone = *req.Timezone
+	}
+	if req.Locale != nil {
+		settings.Locale = *req.Locale
+	}
+	if req.Units != nil {
+		settings.Units = *req.Units
+	}
+	if req.WeekStart != nil {
+		settings.WeekStart = *req.WeekStart
+	}
+	if req.DefaultWorkoutVisibility != nil {
+		settings.DefaultWorkoutVisibility = *req.DefaultWorkoutVisibility
+	}
+	if req.DefaultChallengeVisibility != nil {
+		settings.DefaultChallengeVisibility = *req.DefaultChallengeVisibility
+	}
+}
+
+func validateSettings(settings *store.Settings) error {
+	// "Local" would mean the timezone of the server
+	_, err := time.LoadLocation(settings.Timezone)
+	if err != nil || settings.Timezone == "" || settings.Timezone == "Local" {
+		return errors.New("invalid timezone")
+	}
+	if !localeRegex.MatchString(settings.Locale) {
+		return errors.New("locale must be a language tag such as en-US")
+	}
+	if settings.Units != store.UnitsMetric && settings.Units != store.UnitsImperial {
+		return errors.New("units must be metric or imperial")
+	}
+	if !store.IsValidWeekStart(settings.WeekStart) {
+		return errors.New("week_start must be monday, sunday or saturday")
+	}
+	if !store.IsValidVisibility(settings.DefaultWorkoutVisibility) {
+		return errors.New("invalid default_workout_visibility")
+	}
+	err = validateParticipation(&store.Participant{Visibility: settings.DefaultChallengeVisibility})
+	if err != nil {
+		return errors.New("default_challenge_visibility must be public, anonymous or hidden")
+	}
+	return nil
+}
+
+func (sh *SettingsHandler) HandleGetSettings(w http.ResponseWriter, r *http.Request) {
+	settings, ok := loadSettings(w, r, sh.settingsStore, sh.logger)
+	if !ok {
+		return
+	}
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"settings": settings})
+}
+
+// HandleUpdateSettings changes the given settings. Weekly goals and streaks are evaluated again
+// right away since the timezone and week start move their boundaries.
+func (sh *SettingsHandler) HandleUpdateSettings(w http.ResponseWriter, r *http.Request) {
+	settings, ok := loadSettings(w, r, sh.settingsStore, sh.logger)
+	if !ok {
+		return
+	}
+
+	var req updateSettingsRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid request payload"})
+		return
+	}
+	req.apply(settings)
+	err = validateSettings(settings)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": err.Error()})
+		return
+	}
+
+	err = sh.settingsStore.UpdateSettings(settings)
+	if err != nil {
+		sh.logger.Printf("ERROR: updateSettings: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	// the settings are saved, stale progress is fixed by the next workout change at the latest
+	err = sh.goalTracker.Recompute(settings.UserID)
+	if err != nil {
+		sh.logger.Printf("ERROR: recomputing goals of user %d: %v", settings.UserID, err)
+	}
+	_, err = sh.achievementEngine.Evaluate(settings.UserID)
+	if err != nil {
+		sh.logger.Printf("ERROR: evaluating achievements of user %d: %v", settings.UserID, err)
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"settings": settings})
+}
@@ -0,0 +1,152 @@
+package api
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/kodega2016/femapi/internal/middleware"
+	"github.com/kodega2016/femapi/internal/store"
+	"github.com/kodega2016/femapi/internal/utils"
+)
+
+type ShareHandler struct {
+	workoutStore   store.WorkoutStore
+	shareLinkStore store.ShareLinkStore
+	logger         *log.Logger
+}
+
+// sharedWorkout is the read-only rendering served to anyone holding a share link
+type sharedWorkout struct {
+	Title             string               `json:"title"`
+	Description       string               `json:"description"`
+	CaloriesBurned    int                  `json:"calories_burned"`
+	DurationInMinutes int                  `json:"duration"`
+	Entries           []store.WorkoutEntry `json:"entries"`
+}
+
+func NewShareHandler(workoutStore store.WorkoutStore, shareLinkStore store.ShareLinkStore, logger *log.Logger) *ShareHandler {
+	return &ShareHandler{
+		workoutStore:   workoutStore,
+		shareLinkStore: shareLinkStore,
+		logger:         logger,
+	}
+}
+
+// requireOwner makes sure the current user owns the workout and writes the error response otherwise
+func (sh *ShareHandler) requireOwner(w http.ResponseWriter, r *http.Request, workoutID int64) bool {
+	currentUser := middleware.GetUser(r)
+
+	workoutOwner, err := sh.workoutStore.GetWorkoutOwner(workoutID)
+	if errors.Is(err, sql.ErrNoRows) {
+		utils.WriteJSON(w, http.StatusNotFound, utils.Envelope{"error": "workout not found"})
+		return false
+	}
+	if err != nil {
+		sh.logger.Printf("ERROR: getWorkoutOwner: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return false
+	}
+
+	// someone else's workout is reported as missing, like on GET, so its id cannot be probed
+	if workoutOwner != currentUser.ID {
+		utils.WriteJSON(w, http.StatusNotFound, utils.Envelope{"error": "workout not found"})
+		return false
+	}
+	return true
+}
+
+func (sh *ShareHandler) HandleCreateShareLink(w http.ResponseWriter, r *http.Request) {
+	workoutID, ok := readWorkoutID(w, r, sh.workoutStore, sh.logger)
+	if !ok {
+		return
+	}
+
+	if !sh.requireOwner(w, r, workoutID) {
+		return
+	}
+
+	link, err := sh.shareLinkStore.CreateShareLink(workoutID, middleware.GetUser(r).ID)
+	if err != nil {
+		sh.logger.Printf("ERROR: createShareLink: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+	utils.WriteJSON(w, http.StatusCreated, utils.Envelope{"share_link": link})
+}
+
+func (sh *ShareHandler) HandleListShareLinks(w http.ResponseWriter, r *http.Request) {
+	workoutID, ok := readWorkoutID(w, r, sh.workoutStore, sh.logger)
+	if !ok {
+		return
+	}
+
+	if !sh.requireOwner(w, r, workoutID) {
+		return
+	}
+
+	links, err := sh.shareLinkStore.ListShareLinks(workoutID)
+	if err != nil {
+		sh.logger.Printf("ERROR: listShareLinks: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"share_links": links})
+}
+
+func (sh *ShareHandler) HandleRevokeShareLink(w http.ResponseWriter, r *http.Request) {
+	workoutID, ok := readWorkoutID(w, r, sh.workoutStore, sh.logger)
+	if !ok {
+		return
+	}
+
+	if !sh.requireOwner(w, r, workoutID) {
+		return
+	}
+
+	err := sh.shareLinkStore.RevokeShareLink(workoutID, chi.URLParam(r, "slug"))
+	if errors.Is(err, sql.ErrNoRows) {
+		utils.WriteJSON(w, http.StatusNotFound, utils.Envelope{"error": "share link not found"})
+		return
+	}
+	if err != nil {
+		sh.logger.Printf("ERROR: revokeShareLink: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (sh *ShareHandler) HandleGetSharedWorkout(w http.ResponseWriter, r *http.Request) {
+	workoutID, err := sh.shareLinkStore.GetWorkoutIDBySlug(chi.URLParam(r, "slug"))
+	if errors.Is(err, sql.ErrNoRows) {
+		utils.WriteJSON(w, http.StatusNotFound, utils.Envelope{"error": "workout not found"})
+		return
+	}
+	if err != nil {
+		sh.logger.Printf("ERROR: getWorkoutIDBySlug: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	workout, err := sh.workoutStore.GetWorkoutByID(workoutID)
+	if errors.Is(err, sql.ErrNoRows) {
+		utils.WriteJSON(w, http.StatusNotFound, utils.Envelope{"error": "workout not found"})
+		return
+	}
+	if err != nil {
+		sh.logger.Printf("ERROR: getWorkoutByID: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"workout": sharedWorkout{
+		Title:             workout.Title,
+		Description:       workout.Description,
+		CaloriesBurned:    workout.CaloriesBurned,
+		DurationInMinutes: workout.DurationInMinutes,
+		Entries:           workout.Entries,
+	}})
+}
@@ -0,0 +1,106 @@
+package api
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/kodega2016/femapi/internal/middleware"
+	"github.com/kodega2016/femapi/internal/store"
+	"github.com/kodega2016/femapi/internal/utils"
+)
+
+const (
+	defaultStatsWeeks = 12
+	maxStatsWeeks     = 104
+)
+
+// HandleWeeklyStats returns weekly totals for one workout type, e.g. the weekly running distance.
+// ?type= defaults to run and ?weeks= to the last 12 weeks including the current one.
+func (wh *WorkoutHandler) HandleWeeklyStats(w http.ResponseWriter, r *http.Request) {
+	workoutType := r.URL.Query().Get("type")
+	if workoutType == "" {
+		workoutType = store.WorkoutTypeRun
+	}
+	if !store.IsValidWorkoutType(workoutType) {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid workout type"})
+		return
+	}
+
+	weeks := defaultStatsWeeks
+	if v := r.URL.Query().Get("weeks"); v != "" {
+		parsed, err := strconv.Atoi(v)
+		if err != nil || parsed <= 0 || parsed > maxStatsWeeks {
+			utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "weeks must be between 1 and 104"})
+			return
+		}
+		weeks = parsed
+	}
+
+	// weeks follow the timezone and first weekday of the user
+	settings, ok := loadSettings(w, r, wh.settingsStore, wh.logger)
+	if !ok {
+		return
+	}
+	since := settings.StartOfWeek(time.Now()).AddDate(0, 0, -7*(weeks-1))
+
+	totals, err := wh.workoutStore.WeeklyTotals(middleware.GetUser(r).ID, workoutType, since, settings)
+	if err != nil {
+		wh.logger.Printf("ERROR: weeklyTotals: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"type": workoutType, "since": since, "week_start": settings.WeekStart, "weeks": totals})
+}
+
+const defaultStrengthDays = 365
+
+// relativeLift is a best lift with its one rep max divided by the bodyweight at the time,
+// relative_strength is null when the user never logged a weight
+type relativeLift struct {
+	store.Lift
+	BodyWeight       *float64 `json:"body_weight"`
+	RelativeStrength *float64 `json:"relative_strength"`
+}
+
+// HandleStrengthStats returns the best estimated one rep max of every exercise over the last
+// ?days= (365 by default) and the relative strength, one rep max over bodyweight
+func (wh *WorkoutHandler) HandleStrengthStats(w http.ResponseWriter, r *http.Request) {
+	days := defaultStrengthDays
+	if v := r.URL.Query().Get("days"); v != "" {
+		parsed, err := strconv.Atoi(v)
+		if err != nil || parsed <= 0 || parsed > 10*365 {
+			utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "days must be between 1 and 3650"})
+			return
+		}
+		days = parsed
+	}
+
+	currentUser := middleware.GetUser(r)
+	lifts, err := wh.workoutStore.BestLifts(currentUser.ID, time.Now().AddDate(0, 0, -days))
+	if err != nil {
+		wh.logger.Printf("ERROR: bestLifts: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	result := make([]relativeLift, 0, len(lifts))
+	for _, lift := range lifts {
+		weight, err := wh.bodyMeasurementStore.GetWeightAt(currentUser.ID, lift.PerformedAt)
+		if err != nil {
+			wh.logger.Printf("ERROR: getWeightAt: %v", err)
+			utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+			return
+		}
+
+		relative := relativeLift{Lift: lift}
+		if weight != nil {
+			ratio := math.Round(lift.OneRepMax/weight.Value*100) / 100
+			relative.BodyWeight = &weight.Value
+			relative.RelativeStrength = &ratio
+		}
+		result = append(result, relative)
+	}
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"days": days, "lifts": result})
+}
@@ -0,0 +1,168 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/kodega2016/femapi/internal/middleware"
+	"github.com/kodega2016/femapi/internal/publicid"
+	"github.com/kodega2016/femapi/internal/store"
+	"github.com/kodega2016/femapi/internal/utils"
+)
+
+// maxSyncChanges bounds a single sync, devices with a longer queue send it in several syncs
+const maxSyncChanges = 500
+
+const (
+	syncApplied   = "applied"
+	syncDuplicate = "duplicate"
+	syncRejected  = "rejected"
+)
+
+type SyncHandler struct {
+	workoutStore         store.WorkoutStore
+	settingsStore        store.SettingsStore
+	bodyMeasurementStore store.BodyMeasurementStore
+	logger               *log.Logger
+}
+
+func NewSyncHandler(workoutStore store.WorkoutStore, settingsStore store.SettingsStore, bodyMeasurementStore store.BodyMeasurementStore, logger *log.Logger) *SyncHandler {
+	return &SyncHandler{
+		workoutStore:         workoutStore,
+		settingsStore:        settingsStore,
+		bodyMeasurementStore: bodyMeasurementStore,
+		logger:               logger,
+	}
+}
+
+type syncRequest struct {
+	SyncToken string             `json:"sync_token"`
+	Changes   []store.SyncChange `json:"changes"`
+}
+
+type syncResult struct {
+	MutationID string `json:"mutation_id"`
+	Status     string `json:"status"`
+	Error      string `json:"error,omitempty"`
+}
+
+// checkSyncChange validates the envelope of a change, its fields are checked once merged
+func checkSyncChange(change *store.SyncChange) error {
+	mutation, err := publicid.Parse(change.MutationID)
+	if err != nil || mutation.IsLegacy() {
+		return errors.New("mutation_id must be a UUID")
+	}
+	change.MutationID = mutation.PublicID
+
+	workout, err := publicid.Parse(change.WorkoutID)
+	if err != nil || workout.IsLegacy() {
+		return errors.New("workout_id must be a UUID")
+	}
+	change.WorkoutID = workout.PublicID
+
+	if change.Op != store.SyncOpUpsert && change.Op != store.SyncOpDelete {
+		return errors.New("op must be upsert or delete")
+	}
+	if change.ModifiedAt.IsZero() {
+		return errors.New("modified_at is required")
+	}
+	// a device clock running ahead would otherwise win every later edit
+	if now := time.Now(); change.ModifiedAt.After(now) {
+		change.ModifiedAt = now
+	}
+	return nil
+}
+
+// HandleSync applies the changes a device made offline, in order, then returns the server
+// changes since its last sync. Conflicting writes are resolved field by field, the latest
+// write wins and deletes always win. Changes are idempotent, a device retries a sync that
+// failed half way with the same mutation ids.
+func (sh *SyncHandler) HandleSync(w http.ResponseWriter, r *http.Request) {
+	var req syncRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		sh.logger.Printf("ERROR: decodingSyncRequest: %v", err)
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid request sent"})
+		return
+	}
+	if len(req.Changes) > maxSyncChanges {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "a sync cannot hold more than 500 changes"})
+		return
+	}
+	token, err := store.ParseSyncToken(req.SyncToken)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid sync_token, start over with an empty one"})
+		return
+	}
+
+	user := middleware.GetUser(r)
+	settings, ok := loadSettings(w, r, sh.settingsStore, sh.logger)
+	if !ok {
+		return
+	}
+
+	validate := func(workout *store.Workout) error {
+		normalizeWorkout(workout)
+		err := validateWorkout(workout)
+		if err != nil {
+			return &store.SyncError{Reason: err.Error()}
+		}
+		return applyCalories(sh.bodyMeasurementStore, workout, user.ID, nil)
+	}
+
+	results := make([]syncResult, 0, len(req.Changes))
+	for _, change := range req.Changes {
+		result := syncResult{MutationID: change.MutationID, Status: syncApplied}
+		err := checkSyncChange(&change)
+		if err != nil {
+			result.Status = syncRejected
+			result.Error = err.Error()
+			results = append(results, result)
+			continue
+		}
+
+		base := &store.Workout{
+			UserID:            user.ID,
+			Visibility:        settings.DefaultWorkoutVisibility,
+			CaloriesEstimated: true,
+		}
+		applied, err := sh.workoutStore.ApplySyncChange(change, base, validate)
+		var syncErr *store.SyncError
+		switch {
+		case errors.As(err, &syncErr):
+			result.Status = syncRejected
+			result.Error = syncErr.Reason
+		case err != nil:
+			// the changes applied so far stay applied, the retry skips them
+			sh.logger.Printf("ERROR: applySyncChange: %v", err)
+			utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+			return
+		case !applied:
+			result.Status = syncDuplicate
+		}
+		results = append(results, result)
+	}
+
+	changes, next, hasMore, err := sh.workoutStore.ListSyncChanges(user.ID, token)
+	if errors.Is(err, store.ErrSyncTokenExpired) {
+		// the changes sent were applied, sending them again with an empty token reports them
+		// as duplicates
+		utils.WriteJSON(w, http.StatusGone, utils.Envelope{"error": "sync_token expired, start over with an empty one"})
+		return
+	}
+	if err != nil {
+		sh.logger.Printf("ERROR: listSyncChanges: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{
+		"results":    results,
+		"changes":    changes,
+		"sync_token": next.String(),
+		"has_more":   hasMore,
+	})
+}
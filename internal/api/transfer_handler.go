@@ -0,0 +1,264 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/kodega2016/femapi/internal/importer"
+	"github.com/kodega2016/femapi/internal/middleware"
+	"github.com/kodega2016/femapi/internal/store"
+	"github.com/kodega2016/femapi/internal/transfer"
+	"github.com/kodega2016/femapi/internal/utils"
+)
+
+const (
+	importModeAtomic  = "atomic"
+	importModePartial = "partial"
+
+	maxImportBytes    = 10 << 20
+	maxImportWorkouts = 5000
+)
+
+type TransferHandler struct {
+	workoutStore         store.WorkoutStore
+	bodyMeasurementStore store.BodyMeasurementStore
+	logger               *log.Logger
+}
+
+type importRowError struct {
+	Row   int    `json:"row"`
+	Error string `json:"error"`
+}
+
+func NewTransferHandler(workoutStore store.WorkoutStore, bodyMeasurementStore store.BodyMeasurementStore, logger *log.Logger) *TransferHandler {
+	return &TransferHandler{
+		workoutStore:         workoutStore,
+		bodyMeasurementStore: bodyMeasurementStore,
+		logger:               logger,
+	}
+}
+
+// HandleExportWorkouts streams every workout of the current user as csv, json or ndjson
+func (h *TransferHandler) HandleExportWorkouts(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = transfer.FormatJSON
+	}
+
+	enc, err := transfer.NewEncoder(w, format)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": err.Error()})
+		return
+	}
+
+	w.Header().Set("Content-Type", transfer.ContentType(format))
+	w.Header().Set("Content-Disposition", `attachment; filename="workouts.`+format+`"`)
+
+	flusher, _ := w.(http.Flusher)
+	err = h.workoutStore.StreamWorkoutsForUser(middleware.GetUser(r).ID, func(workout *store.Workout) error {
+		err := enc.Encode(workout)
+		if err == nil && flusher != nil {
+			flusher.Flush()
+		}
+		return err
+	})
+	if err != nil {
+		// the status line is already sent, all we can do is log and cut the stream short
+		h.logger.Printf("ERROR: streamWorkoutsForUser: %v", err)
+		return
+	}
+
+	err = enc.Close()
+	if err != nil {
+		h.logger.Printf("ERROR: closing export encoder: %v", err)
+	}
+}
+
+// HandleImportWorkouts validates every uploaded workout and inserts them, either all or
+// nothing (mode=atomic, the default) or only the valid ones (mode=partial)
+func (h *TransferHandler) HandleImportWorkouts(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = transfer.FormatFromContentType(r.Header.Get("Content-Type"))
+	}
+
+	mode := r.URL.Query().Get("mode")
+	if mode == "" {
+		mode = importModeAtomic
+	}
+	if mode != importModeAtomic && mode != importModePartial {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "mode must be atomic or partial"})
+		return
+	}
+
+	currentUser := middleware.GetUser(r)
+	weightKg, err := weightAt(h.bodyMeasurementStore, currentUser.ID, time.Now())
+	if err != nil {
+		h.logger.Printf("ERROR: weightAt: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportBytes)
+
+	var workouts []*store.Workout
+	var rows []int
+	rowErrors := []importRowError{}
+
+	err = transfer.Decode(r.Body, format, func(record transfer.Record) error {
+		if len(workouts)+len(rowErrors) >= maxImportWorkouts {
+			return errors.New("too many workouts in a single import")
+		}
+		if record.Err != nil {
+			rowErrors = append(rowErrors, importRowError{Row: record.Row, Error: record.Err.Error()})
+			return nil
+		}
+
+		normalizeWorkout(record.Workout)
+		err := validateWorkout(record.Workout)
+		if err != nil {
+			rowErrors = append(rowErrors, importRowError{Row: record.Row, Error: err.Error()})
+			return nil
+		}
+
+		// rows without calories are estimated, like workouts created without calories_burned
+		if record.Workout.CaloriesBurned == 0 || record.Workout.CaloriesEstimated {
+			estimateCalories(record.Workout, weightKg)
+		}
+
+		// imported rows always belong to the uploader and get fresh ids
+		record.Workout.UserID = currentUser.ID
+		record.Workout.PublicID = ""
+		for i := range record.Workout.Entries {
+			record.Workout.Entries[i].PublicID = ""
+		}
+		workouts = append(workouts, record.Workout)
+		rows = append(rows, record.Row)
+		return nil
+	})
+	if err != nil {
+		h.logger.Printf("ERROR: decoding import: %v", err)
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": err.Error()})
+		return
+	}
+
+	if mode == importModeAtomic {
+		if len(rowErrors) > 0 {
+			utils.WriteJSON(w, http.StatusUnprocessableEntity, utils.Envelope{"imported": 0, "errors": rowErrors})
+			return
+		}
+
+		err = h.workoutStore.CreateWorkouts(workouts)
+		if err != nil {
+			h.logger.Printf("ERROR: createWorkouts: %v", err)
+			utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+			return
+		}
+		utils.WriteJSON(w, http.StatusCreated, utils.Envelope{"imported": len(workouts), "errors": rowErrors})
+		return
+	}
+
+	imported := 0
+	for i, workout := range workouts {
+		_, err := h.workoutStore.CreateWorkout(workout)
+		if err != nil {
+			h.logger.Printf("ERROR: createWorkout row %d: %v", rows[i], err)
+			rowErrors = append(rowErrors, importRowError{Row: rows[i], Error: "failed to save workout"})
+			continue
+		}
+		imported++
+	}
+
+	status := http.StatusCreated
+	if len(rowErrors) > 0 {
+		status = http.StatusMultiStatus
+	}
+	utils.WriteJSON(w, status, utils.Envelope{"imported": imported, "errors": rowErrors})
+}
+
+// HandleImportFromApp imports an export file of another app (strong, hevy or fitnotes).
+// With dry_run=true nothing is saved and the response previews what would be created.
+func (h *TransferHandler) HandleImportFromApp(w http.ResponseWriter, r *http.Request) {
+	source := chi.URLParam(r, "source")
+	query := r.URL.Query()
+
+	mode := query.Get("mode")
+	if mode == "" {
+		mode = importModeAtomic
+	}
+	if mode != importModeAtomic && mode != importModePartial {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "mode must be atomic or partial"})
+		return
+	}
+
+	currentUser := middleware.GetUser(r)
+	names, err := h.workoutStore.ListExerciseNames(currentUser.ID)
+	if err != nil {
+		h.logger.Printf("ERROR: listExerciseNames: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportBytes)
+	result, err := importer.Import(source, r.Body, importer.Options{Unit: query.Get("unit")}, importer.NewMatcher(names...))
+	if errors.Is(err, importer.ErrUnknownSource) {
+		utils.WriteJSON(w, http.StatusNotFound, utils.Envelope{"error": "unknown import source, expected strong, hevy or fitnotes"})
+		return
+	}
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": err.Error()})
+		return
+	}
+	if len(result.Workouts) > maxImportWorkouts {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "too many workouts in a single import"})
+		return
+	}
+
+	// none of the supported apps export calories for strength workouts
+	weightKg, err := weightAt(h.bodyMeasurementStore, currentUser.ID, time.Now())
+	if err != nil {
+		h.logger.Printf("ERROR: weightAt: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	workouts := make([]*store.Workout, 0, len(result.Workouts))
+	for _, imported := range result.Workouts {
+		normalizeWorkout(imported.Workout)
+		err := validateWorkout(imported.Workout)
+		if err != nil {
+			result.Errors = append(result.Errors, importer.RowError{Row: imported.Row, Error: err.Error()})
+			continue
+		}
+		imported.Workout.UserID = currentUser.ID
+		estimateCalories(imported.Workout, weightKg)
+		workouts = append(workouts, imported.Workout)
+	}
+
+	if query.Get("dry_run") == "true" {
+		utils.WriteJSON(w, http.StatusOK, utils.Envelope{"preview": result})
+		return
+	}
+
+	if mode == importModeAtomic && len(result.Errors) > 0 {
+		utils.WriteJSON(w, http.StatusUnprocessableEntity, utils.Envelope{"imported": 0, "errors": result.Errors})
+		return
+	}
+
+	if len(workouts) > 0 {
+		err = h.workoutStore.CreateWorkouts(workouts)
+		if err != nil {
+			h.logger.Printf("ERROR: createWorkouts: %v", err)
+			utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+			return
+		}
+	}
+
+	status := http.StatusCreated
+	if len(result.Errors) > 0 {
+		status = http.StatusMultiStatus
+	}
+	utils.WriteJSON(w, status, utils.Envelope{"imported": len(workouts), "exercises": result.Exercises, "errors": result.Errors})
+}
@@ -0,0 +1,106 @@
+package api
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/kodega2016/femapi/internal/middleware"
+	"github.com/kodega2016/femapi/internal/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func intPtr(i int) *int {
+	return &i
+}
+
+func float64Ptr(f float64) *float64 {
+	return &f
+}
+
+func streamedWorkouts(t *testing.T, workouts store.WorkoutStore, userID int) []*store.Workout {
+	t.Helper()
+	var streamed []*store.Workout
+	require.NoError(t, workouts.StreamWorkoutsForUser(userID, func(workout *store.Workout) error {
+		streamed = append(streamed, workout)
+		return nil
+	}))
+	return streamed
+}
+
+func TestExportRoundTripKeepsCardioWorkouts(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	users := store.NewPostgresUserStore(db)
+	workouts := store.NewPostgresWorkoutStore(db)
+	handler := NewTransferHandler(workouts, store.NewPostgresBodyMeasurementStore(db), log.New(io.Discard, "", 0))
+
+	started := time.Date(2024, 5, 6, 7, 0, 0, 0, time.UTC)
+	for _, format := range []string{"json", "ndjson"} {
+		t.Run(format, func(t *testing.T) {
+			_, err := db.Exec("TRUNCATE users CASCADE")
+			require.NoError(t, err)
+			owner := createTestUser(t, users, "exporter")
+			importer := createTestUser(t, users, "importer")
+
+			_, err = workouts.CreateWorkout(&store.Workout{
+				Title: "push day", UserID: owner.ID, DurationInMinutes: 60, CaloriesBurned: 300,
+				Entries: []store.WorkoutEntry{{ExerciseName: "Bench Press", ExerciseSets: 3, Reps: intPtr(10), OrderIndex: 1}},
+			})
+			require.NoError(t, err)
+			_, err = workouts.CreateWorkout(&store.Workout{
+				Title: "tempo run", UserID: owner.ID, DurationInMinutes: 25, CaloriesBurned: 350, Type: store.WorkoutTypeRun,
+				Activity: &store.Activity{
+					Sport:             store.SportRunning,
+					StartedAt:         &started,
+					DistanceMeters:    5000,
+					MovingTimeSeconds: 1500,
+					Splits:            []store.Split{{DistanceMeters: 2500, DurationSeconds: 760}, {DistanceMeters: 2500, DurationSeconds: 740}},
+					Track: []store.TrackPoint{
+						{Time: started, Latitude: float64Ptr(52.52), Longitude: float64Ptr(13.405)},
+						{Time: started.Add(1500 * time.Second), Latitude: float64Ptr(52.54), Longitude: float64Ptr(13.41), HeartRate: intPtr(162)},
+					},
+				},
+			})
+			require.NoError(t, err)
+
+			rr := httptest.NewRecorder()
+			handler.HandleExportWorkouts(rr, middleware.SetUser(httptest.NewRequest(http.MethodGet, "/users/me/export?format="+format, nil), owner))
+			require.Equal(t, http.StatusOK, rr.Code)
+
+			req := httptest.NewRequest(http.MethodPost, "/workouts/import?format="+format, bytes.NewReader(rr.Body.Bytes()))
+			rr = httptest.NewRecorder()
+			handler.HandleImportWorkouts(rr, middleware.SetUser(req, importer))
+			require.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+
+			exported := streamedWorkouts(t, workouts, owner.ID)
+			imported := streamedWorkouts(t, workouts, importer.ID)
+			require.Len(t, imported, 2)
+			for i, want := range exported {
+				got := imported[i]
+				assert.Equal(t, want.Title, got.Title)
+				assert.Equal(t, want.Type, got.Type)
+				assert.Len(t, got.Entries, len(want.Entries))
+				if want.Activity == nil {
+					assert.Nil(t, got.Activity)
+					continue
+				}
+				require.NotNil(t, got.Activity)
+				assert.Equal(t, want.Activity.Sport, got.Activity.Sport)
+				assert.Equal(t, want.Activity.DistanceMeters, got.Activity.DistanceMeters)
+				assert.Equal(t, want.Activity.MovingTimeSeconds, got.Activity.MovingTimeSeconds)
+				assert.Equal(t, want.Activity.Splits, got.Activity.Splits)
+				require.Len(t, got.Activity.Track, 2)
+				assert.True(t, want.Activity.Track[1].Time.Equal(got.Activity.Track[1].Time))
+				assert.Equal(t, want.Activity.Track[1].Latitude, got.Activity.Track[1].Latitude)
+				assert.Equal(t, want.Activity.Track[1].HeartRate, got.Activity.Track[1].HeartRate)
+			}
+		})
+	}
+}
@@ -0,0 +1,183 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/kodega2016/femapi/internal/events"
+	"github.com/kodega2016/femapi/internal/middleware"
+	"github.com/kodega2016/femapi/internal/store"
+	"github.com/kodega2016/femapi/internal/utils"
+)
+
+const (
+	// the heartbeat keeps proxies from closing an idle stream, and re-reads the log in case a
+	// notification was missed
+	eventStreamHeartbeat = 15 * time.Second
+	eventStreamRetry     = 3 * time.Second
+	eventStreamBatch     = 100
+
+	// userEventsReset tells a resuming client the events it missed were purged
+	userEventsReset = "reset"
+)
+
+type UserEventHandler struct {
+	userEventStore store.UserEventStore
+	userStore      store.UserStore
+	notifier       *events.Notifier
+	logger         *log.Logger
+}
+
+func NewUserEventHandler(userEventStore store.UserEventStore, userStore store.UserStore, notifier *events.Notifier, logger *log.Logger) *UserEventHandler {
+	return &UserEventHandler{
+		userEventStore: userEventStore,
+		userStore:      userStore,
+		notifier:       notifier,
+		logger:         logger,
+	}
+}
+
+// readLastEventID returns where a stream resumes: the Last-Event-ID header sent by reconnecting
+// clients, or the last_event_id param for the first connection of a device that synced before
+func readLastEventID(r *http.Request) (int64, bool, error) {
+	v := r.Header.Get("Last-Event-ID")
+	if v == "" {
+		v = r.URL.Query().Get("last_event_id")
+	}
+	if v == "" {
+		return 0, false, nil
+	}
+	id, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || id < 0 {
+		return 0, false, fmt.Errorf("invalid last event id %q", v)
+	}
+	return id, true, nil
+}
+
+// writeUserEvent writes the event in the text/event-stream format
+func writeUserEvent(w io.Writer, event store.UserEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.Seq, event.Type, data)
+	return err
+}
+
+// HandleUserEvents streams the workout changes of the user so their other devices stay in sync.
+// Without a last event id the stream starts with the next change. A client resuming after
+// events that were purged gets a reset event and has to sync from scratch.
+func (uh *UserEventHandler) HandleUserEvents(w http.ResponseWriter, r *http.Request) {
+	r, ok := authenticateQueryToken(w, r, uh.userStore, uh.logger)
+	if !ok {
+		return
+	}
+	user := middleware.GetUser(r)
+
+	lastID, resume, err := readLastEventID(r)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "last event id must be the id of an event"})
+		return
+	}
+
+	// subscribing before reading the log leaves no gap, a wake-up for an event already read
+	// only costs an empty read
+	wake, cancel := uh.notifier.Subscribe(user.ID)
+	defer cancel()
+
+	if !resume {
+		lastID, err = uh.userEventStore.LatestUserEventSeq(user.ID)
+		if err != nil {
+			uh.logger.Printf("ERROR: latestUserEventSeq: %v", err)
+			utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+			return
+		}
+	}
+
+	// the server timeouts are meant for plain requests, not for a stream open for an hour
+	rc := http.NewResponseController(w)
+	rc.SetReadDeadline(time.Time{})
+	rc.SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "retry: %d\n\n", eventStreamRetry.Milliseconds())
+
+	// the events after the last one the client saw were purged, it has to sync from scratch
+	// and the stream goes on from the latest event
+	if resume {
+		lastID, err = uh.resetIfPurged(w, user.ID, lastID)
+		if err != nil {
+			uh.logger.Printf("ERROR: checking purged user events: %v", err)
+			return
+		}
+	}
+
+	heartbeat := time.NewTicker(eventStreamHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		lastID, err = uh.sendUserEvents(w, user.ID, lastID)
+		if err != nil {
+			uh.logger.Printf("ERROR: sending user events: %v", err)
+			return
+		}
+		err = rc.Flush()
+		if err != nil {
+			return
+		}
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-wake:
+		case <-heartbeat.C:
+			_, err = io.WriteString(w, ": heartbeat\n\n")
+			if err != nil {
+				return
+			}
+		}
+	}
+}
+
+// resetIfPurged writes a reset event when the events after lastID are gone and returns the seq
+// the stream goes on from
+func (uh *UserEventHandler) resetIfPurged(w io.Writer, userID int, lastID int64) (int64, error) {
+	purged, err := uh.userEventStore.UserEventsPurgedAfter(userID, lastID)
+	if err != nil || !purged {
+		return lastID, err
+	}
+	latest, err := uh.userEventStore.LatestUserEventSeq(userID)
+	if err != nil {
+		return lastID, err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: {}\n\n", latest, userEventsReset)
+	return latest, err
+}
+
+// sendUserEvents writes every event logged after lastID and returns the seq of the last one sent
+func (uh *UserEventHandler) sendUserEvents(w io.Writer, userID int, lastID int64) (int64, error) {
+	for {
+		batch, err := uh.userEventStore.ListUserEvents(userID, lastID, eventStreamBatch)
+		if err != nil {
+			return lastID, err
+		}
+		for _, event := range batch {
+			err = writeUserEvent(w, event)
+			if err != nil {
+				return lastID, err
+			}
+			lastID = event.Seq
+		}
+		if len(batch) < eventStreamBatch {
+			return lastID, nil
+		}
+	}
+}
@@ -1,12 +1,15 @@
 package api
 
 import (
+	"database/sql"
 	"encoding/json"
 	"errors"
 	"log"
 	"net/http"
 	"regexp"
+	"time"
 
+	"github.com/kodega2016/femapi/internal/middleware"
 	"github.com/kodega2016/femapi/internal/store"
 	"github.com/kodega2016/femapi/internal/utils"
 )
@@ -16,6 +19,7 @@ type registerUserRequest struct {
 	Email    string `json:"email"`
 	Password string `json:"password"`
 	Bio      string `json:"bio"`
+	Timezone string `json:"timezone"`
 }
 
 type UserHandler struct {
@@ -50,6 +54,14 @@ func (h *UserHandler) validateRegisterRequest(req *registerUserRequest) error {
 		return errors.New("password is required")
 	}
 
+	// an IANA name such as Europe/Berlin, streaks and other day based stats use it
+	if req.Timezone != "" {
+		_, err := time.LoadLocation(req.Timezone)
+		if err != nil {
+			return errors.New("invalid timezone")
+		}
+	}
+
 	return nil
 }
 
@@ -75,6 +87,7 @@ func (h *UserHandler) HandleRegisterUser(w http.ResponseWriter, r *http.Request)
 	user := &store.User{
 		Username: req.Username,
 		Email:    req.Email,
+		Timezone: req.Timezone,
 	}
 	if req.Bio != "" {
 		user.Bio = req.Bio
@@ -100,3 +113,98 @@ func (h *UserHandler) HandleRegisterUser(w http.ResponseWriter, r *http.Request)
 
 	utils.WriteJSON(w, http.StatusCreated, utils.Envelope{"user": user})
 }
+
+func (h *UserHandler) HandleFollowUser(w http.ResponseWriter, r *http.Request) {
+	followeeID, ok := readUserID(w, r, h.userStore, h.logger)
+	if !ok {
+		return
+	}
+
+	currentUser := middleware.GetUser(r)
+	if followeeID == currentUser.ID {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "you cannot follow yourself"})
+		return
+	}
+
+	// the follow only counts once the followee approves it
+	approved, err := h.userStore.FollowUser(currentUser.ID, followeeID)
+	if err != nil {
+		h.logger.Printf("ERROR: FollowUser %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+	if approved {
+		utils.WriteJSON(w, http.StatusOK, utils.Envelope{"status": "approved"})
+		return
+	}
+	utils.WriteJSON(w, http.StatusAccepted, utils.Envelope{"status": "pending"})
+}
+
+func (h *UserHandler) HandleUnfollowUser(w http.ResponseWriter, r *http.Request) {
+	followeeID, ok := readUserID(w, r, h.userStore, h.logger)
+	if !ok {
+		return
+	}
+
+	err := h.userStore.UnfollowUser(middleware.GetUser(r).ID, followeeID)
+	if err != nil {
+		h.logger.Printf("ERROR: UnfollowUser %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleListFollowers lists the approved followers of the current user, or with
+// ?status=pending the requests waiting for approval
+func (h *UserHandler) HandleListFollowers(w http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+	if status != "" && status != "approved" && status != "pending" {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "status must be approved or pending"})
+		return
+	}
+
+	followers, err := h.userStore.ListFollowers(middleware.GetUser(r).ID, status == "pending")
+	if err != nil {
+		h.logger.Printf("ERROR: ListFollowers %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"followers": followers})
+}
+
+// HandleApproveFollower approves the follow request of the user in the url
+func (h *UserHandler) HandleApproveFollower(w http.ResponseWriter, r *http.Request) {
+	followerID, ok := readUserID(w, r, h.userStore, h.logger)
+	if !ok {
+		return
+	}
+
+	err := h.userStore.ApproveFollower(middleware.GetUser(r).ID, followerID)
+	if errors.Is(err, sql.ErrNoRows) {
+		utils.WriteJSON(w, http.StatusNotFound, utils.Envelope{"error": "follow request not found"})
+		return
+	}
+	if err != nil {
+		h.logger.Printf("ERROR: ApproveFollower %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleRemoveFollower removes a follower of the current user or declines a pending request
+func (h *UserHandler) HandleRemoveFollower(w http.ResponseWriter, r *http.Request) {
+	followerID, ok := readUserID(w, r, h.userStore, h.logger)
+	if !ok {
+		return
+	}
+
+	err := h.userStore.UnfollowUser(followerID, middleware.GetUser(r).ID)
+	if err != nil {
+		h.logger.Printf("ERROR: UnfollowUser %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
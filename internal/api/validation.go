@@ -0,0 +1,125 @@
+package api
+
+import (
+	"errors"
+
+	"github.com/kodega2016/femapi/internal/store"
+)
+
+// maxSplitOverrun tolerates rounding when splits are compared with the total distance
+const maxSplitOverrun = 1.01
+
+// normalizeWorkout fills the defaults of a workout before it is validated and saved
+func normalizeWorkout(workout *store.Workout) {
+	if workout.Type == "" {
+		workout.Type = store.WorkoutTypeStrength
+	}
+	if workout.Visibility == "" {
+		workout.Visibility = store.VisibilityPrivate
+	}
+
+	// the recorded sport is kept as long as it still fits the workout type
+	if workout.Activity != nil && (workout.Activity.Sport == "" || store.WorkoutTypeForSport(workout.Activity.Sport) != workout.Type) {
+		workout.Activity.Sport = store.SportForWorkoutType(workout.Type)
+	}
+}
+
+func validateWorkout(workout *store.Workout) error {
+	if workout.Title == "" {
+		return errors.New("title is required")
+	}
+	if len(workout.Title) > 100 {
+		return errors.New("title cannot be greater than 100 characters")
+	}
+	if workout.DurationInMinutes < 0 || workout.CaloriesBurned < 0 {
+		return errors.New("duration and calories_burned cannot be negative")
+	}
+	if workout.Visibility != "" && !store.IsValidVisibility(workout.Visibility) {
+		return errors.New("invalid workout visibility")
+	}
+
+	workoutType := workout.Type
+	if workoutType == "" {
+		workoutType = store.WorkoutTypeStrength
+	}
+	if !store.IsValidWorkoutType(workoutType) {
+		return errors.New("type must be one of strength, run, ride, swim, row, hiit or yoga")
+	}
+
+	cardio := store.IsCardioType(workoutType)
+	switch {
+	case cardio && workout.Activity == nil:
+		return errors.New(workoutType + " workouts need activity details with distance_meters")
+	case workoutType == store.WorkoutTypeStrength && workout.Activity != nil:
+		return errors.New("strength workouts cannot have activity details")
+	}
+	if workout.Activity != nil {
+		err := validateActivity(workout.Activity, cardio)
+		if err != nil {
+			return err
+		}
+	}
+
+	for i := range workout.Entries {
+		err := validateEntry(workoutType, &workout.Entries[i])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateActivity checks the cardio details, distance is only required for cardio types
+// (a yoga class with a heart rate strap has time but no distance)
+func validateActivity(activity *store.Activity, cardio bool) error {
+	if activity.DistanceMeters < 0 || activity.ElevationGainMeters < 0 {
+		return errors.New("activity distance_meters and elevation_gain_meters cannot be negative")
+	}
+	if cardio && activity.DistanceMeters == 0 {
+		return errors.New("activity distance_meters must be greater than 0")
+	}
+	if activity.DurationSeconds < 0 || activity.MovingTimeSeconds < 0 {
+		return errors.New("activity duration_seconds and moving_time_seconds cannot be negative")
+	}
+	if activity.DurationSeconds == 0 && activity.MovingTimeSeconds == 0 {
+		return errors.New("activity needs duration_seconds or moving_time_seconds")
+	}
+	if activity.DurationSeconds > 0 && activity.MovingTimeSeconds > activity.DurationSeconds {
+		return errors.New("activity moving_time_seconds cannot be greater than duration_seconds")
+	}
+	for _, rate := range []*int{activity.AvgHeartRate, activity.MaxHeartRate} {
+		if rate != nil && (*rate < 20 || *rate > 250) {
+			return errors.New("activity heart rates must be between 20 and 250")
+		}
+	}
+
+	splitDistance := 0.0
+	for _, split := range activity.Splits {
+		if split.DistanceMeters <= 0 || split.DurationSeconds <= 0 {
+			return errors.New("every split needs a distance_meters and duration_seconds greater than 0")
+		}
+		splitDistance += split.DistanceMeters
+	}
+	if splitDistance > activity.DistanceMeters*maxSplitOverrun+1 {
+		return errors.New("splits cannot add up to more than the activity distance")
+	}
+	return nil
+}
+
+// validateEntry applies the entry rules of the workout type: strength sets are counted in either
+// reps or seconds, entries of other types (drills, poses, intervals) may have neither
+func validateEntry(workoutType string, entry *store.WorkoutEntry) error {
+	if entry.ExerciseName == "" {
+		return errors.New("exercise_name is required")
+	}
+	if entry.ExerciseSets <= 0 {
+		return errors.New("exercise_sets must be greater than 0")
+	}
+	if entry.Reps != nil && entry.DurationSeconds != nil {
+		return errors.New("an entry cannot have both reps and duration_seconds")
+	}
+	if workoutType == store.WorkoutTypeStrength && entry.Reps == nil && entry.DurationSeconds == nil {
+		return errors.New("a strength entry needs either reps or duration_seconds")
+	}
+	return nil
+}
@@ -0,0 +1,160 @@
+package api
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/kodega2016/femapi/internal/middleware"
+	"github.com/kodega2016/femapi/internal/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type visibilityTest struct {
+	router     chi.Router
+	users      *store.PostgresUserStrore
+	workouts   *store.PostgresWorkoutStore
+	owner      *store.User
+	follower   *store.User
+	stranger   *store.User
+	workoutIDs map[string]string
+}
+
+func newVisibilityTest(t *testing.T) *visibilityTest {
+	db := setupTestDB(t)
+	t.Cleanup(func() { db.Close() })
+
+	logger := log.New(io.Discard, "", 0)
+	vt := &visibilityTest{
+		users:      store.NewPostgresUserStore(db),
+		workouts:   store.NewPostgresWorkoutStore(db),
+		workoutIDs: make(map[string]string),
+	}
+	workoutHandler := NewWorkoutHandler(vt.workouts, vt.users, nil, nil, logger)
+	userHandler := NewUserHandler(vt.users, logger)
+	shareHandler := NewShareHandler(vt.workouts, store.NewPostgresShareLinkStore(db), logger)
+
+	vt.router = chi.NewRouter()
+	vt.router.Get("/workouts/{id}", workoutHandler.HandleGetWorkoutByID)
+	vt.router.Post("/workouts/{id}/shares", shareHandler.HandleCreateShareLink)
+	vt.router.Delete("/workouts/{id}/shares/{slug}", shareHandler.HandleRevokeShareLink)
+	vt.router.Get("/shared/{slug}", shareHandler.HandleGetSharedWorkout)
+	vt.router.Post("/users/{id}/follow", userHandler.HandleFollowUser)
+	vt.router.Get("/users/me/followers", userHandler.HandleListFollowers)
+	vt.router.Post("/users/me/followers/{id}/approve", userHandler.HandleApproveFollower)
+	vt.router.Delete("/users/me/followers/{id}", userHandler.HandleRemoveFollower)
+
+	vt.owner = createTestUser(t, vt.users, "owner")
+	vt.follower = createTestUser(t, vt.users, "follower")
+	vt.stranger = createTestUser(t, vt.users, "stranger")
+	for _, visibility := range []string{store.VisibilityPrivate, store.VisibilityFollowers, store.VisibilityPublic, store.VisibilityUnlisted} {
+		workout := &store.Workout{UserID: vt.owner.ID, Title: visibility + " run", DurationInMinutes: 30, CaloriesBurned: 200, Visibility: visibility}
+		_, err := vt.workouts.CreateWorkout(workout)
+		require.NoError(t, err)
+		vt.workoutIDs[visibility] = workout.PublicID
+	}
+	return vt
+}
+
+func createTestUser(t *testing.T, users store.UserStore, username string) *store.User {
+	t.Helper()
+	user := &store.User{Username: username, Email: username + "@example.com"}
+	require.NoError(t, user.PasswordHash.Set("password123"))
+	require.NoError(t, users.CreateUser(user))
+	return user
+}
+
+func (vt *visibilityTest) do(user *store.User, method, path string) *httptest.ResponseRecorder {
+	rr := httptest.NewRecorder()
+	vt.router.ServeHTTP(rr, middleware.SetUser(httptest.NewRequest(method, path, nil), user))
+	return rr
+}
+
+// visible tells for every visibility level whether user is shown the workout
+func (vt *visibilityTest) visible(t *testing.T, user *store.User) map[string]bool {
+	t.Helper()
+	visible := make(map[string]bool)
+	for visibility, id := range vt.workoutIDs {
+		rr := vt.do(user, http.MethodGet, "/workouts/"+id)
+		require.Contains(t, []int{http.StatusOK, http.StatusNotFound}, rr.Code)
+		visible[visibility] = rr.Code == http.StatusOK
+	}
+	return visible
+}
+
+func TestWorkoutVisibility(t *testing.T) {
+	vt := newVisibilityTest(t)
+
+	assert.Equal(t, map[string]bool{"private": true, "followers": true, "public": true, "unlisted": true}, vt.visible(t, vt.owner))
+	// unlisted workouts are not listed anywhere but open to whoever has the id
+	assert.Equal(t, map[string]bool{"private": false, "followers": false, "public": true, "unlisted": true}, vt.visible(t, vt.stranger))
+	assert.Equal(t, map[string]bool{"private": false, "followers": false, "public": true, "unlisted": true}, vt.visible(t, store.AnonymousUser))
+}
+
+func TestFollowersOnlyWorkoutsNeedAnApprovedFollow(t *testing.T) {
+	vt := newVisibilityTest(t)
+	followersOnly := "/workouts/" + vt.workoutIDs[store.VisibilityFollowers]
+
+	rr := vt.do(vt.follower, http.MethodPost, "/users/"+vt.owner.PublicID+"/follow")
+	require.Equal(t, http.StatusAccepted, rr.Code)
+	assert.JSONEq(t, `{"status":"pending"}`, rr.Body.String())
+	assert.Equal(t, http.StatusNotFound, vt.do(vt.follower, http.MethodGet, followersOnly).Code, "a pending request does not count")
+
+	rr = vt.do(vt.owner, http.MethodGet, "/users/me/followers?status=pending")
+	require.Equal(t, http.StatusOK, rr.Code)
+	var pending struct {
+		Followers []store.Follower `json:"followers"`
+	}
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&pending))
+	require.Len(t, pending.Followers, 1)
+	assert.Equal(t, "follower", pending.Followers[0].Username)
+	assert.Nil(t, pending.Followers[0].ApprovedAt)
+
+	// only the followee can approve, the stranger has no request from the follower
+	assert.Equal(t, http.StatusNotFound, vt.do(vt.stranger, http.MethodPost, "/users/me/followers/"+vt.follower.PublicID+"/approve").Code)
+	require.Equal(t, http.StatusNoContent, vt.do(vt.owner, http.MethodPost, "/users/me/followers/"+vt.follower.PublicID+"/approve").Code)
+
+	assert.Equal(t, map[string]bool{"private": false, "followers": true, "public": true, "unlisted": true}, vt.visible(t, vt.follower))
+	rr = vt.do(vt.follower, http.MethodPost, "/users/"+vt.owner.PublicID+"/follow")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `{"status":"approved"}`, rr.Body.String())
+
+	require.Equal(t, http.StatusNoContent, vt.do(vt.owner, http.MethodDelete, "/users/me/followers/"+vt.follower.PublicID).Code)
+	assert.Equal(t, http.StatusNotFound, vt.do(vt.follower, http.MethodGet, followersOnly).Code, "a removed follower loses access")
+}
+
+func TestShareLinks(t *testing.T) {
+	vt := newVisibilityTest(t)
+	private := "/workouts/" + vt.workoutIDs[store.VisibilityPrivate]
+
+	assert.Equal(t, http.StatusNotFound, vt.do(vt.stranger, http.MethodPost, private+"/shares").Code, "someone else's workout cannot be shared")
+
+	rr := vt.do(vt.owner, http.MethodPost, private+"/shares")
+	require.Equal(t, http.StatusCreated, rr.Code)
+	var created struct {
+		ShareLink store.ShareLink `json:"share_link"`
+	}
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&created))
+	slug := created.ShareLink.Slug
+	require.NotEmpty(t, slug)
+
+	// the link opens the private workout to anyone holding it
+	rr = vt.do(store.AnonymousUser, http.MethodGet, "/shared/"+slug)
+	require.Equal(t, http.StatusOK, rr.Code)
+	var shared struct {
+		Workout sharedWorkout `json:"workout"`
+	}
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&shared))
+	assert.Equal(t, "private run", shared.Workout.Title)
+	assert.Equal(t, http.StatusNotFound, vt.do(vt.stranger, http.MethodGet, private).Code, "the workout itself stays private")
+
+	assert.Equal(t, http.StatusNotFound, vt.do(vt.stranger, http.MethodDelete, private+"/shares/"+slug).Code)
+	require.Equal(t, http.StatusNoContent, vt.do(vt.owner, http.MethodDelete, private+"/shares/"+slug).Code)
+	assert.Equal(t, http.StatusNotFound, vt.do(store.AnonymousUser, http.MethodGet, "/shared/"+slug).Code)
+	assert.Equal(t, http.StatusNotFound, vt.do(store.AnonymousUser, http.MethodGet, "/shared/no-such-link").Code)
+}
@@ -0,0 +1,367 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/kodega2016/femapi/internal/middleware"
+	"github.com/kodega2016/femapi/internal/store"
+	"github.com/kodega2016/femapi/internal/utils"
+	"github.com/kodega2016/femapi/internal/webhooks"
+)
+
+const (
+	maxWebhooksPerUser   = 10
+	maxWebhookURL        = 2048
+	defaultDeliveryLimit = 50
+	maxDeliveryLimit     = 200
+)
+
+type WebhookHandler struct {
+	webhookStore store.WebhookStore
+	// allowLoopback accepts endpoints on this machine, for local development only
+	allowLoopback bool
+	logger        *log.Logger
+}
+
+func NewWebhookHandler(webhookStore store.WebhookStore, allowLoopback bool, logger *log.Logger) *WebhookHandler {
+	return &WebhookHandler{
+		webhookStore:  webhookStore,
+		allowLoopback: allowLoopback,
+		logger:        logger,
+	}
+}
+
+// webhookRequest is the body of both create and update, on update only the given fields change
+type webhookRequest struct {
+	URL    *string   `json:"url"`
+	Events *[]string `json:"events"`
+	Active *bool     `json:"active"`
+}
+
+// validateWebhookURL only accepts https endpoints. Hosts given as an address must be public,
+// names are checked when the dispatcher connects. With allowLoopback, loopback hosts are
+// accepted over plain http too.
+func validateWebhookURL(raw string, allowLoopback bool) error {
+	if len(raw) > maxWebhookURL {
+		return errors.New("url cannot be longer than 2048 characters")
+	}
+	parsed, err := url.Parse(raw)
+	if err != nil || parsed.Host == "" || parsed.User != nil {
+		return errors.New("url must be an absolute http or https url")
+	}
+	if parsed.Scheme != "https" && parsed.Scheme != "http" {
+		return errors.New("url must be an absolute http or https url")
+	}
+
+	host := strings.ToLower(strings.TrimSuffix(parsed.Hostname(), "."))
+	loopback := host == "localhost" || strings.HasSuffix(host, ".localhost")
+	if addr, err := netip.ParseAddr(host); err == nil {
+		if !webhooks.AllowedAddr(addr, allowLoopback) {
+			return errors.New("url must point to a public address")
+		}
+		loopback = addr.Unmap().IsLoopback()
+	}
+	if loopback && !allowLoopback {
+		return errors.New("url must point to a public address")
+	}
+	if parsed.Scheme == "http" && !loopback {
+		return errors.New("url must use https")
+	}
+	return nil
+}
+
+// apply copies the given fields of the request onto the webhook
+func (req *webhookRequest) apply(webhook *store.Webhook, allowLoopback bool) error {
+	if req.URL != nil {
+		webhook.URL = strings.TrimSpace(*req.URL)
+		err := validateWebhookURL(webhook.URL, allowLoopback)
+		if err != nil {
+			return err
+		}
+	}
+	if req.Events != nil {
+		events := []string{}
+		seen := make(map[string]bool)
+		for _, event := range *req.Events {
+			if !store.IsValidWebhookEvent(event) {
+				return errors.New("events must be one of " + strings.Join(store.WebhookEventTypes, ", "))
+			}
+			if !seen[event] {
+				seen[event] = true
+				events = append(events, event)
+			}
+		}
+		if len(events) == 0 {
+			return errors.New("events cannot be empty")
+		}
+		webhook.Events = events
+	}
+	if req.Active != nil {
+		webhook.Active = *req.Active
+	}
+	return nil
+}
+
+// loadWebhook resolves the {webhookID} url param to an endpoint of the current user
+func (wh *WebhookHandler) loadWebhook(w http.ResponseWriter, r *http.Request) (*store.Webhook, bool) {
+	ref, err := utils.ReadRefParam(r, "webhookID")
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid webhook id"})
+		return nil, false
+	}
+	// webhooks never had numeric ids
+	if ref.IsLegacy() {
+		utils.WriteJSON(w, http.StatusNotFound, utils.Envelope{"error": "webhook not found"})
+		return nil, false
+	}
+
+	webhook, err := wh.webhookStore.GetWebhook(middleware.GetUser(r).ID, ref.PublicID)
+	if errors.Is(err, sql.ErrNoRows) {
+		utils.WriteJSON(w, http.StatusNotFound, utils.Envelope{"error": "webhook not found"})
+		return nil, false
+	}
+	if err != nil {
+		wh.logger.Printf("ERROR: getWebhook: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return nil, false
+	}
+	return webhook, true
+}
+
+// readDeliveryID reads the {deliveryID} url param, it writes the error response itself
+func readDeliveryID(w http.ResponseWriter, r *http.Request) (string, bool) {
+	ref, err := utils.ReadRefParam(r, "deliveryID")
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid delivery id"})
+		return "", false
+	}
+	if ref.IsLegacy() {
+		utils.WriteJSON(w, http.StatusNotFound, utils.Envelope{"error": "delivery not found"})
+		return "", false
+	}
+	return ref.PublicID, true
+}
+
+// HandleCreateWebhook registers an endpoint. The secret deliveries are signed with is only
+// returned here.
+func (wh *WebhookHandler) HandleCreateWebhook(w http.ResponseWriter, r *http.Request) {
+	var req webhookRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid request payload"})
+		return
+	}
+	if req.URL == nil || req.Events == nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "url and events are required"})
+		return
+	}
+
+	userID := middleware.GetUser(r).ID
+	webhook := &store.Webhook{UserID: userID, Active: true}
+	err = req.apply(webhook, wh.allowLoopback)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": err.Error()})
+		return
+	}
+
+	existing, err := wh.webhookStore.ListWebhooks(userID)
+	if err != nil {
+		wh.logger.Printf("ERROR: listWebhooks: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+	if len(existing) >= maxWebhooksPerUser {
+		utils.WriteJSON(w, http.StatusConflict, utils.Envelope{"error": "you cannot register more than 10 webhooks"})
+		return
+	}
+
+	webhook.Secret, err = webhooks.NewSecret()
+	if err != nil {
+		wh.logger.Printf("ERROR: newSecret: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+	err = wh.webhookStore.CreateWebhook(webhook)
+	if err != nil {
+		wh.logger.Printf("ERROR: createWebhook: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+	utils.WriteJSON(w, http.StatusCreated, utils.Envelope{"webhook": webhook})
+}
+
+func (wh *WebhookHandler) HandleListWebhooks(w http.ResponseWriter, r *http.Request) {
+	userWebhooks, err := wh.webhookStore.ListWebhooks(middleware.GetUser(r).ID)
+	if err != nil {
+		wh.logger.Printf("ERROR: listWebhooks: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"webhooks": userWebhooks})
+}
+
+func (wh *WebhookHandler) HandleGetWebhook(w http.ResponseWriter, r *http.Request) {
+	webhook, ok := wh.loadWebhook(w, r)
+	if !ok {
+		return
+	}
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"webhook": webhook})
+}
+
+// HandleUpdateWebhook changes the url, events or active flag of an endpoint. Deliveries of a
+// disabled endpoint wait and are sent once it is enabled again.
+func (wh *WebhookHandler) HandleUpdateWebhook(w http.ResponseWriter, r *http.Request) {
+	webhook, ok := wh.loadWebhook(w, r)
+	if !ok {
+		return
+	}
+
+	var req webhookRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid request payload"})
+		return
+	}
+	err = req.apply(webhook, wh.allowLoopback)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": err.Error()})
+		return
+	}
+
+	err = wh.webhookStore.UpdateWebhook(webhook)
+	if errors.Is(err, sql.ErrNoRows) {
+		utils.WriteJSON(w, http.StatusNotFound, utils.Envelope{"error": "webhook not found"})
+		return
+	}
+	if err != nil {
+		wh.logger.Printf("ERROR: updateWebhook: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"webhook": webhook})
+}
+
+func (wh *WebhookHandler) HandleDeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	webhook, ok := wh.loadWebhook(w, r)
+	if !ok {
+		return
+	}
+
+	err := wh.webhookStore.DeleteWebhook(webhook.UserID, webhook.PublicID)
+	if errors.Is(err, sql.ErrNoRows) {
+		utils.WriteJSON(w, http.StatusNotFound, utils.Envelope{"error": "webhook not found"})
+		return
+	}
+	if err != nil {
+		wh.logger.Printf("ERROR: deleteWebhook: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleListDeliveries is the delivery log of an endpoint newest first, ?status= limits it to
+// pending, succeeded or dead deliveries
+func (wh *WebhookHandler) HandleListDeliveries(w http.ResponseWriter, r *http.Request) {
+	webhook, ok := wh.loadWebhook(w, r)
+	if !ok {
+		return
+	}
+
+	query := r.URL.Query()
+	status := query.Get("status")
+	if status != "" && status != store.DeliveryPending && status != store.DeliverySucceeded && status != store.DeliveryDead {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "status must be pending, succeeded or dead"})
+		return
+	}
+	limit := defaultDeliveryLimit
+	if v := query.Get("limit"); v != "" {
+		parsed, err := strconv.Atoi(v)
+		if err != nil || parsed <= 0 || parsed > maxDeliveryLimit {
+			utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "limit must be between 1 and 200"})
+			return
+		}
+		limit = parsed
+	}
+	offset := 0
+	if v := query.Get("offset"); v != "" {
+		parsed, err := strconv.Atoi(v)
+		if err != nil || parsed < 0 {
+			utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "offset must be 0 or greater"})
+			return
+		}
+		offset = parsed
+	}
+
+	deliveries, err := wh.webhookStore.ListWebhookDeliveries(webhook.ID, status, limit, offset)
+	if err != nil {
+		wh.logger.Printf("ERROR: listWebhookDeliveries: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"deliveries": deliveries})
+}
+
+// HandleGetDelivery returns a delivery with the event it sends and every attempt made
+func (wh *WebhookHandler) HandleGetDelivery(w http.ResponseWriter, r *http.Request) {
+	webhook, ok := wh.loadWebhook(w, r)
+	if !ok {
+		return
+	}
+	deliveryID, ok := readDeliveryID(w, r)
+	if !ok {
+		return
+	}
+
+	delivery, err := wh.webhookStore.GetWebhookDelivery(webhook.ID, deliveryID)
+	if errors.Is(err, sql.ErrNoRows) {
+		utils.WriteJSON(w, http.StatusNotFound, utils.Envelope{"error": "delivery not found"})
+		return
+	}
+	if err != nil {
+		wh.logger.Printf("ERROR: getWebhookDelivery: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"delivery": delivery})
+}
+
+// HandleRetryDelivery queues a delivery to be sent again right away, typically a dead one once
+// the endpoint was fixed
+func (wh *WebhookHandler) HandleRetryDelivery(w http.ResponseWriter, r *http.Request) {
+	webhook, ok := wh.loadWebhook(w, r)
+	if !ok {
+		return
+	}
+	deliveryID, ok := readDeliveryID(w, r)
+	if !ok {
+		return
+	}
+
+	err := wh.webhookStore.RetryWebhookDelivery(webhook.ID, deliveryID)
+	if errors.Is(err, sql.ErrNoRows) {
+		utils.WriteJSON(w, http.StatusNotFound, utils.Envelope{"error": "delivery not found"})
+		return
+	}
+	if err != nil {
+		wh.logger.Printf("ERROR: retryWebhookDelivery: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	delivery, err := wh.webhookStore.GetWebhookDelivery(webhook.ID, deliveryID)
+	if err != nil {
+		wh.logger.Printf("ERROR: getWebhookDelivery: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+	utils.WriteJSON(w, http.StatusAccepted, utils.Envelope{"delivery": delivery})
+}
@@ -7,6 +7,7 @@ import (
 	"errors"
 	"log"
 	"net/http"
+	"time"
 
 	"github.com/kodega2016/femapi/internal/middleware"
 	"github.com/kodega2016/femapi/internal/store"
@@ -14,22 +15,45 @@ import (
 )
 
 type WorkoutHandler struct {
-	workoutStore store.WorkoutStore
-	logger       *log.Logger
+	workoutStore         store.WorkoutStore
+	userStore            store.UserStore
+	bodyMeasurementStore store.BodyMeasurementStore
+	settingsStore        store.SettingsStore
+	logger               *log.Logger
 }
 
-func NewWorkoutHandler(workoutStore store.WorkoutStore, logger *log.Logger) *WorkoutHandler {
+func NewWorkoutHandler(workoutStore store.WorkoutStore, userStore store.UserStore, bodyMeasurementStore store.BodyMeasurementStore, settingsStore store.SettingsStore, logger *log.Logger) *WorkoutHandler {
 	return &WorkoutHandler{
-		workoutStore: workoutStore,
-		logger:       logger,
+		workoutStore:         workoutStore,
+		userStore:            userStore,
+		bodyMeasurementStore: bodyMeasurementStore,
+		settingsStore:        settingsStore,
+		logger:               logger,
+	}
+}
+
+// canViewWorkout applies the workout visibility rules for the given viewer
+func (wh *WorkoutHandler) canViewWorkout(viewer *store.User, workout *store.Workout) (bool, error) {
+	if viewer != nil && !viewer.IsAnonymous() && viewer.ID == workout.UserID {
+		return true, nil
+	}
+
+	switch workout.Visibility {
+	case store.VisibilityPublic, store.VisibilityUnlisted:
+		return true, nil
+	case store.VisibilityFollowers:
+		if viewer == nil || viewer.IsAnonymous() {
+			return false, nil
+		}
+		return wh.userStore.IsFollowing(viewer.ID, workout.UserID)
+	default:
+		return false, nil
 	}
 }
 
 func (wh *WorkoutHandler) HandleGetWorkoutByID(w http.ResponseWriter, r *http.Request) {
-	workoutID, err := utils.ReadIDParams(r)
-	if err != nil {
-		wh.logger.Printf("ERROR:ReadIDParams:%v", err)
-		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid workout id"})
+	workoutID, ok := readWorkoutID(w, r, wh.workoutStore, wh.logger)
+	if !ok {
 		return
 	}
 
@@ -46,14 +70,40 @@ func (wh *WorkoutHandler) HandleGetWorkoutByID(w http.ResponseWriter, r *http.Re
 		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
 		return
 	}
+
+	canView, err := wh.canViewWorkout(middleware.GetUser(r), workout)
+	if err != nil {
+		wh.logger.Printf("ERROR:canViewWorkout:%v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	// hidden workouts are reported as missing so their ids cannot be probed
+	if !canView {
+		utils.WriteJSON(w, http.StatusNotFound, utils.Envelope{"error": "workout not found"})
+		return
+	}
+
+	etag := workoutETag(workout)
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "private, no-cache")
+	if ifNoneMatch := r.Header.Get("If-None-Match"); ifNoneMatch != "" && utils.MatchETag(ifNoneMatch, etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
 	utils.WriteJSON(w, http.StatusOK, utils.Envelope{
 		"workout": workout,
 	})
 }
 
 func (wh *WorkoutHandler) HandleCreateWorkout(w http.ResponseWriter, r *http.Request) {
-	var workout store.Workout
-	err := json.NewDecoder(r.Body).Decode(&workout)
+	// calories_burned is read separately to tell an omitted value from an explicit 0
+	var createWorkoutRequest struct {
+		store.Workout
+		CaloriesBurned *int `json:"calories_burned"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&createWorkoutRequest)
 	if err != nil {
 		wh.logger.Printf("ERROR: decodingCreateWorkout :%v", err)
 		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid request sent"})
@@ -66,7 +116,29 @@ func (wh *WorkoutHandler) HandleCreateWorkout(w http.ResponseWriter, r *http.Req
 		return
 	}
 
+	workout := createWorkoutRequest.Workout
+	workout.CaloriesEstimated = createWorkoutRequest.CaloriesBurned == nil
+	if workout.Visibility == "" {
+		settings, ok := loadSettings(w, r, wh.settingsStore, wh.logger)
+		if !ok {
+			return
+		}
+		workout.Visibility = settings.DefaultWorkoutVisibility
+	}
+	normalizeWorkout(&workout)
+	err = validateWorkout(&workout)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": err.Error()})
+		return
+	}
+
 	workout.UserID = currentUser.ID
+	err = applyCalories(wh.bodyMeasurementStore, &workout, currentUser.ID, createWorkoutRequest.CaloriesBurned)
+	if err != nil {
+		wh.logger.Printf("ERROR: applyCalories: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "failed to create workout"})
+		return
+	}
 
 	createdWorkout, err := wh.workoutStore.CreateWorkout(&workout)
 	if err != nil {
@@ -74,14 +146,13 @@ func (wh *WorkoutHandler) HandleCreateWorkout(w http.ResponseWriter, r *http.Req
 		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "failed to create workout"})
 		return
 	}
+	w.Header().Set("ETag", workoutETag(createdWorkout))
 	utils.WriteJSON(w, http.StatusCreated, utils.Envelope{"workout": createdWorkout})
 }
 
 func (wh *WorkoutHandler) HandleUpdateWorkoutByID(w http.ResponseWriter, r *http.Request) {
-	workoutID, err := utils.ReadIDParams(r)
-	if err != nil {
-		wh.logger.Printf("ERROR: readIDParams: %v", err)
-		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid workout id to update"})
+	workoutID, ok := readWorkoutID(w, r, wh.workoutStore, wh.logger)
+	if !ok {
 		return
 	}
 
@@ -96,11 +167,45 @@ func (wh *WorkoutHandler) HandleUpdateWorkoutByID(w http.ResponseWriter, r *http
 		http.NotFound(w, r)
 		return
 	}
+
+	currentUser := middleware.GetUser(r)
+	if currentUser == nil || currentUser == store.AnonymousUser {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "you must be logged in to update the workout"})
+		return
+	}
+
+	// the owner is checked before the body so nobody else learns how it would be validated
+	workoutOwner, err := wh.workoutStore.GetWorkoutOwner(workoutID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "workout doesnot exist."})
+			return
+		}
+
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	if workoutOwner != currentUser.ID {
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "you are not authorized to update this workout"})
+		return
+	}
+
+	// the version is compared to the stored workout, before the request changes or estimates it
+	if !checkIfMatch(w, r, existingWorkout) {
+		return
+	}
+
 	var updateWorkoutRequest struct {
 		Title           *string              `json:"title"`
 		Description     *string              `json:"description"`
 		DurationMinutes *int                 `json:"duration"`
 		CaloriesBurned  *int                 `json:"calories_burned"`
+		Estimate        *bool                `json:"calories_estimated"`
+		Type            *string              `json:"type"`
+		Visibility      *string              `json:"visibility"`
+		PerformedAt     *time.Time           `json:"performed_at"`
+		Activity        *store.Activity      `json:"activity"`
 		Entries         []store.WorkoutEntry `json:"entries"`
 	}
 
@@ -122,50 +227,74 @@ func (wh *WorkoutHandler) HandleUpdateWorkoutByID(w http.ResponseWriter, r *http
 		existingWorkout.DurationInMinutes = *updateWorkoutRequest.DurationMinutes
 	}
 
-	if updateWorkoutRequest.CaloriesBurned != nil {
-		existingWorkout.CaloriesBurned = *updateWorkoutRequest.CaloriesBurned
+	// sending calories_estimated=true hands the value back to the estimator
+	if updateWorkoutRequest.Estimate != nil && *updateWorkoutRequest.Estimate && updateWorkoutRequest.CaloriesBurned == nil {
+		existingWorkout.CaloriesEstimated = true
+	}
+
+	if updateWorkoutRequest.Visibility != nil {
+		if !store.IsValidVisibility(*updateWorkoutRequest.Visibility) {
+			utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid workout visibility"})
+			return
+		}
+		existingWorkout.Visibility = *updateWorkoutRequest.Visibility
 	}
 
 	if updateWorkoutRequest.Entries != nil {
 		existingWorkout.Entries = updateWorkoutRequest.Entries
 	}
 
-	currentUser := middleware.GetUser(r)
-	if currentUser == nil || currentUser == store.AnonymousUser {
-		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "you must be logged in to update the workout"})
-		return
+	if updateWorkoutRequest.PerformedAt != nil {
+		existingWorkout.PerformedAt = updateWorkoutRequest.PerformedAt.UTC()
 	}
 
-	workoutOwner, err := wh.workoutStore.GetWorkoutOwner(workoutID)
-	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "workout doesnot exist."})
-			return
+	if updateWorkoutRequest.Type != nil {
+		existingWorkout.Type = *updateWorkoutRequest.Type
+		// turning a workout into a strength workout drops its cardio details
+		if existingWorkout.Type == store.WorkoutTypeStrength && updateWorkoutRequest.Activity == nil {
+			existingWorkout.Activity = nil
 		}
+	}
 
-		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+	if updateWorkoutRequest.Activity != nil {
+		existingWorkout.Activity = updateWorkoutRequest.Activity
+	}
+
+	normalizeWorkout(existingWorkout)
+	err = validateWorkout(existingWorkout)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": err.Error()})
 		return
 	}
 
-	if workoutOwner != currentUser.ID {
-		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "you are not authorized to update this workout"})
+	err = applyCalories(wh.bodyMeasurementStore, existingWorkout, existingWorkout.UserID, updateWorkoutRequest.CaloriesBurned)
+	if err != nil {
+		wh.logger.Printf("ERROR: applyCalories: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
 		return
 	}
 
-	err = wh.workoutStore.UpdateWorkout(existingWorkout)
+	err = wh.workoutStore.UpdateWorkout(existingWorkout, currentUser.ID)
+	if errors.Is(err, store.ErrEditConflict) {
+		utils.WriteJSON(w, http.StatusPreconditionFailed, utils.Envelope{"error": "workout has been modified, fetch the latest version and retry"})
+		return
+	}
+	if errors.Is(err, store.ErrEntryNotFound) {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "unknown entry id"})
+		return
+	}
 	if err != nil {
 		wh.logger.Printf("ERROR: updateWokout: %v", err)
 		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error:": "internal server error"})
 		return
 	}
+	w.Header().Set("ETag", workoutETag(existingWorkout))
 	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"workout": existingWorkout})
 }
 
 func (wh *WorkoutHandler) HandleDeleteWorkout(w http.ResponseWriter, r *http.Request) {
-	workoutID, err := utils.ReadIDParams(r)
-	if err != nil {
-		wh.logger.Printf("ERROR: parseWorkoutIDs:%v", err)
-		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "failed to get the id"})
+	workoutID, ok := readWorkoutID(w, r, wh.workoutStore, wh.logger)
+	if !ok {
 		return
 	}
 
@@ -191,7 +320,22 @@ func (wh *WorkoutHandler) HandleDeleteWorkout(w http.ResponseWriter, r *http.Req
 		return
 	}
 
-	err = wh.workoutStore.DeleteWorkout(workoutID)
+	workout, err := wh.workoutStore.GetWorkoutByID(workoutID)
+	if err != nil {
+		wh.logger.Printf("ERROR: getWorkoutByID:%v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	if !checkIfMatch(w, r, workout) {
+		return
+	}
+
+	err = wh.workoutStore.DeleteWorkout(workoutID, workout.Version)
+	if errors.Is(err, store.ErrEditConflict) {
+		utils.WriteJSON(w, http.StatusPreconditionFailed, utils.Envelope{"error": "workout has been modified, fetch the latest version and retry"})
+		return
+	}
 	if err == sql.ErrNoRows {
 		wh.logger.Printf("ERROR: deleteWorkout:%v", err)
 		utils.WriteJSON(w, http.StatusNotFound, utils.Envelope{"error": "failed to get the workout"})
@@ -205,3 +349,44 @@ func (wh *WorkoutHandler) HandleDeleteWorkout(w http.ResponseWriter, r *http.Req
 	}
 	w.WriteHeader(http.StatusNoContent)
 }
+
+func (wh *WorkoutHandler) HandleListTrash(w http.ResponseWriter, r *http.Request) {
+	currentUser := middleware.GetUser(r)
+
+	workouts, err := wh.workoutStore.ListTrashedWorkouts(currentUser.ID)
+	if err != nil {
+		wh.logger.Printf("ERROR: listTrashedWorkouts: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"workouts": workouts})
+}
+
+func (wh *WorkoutHandler) HandleRestoreWorkout(w http.ResponseWriter, r *http.Request) {
+	ref, err := utils.ReadIDParams(r)
+	if err != nil {
+		wh.logger.Printf("ERROR: readIDParams: %v", err)
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid workout id"})
+		return
+	}
+
+	currentUser := middleware.GetUser(r)
+	workoutID, err := wh.workoutStore.RestoreWorkout(ref, currentUser.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		utils.WriteJSON(w, http.StatusNotFound, utils.Envelope{"error": "workout not found in trash"})
+		return
+	}
+	if err != nil {
+		wh.logger.Printf("ERROR: restoreWorkout: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	workout, err := wh.workoutStore.GetWorkoutByID(workoutID)
+	if err != nil {
+		wh.logger.Printf("ERROR: getWorkoutByID: %v", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"workout": workout})
+}
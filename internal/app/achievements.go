@@ -0,0 +1,15 @@
+package app
+
+import (
+	"github.com/kodega2016/femapi/internal/achievements"
+	"github.com/kodega2016/femapi/internal/jobs"
+	"github.com/kodega2016/femapi/internal/store"
+)
+
+// BackfillAchievements queues a job evaluating the achievements of every user from their workout
+// history, the job workers of any instance run it. While a backfill is queued or running that
+// job is returned instead of queueing another one.
+func (app *Application) BackfillAchievements() (*store.Job, bool, error) {
+	args := achievements.BackfillArgs{}
+	return app.Jobs.Enqueue(args, jobs.Unique(args.Kind()), jobs.MaxAttempts(3))
+}
@@ -2,25 +2,61 @@
 package app
 
 import (
+	"context"
 	"database/sql"
-	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"time"
 
+	"github.com/kodega2016/femapi/internal/achievements"
 	"github.com/kodega2016/femapi/internal/api"
+	"github.com/kodega2016/femapi/internal/challenges"
+	"github.com/kodega2016/femapi/internal/events"
+	"github.com/kodega2016/femapi/internal/goals"
+	"github.com/kodega2016/femapi/internal/jobs"
+	"github.com/kodega2016/femapi/internal/live"
 	"github.com/kodega2016/femapi/internal/middleware"
+	"github.com/kodega2016/femapi/internal/publicid"
+	"github.com/kodega2016/femapi/internal/scheduler"
 	"github.com/kodega2016/femapi/internal/store"
+	"github.com/kodega2016/femapi/internal/utils"
+	"github.com/kodega2016/femapi/internal/webhooks"
 	"github.com/kodega2016/femapi/migrations"
 )
 
 type Application struct {
-	Logger         *log.Logger
-	WorkoutHandler *api.WorkoutHandler
-	UserHandler    *api.UserHandler
-	TokenHandler   *api.TokenHandler
-	Middleware     middleware.UserMiddleware
-	DB             *sql.DB
+	Logger                 *log.Logger
+	WorkoutHandler         *api.WorkoutHandler
+	UserHandler            *api.UserHandler
+	TokenHandler           *api.TokenHandler
+	ShareHandler           *api.ShareHandler
+	TransferHandler        *api.TransferHandler
+	BodyMeasurementHandler *api.BodyMeasurementHandler
+	GoalHandler            *api.GoalHandler
+	AchievementHandler     *api.AchievementHandler
+	ChallengeHandler       *api.ChallengeHandler
+	CalendarHandler        *api.CalendarHandler
+	SettingsHandler        *api.SettingsHandler
+	LiveSessionHandler     *api.LiveSessionHandler
+	UserEventHandler       *api.UserEventHandler
+	SyncHandler            *api.SyncHandler
+	WebhookHandler         *api.WebhookHandler
+	JobHandler             *api.JobHandler
+	Middleware             middleware.UserMiddleware
+	Idempotency            middleware.IdempotencyMiddleware
+	Jobs                   *jobs.Queue
+	DB                     *sql.DB
+
+	achievementRules      []achievements.Rule
+	achievementEngine     *achievements.Engine
+	liveHub               live.Hub
+	eventNotifier         *events.Notifier
+	webhookDispatcher     *webhooks.Dispatcher
+	jobWorker             *jobs.Worker
+	scheduler             *scheduler.Scheduler
+	allowLoopbackWebhooks bool
+	idempotencyKeyTTL     time.Duration
 }
 
 func NewApplication() (*Application, error) {
@@ -34,31 +70,134 @@ func NewApplication() (*Application, error) {
 	}
 
 	logger := log.New(os.Stdout, "", log.Ldate|log.Ltime)
-	// our store goes here
-	workoutStore := store.NewPostgresWorkoutStore(pgDB)
-	userStore := store.NewPostgresUserStore(pgDB)
-	tokenStore := store.NewPostgresTokenStore(pgDB)
 
-	// our handler goes here
-	workoutHandler := api.NewWorkoutHandler(workoutStore, logger)
-	userHandler := api.NewUserHandler(userStore, logger)
-	tokenHandler := api.NewTokenHandler(tokenStore, userStore, logger)
-	middlewareHandler := middleware.UserMiddleware{
-		UserStore: userStore,
+	// numeric ids are still accepted for one release unless explicitly disabled
+	if os.Getenv("NUMERIC_ID_COMPAT") == "false" {
+		publicid.AllowNumeric = false
 	}
 
+	achievementRules, err := achievements.DefaultRules()
+	if err != nil {
+		return nil, err
+	}
 	app := &Application{
-		Logger:         logger,
-		WorkoutHandler: workoutHandler,
-		UserHandler:    userHandler,
-		TokenHandler:   tokenHandler,
-		Middleware:     middlewareHandler,
-		DB:             pgDB,
+		Logger:                logger,
+		DB:                    pgDB,
+		achievementRules:      achievementRules,
+		liveHub:               newLiveHub(pgDB, logger),
+		eventNotifier:         events.NewNotifier(pgDB, logger),
+		allowLoopbackWebhooks: webhookAllowLoopbackFromEnv(),
+		idempotencyKeyTTL:     idempotencyKeyTTLFromEnv(),
+	}
+	app.wire(pgDB, app.liveHub)
+
+	// the background work runs on the pool, never inside the transaction of a batch
+	jobStore := store.NewPostgresJobStore(pgDB)
+	webhookStore := store.NewPostgresWebhookStore(pgDB)
+	app.jobWorker = jobs.NewWorker(jobStore, logger)
+	jobs.Handle(app.jobWorker, app.achievementEngine.RunBackfillJob)
+
+	app.webhookDispatcher = webhooks.NewDispatcher(webhookStore, logger)
+	app.webhookDispatcher.AllowLoopback = app.allowLoopbackWebhooks
+
+	app.scheduler = scheduler.New(pgDB, store.NewPostgresScheduledTaskStore(pgDB), logger)
+	err = addScheduledTasks(app.scheduler, maintenanceStores{
+		workouts:    store.NewPostgresWorkoutStore(pgDB),
+		tokens:      store.NewPostgresTokenStore(pgDB),
+		idempotency: store.NewPostgresIdempotencyStore(pgDB),
+		webhooks:    webhookStore,
+		userEvents:  store.NewPostgresUserEventStore(pgDB),
+		jobs:        jobStore,
+	}, retentionsFromEnv(), logger)
+	if err != nil {
+		return nil, err
 	}
 
 	return app, nil
 }
 
+// WithTx returns the application with its stores and handlers on tx, sharing the rules, hubs
+// and background work of app. Batches use it to run their requests inside a single transaction.
+// The live events published on it are held back until afterCommit is called once tx committed.
+func (app *Application) WithTx(tx *sql.Tx) (txApp *Application, afterCommit func(ctx context.Context) error) {
+	liveHub := live.NewDeferredHub(app.liveHub)
+	copied := *app
+	copied.wire(tx, liveHub)
+	return &copied, liveHub.Flush
+}
+
+// wire builds the stores on db and the handlers on them
+func (app *Application) wire(db store.Querier, liveHub live.Hub) {
+	logger := app.Logger
+
+	// our store goes here
+	workoutStore := store.NewPostgresWorkoutStore(db)
+	userStore := store.NewPostgresUserStore(db)
+	tokenStore := store.NewPostgresTokenStore(db)
+	shareLinkStore := store.NewPostgresShareLinkStore(db)
+	bodyMeasurementStore := store.NewPostgresBodyMeasurementStore(db)
+	goalStore := store.NewPostgresGoalStore(db)
+	achievementStore := store.NewPostgresAchievementStore(db)
+	challengeStore := store.NewPostgresChallengeStore(db)
+	plannedWorkoutStore := store.NewPostgresPlannedWorkoutStore(db)
+	settingsStore := store.NewPostgresSettingsStore(db)
+	liveSessionStore := store.NewPostgresLiveSessionStore(db)
+	userEventStore := store.NewPostgresUserEventStore(db)
+	idempotencyStore := store.NewPostgresIdempotencyStore(db)
+	webhookStore := store.NewPostgresWebhookStore(db)
+	jobStore := store.NewPostgresJobStore(db)
+
+	// everything derived from the workouts is recomputed when they change
+	goalTracker := goals.NewTracker(goalStore, settingsStore, logger)
+	workoutStore.AddListener(goalTracker)
+	achievementEngine := achievements.NewEngine(achievementStore, settingsStore, app.achievementRules, logger)
+	workoutStore.AddListener(achievementEngine)
+	leaderboard := challenges.NewLeaderboard(challengeStore, logger)
+	workoutStore.AddListener(leaderboard)
+	jobQueue := jobs.NewQueue(jobStore)
+
+	// our handler goes here
+	app.WorkoutHandler = api.NewWorkoutHandler(workoutStore, userStore, bodyMeasurementStore, settingsStore, logger)
+	app.UserHandler = api.NewUserHandler(userStore, logger)
+	app.TokenHandler = api.NewTokenHandler(tokenStore, userStore, logger)
+	app.ShareHandler = api.NewShareHandler(workoutStore, shareLinkStore, logger)
+	app.TransferHandler = api.NewTransferHandler(workoutStore, bodyMeasurementStore, logger)
+	app.BodyMeasurementHandler = api.NewBodyMeasurementHandler(bodyMeasurementStore, settingsStore, logger)
+	app.GoalHandler = api.NewGoalHandler(goalStore, goalTracker, logger)
+	app.AchievementHandler = api.NewAchievementHandler(achievementEngine, logger)
+	app.ChallengeHandler = api.NewChallengeHandler(challengeStore, bodyMeasurementStore, settingsStore, leaderboard, logger)
+	app.CalendarHandler = api.NewCalendarHandler(workoutStore, plannedWorkoutStore, tokenStore, userStore, settingsStore, logger)
+	app.LiveSessionHandler = api.NewLiveSessionHandler(liveSessionStore, workoutStore, userStore, settingsStore, bodyMeasurementStore, liveHub, logger)
+	app.SettingsHandler = api.NewSettingsHandler(settingsStore, goalTracker, achievementEngine, logger)
+	app.UserEventHandler = api.NewUserEventHandler(userEventStore, userStore, app.eventNotifier, logger)
+	app.SyncHandler = api.NewSyncHandler(workoutStore, settingsStore, bodyMeasurementStore, logger)
+	app.WebhookHandler = api.NewWebhookHandler(webhookStore, app.allowLoopbackWebhooks, logger)
+	app.JobHandler = api.NewJobHandler(jobStore, jobQueue, logger)
+	app.Middleware = middleware.UserMiddleware{
+		UserStore: userStore,
+	}
+	_, inTransaction := db.(*sql.Tx)
+	app.Idempotency = middleware.IdempotencyMiddleware{
+		Store:         idempotencyStore,
+		Logger:        logger,
+		TTL:           app.idempotencyKeyTTL,
+		InTransaction: inTransaction,
+	}
+	app.Jobs = jobQueue
+	app.achievementEngine = achievementEngine
+}
+
+// HealthCheck reports the instance is up along with the last run of every scheduled task,
+// which instance ran it and whether this one leads the scheduler
 func (app *Application) HealthCheck(w http.ResponseWriter, r *http.Request) {
-	fmt.Fprintf(w, "Status is available\n")
+	tasks, err := app.scheduler.Status()
+	if err != nil {
+		app.Logger.Printf("ERROR: schedulerStatus: %v", err)
+		utils.WriteJSON(w, http.StatusServiceUnavailable, utils.Envelope{"status": "unavailable"})
+		return
+	}
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{
+		"status":    "available",
+		"scheduler": utils.Envelope{"leader": app.scheduler.IsLeader(), "tasks": tasks},
+	})
 }
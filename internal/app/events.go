@@ -0,0 +1,9 @@
+package app
+
+import "context"
+
+// RunEventNotifier wakes the event streams of this instance when changes are logged for their
+// user, on any instance, until ctx is done
+func (app *Application) RunEventNotifier(ctx context.Context) {
+	app.eventNotifier.Run(ctx)
+}
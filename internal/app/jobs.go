@@ -0,0 +1,9 @@
+package app
+
+import "context"
+
+// RunJobWorker runs the background jobs of every instance until ctx is done, then waits for the
+// running ones to finish
+func (app *Application) RunJobWorker(ctx context.Context) {
+	app.jobWorker.Run(ctx)
+}
@@ -0,0 +1,30 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"os"
+
+	"github.com/kodega2016/femapi/internal/live"
+)
+
+// newLiveHub picks the hub from LIVE_HUB: "memory" keeps live sessions inside this process,
+// which is enough for a single instance. By default events go through postgres so devices
+// connected to different instances see each other.
+func newLiveHub(db *sql.DB, logger *log.Logger) live.Hub {
+	if os.Getenv("LIVE_HUB") == "memory" {
+		return live.NewMemoryHub()
+	}
+	return live.NewPostgresHub(db, logger)
+}
+
+// RunLiveHub receives the live session events published by every instance until ctx is done,
+// the in-memory hub needs no background work
+func (app *Application) RunLiveHub(ctx context.Context) {
+	hub, ok := app.liveHub.(*live.PostgresHub)
+	if !ok {
+		return
+	}
+	hub.Run(ctx)
+}
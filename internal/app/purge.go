@@ -0,0 +1,53 @@
+package app
+
+import (
+	"os"
+	"time"
+)
+
+const (
+	defaultTrashRetention   = 30 * 24 * time.Hour
+	defaultHistoryRetention = 30 * 24 * time.Hour
+	defaultIdempotencyTTL   = 24 * time.Hour
+)
+
+// retentions is how long the scheduled purges keep what they delete
+type retentions struct {
+	trash             time.Duration
+	userEvents        time.Duration
+	webhookDeliveries time.Duration
+	jobs              time.Duration
+	syncMutations     time.Duration
+}
+
+// retentionsFromEnv reads the retention periods, e.g. "720h", every one falls back to 30 days:
+//
+//	WORKOUT_TRASH_RETENTION     trashed workouts before they are deleted for good
+//	USER_EVENT_RETENTION        the change log streams and sync tokens resume from
+//	WEBHOOK_DELIVERY_RETENTION  succeeded and dead webhook deliveries
+//	JOB_RETENTION               jobs that succeeded or failed for good
+//	SYNC_MUTATION_RETENTION     the applied client mutations a retried sync skips
+func retentionsFromEnv() retentions {
+	return retentions{
+		trash:             durationFromEnv("WORKOUT_TRASH_RETENTION", defaultTrashRetention),
+		userEvents:        durationFromEnv("USER_EVENT_RETENTION", defaultHistoryRetention),
+		webhookDeliveries: durationFromEnv("WEBHOOK_DELIVERY_RETENTION", defaultHistoryRetention),
+		jobs:              durationFromEnv("JOB_RETENTION", defaultHistoryRetention),
+		syncMutations:     durationFromEnv("SYNC_MUTATION_RETENTION", defaultHistoryRetention),
+	}
+}
+
+// idempotencyKeyTTLFromEnv reads IDEMPOTENCY_KEY_TTL, how long retries get the stored response,
+// and falls back to a day
+func idempotencyKeyTTLFromEnv() time.Duration {
+	return durationFromEnv("IDEMPOTENCY_KEY_TTL", defaultIdempotencyTTL)
+}
+
+// durationFromEnv parses the variable as a time.Duration, unset or invalid values get fallback
+func durationFromEnv(name string, fallback time.Duration) time.Duration {
+	d, err := time.ParseDuration(os.Getenv(name))
+	if err != nil || d <= 0 {
+		return fallback
+	}
+	return d
+}
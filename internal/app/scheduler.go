@@ -0,0 +1,111 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"log"
+
+	"github.com/kodega2016/femapi/internal/scheduler"
+	"github.com/kodega2016/femapi/internal/store"
+)
+
+const (
+	purgeExpiredTokensSchedule   = "*/15 * * * *"
+	purgeTrashSchedule           = "@hourly"
+	purgeIdempotencyKeysSchedule = "@hourly"
+	purgeHistorySchedule         = "15 * * * *"
+	cleanupOrphansSchedule       = "30 3 * * *"
+)
+
+// maintenanceStores are the stores the scheduled tasks clean up
+type maintenanceStores struct {
+	workouts    store.WorkoutStore
+	tokens      store.TokenStore
+	idempotency store.IdempotencyStore
+	webhooks    store.WebhookStore
+	userEvents  store.UserEventStore
+	jobs        store.JobStore
+}
+
+// addPurge registers a task deleting rows with purge, what names them in the log
+func addPurge(tasks *scheduler.Scheduler, name, schedule, what string, purge func() (int64, error), logger *log.Logger) error {
+	return tasks.Add(name, schedule, func(ctx context.Context) error {
+		purged, err := purge()
+		if err != nil {
+			return err
+		}
+		if purged > 0 {
+			logger.Printf("purged %d %s", purged, what)
+		}
+		return nil
+	})
+}
+
+// addScheduledTasks registers the maintenance tasks the leading instance runs
+func addScheduledTasks(tasks *scheduler.Scheduler, stores maintenanceStores, retention retentions, logger *log.Logger) error {
+	err := addPurge(tasks, "purge-expired-tokens", purgeExpiredTokensSchedule, "expired tokens", stores.tokens.DeleteExpiredTokens, logger)
+	if err != nil {
+		return err
+	}
+
+	// hard delete workouts once they outlive the trash retention period
+	err = addPurge(tasks, "purge-trash", purgeTrashSchedule, "trashed workouts", func() (int64, error) {
+		return stores.workouts.PurgeTrashedWorkouts(retention.trash)
+	}, logger)
+	if err != nil {
+		return err
+	}
+
+	// forget the responses kept for retries once their idempotency key expired
+	err = addPurge(tasks, "purge-idempotency-keys", purgeIdempotencyKeysSchedule, "expired idempotency keys",
+		stores.idempotency.DeleteExpiredIdempotencyKeys, logger)
+	if err != nil {
+		return err
+	}
+
+	// the histories below only grow, each is kept for its own retention period
+	err = addPurge(tasks, "purge-user-events", purgeHistorySchedule, "user events", func() (int64, error) {
+		return stores.userEvents.PurgeUserEvents(retention.userEvents)
+	}, logger)
+	if err != nil {
+		return err
+	}
+
+	err = addPurge(tasks, "purge-webhook-deliveries", purgeHistorySchedule, "webhook deliveries", func() (int64, error) {
+		return stores.webhooks.PurgeWebhookDeliveries(retention.webhookDeliveries)
+	}, logger)
+	if err != nil {
+		return err
+	}
+
+	err = addPurge(tasks, "purge-jobs", purgeHistorySchedule, "finished jobs", func() (int64, error) {
+		return stores.jobs.PurgeFinishedJobs(retention.jobs)
+	}, logger)
+	if err != nil {
+		return err
+	}
+
+	err = addPurge(tasks, "purge-sync-mutations", purgeHistorySchedule, "applied sync mutations", func() (int64, error) {
+		return stores.workouts.PurgeSyncMutations(retention.syncMutations)
+	}, logger)
+	if err != nil {
+		return err
+	}
+
+	// rows whose owner is gone but that no foreign key removes with it, and the webhook events
+	// left without a delivery once theirs were purged
+	return tasks.Add("cleanup-orphans", cleanupOrphansSchedule, func(ctx context.Context) error {
+		keys, keysErr := stores.idempotency.DeleteOrphanedIdempotencyKeys()
+		events, eventsErr := stores.webhooks.DeleteOrphanedWebhookEvents()
+		if keys > 0 || events > 0 {
+			logger.Printf("cleaned up %d orphaned idempotency keys and %d orphaned webhook events", keys, events)
+		}
+		return errors.Join(keysErr, eventsErr)
+	})
+}
+
+// RunScheduler takes part in electing the instance that runs the scheduled tasks and runs them
+// while this one leads, until ctx is done
+func (app *Application) RunScheduler(ctx context.Context) {
+	app.scheduler.Run(ctx)
+}
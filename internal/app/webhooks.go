@@ -0,0 +1,19 @@
+package app
+
+import (
+	"context"
+	"os"
+	"time"
+)
+
+// webhookAllowLoopbackFromEnv reads WEBHOOK_ALLOW_LOOPBACK, set to "true" in development to
+// deliver webhooks to a receiver on the same machine. Anywhere else endpoints must be public.
+func webhookAllowLoopbackFromEnv() bool {
+	return os.Getenv("WEBHOOK_ALLOW_LOOPBACK") == "true"
+}
+
+// RunWebhookDispatcher sends the queued webhook deliveries on every interval until ctx is done,
+// instances share the work
+func (app *Application) RunWebhookDispatcher(ctx context.Context, interval time.Duration) {
+	app.webhookDispatcher.Run(ctx, interval)
+}
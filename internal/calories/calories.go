@@ -0,0 +1,167 @@
+// Package calories estimates the energy spent in a workout from MET values
+package calories
+
+import (
+	"math"
+	"strings"
+
+	"github.com/kodega2016/femapi/internal/store"
+)
+
+// DefaultWeightKg is used for users that never logged their body weight
+const DefaultWeightKg = 70.0
+
+// speedMET maps a minimum speed in km/h to the MET of moving at least that fast.
+// Values come from the Compendium of Physical Activities.
+type speedMET struct {
+	minSpeed float64
+	met      float64
+}
+
+var (
+	walkRunMETs = []speedMET{
+		{0, 2.0}, {3.2, 2.8}, {4.0, 3.0}, {4.8, 3.5}, {5.6, 4.3}, {6.4, 6.0}, {8.0, 8.3}, {9.7, 9.8},
+		{10.8, 10.5}, {11.3, 11.0}, {12.1, 11.8}, {12.9, 12.3}, {13.8, 12.8}, {14.5, 14.5}, {16.1, 16.0}, {17.7, 19.0},
+	}
+	rideMETs = []speedMET{{0, 4.0}, {16.0, 6.8}, {19.3, 8.0}, {22.5, 10.0}, {25.7, 12.0}, {30.6, 15.8}}
+	swimMETs = []speedMET{{0, 5.8}, {2.5, 8.3}, {3.0, 9.8}}
+	rowMETs  = []speedMET{{0, 4.8}, {10.0, 7.0}, {12.5, 8.5}, {14.5, 12.0}}
+)
+
+// typeMETs are used when the speed of a workout is unknown
+var typeMETs = map[string]float64{
+	store.WorkoutTypeStrength: 3.5,
+	store.WorkoutTypeRun:      8.0,
+	store.WorkoutTypeRide:     7.5,
+	store.WorkoutTypeSwim:     7.0,
+	store.WorkoutTypeRow:      7.0,
+	store.WorkoutTypeHIIT:     8.0,
+	store.WorkoutTypeYoga:     2.5,
+}
+
+// exerciseMETs are matched in order against the lower cased exercise name, so more specific
+// keywords come first
+var exerciseMETs = []struct {
+	keyword string
+	met     float64
+}{
+	{"jump rope", 12.3},
+	{"skipping", 12.3},
+	{"burpee", 8.0},
+	{"thruster", 8.0},
+	{"kettlebell", 9.8},
+	{"treadmill", 9.0},
+	{"running", 9.0},
+	{"cycling", 7.0},
+	{"bike", 7.0},
+	{"pull up", 8.0},
+	{"chin up", 8.0},
+	{"clean", 6.0},
+	{"snatch", 6.0},
+	{"deadlift", 6.0},
+	{"squat", 5.0},
+	{"lunge", 4.0},
+	{"push up", 3.8},
+	{"dip", 5.0},
+	{"plank", 3.8},
+	{"crunch", 2.8},
+	{"sit up", 2.8},
+	{"curl", 3.5},
+	{"raise", 3.5},
+	{"press", 5.0},
+	{"row", 5.0},
+	{"stretch", 2.3},
+}
+
+func metForSpeed(table []speedMET, speed float64) float64 {
+	met := table[0].met
+	for _, entry := range table {
+		if speed >= entry.minSpeed {
+			met = entry.met
+		}
+	}
+	return met
+}
+
+// ExerciseMET returns the MET of a strength exercise, or 0 when the name is not recognised
+func ExerciseMET(name string) float64 {
+	name = strings.ToLower(strings.ReplaceAll(name, "-", " "))
+	for _, exercise := range exerciseMETs {
+		if strings.Contains(name, exercise.keyword) {
+			return exercise.met
+		}
+	}
+	return 0
+}
+
+// MET returns the metabolic equivalent of the workout. Cardio workouts use their average speed,
+// strength workouts the average of their exercises weighted by the number of sets.
+func MET(workout *store.Workout) float64 {
+	workoutType := workout.Type
+	if workoutType == "" {
+		workoutType = store.WorkoutTypeStrength
+	}
+	fallback := typeMETs[workoutType]
+
+	if activity := workout.Activity; activity != nil {
+		if activity.Sport == store.SportHiking {
+			return 6.0
+		}
+		if activity.AvgSpeedKph != nil && *activity.AvgSpeedKph > 0 {
+			speed := *activity.AvgSpeedKph
+			switch workoutType {
+			case store.WorkoutTypeRun:
+				return metForSpeed(walkRunMETs, speed)
+			case store.WorkoutTypeRide:
+				return metForSpeed(rideMETs, speed)
+			case store.WorkoutTypeSwim:
+				return metForSpeed(swimMETs, speed)
+			case store.WorkoutTypeRow:
+				return metForSpeed(rowMETs, speed)
+			}
+		}
+	}
+
+	if workoutType != store.WorkoutTypeStrength || len(workout.Entries) == 0 {
+		return fallback
+	}
+
+	total, sets := 0.0, 0
+	for _, entry := range workout.Entries {
+		met := ExerciseMET(entry.ExerciseName)
+		if met == 0 {
+			met = fallback
+		}
+		weight := max(entry.ExerciseSets, 1)
+		total += met * float64(weight)
+		sets += weight
+	}
+	return total / float64(sets)
+}
+
+// Duration returns the active time in hours. Cardio details provide the moving time, otherwise
+// the workout duration is used, and for strength workouts without one the timed sets.
+func Duration(workout *store.Workout) float64 {
+	if activity := workout.Activity; activity != nil && activity.MovingTimeSeconds > 0 {
+		return float64(activity.MovingTimeSeconds) / 3600
+	}
+	if workout.DurationInMinutes > 0 {
+		return float64(workout.DurationInMinutes) / 60
+	}
+
+	seconds := 0
+	for _, entry := range workout.Entries {
+		if entry.DurationSeconds != nil {
+			seconds += *entry.DurationSeconds * max(entry.ExerciseSets, 1)
+		}
+	}
+	return float64(seconds) / 3600
+}
+
+// Estimate returns the calories burned: MET x body weight in kg x hours
+func Estimate(workout *store.Workout, weightKg float64) int {
+	if weightKg <= 0 {
+		weightKg = DefaultWeightKg
+	}
+	return int(math.Round(MET(workout) * weightKg * Duration(workout)))
+}
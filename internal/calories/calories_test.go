@@ -0,0 +1,73 @@
+package calories
+
+import (
+	"testing"
+
+	"github.com/kodega2016/femapi/internal/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func intPtr(i int) *int {
+	return &i
+}
+
+func cardio(workoutType string, meters float64, seconds int) *store.Workout {
+	activity := &store.Activity{DistanceMeters: meters, MovingTimeSeconds: seconds}
+	activity.ComputeDerived()
+	return &store.Workout{Type: workoutType, Activity: activity}
+}
+
+func TestEstimateCardioUsesSpeed(t *testing.T) {
+	tests := []struct {
+		name    string
+		workout *store.Workout
+		met     float64
+		kcal    int
+	}{
+		// 10 km/h run for an hour at 70kg
+		{"run", cardio(store.WorkoutTypeRun, 10000, 3600), 9.8, 686},
+		{"walk", cardio(store.WorkoutTypeRun, 5000, 3600), 3.5, 245},
+		{"ride", cardio(store.WorkoutTypeRide, 12000, 1800), 10.0, 350},
+		{"swim", cardio(store.WorkoutTypeSwim, 1500, 1800), 9.8, 343},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.met, MET(tt.workout))
+			assert.Equal(t, tt.kcal, Estimate(tt.workout, 70))
+		})
+	}
+}
+
+func TestEstimateStrengthWeighsExercisesBySets(t *testing.T) {
+	workout := &store.Workout{
+		Type:              store.WorkoutTypeStrength,
+		DurationInMinutes: 45,
+		Entries: []store.WorkoutEntry{
+			{ExerciseName: "Barbell Back Squat", ExerciseSets: 3, Reps: intPtr(5)},
+			{ExerciseName: "Burpees", ExerciseSets: 1, Reps: intPtr(20)},
+		},
+	}
+
+	// (5.0*3 + 8.0*1) / 4 sets
+	assert.Equal(t, 5.75, MET(workout))
+	assert.Equal(t, 345, Estimate(workout, 80))
+}
+
+func TestEstimateFallsBackToTimedSetsAndDefaultWeight(t *testing.T) {
+	workout := &store.Workout{
+		Type: store.WorkoutTypeStrength,
+		Entries: []store.WorkoutEntry{
+			{ExerciseName: "Plank", ExerciseSets: 5, DurationSeconds: intPtr(72)},
+		},
+	}
+
+	// 6 minutes of planks at 3.8 MET for the default 70kg
+	assert.Equal(t, 0.1, Duration(workout))
+	assert.Equal(t, 27, Estimate(workout, 0))
+}
+
+func TestEstimateUnknownTypesUseTypeMET(t *testing.T) {
+	yoga := &store.Workout{Type: store.WorkoutTypeYoga, DurationInMinutes: 60}
+	assert.Equal(t, 175, Estimate(yoga, 70))
+}
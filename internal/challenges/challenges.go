@@ -0,0 +1,123 @@
+// Package challenges scores challenge participants and keeps the stored leaderboards current
+package challenges
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"time"
+
+	"github.com/kodega2016/femapi/internal/store"
+)
+
+// ScoringGrace is how long after a challenge ended workouts logged late still change its
+// leaderboard, after that the results are final
+const ScoringGrace = 7 * 24 * time.Hour
+
+// weightClasses are the upper limits in kg of every weight class but the open one
+var weightClasses = []float64{59, 66, 74, 83, 93, 105, 120}
+
+// ageGroups are the lower bounds of every age group but the youngest
+var ageGroups = []int{18, 30, 40, 50, 60}
+
+// Leaderboard maintains the standings of the challenges. It listens to workout changes, so
+// leaderboards are read from the table instead of being computed per request.
+type Leaderboard struct {
+	challengeStore store.ChallengeStore
+	logger         *log.Logger
+	now            func() time.Time
+}
+
+func NewLeaderboard(challengeStore store.ChallengeStore, logger *log.Logger) *Leaderboard {
+	return &Leaderboard{
+		challengeStore: challengeStore,
+		logger:         logger,
+		now:            time.Now,
+	}
+}
+
+// WorkoutChanged implements store.WorkoutListener
+func (l *Leaderboard) WorkoutChanged(event store.WorkoutEvent) {
+	err := l.Recompute(event.UserID)
+	if err != nil {
+		l.logger.Printf("ERROR: recomputing challenges of user %d: %v", event.UserID, err)
+	}
+}
+
+// Recompute scores the user in every challenge they joined that is still open for scoring
+func (l *Leaderboard) Recompute(userID int) error {
+	challenges, err := l.challengeStore.ListJoinedChallenges(userID, l.now().Add(-ScoringGrace))
+	if err != nil {
+		return err
+	}
+	for i := range challenges {
+		err = l.Refresh(&challenges[i], userID)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Refresh scores one participant from their workouts and re-ranks the challenge
+func (l *Leaderboard) Refresh(challenge *store.Challenge, userID int) error {
+	samples, err := l.challengeStore.ChallengeSamples(userID, challenge)
+	if err != nil {
+		return err
+	}
+	participant := &store.Participant{ChallengeID: challenge.ID, UserID: userID}
+	Score(participant, samples, challenge.TargetValue)
+
+	err = l.challengeStore.SaveStanding(participant)
+	if err != nil {
+		return err
+	}
+	return l.challengeStore.RankChallenge(challenge.ID)
+}
+
+// Score sums the samples, oldest first, into the standing of the participant. LastScoredAt is
+// when the score was reached, which breaks ties, and ReachedTargetAt when the sum first
+// crossed the target.
+func Score(participant *store.Participant, samples []store.GoalSample, target *float64) {
+	participant.Score = 0
+	participant.LastScoredAt = nil
+	participant.ReachedTargetAt = nil
+
+	for _, sample := range samples {
+		participant.Score += sample.Value
+		at := sample.Time
+		participant.LastScoredAt = &at
+		if target != nil && participant.ReachedTargetAt == nil && participant.Score >= *target {
+			participant.ReachedTargetAt = &at
+		}
+	}
+	participant.Score = math.Round(participant.Score*100) / 100
+}
+
+// WeightClass returns the bracket of a body weight in kg, e.g. "-83kg" or "120+kg"
+func WeightClass(weightKg float64) string {
+	for _, limit := range weightClasses {
+		if weightKg <= limit {
+			return fmt.Sprintf("-%gkg", limit)
+		}
+	}
+	return fmt.Sprintf("%g+kg", weightClasses[len(weightClasses)-1])
+}
+
+// AgeGroup returns the bracket of someone born in birthYear as of at, e.g. "30-39" or "60+"
+func AgeGroup(birthYear int, at time.Time) string {
+	age := at.Year() - birthYear
+	if age < ageGroups[0] {
+		return fmt.Sprintf("under %d", ageGroups[0])
+	}
+	for i := len(ageGroups) - 1; i >= 0; i-- {
+		if age < ageGroups[i] {
+			continue
+		}
+		if i == len(ageGroups)-1 {
+			return fmt.Sprintf("%d+", ageGroups[i])
+		}
+		return fmt.Sprintf("%d-%d", ageGroups[i], ageGroups[i+1]-1)
+	}
+	return ""
+}
@@ -0,0 +1,55 @@
+package challenges
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kodega2016/femapi/internal/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScoreRecordsWhenTheTargetWasReached(t *testing.T) {
+	at := func(day int) time.Time { return time.Date(2024, 3, day, 7, 0, 0, 0, time.UTC) }
+	samples := []store.GoalSample{
+		{Time: at(2), Value: 40000},
+		{Time: at(9), Value: 35000},
+		{Time: at(16), Value: 30000},
+		{Time: at(23), Value: 21000},
+	}
+	target := 100000.0
+
+	var participant store.Participant
+	Score(&participant, samples, &target)
+	assert.Equal(t, 126000.0, participant.Score)
+	require.NotNil(t, participant.ReachedTargetAt)
+	assert.Equal(t, at(16), *participant.ReachedTargetAt)
+	require.NotNil(t, participant.LastScoredAt)
+	assert.Equal(t, at(23), *participant.LastScoredAt)
+
+	Score(&participant, samples[:2], nil)
+	assert.Equal(t, 75000.0, participant.Score)
+	assert.Nil(t, participant.ReachedTargetAt)
+}
+
+func TestScoreWithoutWorkouts(t *testing.T) {
+	participant := store.Participant{Score: 12}
+	Score(&participant, nil, nil)
+	assert.Zero(t, participant.Score)
+	assert.Nil(t, participant.LastScoredAt)
+}
+
+func TestWeightClass(t *testing.T) {
+	assert.Equal(t, "-59kg", WeightClass(52.5))
+	assert.Equal(t, "-83kg", WeightClass(83))
+	assert.Equal(t, "-93kg", WeightClass(83.1))
+	assert.Equal(t, "120+kg", WeightClass(131))
+}
+
+func TestAgeGroup(t *testing.T) {
+	at := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	assert.Equal(t, "under 18", AgeGroup(2010, at))
+	assert.Equal(t, "18-29", AgeGroup(2006, at))
+	assert.Equal(t, "30-39", AgeGroup(1990, at))
+	assert.Equal(t, "60+", AgeGroup(1950, at))
+}
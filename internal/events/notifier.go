@@ -0,0 +1,109 @@
+// Package events wakes the event streams of a user when a change was logged for them. The log
+// itself lives in the store, the notifier only tells streams there is something new to read.
+package events
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/kodega2016/femapi/internal/store"
+)
+
+const reconnectDelay = 5 * time.Second
+
+// Notifier keeps a single LISTEN connection per instance and wakes the streams of the user
+// named by each notification
+type Notifier struct {
+	db     *sql.DB
+	logger *log.Logger
+
+	mu      sync.Mutex
+	streams map[int]map[chan struct{}]struct{}
+}
+
+func NewNotifier(db *sql.DB, logger *log.Logger) *Notifier {
+	return &Notifier{
+		db:      db,
+		logger:  logger,
+		streams: make(map[int]map[chan struct{}]struct{}),
+	}
+}
+
+// Subscribe returns a channel that receives a value whenever events were logged for the user.
+// Wake-ups coalesce: a stream reads everything new from the store once it is woken.
+func (n *Notifier) Subscribe(userID int) (<-chan struct{}, func()) {
+	wake := make(chan struct{}, 1)
+
+	n.mu.Lock()
+	if n.streams[userID] == nil {
+		n.streams[userID] = make(map[chan struct{}]struct{})
+	}
+	n.streams[userID][wake] = struct{}{}
+	n.mu.Unlock()
+
+	cancel := func() {
+		n.mu.Lock()
+		defer n.mu.Unlock()
+		delete(n.streams[userID], wake)
+		if len(n.streams[userID]) == 0 {
+			delete(n.streams, userID)
+		}
+	}
+	return wake, cancel
+}
+
+// Wake wakes every stream of the user
+func (n *Notifier) Wake(userID int) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for wake := range n.streams[userID] {
+		select {
+		case wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// wakeAll is used after the connection was lost, streams re-read the store in case they
+// missed a notification
+func (n *Notifier) wakeAll() {
+	n.mu.Lock()
+	users := make([]int, 0, len(n.streams))
+	for userID := range n.streams {
+		users = append(users, userID)
+	}
+	n.mu.Unlock()
+
+	for _, userID := range users {
+		n.Wake(userID)
+	}
+}
+
+// Run listens for notifications until ctx is done, reconnecting when the connection is lost
+func (n *Notifier) Run(ctx context.Context) {
+	for {
+		err := store.Listen(ctx, n.db, store.UserEventsChannel, func(payload string) {
+			userID, err := strconv.Atoi(payload)
+			if err != nil {
+				n.logger.Printf("ERROR: decoding user event notification %q: %v", payload, err)
+				return
+			}
+			n.Wake(userID)
+		})
+		if ctx.Err() != nil {
+			return
+		}
+		n.logger.Printf("ERROR: listening for user events: %v", err)
+		n.wakeAll()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(reconnectDelay):
+		}
+	}
+}
@@ -0,0 +1,26 @@
+package events
+
+import (
+	"log"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNotifierCoalescesWakeUps(t *testing.T) {
+	notifier := NewNotifier(nil, log.Default())
+	wake, cancel := notifier.Subscribe(1)
+	other, cancelOther := notifier.Subscribe(2)
+	defer cancelOther()
+
+	notifier.Wake(1)
+	notifier.Wake(1)
+	assert.Len(t, wake, 1, "pending wake-ups are merged")
+	assert.Empty(t, other, "only the streams of the user are woken")
+
+	<-wake
+	cancel()
+	notifier.Wake(1)
+	assert.Empty(t, wake)
+	assert.NotContains(t, notifier.streams, 1)
+}
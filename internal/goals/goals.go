@@ -0,0 +1,242 @@
+// Package goals evaluates user goals against their workouts and keeps the stored progress current
+package goals
+
+import (
+	"log"
+	"math"
+	"time"
+
+	"github.com/kodega2016/femapi/internal/store"
+)
+
+// Tracker recomputes the progress of goals. It listens to workout changes, so progress is
+// always up to date when clients read it.
+type Tracker struct {
+	goalStore     store.GoalStore
+	settingsStore store.SettingsStore
+	logger        *log.Logger
+	now           func() time.Time
+}
+
+func NewTracker(goalStore store.GoalStore, settingsStore store.SettingsStore, logger *log.Logger) *Tracker {
+	return &Tracker{
+		goalStore:     goalStore,
+		settingsStore: settingsStore,
+		logger:        logger,
+		now:           time.Now,
+	}
+}
+
+// WorkoutChanged implements store.WorkoutListener
+func (t *Tracker) WorkoutChanged(event store.WorkoutEvent) {
+	err := t.Recompute(event.UserID)
+	if err != nil {
+		t.logger.Printf("ERROR: recomputing goals of user %d: %v", event.UserID, err)
+	}
+}
+
+// Recompute evaluates every goal of the user
+func (t *Tracker) Recompute(userID int) error {
+	goals, err := t.goalStore.ListGoals(userID)
+	if err != nil {
+		return err
+	}
+	for i := range goals {
+		err = t.Evaluate(&goals[i])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Evaluate computes the progress of a single goal from the workouts and saves it
+func (t *Tracker) Evaluate(goal *store.Goal) error {
+	now := t.now().UTC()
+
+	switch goal.Kind {
+	case store.GoalLift:
+		samples, err := t.goalStore.LiftSamples(goal.UserID, goal.ExerciseName)
+		if err != nil {
+			return err
+		}
+		evaluateLift(goal, samples, now)
+	case store.GoalDistance:
+		to := now.Add(time.Second)
+		if goal.Deadline != nil {
+			to = *goal.Deadline
+		}
+		samples, err := t.goalStore.DistanceSamples(goal.UserID, goal.WorkoutType, goal.StartsAt, to)
+		if err != nil {
+			return err
+		}
+		evaluateDistance(goal, samples, now)
+	case store.GoalFrequency:
+		// periods follow the calendar of the user
+		settings, err := t.settingsStore.GetSettings(goal.UserID)
+		if err != nil {
+			return err
+		}
+		from, to := PeriodBounds(goal.Period, now, settings)
+		if goal.StartsAt.After(from) {
+			from = goal.StartsAt
+		}
+		count := 0
+		if from.Before(to) {
+			count, err = t.goalStore.CountWorkouts(goal.UserID, goal.WorkoutType, from, to)
+			if err != nil {
+				return err
+			}
+		}
+		evaluateFrequency(goal, count)
+	}
+
+	goal.EvaluatedAt = &now
+	goal.Status = goal.CurrentStatus(now)
+	return t.goalStore.SaveGoalProgress(goal)
+}
+
+// evaluateLift tracks the best one rep max ever lifted. The projection follows the trend of
+// the running best since the goal was set.
+func evaluateLift(goal *store.Goal, samples []store.GoalSample, now time.Time) {
+	best := 0.0
+	var reachedAt *time.Time
+	trend := []store.GoalSample{}
+	for _, sample := range samples {
+		best = math.Max(best, sample.Value)
+		if reachedAt == nil && best >= goal.TargetValue {
+			reachedAt = &sample.Time
+		}
+		if !sample.Time.Before(goal.StartsAt) {
+			trend = append(trend, store.GoalSample{Time: sample.Time, Value: best})
+		}
+	}
+
+	setProgress(goal, best, reachedAt)
+	if goal.CompletedAt == nil {
+		goal.ProjectedAt = projectTrend(trend, goal.TargetValue, now)
+	}
+}
+
+// evaluateDistance adds up the distance since the goal started and projects the current pace
+func evaluateDistance(goal *store.Goal, samples []store.GoalSample, now time.Time) {
+	total := 0.0
+	var reachedAt *time.Time
+	for _, sample := range samples {
+		total += sample.Value
+		if reachedAt == nil && total >= goal.TargetValue {
+			reachedAt = &sample.Time
+		}
+	}
+
+	setProgress(goal, total, reachedAt)
+	if goal.CompletedAt == nil {
+		goal.ProjectedAt = projectPace(goal.StartsAt, total, goal.TargetValue, now)
+	}
+}
+
+// evaluateFrequency counts the workouts of the current period. The goal recurs every period,
+// so it never completes and has no projection.
+func evaluateFrequency(goal *store.Goal, count int) {
+	goal.CurrentValue = float64(count)
+	goal.Progress = Progress(goal.CurrentValue, goal.TargetValue)
+	goal.ProjectedAt = nil
+	goal.CompletedAt = nil
+}
+
+// setProgress keeps the first completion time, and clears it when deleted or edited workouts
+// put the goal out of reach again
+func setProgress(goal *store.Goal, current float64, reachedAt *time.Time) {
+	goal.CurrentValue = math.Round(current*100) / 100
+	goal.Progress = Progress(current, goal.TargetValue)
+	goal.ProjectedAt = nil
+	switch {
+	case reachedAt == nil:
+		goal.CompletedAt = nil
+	case goal.CompletedAt == nil:
+		goal.CompletedAt = reachedAt
+	}
+}
+
+// Progress is the percentage of the target reached, capped at 100
+func Progress(current, target float64) float64 {
+	if target <= 0 {
+		return 0
+	}
+	return math.Round(math.Min(current/target, 1)*10000) / 100
+}
+
+// PeriodBounds returns the [start, end) of the week or month containing now, in the timezone
+// of the settings and with weeks starting on their first weekday
+func PeriodBounds(period string, now time.Time, settings *store.Settings) (time.Time, time.Time) {
+	if period == store.PeriodMonth {
+		local := now.In(settings.Location())
+		start := time.Date(local.Year(), local.Month(), 1, 0, 0, 0, 0, local.Location())
+		return start, start.AddDate(0, 1, 0)
+	}
+	start := settings.StartOfWeek(now)
+	return start, start.AddDate(0, 0, 7)
+}
+
+// maxProjection bounds projected dates, a tiny rate would otherwise overflow time.Duration
+const maxProjection = 100 * 365 * 24 * time.Hour
+
+// clampDuration converts nanoseconds to a duration within maxProjection either way
+func clampDuration(nanos float64) time.Duration {
+	switch {
+	case math.IsNaN(nanos):
+		return 0
+	case nanos > float64(maxProjection):
+		return maxProjection
+	case nanos < -float64(maxProjection):
+		return -maxProjection
+	}
+	return time.Duration(nanos)
+}
+
+// projectPace extrapolates the average pace since start to the target
+func projectPace(start time.Time, current, target float64, now time.Time) *time.Time {
+	elapsed := now.Sub(start)
+	if current <= 0 || elapsed <= 0 {
+		return nil
+	}
+	remaining := (target - current) / current * float64(elapsed)
+	projected := now.Add(clampDuration(remaining))
+	return &projected
+}
+
+// projectTrend fits a least squares line through the samples and returns when it reaches the
+// target, or nil when there are too few samples or the trend is not rising
+func projectTrend(samples []store.GoalSample, target float64, now time.Time) *time.Time {
+	if len(samples) < 2 {
+		return nil
+	}
+
+	origin := samples[0].Time
+	n := float64(len(samples))
+	var sumX, sumY float64
+	for _, sample := range samples {
+		sumX += sample.Time.Sub(origin).Seconds()
+		sumY += sample.Value
+	}
+	meanX, meanY := sumX/n, sumY/n
+
+	var covariance, variance float64
+	for _, sample := range samples {
+		dx := sample.Time.Sub(origin).Seconds() - meanX
+		covariance += dx * (sample.Value - meanY)
+		variance += dx * dx
+	}
+	if variance == 0 || covariance <= 0 {
+		return nil
+	}
+
+	slope := covariance / variance
+	seconds := meanX + (target-meanY)/slope
+	projected := origin.Add(clampDuration(seconds * float64(time.Second)))
+	// the line can cross the target before the last sample while the best lift is still short of it
+	if projected.Before(now) {
+		projected = now
+	}
+	return &projected
+}
@@ -0,0 +1,121 @@
+package goals
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kodega2016/femapi/internal/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var start = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func day(n int) time.Time {
+	return start.AddDate(0, 0, n)
+}
+
+func TestEvaluateLiftProjectsTrend(t *testing.T) {
+	goal := &store.Goal{Kind: store.GoalLift, TargetValue: 100, StartsAt: start}
+	samples := []store.GoalSample{
+		{Time: day(-30), Value: 85},
+		{Time: day(0), Value: 80},
+		{Time: day(10), Value: 90},
+		{Time: day(20), Value: 92},
+		{Time: day(30), Value: 95},
+	}
+
+	evaluateLift(goal, samples, day(30))
+	assert.Equal(t, 95.0, goal.CurrentValue)
+	assert.Equal(t, 95.0, goal.Progress)
+	assert.Nil(t, goal.CompletedAt)
+	require.NotNil(t, goal.ProjectedAt)
+	// the running best rises from 85 to 95 over 30 days
+	assert.True(t, goal.ProjectedAt.After(day(35)) && goal.ProjectedAt.Before(day(60)), goal.ProjectedAt)
+}
+
+func TestEvaluateLiftCompletion(t *testing.T) {
+	goal := &store.Goal{Kind: store.GoalLift, TargetValue: 100, StartsAt: start}
+	samples := []store.GoalSample{{Time: day(1), Value: 90}, {Time: day(5), Value: 101}, {Time: day(9), Value: 103}}
+
+	evaluateLift(goal, samples, day(10))
+	assert.Equal(t, 100.0, goal.Progress)
+	require.NotNil(t, goal.CompletedAt)
+	assert.Equal(t, day(5), *goal.CompletedAt)
+	assert.Nil(t, goal.ProjectedAt)
+
+	// the workout that reached the target was deleted
+	evaluateLift(goal, samples[:1], day(11))
+	assert.Nil(t, goal.CompletedAt)
+	assert.Equal(t, 90.0, goal.Progress)
+}
+
+func TestEvaluateDistance(t *testing.T) {
+	goal := &store.Goal{Kind: store.GoalDistance, TargetValue: 500000, StartsAt: start}
+	samples := []store.GoalSample{{Time: day(2), Value: 10000}, {Time: day(5), Value: 15000}, {Time: day(9), Value: 25000}}
+
+	evaluateDistance(goal, samples, day(10))
+	assert.Equal(t, 50000.0, goal.CurrentValue)
+	assert.Equal(t, 10.0, goal.Progress)
+	require.NotNil(t, goal.ProjectedAt)
+	// 5 km a day leaves 90 days for the remaining 450 km
+	assert.Equal(t, day(100), *goal.ProjectedAt)
+}
+
+func TestEvaluateFrequency(t *testing.T) {
+	goal := &store.Goal{Kind: store.GoalFrequency, TargetValue: 3, Period: store.PeriodWeek}
+	evaluateFrequency(goal, 2)
+	assert.Equal(t, 66.67, goal.Progress)
+
+	evaluateFrequency(goal, 5)
+	assert.Equal(t, 100.0, goal.Progress)
+	assert.Nil(t, goal.CompletedAt)
+}
+
+func TestPeriodBounds(t *testing.T) {
+	// 2024-03-14 is a thursday
+	now := time.Date(2024, 3, 14, 18, 30, 0, 0, time.UTC)
+	settings := store.DefaultSettings(1)
+
+	from, to := PeriodBounds(store.PeriodWeek, now, settings)
+	assert.Equal(t, time.Date(2024, 3, 11, 0, 0, 0, 0, time.UTC), from)
+	assert.Equal(t, time.Date(2024, 3, 18, 0, 0, 0, 0, time.UTC), to)
+
+	from, to = PeriodBounds(store.PeriodMonth, now, settings)
+	assert.Equal(t, time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), from)
+	assert.Equal(t, time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC), to)
+}
+
+func TestPeriodBoundsFollowTheSettings(t *testing.T) {
+	// sunday 2024-03-31 23:30 in New York is already monday in UTC
+	now := time.Date(2024, 4, 1, 3, 30, 0, 0, time.UTC)
+	settings := store.DefaultSettings(1)
+	settings.Timezone = "America/New_York"
+	settings.WeekStart = store.WeekStartSunday
+	newYork := settings.Location()
+
+	from, to := PeriodBounds(store.PeriodWeek, now, settings)
+	assert.True(t, from.Equal(time.Date(2024, 3, 31, 0, 0, 0, 0, newYork)))
+	assert.True(t, to.Equal(time.Date(2024, 4, 7, 0, 0, 0, 0, newYork)))
+
+	from, to = PeriodBounds(store.PeriodMonth, now, settings)
+	assert.True(t, from.Equal(time.Date(2024, 3, 1, 0, 0, 0, 0, newYork)))
+	assert.True(t, to.Equal(time.Date(2024, 4, 1, 0, 0, 0, 0, newYork)))
+}
+
+func TestProjectTrendNeedsRisingSamples(t *testing.T) {
+	assert.Nil(t, projectTrend([]store.GoalSample{{Time: day(0), Value: 80}}, 100, day(1)))
+	assert.Nil(t, projectTrend([]store.GoalSample{{Time: day(0), Value: 80}, {Time: day(5), Value: 80}}, 100, day(6)))
+}
+
+func TestProjectionsOfTinyRatesAreClamped(t *testing.T) {
+	now := day(10)
+
+	projected := projectPace(day(0), 1e-12, 100, now)
+	require.NotNil(t, projected)
+	assert.Equal(t, now.Add(maxProjection), *projected)
+
+	projected = projectTrend([]store.GoalSample{{Time: day(0), Value: 80}, {Time: day(5), Value: 80.000000001}}, 1e12, now)
+	require.NotNil(t, projected)
+	assert.Equal(t, day(0).Add(maxProjection), *projected)
+}
@@ -0,0 +1,115 @@
+// Package ical writes iCalendar (RFC 5545) feeds that calendar apps can subscribe to
+package ical
+
+import (
+	"bufio"
+	"io"
+	"strings"
+	"time"
+)
+
+const (
+	StatusConfirmed = "CONFIRMED"
+	StatusTentative = "TENTATIVE"
+
+	ContentType = "text/calendar; charset=utf-8"
+
+	timeFormat = "20060102T150405Z"
+	// lines are folded at 75 octets, continuation lines start with a space
+	maxLineOctets = 75
+)
+
+// Event is a VEVENT, UID must stay the same across feed refreshes so apps update the event
+// instead of adding a copy
+type Event struct {
+	UID         string
+	Summary     string
+	Description string
+	Start       time.Time
+	End         time.Time
+	Status      string
+	Updated     time.Time
+}
+
+// Calendar is a VCALENDAR with a name shown by the subscribing app
+type Calendar struct {
+	Name     string
+	Timezone string
+	Events   []Event
+}
+
+// Write encodes the calendar, all times are written in UTC
+func (c *Calendar) Write(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	line := func(name, value string) {
+		writeLine(bw, name+":"+value)
+	}
+
+	line("BEGIN", "VCALENDAR")
+	line("VERSION", "2.0")
+	line("PRODID", "-//femapi//workouts//EN")
+	line("CALSCALE", "GREGORIAN")
+	line("METHOD", "PUBLISH")
+	if c.Name != "" {
+		line("X-WR-CALNAME", Escape(c.Name))
+	}
+	if c.Timezone != "" {
+		line("X-WR-TIMEZONE", c.Timezone)
+	}
+
+	for _, event := range c.Events {
+		updated := event.Updated
+		if updated.IsZero() {
+			updated = event.Start
+		}
+		line("BEGIN", "VEVENT")
+		line("UID", event.UID)
+		line("DTSTAMP", updated.UTC().Format(timeFormat))
+		line("DTSTART", event.Start.UTC().Format(timeFormat))
+		line("DTEND", event.End.UTC().Format(timeFormat))
+		line("SUMMARY", Escape(event.Summary))
+		if event.Description != "" {
+			line("DESCRIPTION", Escape(event.Description))
+		}
+		if event.Status != "" {
+			line("STATUS", event.Status)
+		}
+		if event.Status == StatusTentative {
+			// tentative sessions do not block the time in the subscriber's calendar
+			line("TRANSP", "TRANSPARENT")
+		}
+		line("END", "VEVENT")
+	}
+
+	line("END", "VCALENDAR")
+	return bw.Flush()
+}
+
+var escaper = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`, "\r", `\n`)
+
+// Escape escapes a TEXT value
+func Escape(text string) string {
+	return escaper.Replace(text)
+}
+
+// writeLine writes a content line terminated by CRLF, folding it without splitting a UTF-8 sequence
+func writeLine(w *bufio.Writer, line string) {
+	limit := maxLineOctets
+	for len(line) > limit {
+		cut := limit
+		for cut > 0 && !startsRune(line[cut]) {
+			cut--
+		}
+		w.WriteString(line[:cut])
+		w.WriteString("\r\n ")
+		line = line[cut:]
+		// the leading space of a continuation line counts towards its length
+		limit = maxLineOctets - 1
+	}
+	w.WriteString(line)
+	w.WriteString("\r\n")
+}
+
+func startsRune(b byte) bool {
+	return b&0xC0 != 0x80
+}
@@ -0,0 +1,53 @@
+package ical
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteCalendar(t *testing.T) {
+	start := time.Date(2024, 5, 6, 18, 30, 0, 0, time.FixedZone("CEST", 2*60*60))
+	calendar := Calendar{
+		Name:     "Workouts",
+		Timezone: "Europe/Berlin",
+		Events: []Event{
+			{UID: "a@femapi", Summary: "Push day, heavy; PR", Start: start, End: start.Add(time.Hour), Status: StatusConfirmed},
+			{UID: "b@femapi", Summary: "Long run", Description: "easy pace\nhydrate", Start: start.AddDate(0, 0, 2), End: start.AddDate(0, 0, 2).Add(90 * time.Minute), Status: StatusTentative},
+		},
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, calendar.Write(&buf))
+	out := buf.String()
+
+	assert.True(t, strings.HasPrefix(out, "BEGIN:VCALENDAR\r\nVERSION:2.0\r\n"))
+	assert.True(t, strings.HasSuffix(out, "END:VCALENDAR\r\n"))
+	assert.Contains(t, out, "DTSTART:20240506T163000Z\r\n")
+	assert.Contains(t, out, "DTEND:20240506T173000Z\r\n")
+	assert.Contains(t, out, `SUMMARY:Push day\, heavy\; PR`)
+	assert.Contains(t, out, `DESCRIPTION:easy pace\nhydrate`)
+	assert.Contains(t, out, "STATUS:TENTATIVE\r\nTRANSP:TRANSPARENT\r\n")
+	assert.Equal(t, 2, strings.Count(out, "BEGIN:VEVENT"))
+}
+
+func TestLongLinesAreFolded(t *testing.T) {
+	summary := strings.Repeat("ü", 60)
+	var buf bytes.Buffer
+	require.NoError(t, (&Calendar{Events: []Event{{UID: "x", Summary: summary}}}).Write(&buf))
+
+	var unfolded strings.Builder
+	for i, line := range strings.Split(strings.TrimSuffix(buf.String(), "\r\n"), "\r\n") {
+		assert.LessOrEqual(t, len(line), maxLineOctets, "line %d", i)
+		if strings.HasPrefix(line, " ") {
+			unfolded.WriteString(line[1:])
+			continue
+		}
+		unfolded.WriteString("\n" + line)
+	}
+	assert.Contains(t, unfolded.String(), "SUMMARY:"+summary)
+}
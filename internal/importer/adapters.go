@@ -0,0 +1,223 @@
+package importer
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// strongAdapter reads the Strong app export. Strong does not write units, so opts.Unit applies.
+type strongAdapter struct{}
+
+var strongDurationRegex = regexp.MustCompile(`(?:(\d+)h)?\s*(?:(\d+)m)?\s*(?:(\d+)s)?`)
+
+func parseStrongDuration(value string) time.Duration {
+	match := strongDurationRegex.FindStringSubmatch(strings.TrimSpace(value))
+	var d time.Duration
+	units := []time.Duration{time.Hour, time.Minute, time.Second}
+	for i, unit := range units {
+		if match == nil || match[i+1] == "" {
+			continue
+		}
+		n, _ := strconv.Atoi(match[i+1])
+		d += time.Duration(n) * unit
+	}
+	return d
+}
+
+func (strongAdapter) ReadSets(r io.Reader, opts Options) ([]Set, []RowError, error) {
+	table, err := readCSVTable(r, "date", "workout name", "exercise name", "weight", "reps")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sets := []Set{}
+	rowErrors := []RowError{}
+	for i, row := range table.rows {
+		line := i + 2
+		performedAt, err := time.Parse("2006-01-02 15:04:05", table.field(row, "date"))
+		if err != nil {
+			rowErrors = append(rowErrors, RowError{Row: line, Error: fmt.Sprintf("invalid date %q", table.field(row, "date"))})
+			continue
+		}
+
+		set := Set{
+			Row:         line,
+			WorkoutKey:  table.field(row, "date") + "|" + table.field(row, "workout name"),
+			WorkoutName: table.field(row, "workout name"),
+			Description: table.field(row, "workout notes"),
+			PerformedAt: performedAt,
+			Duration:    parseStrongDuration(table.field(row, "duration")),
+			Exercise:    table.field(row, "exercise name"),
+			Notes:       table.field(row, "notes"),
+		}
+
+		weight, err := parseOptionalFloat(table.field(row, "weight"))
+		if err == nil {
+			set.WeightKg = toKilograms(weight, opts.Unit)
+			set.Reps, err = parsePositiveInt(table.field(row, "reps"))
+		}
+		if err == nil {
+			set.Seconds, err = parsePositiveInt(table.field(row, "seconds"))
+		}
+		if err == nil {
+			err = validateSet(set)
+		}
+		if err != nil {
+			rowErrors = append(rowErrors, RowError{Row: line, Error: err.Error()})
+			continue
+		}
+		sets = append(sets, set)
+	}
+	return sets, rowErrors, nil
+}
+
+// hevyAdapter reads the Hevy export, which names the weight column after its unit
+type hevyAdapter struct{}
+
+const hevyTimeLayout = "2 Jan 2006, 15:04"
+
+func (hevyAdapter) ReadSets(r io.Reader, opts Options) ([]Set, []RowError, error) {
+	table, err := readCSVTable(r, "title", "start_time", "exercise_title", "reps")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	weightColumn, unit := "weight_kg", UnitKilograms
+	if table.has("weight_lbs") {
+		weightColumn, unit = "weight_lbs", UnitPounds
+	}
+
+	sets := []Set{}
+	rowErrors := []RowError{}
+	for i, row := range table.rows {
+		line := i + 2
+		start, err := time.Parse(hevyTimeLayout, table.field(row, "start_time"))
+		if err != nil {
+			rowErrors = append(rowErrors, RowError{Row: line, Error: fmt.Sprintf("invalid start_time %q", table.field(row, "start_time"))})
+			continue
+		}
+
+		set := Set{
+			Row:         line,
+			WorkoutKey:  table.field(row, "start_time") + "|" + table.field(row, "title"),
+			WorkoutName: table.field(row, "title"),
+			Description: table.field(row, "description"),
+			PerformedAt: start,
+			Exercise:    table.field(row, "exercise_title"),
+			Notes:       table.field(row, "exercise_notes"),
+		}
+		if end, err := time.Parse(hevyTimeLayout, table.field(row, "end_time")); err == nil && end.After(start) {
+			set.Duration = end.Sub(start)
+		}
+
+		weight, err := parseOptionalFloat(table.field(row, weightColumn))
+		if err == nil {
+			set.WeightKg = toKilograms(weight, unit)
+			set.Reps, err = parsePositiveInt(table.field(row, "reps"))
+		}
+		if err == nil {
+			set.Seconds, err = parsePositiveInt(table.field(row, "duration_seconds"))
+		}
+		if err == nil {
+			err = validateSet(set)
+		}
+		if err != nil {
+			rowErrors = append(rowErrors, RowError{Row: line, Error: err.Error()})
+			continue
+		}
+		sets = append(sets, set)
+	}
+	return sets, rowErrors, nil
+}
+
+// fitNotesAdapter reads the FitNotes export. FitNotes has no workout names or durations,
+// so every training day becomes a workout named after the categories trained.
+type fitNotesAdapter struct{}
+
+// parseClock reads FitNotes "H:MM:SS" times
+func parseClock(value string) (*int, error) {
+	if value == "" {
+		return nil, nil
+	}
+	parts := strings.Split(value, ":")
+	seconds := 0
+	for _, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return nil, fmt.Errorf("invalid time %q", value)
+		}
+		seconds = seconds*60 + n
+	}
+	if seconds <= 0 {
+		return nil, nil
+	}
+	return &seconds, nil
+}
+
+func (fitNotesAdapter) ReadSets(r io.Reader, opts Options) ([]Set, []RowError, error) {
+	table, err := readCSVTable(r, "date", "exercise", "reps")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	weightColumn, unit := "weight (kgs)", UnitKilograms
+	if table.has("weight (lbs)") {
+		weightColumn, unit = "weight (lbs)", UnitPounds
+	}
+
+	// workout names are only known once all the categories of a day have been seen
+	categories := make(map[string][]string)
+	sets := []Set{}
+	rowErrors := []RowError{}
+	for i, row := range table.rows {
+		line := i + 2
+		date := table.field(row, "date")
+		performedAt, err := time.Parse("2006-01-02", date)
+		if err != nil {
+			rowErrors = append(rowErrors, RowError{Row: line, Error: fmt.Sprintf("invalid date %q", date)})
+			continue
+		}
+
+		if category := table.field(row, "category"); category != "" && !containsString(categories[date], category) {
+			categories[date] = append(categories[date], category)
+		}
+
+		set := Set{
+			Row:         line,
+			WorkoutKey:  date,
+			PerformedAt: performedAt,
+			Exercise:    table.field(row, "exercise"),
+			Notes:       table.field(row, "comment"),
+		}
+
+		weight, err := parseOptionalFloat(table.field(row, weightColumn))
+		if err == nil {
+			set.WeightKg = toKilograms(weight, unit)
+			set.Reps, err = parsePositiveInt(table.field(row, "reps"))
+		}
+		if err == nil {
+			set.Seconds, err = parseClock(table.field(row, "time"))
+		}
+		if err == nil {
+			err = validateSet(set)
+		}
+		if err != nil {
+			rowErrors = append(rowErrors, RowError{Row: line, Error: err.Error()})
+			continue
+		}
+		sets = append(sets, set)
+	}
+
+	for i := range sets {
+		name := strings.Join(categories[sets[i].WorkoutKey], ", ")
+		if name == "" {
+			name = "Workout"
+		}
+		sets[i].WorkoutName = name
+	}
+	return sets, rowErrors, nil
+}
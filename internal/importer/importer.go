@@ -0,0 +1,358 @@
+// Package importer converts export files from other lifting apps into workouts
+package importer
+
+import (
+	"bufio"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kodega2016/femapi/internal/store"
+)
+
+const (
+	UnitKilograms = "kg"
+	UnitPounds    = "lbs"
+
+	poundsToKilograms = 0.45359237
+)
+
+var ErrUnknownSource = errors.New("unknown import source")
+
+// Set is a single performed set, the common shape every adapter maps its rows into
+type Set struct {
+	Row         int
+	WorkoutKey  string
+	WorkoutName string
+	Description string
+	PerformedAt time.Time
+	Duration    time.Duration
+	Exercise    string
+	WeightKg    *float64
+	Reps        *int
+	Seconds     *int
+	Notes       string
+}
+
+type RowError struct {
+	Row   int    `json:"row"`
+	Error string `json:"error"`
+}
+
+// Options are the user supplied hints for formats that do not carry them in the file
+type Options struct {
+	Unit string
+}
+
+// Adapter maps the rows of one app's export file into sets
+type Adapter interface {
+	ReadSets(r io.Reader, opts Options) ([]Set, []RowError, error)
+}
+
+var adapters = map[string]Adapter{
+	"strong":   strongAdapter{},
+	"hevy":     hevyAdapter{},
+	"fitnotes": fitNotesAdapter{},
+}
+
+func GetAdapter(source string) (Adapter, error) {
+	adapter, ok := adapters[strings.ToLower(source)]
+	if !ok {
+		return nil, ErrUnknownSource
+	}
+	return adapter, nil
+}
+
+// ImportedWorkout is a workout ready to be created, with the source date kept for the preview
+type ImportedWorkout struct {
+	Row         int            `json:"row"`
+	PerformedAt time.Time      `json:"performed_at"`
+	Workout     *store.Workout `json:"workout"`
+}
+
+// Result is what an import would create, it doubles as the dry-run preview
+type Result struct {
+	Workouts  []ImportedWorkout `json:"workouts"`
+	Exercises []ExerciseMatch   `json:"exercises"`
+	Errors    []RowError        `json:"errors"`
+}
+
+// Import reads the file with the adapter for source, normalizes exercise names and groups sets into workouts
+func Import(source string, r io.Reader, opts Options, matcher *Matcher) (*Result, error) {
+	adapter, err := GetAdapter(source)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Unit == "" {
+		opts.Unit = UnitKilograms
+	}
+	if opts.Unit != UnitKilograms && opts.Unit != UnitPounds {
+		return nil, fmt.Errorf("unit must be %s or %s", UnitKilograms, UnitPounds)
+	}
+
+	sets, rowErrors, err := adapter.ReadSets(r, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &Result{
+		Workouts:  []ImportedWorkout{},
+		Exercises: []ExerciseMatch{},
+		Errors:    rowErrors,
+	}
+	if result.Errors == nil {
+		result.Errors = []RowError{}
+	}
+
+	matches := make(map[string]ExerciseMatch)
+	for i := range sets {
+		match, ok := matches[sets[i].Exercise]
+		if !ok {
+			match = matcher.Match(sets[i].Exercise)
+			matches[sets[i].Exercise] = match
+			result.Exercises = append(result.Exercises, match)
+		}
+		sets[i].Exercise = match.Name
+	}
+
+	result.Workouts = groupWorkouts(sets)
+	return result, nil
+}
+
+// groupWorkouts keeps the file order: sets are grouped by workout and consecutive sets of
+// the same exercise become one entry
+func groupWorkouts(sets []Set) []ImportedWorkout {
+	workouts := []ImportedWorkout{}
+	var current *ImportedWorkout
+	currentKey := ""
+	var exerciseSets []Set
+
+	flushEntry := func() {
+		if current != nil && len(exerciseSets) > 0 {
+			entry := buildEntry(exerciseSets)
+			entry.OrderIndex = len(current.Workout.Entries) + 1
+			current.Workout.Entries = append(current.Workout.Entries, entry)
+		}
+		exerciseSets = nil
+	}
+
+	for _, set := range sets {
+		if current == nil || set.WorkoutKey != currentKey {
+			flushEntry()
+			workouts = append(workouts, ImportedWorkout{
+				Row:         set.Row,
+				PerformedAt: set.PerformedAt,
+				Workout: &store.Workout{
+					Title:             set.WorkoutName,
+					Description:       set.Description,
+					DurationInMinutes: int(set.Duration.Minutes()),
+					Visibility:        store.VisibilityPrivate,
+					PerformedAt:       set.PerformedAt,
+				},
+			})
+			current = &workouts[len(workouts)-1]
+			currentKey = set.WorkoutKey
+		}
+
+		if len(exerciseSets) > 0 && exerciseSets[0].Exercise != set.Exercise {
+			flushEntry()
+		}
+		exerciseSets = append(exerciseSets, set)
+	}
+	flushEntry()
+	return workouts
+}
+
+func formatWeight(kg float64) string {
+	return strconv.FormatFloat(kg, 'f', -1, 64) + "kg"
+}
+
+// buildEntry collapses the sets of an exercise into one entry: the top set (heaviest, then
+// most reps or longest) gives reps, weight and duration, every set is kept in the notes
+func buildEntry(sets []Set) store.WorkoutEntry {
+	top := sets[0]
+	for _, set := range sets[1:] {
+		if isHigherSet(set, top) {
+			top = set
+		}
+	}
+
+	entry := store.WorkoutEntry{
+		ExerciseName: sets[0].Exercise,
+		ExerciseSets: len(sets),
+	}
+
+	if top.Reps != nil {
+		reps := *top.Reps
+		entry.Reps = &reps
+	} else if top.Seconds != nil {
+		seconds := *top.Seconds
+		entry.DurationSeconds = &seconds
+	}
+	if top.WeightKg != nil && *top.WeightKg > 0 {
+		weight := float32(*top.WeightKg)
+		entry.Weight = &weight
+	}
+
+	details := make([]string, 0, len(sets))
+	notes := []string{}
+	for _, set := range sets {
+		var parts []string
+		if set.WeightKg != nil && *set.WeightKg > 0 {
+			parts = append(parts, formatWeight(*set.WeightKg))
+		}
+		if set.Reps != nil {
+			parts = append(parts, strconv.Itoa(*set.Reps)+" reps")
+		}
+		if set.Seconds != nil {
+			parts = append(parts, strconv.Itoa(*set.Seconds)+"s")
+		}
+		details = append(details, strings.Join(parts, " x "))
+		if set.Notes != "" && !containsString(notes, set.Notes) {
+			notes = append(notes, set.Notes)
+		}
+	}
+
+	entry.Notes = "sets: " + strings.Join(details, ", ")
+	if len(notes) > 0 {
+		entry.Notes += "; " + strings.Join(notes, "; ")
+	}
+	return entry
+}
+
+func isHigherSet(a, b Set) bool {
+	weightA, weightB := 0.0, 0.0
+	if a.WeightKg != nil {
+		weightA = *a.WeightKg
+	}
+	if b.WeightKg != nil {
+		weightB = *b.WeightKg
+	}
+	if weightA != weightB {
+		return weightA > weightB
+	}
+	if a.Reps != nil && b.Reps != nil {
+		return *a.Reps > *b.Reps
+	}
+	if a.Seconds != nil && b.Seconds != nil {
+		return *a.Seconds > *b.Seconds
+	}
+	return false
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// csvTable is a header indexed csv file
+type csvTable struct {
+	columns map[string]int
+	rows    [][]string
+}
+
+func (t *csvTable) has(column string) bool {
+	_, ok := t.columns[column]
+	return ok
+}
+
+func (t *csvTable) field(row []string, column string) string {
+	i, ok := t.columns[column]
+	if !ok || i >= len(row) {
+		return ""
+	}
+	return strings.TrimSpace(row[i])
+}
+
+// readCSVTable reads the whole file, sniffing the delimiter since some apps export with ';'
+func readCSVTable(r io.Reader, required ...string) (*csvTable, error) {
+	br := bufio.NewReader(r)
+	firstLine, err := br.Peek(4096)
+	if err != nil && err != io.EOF && !errors.Is(err, bufio.ErrBufferFull) {
+		return nil, err
+	}
+	if strings.HasPrefix(string(firstLine), "\ufeff") {
+		br.Discard(len("\ufeff"))
+	}
+	header := strings.SplitN(string(firstLine), "\n", 2)[0]
+
+	reader := csv.NewReader(br)
+	reader.FieldsPerRecord = -1
+	if strings.Count(header, ";") > strings.Count(header, ",") {
+		reader.Comma = ';'
+	}
+
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, errors.New("file is empty")
+	}
+
+	table := &csvTable{columns: make(map[string]int), rows: records[1:]}
+	for i, name := range records[0] {
+		table.columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+
+	missing := []string{}
+	for _, column := range required {
+		if !table.has(column) {
+			missing = append(missing, column)
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return nil, fmt.Errorf("missing columns: %s", strings.Join(missing, ", "))
+	}
+	return table, nil
+}
+
+func parseOptionalFloat(value string) (*float64, error) {
+	if value == "" {
+		return nil, nil
+	}
+	f, err := strconv.ParseFloat(strings.ReplaceAll(value, ",", "."), 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid number %q", value)
+	}
+	return &f, nil
+}
+
+// parsePositiveInt treats empty and zero as absent, which is how the apps export unused columns
+func parsePositiveInt(value string) (*int, error) {
+	f, err := parseOptionalFloat(value)
+	if err != nil || f == nil || *f <= 0 {
+		return nil, err
+	}
+	i := int(*f)
+	return &i, nil
+}
+
+func toKilograms(weight *float64, unit string) *float64 {
+	if weight == nil || unit != UnitPounds {
+		return weight
+	}
+	kg := float64(int(*weight*poundsToKilograms*100+0.5)) / 100
+	return &kg
+}
+
+// validateSet rejects sets the workout_entries constraint would refuse
+func validateSet(set Set) error {
+	if set.Exercise == "" {
+		return errors.New("missing exercise name")
+	}
+	if set.Reps == nil && set.Seconds == nil {
+		return errors.New("set has neither reps nor duration")
+	}
+	return nil
+}
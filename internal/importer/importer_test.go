@@ -0,0 +1,115 @@
+package importer
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func importFixture(t *testing.T, source, file string, opts Options) *Result {
+	f, err := os.Open("testdata/" + file)
+	require.NoError(t, err)
+	defer f.Close()
+
+	result, err := Import(source, f, opts, NewMatcher())
+	require.NoError(t, err)
+	return result
+}
+
+func TestImportStrong(t *testing.T) {
+	result := importFixture(t, "strong", "strong.csv", Options{})
+
+	require.Len(t, result.Workouts, 2)
+	push := result.Workouts[0].Workout
+	assert.Equal(t, "Push Day", push.Title)
+	assert.Equal(t, "Felt good", push.Description)
+	assert.Equal(t, 65, push.DurationInMinutes)
+	require.Len(t, push.Entries, 3)
+
+	bench := push.Entries[0]
+	assert.Equal(t, "Barbell Bench Press", bench.ExerciseName)
+	assert.Equal(t, 3, bench.ExerciseSets)
+	require.NotNil(t, bench.Weight)
+	assert.Equal(t, float32(80), *bench.Weight)
+	assert.Equal(t, 5, *bench.Reps)
+	assert.Contains(t, bench.Notes, "paused reps")
+
+	assert.Equal(t, "Tricep Pushdown", push.Entries[1].ExerciseName)
+	assert.Equal(t, "Plank", push.Entries[2].ExerciseName)
+	require.NotNil(t, push.Entries[2].DurationSeconds)
+	assert.Equal(t, 60, *push.Entries[2].DurationSeconds)
+	assert.Nil(t, push.Entries[2].Reps)
+
+	legs := result.Workouts[1].Workout
+	assert.Equal(t, "Barbell Back Squat", legs.Entries[0].ExerciseName)
+	assert.Equal(t, 2, legs.Entries[0].ExerciseSets)
+
+	require.Len(t, result.Errors, 1)
+	assert.Equal(t, 8, result.Errors[0].Row)
+}
+
+func TestImportStrongInPounds(t *testing.T) {
+	result := importFixture(t, "strong", "strong.csv", Options{Unit: UnitPounds})
+
+	bench := result.Workouts[0].Workout.Entries[0]
+	require.NotNil(t, bench.Weight)
+	assert.InDelta(t, 36.29, *bench.Weight, 0.01)
+}
+
+func TestImportHevy(t *testing.T) {
+	result := importFixture(t, "hevy", "hevy.csv", Options{})
+
+	require.Empty(t, result.Errors)
+	require.Len(t, result.Workouts, 2)
+
+	upper := result.Workouts[0].Workout
+	assert.Equal(t, "Upper", upper.Title)
+	assert.Equal(t, 70, upper.DurationInMinutes)
+	require.Len(t, upper.Entries, 2)
+	assert.Equal(t, "Barbell Bench Press", upper.Entries[0].ExerciseName)
+	assert.Equal(t, float32(82.5), *upper.Entries[0].Weight)
+	assert.Equal(t, "Lat Pulldown", upper.Entries[1].ExerciseName)
+	assert.Equal(t, 10, *upper.Entries[1].Reps)
+
+	cardio := result.Workouts[1].Workout
+	assert.Equal(t, "Treadmill Running", cardio.Entries[0].ExerciseName)
+	assert.Equal(t, 1800, *cardio.Entries[0].DurationSeconds)
+}
+
+func TestImportFitNotes(t *testing.T) {
+	result := importFixture(t, "fitnotes", "fitnotes.csv", Options{})
+
+	require.Empty(t, result.Errors)
+	require.Len(t, result.Workouts, 2)
+
+	first := result.Workouts[0].Workout
+	assert.Equal(t, "Chest, Shoulders", first.Title)
+	assert.Equal(t, "Barbell Bench Press", first.Entries[0].ExerciseName)
+	assert.InDelta(t, 83.91, *first.Entries[0].Weight, 0.01)
+	assert.Equal(t, "Lateral Raise", first.Entries[1].ExerciseName)
+
+	second := result.Workouts[1].Workout
+	assert.Equal(t, "Back, Cardio", second.Title)
+	assert.Equal(t, "Deadlift", second.Entries[0].ExerciseName)
+	assert.Equal(t, "Treadmill Running", second.Entries[1].ExerciseName)
+	assert.Equal(t, 1200, *second.Entries[1].DurationSeconds)
+}
+
+func TestImportUnknownSource(t *testing.T) {
+	_, err := Import("myfitnesspal", nil, Options{}, NewMatcher())
+	assert.ErrorIs(t, err, ErrUnknownSource)
+}
+
+func TestMatcherKeepsUnknownExercises(t *testing.T) {
+	match := NewMatcher().Match("Zercher Carry")
+	assert.False(t, match.Matched)
+	assert.Equal(t, "Zercher Carry", match.Name)
+}
+
+func TestMatcherPrefersUserExercises(t *testing.T) {
+	match := NewMatcher("Bench").Match("bench")
+	assert.True(t, match.Matched)
+	assert.Equal(t, "Bench", match.Name)
+}
@@ -0,0 +1,184 @@
+package importer
+
+import (
+	"bufio"
+	_ "embed"
+	"regexp"
+	"strings"
+)
+
+//go:embed exercises.txt
+var exerciseCatalog string
+
+// minMatchScore is the similarity an imported name needs to be mapped onto a catalog name
+const minMatchScore = 0.75
+
+type ExerciseMatch struct {
+	Source  string  `json:"source"`
+	Name    string  `json:"name"`
+	Score   float64 `json:"score"`
+	Matched bool    `json:"matched"`
+}
+
+// Matcher fuzzy matches exercise names from other apps onto our canonical names
+type Matcher struct {
+	names      []string
+	normalized []string
+}
+
+// NewMatcher builds a matcher from the embedded catalog plus any extra names, typically the
+// exercises the user already logged so imports line up with their history
+func NewMatcher(extra ...string) *Matcher {
+	m := &Matcher{}
+	seen := make(map[string]bool)
+	add := func(name string) {
+		name = strings.TrimSpace(name)
+		normalized := normalizeExercise(name)
+		if name == "" || strings.HasPrefix(name, "#") || seen[normalized] {
+			return
+		}
+		seen[normalized] = true
+		m.names = append(m.names, name)
+		m.normalized = append(m.normalized, normalized)
+	}
+
+	for _, name := range extra {
+		add(name)
+	}
+	scanner := bufio.NewScanner(strings.NewReader(exerciseCatalog))
+	for scanner.Scan() {
+		add(scanner.Text())
+	}
+	return m
+}
+
+var (
+	parenthesisRegex = regexp.MustCompile(`\(([^)]*)\)`)
+	nonWordRegex     = regexp.MustCompile(`[^a-z0-9]+`)
+)
+
+// synonyms maps the wording other apps use onto the catalog wording
+var synonyms = map[string]string{
+	"flat":       "",
+	"triceps":    "tricep",
+	"biceps":     "",
+	"db":         "dumbbell",
+	"bb":         "barbell",
+	"pulldowns":  "pulldown",
+	"pushups":    "push up",
+	"pushup":     "push up",
+	"pullup":     "pull up",
+	"chinup":     "chin up",
+	"ohp":        "overhead press",
+	"rdl":        "romanian deadlift",
+	"treadmill":  "treadmill running",
+	"run":        "running",
+	"bike":       "cycling",
+	"machine":    "",
+	"cable":      "",
+	"bodyweight": "",
+}
+
+// normalizeExercise lowercases, moves equipment written in parenthesis ("Bench Press (Barbell)")
+// to the front and maps common synonyms
+func normalizeExercise(name string) string {
+	name = strings.ToLower(name)
+	var equipment []string
+	name = parenthesisRegex.ReplaceAllStringFunc(name, func(match string) string {
+		equipment = append(equipment, strings.Trim(match, "()"))
+		return " "
+	})
+	name = strings.Join(append(equipment, name), " ")
+	name = nonWordRegex.ReplaceAllString(name, " ")
+
+	// expanded synonyms can repeat a word already in the name ("running (treadmill)")
+	words := []string{}
+	seen := make(map[string]bool)
+	for _, word := range strings.Fields(name) {
+		if replacement, ok := synonyms[word]; ok {
+			word = replacement
+		}
+		for _, w := range strings.Fields(word) {
+			if !seen[w] {
+				seen[w] = true
+				words = append(words, w)
+			}
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+// Match returns the best catalog name for source. Names scoring below minMatchScore are kept as is.
+func (m *Matcher) Match(source string) ExerciseMatch {
+	normalized := normalizeExercise(source)
+	best := ExerciseMatch{Source: source, Name: strings.TrimSpace(source)}
+
+	for i, candidate := range m.normalized {
+		score := similarity(normalized, candidate)
+		if score > best.Score {
+			best.Score = score
+			if score >= minMatchScore {
+				best.Name = m.names[i]
+				best.Matched = true
+			}
+		}
+	}
+	best.Score = float64(int(best.Score*100)) / 100
+	return best
+}
+
+// similarity blends edit distance with word overlap, so reordered words still match
+func similarity(a, b string) float64 {
+	if a == b {
+		return 1
+	}
+	if a == "" || b == "" {
+		return 0
+	}
+
+	maxLen := len(a)
+	if len(b) > maxLen {
+		maxLen = len(b)
+	}
+	editScore := 1 - float64(levenshtein(a, b))/float64(maxLen)
+
+	wordsA := strings.Fields(a)
+	wordsB := make(map[string]bool)
+	for _, word := range strings.Fields(b) {
+		wordsB[word] = true
+	}
+	common := 0
+	for _, word := range wordsA {
+		if wordsB[word] {
+			common++
+		}
+	}
+	total := len(wordsA) + len(wordsB)
+	wordScore := 2 * float64(common) / float64(total)
+
+	if wordScore > editScore {
+		return wordScore
+	}
+	return editScore
+}
+
+func levenshtein(a, b string) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(b)]
+}
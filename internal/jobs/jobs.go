@@ -0,0 +1,129 @@
+// Package jobs runs background work from a queue kept in postgres. Jobs are enqueued with typed
+// arguments, workers on any number of instances claim them with SKIP LOCKED and failed jobs are
+// retried with an exponential backoff until they run out of attempts.
+package jobs
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/kodega2016/femapi/internal/store"
+)
+
+const (
+	DefaultMaxAttempts = 5
+
+	firstRetry = 10 * time.Second
+	maxRetry   = time.Hour
+)
+
+// Args are the arguments of a job, Kind names the handler that runs it
+type Args interface {
+	Kind() string
+}
+
+// Backoff is the wait after the given failed attempt: 10s doubling on every attempt up to an hour
+func Backoff(attempt int) time.Duration {
+	if attempt < 1 {
+		attempt = 1
+	}
+	wait := firstRetry
+	for i := 1; i < attempt; i++ {
+		wait *= 2
+		if wait >= maxRetry {
+			return maxRetry
+		}
+	}
+	return wait
+}
+
+// permanentError fails a job without retrying it
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
+
+// Permanent wraps an error a retry can not fix, such as invalid arguments: the job fails
+// right away instead of using up its attempts
+func Permanent(err error) error {
+	return &permanentError{err: err}
+}
+
+func isPermanent(err error) bool {
+	var permanent *permanentError
+	return errors.As(err, &permanent)
+}
+
+type Option func(job *store.Job)
+
+// RunAt schedules the job, it is not run before t
+func RunAt(t time.Time) Option {
+	return func(job *store.Job) {
+		job.RunAt = t
+	}
+}
+
+// Delay schedules the job d from now
+func Delay(d time.Duration) Option {
+	return func(job *store.Job) {
+		job.RunAt = time.Now().Add(d)
+	}
+}
+
+// Unique enqueues the job only when no job with the same key is queued or running
+func Unique(key string) Option {
+	return func(job *store.Job) {
+		job.UniqueKey = &key
+	}
+}
+
+// MaxAttempts is how often the job runs before it is failed, DefaultMaxAttempts otherwise
+func MaxAttempts(n int) Option {
+	return func(job *store.Job) {
+		job.MaxAttempts = n
+	}
+}
+
+// Queue enqueues jobs
+type Queue struct {
+	store store.JobStore
+}
+
+func NewQueue(jobStore store.JobStore) *Queue {
+	return &Queue{store: jobStore}
+}
+
+// Enqueue stores a job for args. It returns the job and whether it was inserted: a unique job
+// that is already queued or running is not inserted again and that job is returned.
+func (q *Queue) Enqueue(args Args, opts ...Option) (*store.Job, bool, error) {
+	data, err := json.Marshal(args)
+	if err != nil {
+		return nil, false, err
+	}
+	job := &store.Job{
+		Kind:        args.Kind(),
+		Args:        data,
+		MaxAttempts: DefaultMaxAttempts,
+		RunAt:       time.Now(),
+	}
+	for _, opt := range opts {
+		opt(job)
+	}
+	if job.MaxAttempts < 1 {
+		return nil, false, errors.New("a job needs at least one attempt")
+	}
+
+	inserted, err := q.store.EnqueueJob(job)
+	if err != nil {
+		return nil, false, err
+	}
+	return job, inserted, nil
+}
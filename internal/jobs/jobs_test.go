@@ -0,0 +1,274 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kodega2016/femapi/internal/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type sendEmail struct {
+	To string `json:"to"`
+}
+
+func (sendEmail) Kind() string { return "send_email" }
+
+// fakeStore keeps jobs in memory, ClaimJobs hands out the queued jobs that are due
+type fakeStore struct {
+	store.JobStore
+
+	mu       sync.Mutex
+	jobs     []*store.Job
+	released []int64
+}
+
+func (fs *fakeStore) EnqueueJob(job *store.Job) (bool, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if job.UniqueKey != nil {
+		for _, existing := range fs.jobs {
+			if existing.UniqueKey != nil && *existing.UniqueKey == *job.UniqueKey &&
+				(existing.Status == store.JobQueued || existing.Status == store.JobRunning) {
+				*job = *existing
+				return false, nil
+			}
+		}
+	}
+	job.ID = int64(len(fs.jobs) + 1)
+	job.Status = store.JobQueued
+	stored := *job
+	fs.jobs = append(fs.jobs, &stored)
+	return true, nil
+}
+
+func (fs *fakeStore) ClaimJobs(kinds []string, limit int, lease time.Duration) ([]store.Job, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	claimed := []store.Job{}
+	for _, job := range fs.jobs {
+		if len(claimed) == limit {
+			break
+		}
+		if job.Status == store.JobQueued && !job.RunAt.After(time.Now()) {
+			job.Status = store.JobRunning
+			job.Attempts++
+			claimed = append(claimed, *job)
+		}
+	}
+	return claimed, nil
+}
+
+// claimed returns the job when it is still running the given attempt, like the fencing of the
+// postgres store
+func (fs *fakeStore) claimed(id int64, attempt int) (*store.Job, error) {
+	job := fs.jobs[id-1]
+	if job.Status != store.JobRunning || job.Attempts != attempt {
+		return nil, store.ErrJobReclaimed
+	}
+	return job, nil
+}
+
+func (fs *fakeStore) ExtendJobLease(int64, int, time.Duration) error {
+	return nil
+}
+
+func (fs *fakeStore) CompleteJob(id int64, attempt int) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	job, err := fs.claimed(id, attempt)
+	if err != nil {
+		return err
+	}
+	job.Status = store.JobSucceeded
+	return nil
+}
+
+func (fs *fakeStore) FailJob(id int64, attempt int, jobErr string, retryAt *time.Time) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	job, err := fs.claimed(id, attempt)
+	if err != nil {
+		return err
+	}
+	job.LastError = &jobErr
+	if retryAt == nil {
+		job.Status = store.JobFailed
+		return nil
+	}
+	job.Status = store.JobQueued
+	job.RunAt = *retryAt
+	return nil
+}
+
+func (fs *fakeStore) ReleaseJob(id int64, attempt int) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	job, err := fs.claimed(id, attempt)
+	if err != nil {
+		return err
+	}
+	job.Status = store.JobQueued
+	job.Attempts--
+	fs.released = append(fs.released, id)
+	return nil
+}
+
+func (fs *fakeStore) job(id int64) store.Job {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return *fs.jobs[id-1]
+}
+
+func testWorker(fs *fakeStore) *Worker {
+	worker := NewWorker(fs, log.New(io.Discard, "", 0))
+	worker.PollInterval = 5 * time.Millisecond
+	return worker
+}
+
+// runUntil runs the worker until cond holds
+func runUntil(t *testing.T, worker *Worker, cond func() bool) {
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		worker.Run(ctx)
+		close(stopped)
+	}()
+	assert.Eventually(t, cond, time.Second, 5*time.Millisecond)
+	cancel()
+	<-stopped
+}
+
+func TestBackoff(t *testing.T) {
+	assert.Equal(t, 10*time.Second, Backoff(1))
+	assert.Equal(t, 40*time.Second, Backoff(3))
+	assert.Equal(t, time.Hour, Backoff(12))
+}
+
+func TestEnqueueOptions(t *testing.T) {
+	fs := &fakeStore{}
+	queue := NewQueue(fs)
+	runAt := time.Date(2024, 5, 6, 10, 0, 0, 0, time.UTC)
+
+	job, inserted, err := queue.Enqueue(sendEmail{To: "a@example.com"}, RunAt(runAt), Unique("welcome:a"), MaxAttempts(2))
+	require.NoError(t, err)
+	assert.True(t, inserted)
+	assert.Equal(t, "send_email", job.Kind)
+	assert.JSONEq(t, `{"to":"a@example.com"}`, string(job.Args))
+	assert.Equal(t, runAt, job.RunAt)
+	assert.Equal(t, 2, job.MaxAttempts)
+
+	again, inserted, err := queue.Enqueue(sendEmail{To: "a@example.com"}, Unique("welcome:a"))
+	require.NoError(t, err)
+	assert.False(t, inserted, "a unique job is only queued once")
+	assert.Equal(t, job.ID, again.ID)
+
+	_, _, err = queue.Enqueue(sendEmail{}, MaxAttempts(0))
+	assert.Error(t, err)
+}
+
+func TestWorkerRunsAndRetriesJobs(t *testing.T) {
+	fs := &fakeStore{}
+	queue := NewQueue(fs)
+	ok, _, _ := queue.Enqueue(sendEmail{To: "ok@example.com"})
+	flaky, _, _ := queue.Enqueue(sendEmail{To: "flaky@example.com"})
+	invalid, _, _ := queue.Enqueue(sendEmail{To: "invalid"})
+	panics, _, _ := queue.Enqueue(sendEmail{To: "panic@example.com"}, MaxAttempts(1))
+
+	var mu sync.Mutex
+	sent := []string{}
+	worker := testWorker(fs)
+	Handle(worker, func(ctx context.Context, args sendEmail) error {
+		switch args.To {
+		case "flaky@example.com":
+			return errors.New("smtp unavailable")
+		case "invalid":
+			return Permanent(errors.New("invalid address"))
+		case "panic@example.com":
+			panic("boom")
+		}
+		mu.Lock()
+		sent = append(sent, args.To)
+		mu.Unlock()
+		return nil
+	})
+
+	runUntil(t, worker, func() bool {
+		return fs.job(ok.ID).Status == store.JobSucceeded &&
+			fs.job(invalid.ID).Status == store.JobFailed &&
+			fs.job(panics.ID).Status == store.JobFailed &&
+			fs.job(flaky.ID).LastError != nil
+	})
+
+	assert.Equal(t, []string{"ok@example.com"}, sent)
+	retried := fs.job(flaky.ID)
+	assert.Equal(t, store.JobQueued, retried.Status)
+	assert.WithinDuration(t, time.Now().Add(Backoff(1)), retried.RunAt, time.Second)
+	assert.Equal(t, "invalid address", *fs.job(invalid.ID).LastError)
+	assert.Contains(t, *fs.job(panics.ID).LastError, "boom")
+}
+
+func TestWorkerReleasesJobsOnShutdown(t *testing.T) {
+	fs := &fakeStore{}
+	job, _, _ := NewQueue(fs).Enqueue(sendEmail{To: "slow@example.com"})
+
+	started := make(chan struct{})
+	worker := testWorker(fs)
+	worker.ShutdownTimeout = 10 * time.Millisecond
+	Handle(worker, func(ctx context.Context, args sendEmail) error {
+		close(started)
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		worker.Run(ctx)
+		close(stopped)
+	}()
+	<-started
+	cancel()
+	<-stopped
+
+	released := fs.job(job.ID)
+	assert.Equal(t, store.JobQueued, released.Status)
+	assert.Zero(t, released.Attempts, "a released job keeps its attempts")
+	assert.Equal(t, []int64{job.ID}, fs.released)
+}
+
+func TestWorkerDropsTheOutcomeOfAReclaimedJob(t *testing.T) {
+	fs := &fakeStore{}
+	job, _, _ := NewQueue(fs).Enqueue(sendEmail{To: "slow@example.com"})
+
+	done := make(chan struct{})
+	worker := testWorker(fs)
+	Handle(worker, func(ctx context.Context, args sendEmail) error {
+		// the lease ran out and another worker claimed the job again
+		fs.mu.Lock()
+		fs.jobs[job.ID-1].Attempts++
+		fs.mu.Unlock()
+		close(done)
+		return nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		worker.Run(ctx)
+		close(stopped)
+	}()
+	<-done
+	cancel()
+	<-stopped
+
+	reclaimed := fs.job(job.ID)
+	assert.Equal(t, store.JobRunning, reclaimed.Status, "the job belongs to the worker that claimed it again")
+	assert.Equal(t, 2, reclaimed.Attempts)
+}
@@ -0,0 +1,210 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/kodega2016/femapi/internal/store"
+)
+
+const (
+	defaultConcurrency     = 4
+	defaultPollInterval    = time.Second
+	defaultShutdownTimeout = 30 * time.Second
+	// a claimed job is locked for this long and the lock is extended while its handler runs
+	jobLease = time.Minute
+)
+
+type handler func(ctx context.Context, args json.RawMessage) error
+
+// Worker runs the jobs it has handlers for. Concurrency, PollInterval and ShutdownTimeout can
+// be changed before Run.
+type Worker struct {
+	Concurrency     int
+	PollInterval    time.Duration
+	ShutdownTimeout time.Duration
+
+	store    store.JobStore
+	logger   *log.Logger
+	handlers map[string]handler
+}
+
+func NewWorker(jobStore store.JobStore, logger *log.Logger) *Worker {
+	return &Worker{
+		Concurrency:     defaultConcurrency,
+		PollInterval:    defaultPollInterval,
+		ShutdownTimeout: defaultShutdownTimeout,
+		store:           jobStore,
+		logger:          logger,
+		handlers:        make(map[string]handler),
+	}
+}
+
+// Handle registers fn for the jobs of kind T. T is a struct whose Kind method has a value
+// receiver, the arguments of each job are decoded into it. Arguments that do not decode fail
+// the job without retrying it.
+func Handle[T Args](w *Worker, fn func(ctx context.Context, args T) error) {
+	var zero T
+	kind := zero.Kind()
+	w.handlers[kind] = func(ctx context.Context, raw json.RawMessage) error {
+		var args T
+		err := json.Unmarshal(raw, &args)
+		if err != nil {
+			return Permanent(fmt.Errorf("decoding %s args: %w", kind, err))
+		}
+		return fn(ctx, args)
+	}
+}
+
+func (w *Worker) kinds() []string {
+	kinds := make([]string, 0, len(w.handlers))
+	for kind := range w.handlers {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	return kinds
+}
+
+// Run claims and runs jobs until ctx is done. It then stops claiming and waits up to
+// ShutdownTimeout for the running jobs. Jobs still running after that have their context
+// cancelled and go back to the queue without using up an attempt.
+func (w *Worker) Run(ctx context.Context) {
+	kinds := w.kinds()
+	if len(kinds) == 0 {
+		return
+	}
+
+	jobCtx, cancelJobs := context.WithCancel(context.Background())
+	defer cancelJobs()
+
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, w.Concurrency)
+	// a finished job frees a slot, the next job is claimed without waiting for the ticker
+	finished := make(chan struct{}, 1)
+	ticker := time.NewTicker(w.PollInterval)
+	defer ticker.Stop()
+
+	for ctx.Err() == nil {
+		free := cap(slots) - len(slots)
+		if free > 0 {
+			jobs, err := w.store.ClaimJobs(kinds, free, jobLease)
+			if err != nil {
+				w.logger.Printf("ERROR: claimJobs: %v", err)
+			}
+			for _, job := range jobs {
+				slots <- struct{}{}
+				wg.Add(1)
+				go func(job store.Job) {
+					defer wg.Done()
+					w.run(jobCtx, job)
+					<-slots
+					select {
+					case finished <- struct{}{}:
+					default:
+					}
+				}(job)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+		case <-finished:
+		case <-ticker.C:
+		}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(w.ShutdownTimeout):
+		w.logger.Printf("jobs still running after %s, returning them to the queue", w.ShutdownTimeout)
+		cancelJobs()
+		<-done
+	}
+}
+
+// run calls the handler of the job and settles it: done, retried after a backoff or failed
+func (w *Worker) run(ctx context.Context, job store.Job) {
+	stop := w.keepLease(job)
+	err := w.call(ctx, job)
+	stop()
+
+	switch {
+	case err == nil:
+		err = w.store.CompleteJob(job.ID, job.Attempts)
+		w.logSettleError("completeJob", job, err)
+		return
+	case ctx.Err() != nil:
+		// the worker is shutting down, another one runs the job again
+		err = w.store.ReleaseJob(job.ID, job.Attempts)
+		w.logSettleError("releaseJob", job, err)
+		return
+	}
+
+	var retryAt *time.Time
+	if !isPermanent(err) && job.Attempts < job.MaxAttempts {
+		next := time.Now().Add(Backoff(job.Attempts))
+		retryAt = &next
+	}
+	w.logger.Printf("ERROR: job %s %s attempt %d: %v", job.Kind, job.PublicID, job.Attempts, err)
+	err = w.store.FailJob(job.ID, job.Attempts, err.Error(), retryAt)
+	w.logSettleError("failJob", job, err)
+}
+
+// logSettleError logs a failure to settle a job. A job claimed again after this worker's lease
+// ran out belongs to the other worker, its outcome is left to that one.
+func (w *Worker) logSettleError(op string, job store.Job, err error) {
+	switch {
+	case err == nil:
+	case errors.Is(err, store.ErrJobReclaimed):
+		w.logger.Printf("job %s %s was claimed again while attempt %d ran, dropping its outcome", job.Kind, job.PublicID, job.Attempts)
+	default:
+		w.logger.Printf("ERROR: %s: %v", op, err)
+	}
+}
+
+// call runs the handler, a panic fails the attempt instead of the worker
+func (w *Worker) call(ctx context.Context, job store.Job) (err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = fmt.Errorf("panic: %v", recovered)
+		}
+	}()
+
+	h, ok := w.handlers[job.Kind]
+	if !ok {
+		return Permanent(fmt.Errorf("no handler for %s jobs", job.Kind))
+	}
+	return h(ctx, job.Args)
+}
+
+// keepLease extends the lock of a running job until the returned func is called
+func (w *Worker) keepLease(job store.Job) func() {
+	ticker := time.NewTicker(jobLease / 3)
+	done := make(chan struct{})
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				err := w.store.ExtendJobLease(job.ID, job.Attempts, jobLease)
+				if err != nil && !errors.Is(err, store.ErrJobReclaimed) {
+					w.logger.Printf("ERROR: extendJobLease: %v", err)
+				}
+			}
+		}
+	}()
+	return func() { close(done) }
+}
@@ -0,0 +1,142 @@
+package live
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/kodega2016/femapi/internal/store"
+)
+
+// subscriberBuffer is how many events a subscriber may fall behind before it is dropped
+const subscriberBuffer = 64
+
+// Hub delivers the events of a session to everyone subscribed to it. Events are stored before
+// they are published, a subscriber that was dropped or reconnects catches up from the store.
+type Hub interface {
+	Publish(ctx context.Context, event store.LiveEvent) error
+	Subscribe(sessionID string) *Subscription
+}
+
+// Subscription receives the events of one session. C is closed when the subscription is
+// closed or when the subscriber fell too far behind.
+type Subscription struct {
+	C <-chan store.LiveEvent
+
+	ch     chan store.LiveEvent
+	closed bool
+	cancel func()
+}
+
+// Close stops the subscription, it is safe to call more than once
+func (s *Subscription) Close() {
+	s.cancel()
+}
+
+// MemoryHub delivers events to the subscribers of this process only
+type MemoryHub struct {
+	mu       sync.Mutex
+	sessions map[string]map[*Subscription]struct{}
+}
+
+func NewMemoryHub() *MemoryHub {
+	return &MemoryHub{sessions: make(map[string]map[*Subscription]struct{})}
+}
+
+func (h *MemoryHub) Subscribe(sessionID string) *Subscription {
+	ch := make(chan store.LiveEvent, subscriberBuffer)
+	sub := &Subscription{C: ch, ch: ch}
+	sub.cancel = func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		h.remove(sessionID, sub)
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.sessions[sessionID] == nil {
+		h.sessions[sessionID] = make(map[*Subscription]struct{})
+	}
+	h.sessions[sessionID][sub] = struct{}{}
+	return sub
+}
+
+// Publish never blocks, subscribers that cannot keep up are dropped
+func (h *MemoryHub) Publish(ctx context.Context, event store.LiveEvent) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for sub := range h.sessions[event.SessionID] {
+		select {
+		case sub.ch <- event:
+		default:
+			h.remove(event.SessionID, sub)
+		}
+	}
+	return nil
+}
+
+// remove closes the subscription, h.mu must be held
+func (h *MemoryHub) remove(sessionID string, sub *Subscription) {
+	if sub.closed {
+		return
+	}
+	sub.closed = true
+	close(sub.ch)
+	delete(h.sessions[sessionID], sub)
+	if len(h.sessions[sessionID]) == 0 {
+		delete(h.sessions, sessionID)
+	}
+}
+
+// closeAll drops every subscriber
+func (h *MemoryHub) closeAll() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for sessionID, subs := range h.sessions {
+		for sub := range subs {
+			h.remove(sessionID, sub)
+		}
+	}
+}
+
+// DeferredHub holds back the events published inside a transaction: Flush hands them to the
+// hub once it committed, they are dropped with the hub when it rolled back. Subscribing goes
+// straight to the hub.
+type DeferredHub struct {
+	hub    Hub
+	mu     sync.Mutex
+	events []store.LiveEvent
+}
+
+func NewDeferredHub(hub Hub) *DeferredHub {
+	return &DeferredHub{hub: hub}
+}
+
+func (h *DeferredHub) Subscribe(sessionID string) *Subscription {
+	return h.hub.Subscribe(sessionID)
+}
+
+func (h *DeferredHub) Publish(ctx context.Context, event store.LiveEvent) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.events = append(h.events, event)
+	return nil
+}
+
+// Flush publishes the held back events in order. Subscribers that miss one catch up from the
+// store, so every event is tried and the errors are returned together.
+func (h *DeferredHub) Flush(ctx context.Context) error {
+	h.mu.Lock()
+	events := h.events
+	h.events = nil
+	h.mu.Unlock()
+
+	var errs []error
+	for _, event := range events {
+		err := h.hub.Publish(ctx, event)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
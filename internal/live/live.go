@@ -0,0 +1,250 @@
+// Package live tracks workouts while they happen. Devices stream events of a session, every
+// subscriber of the session receives them and finishing the session turns them into a workout.
+package live
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kodega2016/femapi/internal/store"
+)
+
+// events sent by the devices of the session owner
+const (
+	EventSetCompleted = "set_completed"
+	EventRestStarted  = "rest_started"
+	EventRestEnded    = "rest_ended"
+	EventHeartRate    = "heart_rate"
+)
+
+// events recorded by the server when the session ends
+const (
+	EventFinished  = "session_finished"
+	EventAbandoned = "session_abandoned"
+)
+
+const (
+	minHeartRate = 20
+	maxHeartRate = 250
+	maxRest      = 60 * 60
+)
+
+// SetCompleted is a set done, either with reps or held for a duration
+type SetCompleted struct {
+	ExerciseName    string   `json:"exercise_name"`
+	Reps            *int     `json:"reps,omitempty"`
+	DurationSeconds *int     `json:"duration_seconds,omitempty"`
+	Weight          *float32 `json:"weight,omitempty"`
+}
+
+// RestStarted starts the rest timer, Seconds is how long the rest is planned to last
+type RestStarted struct {
+	Seconds int `json:"seconds"`
+}
+
+type HeartRate struct {
+	BPM int `json:"bpm"`
+}
+
+// Finished tells subscribers which workout the session became
+type Finished struct {
+	WorkoutID string `json:"workout_id"`
+}
+
+// ParseEvent validates an event sent by a device and returns its data in canonical form
+func ParseEvent(eventType string, data json.RawMessage) (json.RawMessage, error) {
+	var value any
+	switch eventType {
+	case EventSetCompleted:
+		var set SetCompleted
+		err := decode(data, &set)
+		if err != nil {
+			return nil, err
+		}
+		set.ExerciseName = strings.TrimSpace(set.ExerciseName)
+		switch {
+		case set.ExerciseName == "":
+			return nil, errors.New("exercise_name is required")
+		case len(set.ExerciseName) > 100:
+			return nil, errors.New("exercise_name cannot be greater than 100 characters")
+		case (set.Reps == nil) == (set.DurationSeconds == nil):
+			return nil, errors.New("a set needs either reps or duration_seconds")
+		case set.Reps != nil && *set.Reps <= 0, set.DurationSeconds != nil && *set.DurationSeconds <= 0:
+			return nil, errors.New("reps and duration_seconds must be greater than 0")
+		case set.Weight != nil && *set.Weight < 0:
+			return nil, errors.New("weight cannot be negative")
+		}
+		value = set
+	case EventRestStarted:
+		var rest RestStarted
+		err := decode(data, &rest)
+		if err != nil {
+			return nil, err
+		}
+		if rest.Seconds <= 0 || rest.Seconds > maxRest {
+			return nil, errors.New("seconds must be between 1 and 3600")
+		}
+		value = rest
+	case EventRestEnded:
+		value = struct{}{}
+	case EventHeartRate:
+		var rate HeartRate
+		err := decode(data, &rate)
+		if err != nil {
+			return nil, err
+		}
+		if rate.BPM < minHeartRate || rate.BPM > maxHeartRate {
+			return nil, errors.New("bpm must be between 20 and 250")
+		}
+		value = rate
+	default:
+		return nil, errors.New("type must be set_completed, rest_started, rest_ended or heart_rate")
+	}
+	return json.Marshal(value)
+}
+
+func decode(data json.RawMessage, v any) error {
+	if len(data) == 0 {
+		return errors.New("data is required")
+	}
+	err := json.Unmarshal(data, v)
+	if err != nil {
+		return errors.New("invalid event data")
+	}
+	return nil
+}
+
+// exerciseSets collects the sets of one exercise in the order they were done
+type exerciseSets struct {
+	name string
+	sets []SetCompleted
+}
+
+// BuildWorkout turns the events of a session into the workout it describes. Every exercise
+// becomes one entry with its top set, the heaviest or longest, and all sets in the notes.
+// Heart rate samples end up in the activity, strength workouts have none and mention them in
+// the description instead.
+func BuildWorkout(session *store.LiveSession, events []store.LiveEvent, end time.Time) *store.Workout {
+	workout := &store.Workout{
+		Title:             session.Title,
+		UserID:            session.UserID,
+		Type:              session.Type,
+		PerformedAt:       session.StartedAt,
+		DurationInMinutes: max(int(math.Round(end.Sub(session.StartedAt).Minutes())), 1),
+		CaloriesEstimated: true,
+		Entries:           []store.WorkoutEntry{},
+	}
+
+	var exercises []*exerciseSets
+	byName := make(map[string]*exerciseSets)
+	var beats, samples, maxBPM int
+	for _, event := range events {
+		switch event.Type {
+		case EventSetCompleted:
+			var set SetCompleted
+			if json.Unmarshal(event.Data, &set) != nil {
+				continue
+			}
+			key := strings.ToLower(set.ExerciseName)
+			exercise, ok := byName[key]
+			if !ok {
+				exercise = &exerciseSets{name: set.ExerciseName}
+				byName[key] = exercise
+				exercises = append(exercises, exercise)
+			}
+			exercise.sets = append(exercise.sets, set)
+		case EventHeartRate:
+			var rate HeartRate
+			if json.Unmarshal(event.Data, &rate) != nil {
+				continue
+			}
+			beats += rate.BPM
+			samples++
+			maxBPM = max(maxBPM, rate.BPM)
+		}
+	}
+
+	for i, exercise := range exercises {
+		workout.Entries = append(workout.Entries, entry(exercise, i))
+	}
+
+	if samples > 0 {
+		avgBPM := int(math.Round(float64(beats) / float64(samples)))
+		if workout.Type == store.WorkoutTypeStrength {
+			workout.Description = fmt.Sprintf("Average heart rate %d bpm, max %d bpm", avgBPM, maxBPM)
+		} else {
+			workout.Activity = &store.Activity{
+				StartedAt:       &session.StartedAt,
+				DurationSeconds: int(end.Sub(session.StartedAt).Seconds()),
+				AvgHeartRate:    &avgBPM,
+				MaxHeartRate:    &maxBPM,
+			}
+		}
+	}
+	return workout
+}
+
+// entry summarizes the sets of an exercise
+func entry(exercise *exerciseSets, index int) store.WorkoutEntry {
+	top := exercise.sets[0]
+	details := make([]string, 0, len(exercise.sets))
+	for _, set := range exercise.sets {
+		if heavier(set, top) {
+			top = set
+		}
+		details = append(details, describe(set))
+	}
+
+	e := store.WorkoutEntry{
+		ExerciseName:    exercise.name,
+		ExerciseSets:    len(exercise.sets),
+		Reps:            top.Reps,
+		DurationSeconds: top.DurationSeconds,
+		Weight:          top.Weight,
+		OrderIndex:      index,
+	}
+	if len(exercise.sets) > 1 {
+		e.Notes = strings.Join(details, ", ")
+	}
+	return e
+}
+
+// heavier reports whether a is a better set than b: more weight, then more reps or time
+func heavier(a, b SetCompleted) bool {
+	wa, wb := weight(a), weight(b)
+	if wa != wb {
+		return wa > wb
+	}
+	return volume(a) > volume(b)
+}
+
+func weight(set SetCompleted) float32 {
+	if set.Weight == nil {
+		return 0
+	}
+	return *set.Weight
+}
+
+func volume(set SetCompleted) int {
+	if set.Reps != nil {
+		return *set.Reps
+	}
+	return *set.DurationSeconds
+}
+
+// describe formats a set as "8 x 60", "12" or "45s"
+func describe(set SetCompleted) string {
+	amount := strconv.Itoa(volume(set))
+	if set.DurationSeconds != nil {
+		amount += "s"
+	}
+	if set.Weight == nil || *set.Weight == 0 {
+		return amount
+	}
+	return amount + " x " + strconv.FormatFloat(float64(*set.Weight), 'f', -1, 32)
+}
@@ -0,0 +1,120 @@
+package live
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/kodega2016/femapi/internal/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseEvent(t *testing.T) {
+	data, err := ParseEvent(EventSetCompleted, json.RawMessage(`{"exercise_name":" Squat ","reps":5,"weight":100,"extra":true}`))
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"exercise_name":"Squat","reps":5,"weight":100}`, string(data))
+
+	_, err = ParseEvent(EventSetCompleted, json.RawMessage(`{"exercise_name":"Plank","reps":1,"duration_seconds":60}`))
+	assert.Error(t, err)
+	_, err = ParseEvent(EventHeartRate, json.RawMessage(`{"bpm":300}`))
+	assert.Error(t, err)
+	_, err = ParseEvent(EventFinished, json.RawMessage(`{}`))
+	assert.Error(t, err, "devices cannot end a session with an event")
+}
+
+func event(eventType, data string) store.LiveEvent {
+	return store.LiveEvent{Type: eventType, Data: json.RawMessage(data)}
+}
+
+func TestBuildWorkout(t *testing.T) {
+	start := time.Date(2024, 5, 6, 18, 0, 0, 0, time.UTC)
+	session := &store.LiveSession{UserID: 7, Title: "Leg day", Type: store.WorkoutTypeStrength, StartedAt: start}
+	events := []store.LiveEvent{
+		event(EventSetCompleted, `{"exercise_name":"Squat","reps":8,"weight":80}`),
+		event(EventHeartRate, `{"bpm":120}`),
+		event(EventRestStarted, `{"seconds":90}`),
+		event(EventSetCompleted, `{"exercise_name":"squat","reps":5,"weight":100}`),
+		event(EventHeartRate, `{"bpm":160}`),
+		event(EventSetCompleted, `{"exercise_name":"Plank","duration_seconds":60}`),
+	}
+
+	workout := BuildWorkout(session, events, start.Add(47*time.Minute))
+
+	assert.Equal(t, 7, workout.UserID)
+	assert.Equal(t, start, workout.PerformedAt)
+	assert.Equal(t, 47, workout.DurationInMinutes)
+	assert.Equal(t, "Average heart rate 140 bpm, max 160 bpm", workout.Description)
+	assert.Nil(t, workout.Activity, "strength workouts have no activity")
+
+	require.Len(t, workout.Entries, 2)
+	squat := workout.Entries[0]
+	assert.Equal(t, "Squat", squat.ExerciseName)
+	assert.Equal(t, 2, squat.ExerciseSets)
+	assert.Equal(t, 5, *squat.Reps)
+	assert.Equal(t, float32(100), *squat.Weight)
+	assert.Equal(t, "8 x 80, 5 x 100", squat.Notes)
+	assert.Equal(t, 60, *workout.Entries[1].DurationSeconds)
+	assert.Equal(t, 1, workout.Entries[1].OrderIndex)
+}
+
+func TestBuildWorkoutPutsHeartRateInTheActivity(t *testing.T) {
+	start := time.Date(2024, 5, 6, 7, 0, 0, 0, time.UTC)
+	session := &store.LiveSession{Title: "Intervals", Type: store.WorkoutTypeHIIT, StartedAt: start}
+
+	workout := BuildWorkout(session, []store.LiveEvent{event(EventHeartRate, `{"bpm":150}`)}, start.Add(20*time.Minute))
+
+	require.NotNil(t, workout.Activity)
+	assert.Equal(t, 150, *workout.Activity.AvgHeartRate)
+	assert.Equal(t, 1200, workout.Activity.DurationSeconds)
+	assert.Empty(t, workout.Description)
+}
+
+func TestMemoryHub(t *testing.T) {
+	hub := NewMemoryHub()
+	sub := hub.Subscribe("a")
+	other := hub.Subscribe("b")
+	defer other.Close()
+
+	require.NoError(t, hub.Publish(context.Background(), store.LiveEvent{Seq: 1, SessionID: "a"}))
+	assert.Equal(t, int64(1), (<-sub.C).Seq)
+	assert.Empty(t, other.C, "events only reach the subscribers of their session")
+
+	sub.Close()
+	sub.Close()
+	_, ok := <-sub.C
+	assert.False(t, ok)
+}
+
+func TestMemoryHubDropsSlowSubscribers(t *testing.T) {
+	hub := NewMemoryHub()
+	sub := hub.Subscribe("a")
+	for seq := range subscriberBuffer + 1 {
+		require.NoError(t, hub.Publish(context.Background(), store.LiveEvent{Seq: int64(seq), SessionID: "a"}))
+	}
+
+	received := 0
+	for range sub.C {
+		received++
+	}
+	assert.Equal(t, subscriberBuffer, received)
+}
+
+func TestDeferredHubPublishesOnFlush(t *testing.T) {
+	hub := NewMemoryHub()
+	deferred := NewDeferredHub(hub)
+	sub := deferred.Subscribe("a")
+	defer sub.Close()
+
+	require.NoError(t, deferred.Publish(context.Background(), store.LiveEvent{Seq: 1, SessionID: "a"}))
+	require.NoError(t, deferred.Publish(context.Background(), store.LiveEvent{Seq: 2, SessionID: "a"}))
+	assert.Empty(t, sub.C, "events wait for the transaction to commit")
+
+	require.NoError(t, deferred.Flush(context.Background()))
+	assert.Equal(t, int64(1), (<-sub.C).Seq)
+	assert.Equal(t, int64(2), (<-sub.C).Seq)
+
+	require.NoError(t, deferred.Flush(context.Background()))
+	assert.Empty(t, sub.C, "flushed events are only published once")
+}
@@ -0,0 +1,87 @@
+package live
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/kodega2016/femapi/internal/store"
+)
+
+const (
+	notifyChannel = "live_session_events"
+	// postgres rejects NOTIFY payloads of 8000 bytes and more
+	maxNotifyPayload = 7999
+	reconnectDelay   = 5 * time.Second
+)
+
+var ErrEventTooLarge = errors.New("live event is too large to publish")
+
+// PostgresHub publishes events with NOTIFY, so a device connected to one API instance reaches
+// the subscribers connected to every other one. Each instance keeps a single LISTEN connection
+// and fans the notifications out to its own subscribers.
+type PostgresHub struct {
+	db     *sql.DB
+	local  *MemoryHub
+	logger *log.Logger
+}
+
+func NewPostgresHub(db *sql.DB, logger *log.Logger) *PostgresHub {
+	return &PostgresHub{
+		db:     db,
+		local:  NewMemoryHub(),
+		logger: logger,
+	}
+}
+
+func (h *PostgresHub) Subscribe(sessionID string) *Subscription {
+	return h.local.Subscribe(sessionID)
+}
+
+// Publish sends the event to every instance, this one included through its own LISTEN
+func (h *PostgresHub) Publish(ctx context.Context, event store.LiveEvent) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	if len(payload) > maxNotifyPayload {
+		return ErrEventTooLarge
+	}
+	_, err = h.db.ExecContext(ctx, `SELECT pg_notify($1,$2)`, notifyChannel, string(payload))
+	return err
+}
+
+// Run listens for notifications until ctx is done, reconnecting when the connection is lost
+func (h *PostgresHub) Run(ctx context.Context) {
+	for {
+		err := h.listen(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		h.logger.Printf("ERROR: listening for live session events: %v", err)
+		// notifications sent while disconnected are lost, dropping the subscribers makes
+		// them reconnect and catch up from the store
+		h.local.closeAll()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(reconnectDelay):
+		}
+	}
+}
+
+func (h *PostgresHub) listen(ctx context.Context) error {
+	return store.Listen(ctx, h.db, notifyChannel, func(payload string) {
+		var event store.LiveEvent
+		err := json.Unmarshal([]byte(payload), &event)
+		if err != nil {
+			h.logger.Printf("ERROR: decoding live session event: %v", err)
+			return
+		}
+		h.local.Publish(ctx, event)
+	})
+}
@@ -0,0 +1,105 @@
+// Package metrics converts body measurements between units and smooths them into trends
+package metrics
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/kodega2016/femapi/internal/store"
+)
+
+const (
+	UnitPounds = "lbs"
+	UnitInches = "in"
+
+	UnitSystemMetric   = "metric"
+	UnitSystemImperial = "imperial"
+
+	// DefaultAlpha weighs every new measurement at 10%, which evens out day to day water swings
+	// in bodyweight while still following a real change within a couple of weeks
+	DefaultAlpha = 0.1
+
+	poundsToKilograms   = 0.45359237
+	inchesToCentimeters = 2.54
+)
+
+// ToCanonical converts a value given in unit to the unit the kind is stored in. An empty unit
+// means the value already is in the stored unit.
+func ToCanonical(kind string, value float64, unit string) (float64, error) {
+	canonical := store.MeasurementUnit(kind)
+	if unit == "" || unit == canonical {
+		return value, nil
+	}
+
+	switch {
+	case canonical == store.UnitKilograms && unit == UnitPounds:
+		return value * poundsToKilograms, nil
+	case canonical == store.UnitCentimeters && unit == UnitInches:
+		return value * inchesToCentimeters, nil
+	}
+	return 0, fmt.Errorf("unit of %s must be %s", kind, allowedUnits(canonical))
+}
+
+func allowedUnits(canonical string) string {
+	switch canonical {
+	case store.UnitKilograms:
+		return store.UnitKilograms + " or " + UnitPounds
+	case store.UnitCentimeters:
+		return store.UnitCentimeters + " or " + UnitInches
+	}
+	return canonical
+}
+
+// FromCanonical converts a stored value to the given unit system, returning the value rounded
+// to two decimals and its unit
+func FromCanonical(kind string, value float64, system string) (float64, string) {
+	unit := store.MeasurementUnit(kind)
+	if system == UnitSystemImperial {
+		switch unit {
+		case store.UnitKilograms:
+			value, unit = value/poundsToKilograms, UnitPounds
+		case store.UnitCentimeters:
+			value, unit = value/inchesToCentimeters, UnitInches
+		}
+	}
+	return math.Round(value*100) / 100, unit
+}
+
+// Point is a single measurement in a series
+type Point struct {
+	Time  time.Time
+	Value float64
+}
+
+// TrendPoint is a measurement with the smoothed value at that time
+type TrendPoint struct {
+	MeasuredAt time.Time `json:"measured_at"`
+	Value      float64   `json:"value"`
+	Trend      float64   `json:"trend"`
+}
+
+// EMA smooths the series with an exponential moving average, trend = trend + alpha x (value - trend),
+// seeded with the first measurement. Points are sorted by time first.
+func EMA(points []Point, alpha float64) []TrendPoint {
+	if alpha <= 0 || alpha > 1 {
+		alpha = DefaultAlpha
+	}
+
+	sorted := make([]Point, len(points))
+	copy(sorted, points)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Time.Before(sorted[j].Time) })
+
+	trend := make([]TrendPoint, 0, len(sorted))
+	var current float64
+	for i, point := range sorted {
+		if i == 0 {
+			current = point.Value
+		} else {
+			current += alpha * (point.Value - current)
+		}
+		trend = append(trend, TrendPoint{MeasuredAt: point.Time, Value: point.Value, Trend: math.Round(current*100) / 100})
+	}
+	return trend
+}
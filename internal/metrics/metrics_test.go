@@ -0,0 +1,78 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kodega2016/femapi/internal/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestToCanonical(t *testing.T) {
+	tests := []struct {
+		name    string
+		kind    string
+		value   float64
+		unit    string
+		want    float64
+		wantErr bool
+	}{
+		{"kg", store.MeasurementWeight, 80, "kg", 80, false},
+		{"default unit", store.MeasurementWeight, 80, "", 80, false},
+		{"pounds", store.MeasurementWeight, 200, "lbs", 90.718474, false},
+		{"inches", store.MeasurementWaist, 32, "in", 81.28, false},
+		{"percent", store.MeasurementBodyFat, 18.5, "%", 18.5, false},
+		{"weight in inches", store.MeasurementWeight, 80, "in", 0, true},
+		{"body fat in kg", store.MeasurementBodyFat, 18, "kg", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ToCanonical(tt.kind, tt.value, tt.unit)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.InDelta(t, tt.want, got, 1e-9)
+		})
+	}
+}
+
+func TestFromCanonical(t *testing.T) {
+	value, unit := FromCanonical(store.MeasurementWeight, 90.718474, UnitSystemImperial)
+	assert.Equal(t, 200.0, value)
+	assert.Equal(t, UnitPounds, unit)
+
+	value, unit = FromCanonical(store.MeasurementBodyFat, 18.5, UnitSystemImperial)
+	assert.Equal(t, 18.5, value)
+	assert.Equal(t, store.UnitPercent, unit)
+
+	value, unit = FromCanonical(store.MeasurementChest, 100, UnitSystemMetric)
+	assert.Equal(t, 100.0, value)
+	assert.Equal(t, store.UnitCentimeters, unit)
+}
+
+func TestEMA(t *testing.T) {
+	day := time.Date(2024, 3, 1, 7, 0, 0, 0, time.UTC)
+	points := []Point{
+		{day.AddDate(0, 0, 2), 79},
+		{day, 80},
+		{day.AddDate(0, 0, 1), 82},
+	}
+
+	trend := EMA(points, 0.5)
+	require.Len(t, trend, 3)
+	assert.Equal(t, day, trend[0].MeasuredAt)
+	assert.Equal(t, 80.0, trend[0].Trend)
+	assert.Equal(t, 81.0, trend[1].Trend)
+	assert.Equal(t, 80.0, trend[2].Trend)
+	assert.Equal(t, 79.0, trend[2].Value)
+
+	// invalid smoothing factors fall back to the default
+	trend = EMA(points[1:], 0)
+	assert.Equal(t, 80.2, trend[1].Trend)
+
+	assert.Empty(t, EMA(nil, DefaultAlpha))
+}
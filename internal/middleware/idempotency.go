@@ -0,0 +1,200 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/kodega2016/femapi/internal/store"
+	"github.com/kodega2016/femapi/internal/utils"
+)
+
+const (
+	IdempotencyKeyHeader = "Idempotency-Key"
+	maxIdempotencyKey    = 255
+	// the body is hashed before the handler runs, so it is read into memory
+	maxIdempotentBody = 1 << 20
+	// a key is locked for this long and the lock is extended while the handler runs, a request
+	// whose lock ran out is assumed lost and its key may be claimed again
+	idempotencyLock = time.Minute
+)
+
+// replayedHeaders are the response headers stored with the body, the rest are set again by
+// the middlewares in front of this one
+var replayedHeaders = []string{"Content-Type", "ETag", "Location"}
+
+type IdempotencyMiddleware struct {
+	Store  store.IdempotencyStore
+	Logger *log.Logger
+	TTL    time.Duration
+	// InTransaction is set when Store runs on the transaction of a batch. The uncommitted key
+	// then blocks a retry until the batch ends, and the transaction must not be used from a
+	// second goroutine, so the lock is not extended.
+	InTransaction bool
+
+	// lock overrides idempotencyLock in tests
+	lock time.Duration
+}
+
+func (im *IdempotencyMiddleware) lockDuration() time.Duration {
+	if im.lock > 0 {
+		return im.lock
+	}
+	return idempotencyLock
+}
+
+// keepLock extends the lock of a claimed key until the returned func is called, so a retry
+// can not claim it again while a slow handler still runs
+func (im *IdempotencyMiddleware) keepLock(userID int, key string) func() {
+	lock := im.lockDuration()
+	ticker := time.NewTicker(lock / 3)
+	done := make(chan struct{})
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				err := im.Store.ExtendIdempotencyKey(userID, key, lock)
+				if err != nil {
+					im.Logger.Printf("ERROR: extendIdempotencyKey: %v", err)
+				}
+			}
+		}
+	}()
+	return func() { close(done) }
+}
+
+// responseRecorder passes the response through while keeping a copy of it
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rr *responseRecorder) WriteHeader(status int) {
+	if rr.status == 0 {
+		rr.status = status
+	}
+	rr.ResponseWriter.WriteHeader(status)
+}
+
+func (rr *responseRecorder) Write(b []byte) (int, error) {
+	if rr.status == 0 {
+		rr.status = http.StatusOK
+	}
+	rr.body.Write(b)
+	return rr.ResponseWriter.Write(b)
+}
+
+// requestUser is the id the key is scoped to, 0 for routes open to anonymous users
+func requestUser(r *http.Request) int {
+	user, ok := r.Context().Value(UserContextKey).(*store.User)
+	if !ok || user.IsAnonymous() {
+		return 0
+	}
+	return user.ID
+}
+
+// Idempotent makes retries of a request sent with an Idempotency-Key get the response of the
+// first attempt instead of running the handler again. Requests without the header are
+// handled as usual. Server errors are not stored, retrying them runs the handler again.
+func (im *IdempotencyMiddleware) Idempotent(next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(IdempotencyKeyHeader)
+		if key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKey {
+			utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "Idempotency-Key cannot be longer than 255 characters"})
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxIdempotentBody))
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			utils.WriteJSON(w, http.StatusRequestEntityTooLarge, utils.Envelope{"error": "request body is too large"})
+			return
+		}
+		if err != nil {
+			utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid request sent"})
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		hash := sha256.New()
+		io.WriteString(hash, r.Method+" "+r.URL.Path+"\n")
+		hash.Write(body)
+		fingerprint := hash.Sum(nil)
+
+		userID := requestUser(r)
+		record, claimed, err := im.Store.ClaimIdempotencyKey(userID, key, fingerprint, im.lockDuration(), im.TTL)
+		if err != nil {
+			im.Logger.Printf("ERROR: claimIdempotencyKey: %v", err)
+			utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+			return
+		}
+		if !claimed {
+			im.replay(w, record, fingerprint)
+			return
+		}
+
+		recorder := &responseRecorder{ResponseWriter: w}
+		if im.InTransaction {
+			next.ServeHTTP(recorder, r)
+		} else {
+			stop := im.keepLock(userID, key)
+			next.ServeHTTP(recorder, r)
+			stop()
+		}
+
+		if recorder.status == 0 || recorder.status >= http.StatusInternalServerError {
+			err = im.Store.ReleaseIdempotencyKey(userID, key)
+			if err != nil {
+				im.Logger.Printf("ERROR: releaseIdempotencyKey: %v", err)
+			}
+			return
+		}
+
+		header := make(map[string]string)
+		for _, name := range replayedHeaders {
+			if v := recorder.Header().Get(name); v != "" {
+				header[name] = v
+			}
+		}
+		err = im.Store.CompleteIdempotencyKey(userID, key, &store.IdempotencyRecord{
+			StatusCode: &recorder.status,
+			Header:     header,
+			Body:       recorder.body.Bytes(),
+		})
+		if err != nil {
+			// the response was sent already, retries get a conflict until the lock runs out
+			im.Logger.Printf("ERROR: completeIdempotencyKey: %v", err)
+		}
+	})
+}
+
+func (im *IdempotencyMiddleware) replay(w http.ResponseWriter, record *store.IdempotencyRecord, fingerprint []byte) {
+	if !bytes.Equal(record.Fingerprint, fingerprint) {
+		utils.WriteJSON(w, http.StatusUnprocessableEntity, utils.Envelope{"error": "Idempotency-Key was already used for a different request"})
+		return
+	}
+	if record.StatusCode == nil {
+		w.Header().Set("Retry-After", "1")
+		utils.WriteJSON(w, http.StatusConflict, utils.Envelope{"error": "a request with this Idempotency-Key is still in progress"})
+		return
+	}
+
+	for name, v := range record.Header {
+		w.Header().Set(name, v)
+	}
+	w.Header().Set("Idempotent-Replayed", "true")
+	w.WriteHeader(*record.StatusCode)
+	w.Write(record.Body)
+}
@@ -0,0 +1,225 @@
+package middleware
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/kodega2016/femapi/internal/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeIdempotencyKey struct {
+	record      store.IdempotencyRecord
+	lockedUntil time.Time
+}
+
+// fakeIdempotencyStore keeps the keys in memory with the claim rules of the postgres store
+type fakeIdempotencyStore struct {
+	mu      sync.Mutex
+	keys    map[string]*fakeIdempotencyKey
+	extends int
+}
+
+func newFakeIdempotencyStore() *fakeIdempotencyStore {
+	return &fakeIdempotencyStore{keys: make(map[string]*fakeIdempotencyKey)}
+}
+
+func (fs *fakeIdempotencyStore) ClaimIdempotencyKey(userID int, key string, fingerprint []byte, lock, ttl time.Duration) (*store.IdempotencyRecord, bool, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	existing, ok := fs.keys[key]
+	lost := ok && existing.record.StatusCode == nil && existing.lockedUntil.Before(time.Now()) &&
+		bytes.Equal(existing.record.Fingerprint, fingerprint)
+	if ok && !lost {
+		record := existing.record
+		return &record, false, nil
+	}
+	fs.keys[key] = &fakeIdempotencyKey{
+		record:      store.IdempotencyRecord{Fingerprint: fingerprint},
+		lockedUntil: time.Now().Add(lock),
+	}
+	return nil, true, nil
+}
+
+func (fs *fakeIdempotencyStore) ExtendIdempotencyKey(userID int, key string, lock time.Duration) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if existing, ok := fs.keys[key]; ok && existing.record.StatusCode == nil {
+		existing.lockedUntil = time.Now().Add(lock)
+		fs.extends++
+	}
+	return nil
+}
+
+func (fs *fakeIdempotencyStore) CompleteIdempotencyKey(userID int, key string, record *store.IdempotencyRecord) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	existing := fs.keys[key]
+	existing.record.StatusCode = record.StatusCode
+	existing.record.Header = record.Header
+	existing.record.Body = record.Body
+	return nil
+}
+
+func (fs *fakeIdempotencyStore) ReleaseIdempotencyKey(userID int, key string) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	delete(fs.keys, key)
+	return nil
+}
+
+func (fs *fakeIdempotencyStore) DeleteExpiredIdempotencyKeys() (int64, error)  { return 0, nil }
+func (fs *fakeIdempotencyStore) DeleteOrphanedIdempotencyKeys() (int64, error) { return 0, nil }
+
+func newTestIdempotency(fs *fakeIdempotencyStore) *IdempotencyMiddleware {
+	return &IdempotencyMiddleware{Store: fs, Logger: log.New(io.Discard, "", 0), TTL: time.Hour}
+}
+
+func idempotentRequest(t *testing.T, handler http.HandlerFunc, key, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/workouts", strings.NewReader(body))
+	req.Header.Set(IdempotencyKeyHeader, key)
+	rr := httptest.NewRecorder()
+	handler(rr, req)
+	return rr
+}
+
+func TestIdempotentReplaysTheFirstResponse(t *testing.T) {
+	var calls atomic.Int32
+	handler := newTestIdempotency(newFakeIdempotencyStore()).Idempotent(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.Header().Set("Location", "/workouts/1")
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		io.WriteString(w, `{"id":1}`)
+	})
+
+	first := idempotentRequest(t, handler, "key-1", `{"title":"push day"}`)
+	assert.Equal(t, http.StatusCreated, first.Code)
+	assert.Empty(t, first.Header().Get("Idempotent-Replayed"))
+
+	retry := idempotentRequest(t, handler, "key-1", `{"title":"push day"}`)
+	assert.Equal(t, http.StatusCreated, retry.Code)
+	assert.Equal(t, "true", retry.Header().Get("Idempotent-Replayed"))
+	assert.Equal(t, "/workouts/1", retry.Header().Get("Location"))
+	assert.Equal(t, `{"id":1}`, retry.Body.String())
+	assert.Equal(t, int32(1), calls.Load())
+}
+
+func TestIdempotentRejectsADifferentPayload(t *testing.T) {
+	handler := newTestIdempotency(newFakeIdempotencyStore()).Idempotent(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	})
+
+	idempotentRequest(t, handler, "key-1", `{"title":"push day"}`)
+	rr := idempotentRequest(t, handler, "key-1", `{"title":"pull day"}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, rr.Code)
+}
+
+func TestIdempotentConflictsWhileInFlight(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	handler := newTestIdempotency(newFakeIdempotencyStore()).Idempotent(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		w.WriteHeader(http.StatusCreated)
+	})
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() {
+		done <- idempotentRequest(t, handler, "key-1", `{}`)
+	}()
+	<-started
+
+	rr := idempotentRequest(t, handler, "key-1", `{}`)
+	assert.Equal(t, http.StatusConflict, rr.Code)
+	assert.Equal(t, "1", rr.Header().Get("Retry-After"))
+
+	close(release)
+	assert.Equal(t, http.StatusCreated, (<-done).Code)
+}
+
+func TestIdempotentReleasesTheKeyOnServerErrors(t *testing.T) {
+	var calls atomic.Int32
+	handler := newTestIdempotency(newFakeIdempotencyStore()).Idempotent(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	})
+
+	assert.Equal(t, http.StatusInternalServerError, idempotentRequest(t, handler, "key-1", `{}`).Code)
+	retry := idempotentRequest(t, handler, "key-1", `{}`)
+	assert.Equal(t, http.StatusCreated, retry.Code)
+	assert.Empty(t, retry.Header().Get("Idempotent-Replayed"))
+	assert.Equal(t, int32(2), calls.Load())
+}
+
+func TestIdempotentLimitsTheBody(t *testing.T) {
+	var calls atomic.Int32
+	handler := newTestIdempotency(newFakeIdempotencyStore()).Idempotent(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+	})
+
+	rr := idempotentRequest(t, handler, "key-1", strings.Repeat("a", maxIdempotentBody+1))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rr.Code)
+	assert.Zero(t, calls.Load())
+}
+
+func TestIdempotentKeepsTheLockOfASlowHandler(t *testing.T) {
+	fs := newFakeIdempotencyStore()
+	im := newTestIdempotency(fs)
+	im.lock = 30 * time.Millisecond
+
+	started, release := make(chan struct{}), make(chan struct{})
+	handler := im.Idempotent(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		w.WriteHeader(http.StatusCreated)
+	})
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() {
+		done <- idempotentRequest(t, handler, "key-1", `{}`)
+	}()
+	<-started
+
+	// well past the lock, a retry still finds the request in flight
+	time.Sleep(4 * im.lock)
+	rr := idempotentRequest(t, handler, "key-1", `{}`)
+	require.Equal(t, http.StatusConflict, rr.Code)
+
+	close(release)
+	assert.Equal(t, http.StatusCreated, (<-done).Code)
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	assert.Positive(t, fs.extends)
+}
+
+func TestIdempotentDoesNotExtendTheLockInATransaction(t *testing.T) {
+	fs := newFakeIdempotencyStore()
+	im := newTestIdempotency(fs)
+	im.lock = 30 * time.Millisecond
+	im.InTransaction = true
+
+	handler := im.Idempotent(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(4 * im.lock)
+		w.WriteHeader(http.StatusCreated)
+	})
+
+	rr := idempotentRequest(t, handler, "key-1", `{}`)
+	assert.Equal(t, http.StatusCreated, rr.Code)
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	assert.Zero(t, fs.extends)
+}
@@ -84,3 +84,17 @@ func (um *UserMiddleware) RequireUser(next http.HandlerFunc) http.HandlerFunc {
 		next.ServeHTTP(w, r)
 	})
 }
+
+// RequireAdmin lets through the users flagged as admins in the database, there is no api to
+// grant it
+func (um *UserMiddleware) RequireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return um.RequireUser(func(w http.ResponseWriter, r *http.Request) {
+		if !GetUser(r).IsAdmin {
+			utils.WriteJSON(w, http.StatusForbidden, utils.Envelope{
+				"error": "you must be an admin to access this route",
+			})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
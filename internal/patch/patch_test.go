@@ -0,0 +1,130 @@
+package patch
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMergePatch(t *testing.T) {
+	tests := []struct {
+		name  string
+		doc   string
+		patch string
+		want  string
+	}{
+		{
+			name:  "replace a field",
+			doc:   `{"title":"push day","duration":60}`,
+			patch: `{"title":"pull day"}`,
+			want:  `{"title":"pull day","duration":60}`,
+		},
+		{
+			name:  "null removes a field",
+			doc:   `{"title":"push day","description":"chest"}`,
+			patch: `{"description":null}`,
+			want:  `{"title":"push day"}`,
+		},
+		{
+			name:  "nested objects are merged",
+			doc:   `{"a":{"b":1,"c":2}}`,
+			patch: `{"a":{"c":3,"d":4}}`,
+			want:  `{"a":{"b":1,"c":3,"d":4}}`,
+		},
+		{
+			name:  "arrays are replaced",
+			doc:   `{"entries":[1,2,3]}`,
+			patch: `{"entries":[4]}`,
+			want:  `{"entries":[4]}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := MergePatch([]byte(tt.doc), []byte(tt.patch))
+			require.NoError(t, err)
+			assert.JSONEq(t, tt.want, string(got))
+		})
+	}
+}
+
+func TestJSONPatch(t *testing.T) {
+	doc := `{"title":"push day","entries":[{"exercise_name":"bench"},{"exercise_name":"dips"}]}`
+
+	tests := []struct {
+		name      string
+		patch     string
+		want      string
+		wantError error
+	}{
+		{
+			name:  "replace",
+			patch: `[{"op":"replace","path":"/title","value":"pull day"}]`,
+			want:  `{"title":"pull day","entries":[{"exercise_name":"bench"},{"exercise_name":"dips"}]}`,
+		},
+		{
+			name:  "append to array",
+			patch: `[{"op":"add","path":"/entries/-","value":{"exercise_name":"flyes"}}]`,
+			want:  `{"title":"push day","entries":[{"exercise_name":"bench"},{"exercise_name":"dips"},{"exercise_name":"flyes"}]}`,
+		},
+		{
+			name:  "insert into array",
+			patch: `[{"op":"add","path":"/entries/0","value":{"exercise_name":"warmup"}}]`,
+			want:  `{"title":"push day","entries":[{"exercise_name":"warmup"},{"exercise_name":"bench"},{"exercise_name":"dips"}]}`,
+		},
+		{
+			name:  "remove from array",
+			patch: `[{"op":"remove","path":"/entries/0"}]`,
+			want:  `{"title":"push day","entries":[{"exercise_name":"dips"}]}`,
+		},
+		{
+			name:  "move reorders entries",
+			patch: `[{"op":"move","from":"/entries/1","path":"/entries/0"}]`,
+			want:  `{"title":"push day","entries":[{"exercise_name":"dips"},{"exercise_name":"bench"}]}`,
+		},
+		{
+			name:  "copy",
+			patch: `[{"op":"copy","from":"/title","path":"/description"}]`,
+			want:  `{"title":"push day","description":"push day","entries":[{"exercise_name":"bench"},{"exercise_name":"dips"}]}`,
+		},
+		{
+			name:  "test then replace",
+			patch: `[{"op":"test","path":"/entries/1/exercise_name","value":"dips"},{"op":"replace","path":"/entries/1/exercise_name","value":"weighted dips"}]`,
+			want:  `{"title":"push day","entries":[{"exercise_name":"bench"},{"exercise_name":"weighted dips"}]}`,
+		},
+		{
+			name:      "failed test aborts the patch",
+			patch:     `[{"op":"test","path":"/title","value":"leg day"}]`,
+			wantError: ErrTestFailed,
+		},
+		{
+			name:      "replace of a missing path",
+			patch:     `[{"op":"replace","path":"/missing","value":1}]`,
+			wantError: ErrInvalidPatch,
+		},
+		{
+			name:      "unknown op",
+			patch:     `[{"op":"frobnicate","path":"/title"}]`,
+			wantError: ErrInvalidPatch,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := JSONPatch([]byte(doc), []byte(tt.patch))
+			if tt.wantError != nil {
+				assert.ErrorIs(t, err, tt.wantError)
+				return
+			}
+			require.NoError(t, err)
+			assert.JSONEq(t, tt.want, string(got))
+		})
+	}
+}
+
+func TestParsePointerUnescapes(t *testing.T) {
+	tokens, err := parsePointer("/a~1b/c~0d")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a/b", "c~d"}, tokens)
+}
@@ -0,0 +1,50 @@
+// Package publicid parses the opaque identifiers exposed in urls and payloads
+package publicid
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// AllowNumeric keeps the legacy BIGSERIAL ids resolvable while clients move
+// over to public ids. It will be switched off in the next release.
+var AllowNumeric = true
+
+var ErrInvalid = errors.New("invalid id")
+
+var uuidRegex = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`)
+
+// Ref is a reference to a row, either by its public id or by its legacy numeric id
+type Ref struct {
+	PublicID string
+	LegacyID int64
+}
+
+func (r Ref) IsLegacy() bool {
+	return r.PublicID == ""
+}
+
+func (r Ref) String() string {
+	if r.IsLegacy() {
+		return strconv.FormatInt(r.LegacyID, 10)
+	}
+	return r.PublicID
+}
+
+// Parse accepts a UUID and, while AllowNumeric is set, a positive numeric id
+func Parse(s string) (Ref, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if uuidRegex.MatchString(s) {
+		return Ref{PublicID: s}, nil
+	}
+
+	if AllowNumeric {
+		id, err := strconv.ParseInt(s, 10, 64)
+		if err == nil && id > 0 {
+			return Ref{LegacyID: id}, nil
+		}
+	}
+	return Ref{}, ErrInvalid
+}
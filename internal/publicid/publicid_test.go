@@ -0,0 +1,71 @@
+package publicid
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name         string
+		input        string
+		allowNumeric bool
+		want         Ref
+		wantError    bool
+	}{
+		{
+			name:         "uuid",
+			input:        "0190a1b2-c3d4-7e5f-8a6b-7c8d9e0f1a2b",
+			allowNumeric: true,
+			want:         Ref{PublicID: "0190a1b2-c3d4-7e5f-8a6b-7c8d9e0f1a2b"},
+		},
+		{
+			name:         "uppercase uuid is normalized",
+			input:        "0190A1B2-C3D4-7E5F-8A6B-7C8D9E0F1A2B",
+			allowNumeric: false,
+			want:         Ref{PublicID: "0190a1b2-c3d4-7e5f-8a6b-7c8d9e0f1a2b"},
+		},
+		{
+			name:         "numeric id in compatibility mode",
+			input:        "42",
+			allowNumeric: true,
+			want:         Ref{LegacyID: 42},
+		},
+		{
+			name:         "numeric id without compatibility mode",
+			input:        "42",
+			allowNumeric: false,
+			wantError:    true,
+		},
+		{
+			name:         "negative numeric id",
+			input:        "-1",
+			allowNumeric: true,
+			wantError:    true,
+		},
+		{
+			name:         "garbage",
+			input:        "not-an-id",
+			allowNumeric: true,
+			wantError:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			AllowNumeric = tt.allowNumeric
+			defer func() { AllowNumeric = true }()
+
+			ref, err := Parse(tt.input)
+			if tt.wantError {
+				assert.ErrorIs(t, err, ErrInvalid)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, ref)
+		})
+	}
+}
@@ -2,24 +2,142 @@
 package routes
 
 import (
+	"context"
+	"database/sql"
+	"net/http"
+
 	"github.com/go-chi/chi/v5"
+	"github.com/kodega2016/femapi/internal/api"
 	"github.com/kodega2016/femapi/internal/app"
 )
 
 func SetupRoutes(app *app.Application) *chi.Mux {
 	r := chi.NewRouter()
+	mountRoutes(r, app)
 
+	// transactional batches run on the same routes with the stores and handlers on their
+	// transaction, the rest of the application is shared
+	batchHandler := api.NewBatchHandler(r, app.DB, func(tx *sql.Tx) (http.Handler, func(ctx context.Context) error) {
+		txApp, afterCommit := app.WithTx(tx)
+		txRouter := chi.NewRouter()
+		mountRoutes(txRouter, txApp)
+		return txRouter, afterCommit
+	}, app.Logger)
+	r.With(app.Middleware.Authenticate).Post("/batch", app.Middleware.RequireUser(batchHandler.HandleBatch))
+	return r
+}
+
+// mountRoutes registers every route but /batch, batches cannot be nested
+func mountRoutes(r chi.Router, app *app.Application) {
 	r.Group(func(r chi.Router) {
 		r.Use(app.Middleware.Authenticate)
 
+		r.Get("/workouts/trash", app.Middleware.RequireUser(app.WorkoutHandler.HandleListTrash))
 		r.Get("/workouts/{id}", app.Middleware.RequireUser(app.WorkoutHandler.HandleGetWorkoutByID))
-		r.Post("/workouts", app.Middleware.RequireUser(app.WorkoutHandler.HandleCreateWorkout))
+		r.Post("/workouts", app.Middleware.RequireUser(app.Idempotency.Idempotent(app.WorkoutHandler.HandleCreateWorkout)))
+		r.Post("/workouts/import", app.Middleware.RequireUser(app.TransferHandler.HandleImportWorkouts))
+		r.Post("/workouts/import/{source}", app.Middleware.RequireUser(app.TransferHandler.HandleImportFromApp))
+		r.Post("/workouts/activities", app.Middleware.RequireUser(app.TransferHandler.HandleImportActivity))
 		r.Put("/workouts/{id}", app.Middleware.RequireUser(app.WorkoutHandler.HandleUpdateWorkoutByID))
+		r.Patch("/workouts/{id}", app.Middleware.RequireUser(app.WorkoutHandler.HandlePatchWorkout))
 		r.Delete("/workouts/{id}", app.Middleware.RequireUser(app.WorkoutHandler.HandleDeleteWorkout))
+		r.Post("/workouts/{id}/restore", app.Middleware.RequireUser(app.WorkoutHandler.HandleRestoreWorkout))
+		r.Get("/workouts/{id}/track", app.Middleware.RequireUser(app.WorkoutHandler.HandleGetWorkoutTrack))
+
+		r.Post("/workouts/{id}/entries", app.Middleware.RequireUser(app.Idempotency.Idempotent(app.WorkoutHandler.HandleCreateEntry)))
+		r.Put("/workouts/{id}/entries/order", app.Middleware.RequireUser(app.WorkoutHandler.HandleReorderEntries))
+		r.Patch("/workouts/{id}/entries/{entryID}", app.Middleware.RequireUser(app.WorkoutHandler.HandleUpdateEntry))
+		r.Delete("/workouts/{id}/entries/{entryID}", app.Middleware.RequireUser(app.WorkoutHandler.HandleDeleteEntry))
+
+		r.Get("/workouts/{id}/revisions", app.Middleware.RequireUser(app.WorkoutHandler.HandleListRevisions))
+		r.Get("/workouts/{id}/revisions/diff", app.Middleware.RequireUser(app.WorkoutHandler.HandleDiffRevisions))
+		r.Get("/workouts/{id}/revisions/{rev}", app.Middleware.RequireUser(app.WorkoutHandler.HandleGetRevision))
+		r.Post("/workouts/{id}/revisions/{rev}/revert", app.Middleware.RequireUser(app.WorkoutHandler.HandleRevertRevision))
+
+		r.Get("/workouts/{id}/shares", app.Middleware.RequireUser(app.ShareHandler.HandleListShareLinks))
+		r.Post("/workouts/{id}/shares", app.Middleware.RequireUser(app.ShareHandler.HandleCreateShareLink))
+		r.Delete("/workouts/{id}/shares/{slug}", app.Middleware.RequireUser(app.ShareHandler.HandleRevokeShareLink))
+
+		r.Get("/users/me/export", app.Middleware.RequireUser(app.TransferHandler.HandleExportWorkouts))
+		r.Get("/users/me/stats/weekly", app.Middleware.RequireUser(app.WorkoutHandler.HandleWeeklyStats))
+		r.Get("/users/me/stats/strength", app.Middleware.RequireUser(app.WorkoutHandler.HandleStrengthStats))
+
+		r.Get("/users/me/measurements", app.Middleware.RequireUser(app.BodyMeasurementHandler.HandleListMeasurements))
+		r.Post("/users/me/measurements", app.Middleware.RequireUser(app.Idempotency.Idempotent(app.BodyMeasurementHandler.HandleCreateMeasurement)))
+		r.Get("/users/me/measurements/latest", app.Middleware.RequireUser(app.BodyMeasurementHandler.HandleLatestMeasurements))
+		r.Get("/users/me/measurements/trend", app.Middleware.RequireUser(app.BodyMeasurementHandler.HandleMeasurementTrend))
+		r.Get("/users/me/measurements/{measurementID}", app.Middleware.RequireUser(app.BodyMeasurementHandler.HandleGetMeasurement))
+		r.Patch("/users/me/measurements/{measurementID}", app.Middleware.RequireUser(app.BodyMeasurementHandler.HandleUpdateMeasurement))
+		r.Delete("/users/me/measurements/{measurementID}", app.Middleware.RequireUser(app.BodyMeasurementHandler.HandleDeleteMeasurement))
+
+		// event streams authenticate with ?access_token= too, EventSource cannot send headers
+		r.Get("/users/me/events", app.UserEventHandler.HandleUserEvents)
+		r.Post("/users/me/sync", app.Middleware.RequireUser(app.SyncHandler.HandleSync))
+
+		r.Get("/users/me/settings", app.Middleware.RequireUser(app.SettingsHandler.HandleGetSettings))
+		r.Patch("/users/me/settings", app.Middleware.RequireUser(app.SettingsHandler.HandleUpdateSettings))
+
+		r.Get("/users/me/calendar", app.Middleware.RequireUser(app.CalendarHandler.HandleGetCalendar))
+		r.Post("/users/me/calendar/feed", app.Middleware.RequireUser(app.CalendarHandler.HandleCreateCalendarFeed))
+		r.Delete("/users/me/calendar/feed", app.Middleware.RequireUser(app.CalendarHandler.HandleDeleteCalendarFeed))
+		r.Get("/users/me/planned-workouts", app.Middleware.RequireUser(app.CalendarHandler.HandleListPlannedWorkouts))
+		r.Post("/users/me/planned-workouts", app.Middleware.RequireUser(app.Idempotency.Idempotent(app.CalendarHandler.HandleCreatePlannedWorkout)))
+		r.Patch("/users/me/planned-workouts/{plannedID}", app.Middleware.RequireUser(app.CalendarHandler.HandleUpdatePlannedWorkout))
+		r.Delete("/users/me/planned-workouts/{plannedID}", app.Middleware.RequireUser(app.CalendarHandler.HandleDeletePlannedWorkout))
+
+		r.Get("/users/me/achievements", app.Middleware.RequireUser(app.AchievementHandler.HandleListAchievements))
+
+		r.Get("/users/me/goals", app.Middleware.RequireUser(app.GoalHandler.HandleListGoals))
+		r.Post("/users/me/goals", app.Middleware.RequireUser(app.Idempotency.Idempotent(app.GoalHandler.HandleCreateGoal)))
+		r.Get("/users/me/goals/{goalID}", app.Middleware.RequireUser(app.GoalHandler.HandleGetGoal))
+		r.Patch("/users/me/goals/{goalID}", app.Middleware.RequireUser(app.GoalHandler.HandleUpdateGoal))
+		r.Delete("/users/me/goals/{goalID}", app.Middleware.RequireUser(app.GoalHandler.HandleDeleteGoal))
+
+		r.Get("/challenges", app.Middleware.RequireUser(app.ChallengeHandler.HandleListChallenges))
+		r.Post("/challenges", app.Middleware.RequireUser(app.Idempotency.Idempotent(app.ChallengeHandler.HandleCreateChallenge)))
+		r.Get("/challenges/{challengeID}", app.Middleware.RequireUser(app.ChallengeHandler.HandleGetChallenge))
+		r.Delete("/challenges/{challengeID}", app.Middleware.RequireUser(app.ChallengeHandler.HandleDeleteChallenge))
+		r.Post("/challenges/{challengeID}/participants", app.Middleware.RequireUser(app.ChallengeHandler.HandleJoinChallenge))
+		r.Patch("/challenges/{challengeID}/participants/me", app.Middleware.RequireUser(app.ChallengeHandler.HandleUpdateParticipation))
+		r.Delete("/challenges/{challengeID}/participants/me", app.Middleware.RequireUser(app.ChallengeHandler.HandleLeaveChallenge))
+		r.Get("/challenges/{challengeID}/leaderboard", app.Middleware.RequireUser(app.ChallengeHandler.HandleGetLeaderboard))
+		r.Get("/challenges/{challengeID}/leaderboard/teams", app.Middleware.RequireUser(app.ChallengeHandler.HandleGetTeamLeaderboard))
+
+		r.Get("/live-sessions", app.Middleware.RequireUser(app.LiveSessionHandler.HandleListLiveSessions))
+		r.Post("/live-sessions", app.Middleware.RequireUser(app.LiveSessionHandler.HandleStartLiveSession))
+		r.Get("/live-sessions/{sessionID}", app.Middleware.RequireUser(app.LiveSessionHandler.HandleGetLiveSession))
+		r.Delete("/live-sessions/{sessionID}", app.Middleware.RequireUser(app.LiveSessionHandler.HandleAbandonLiveSession))
+		r.Post("/live-sessions/{sessionID}/events", app.Middleware.RequireUser(app.LiveSessionHandler.HandleRecordLiveEvent))
+		r.Post("/live-sessions/{sessionID}/finish", app.Middleware.RequireUser(app.LiveSessionHandler.HandleFinishLiveSession))
+		r.Put("/live-sessions/{sessionID}/viewers/{id}", app.Middleware.RequireUser(app.LiveSessionHandler.HandleAddLiveSessionViewer))
+		r.Delete("/live-sessions/{sessionID}/viewers/{id}", app.Middleware.RequireUser(app.LiveSessionHandler.HandleRemoveLiveSessionViewer))
+		// authenticates itself, browsers cannot send the Authorization header on the handshake
+		r.Get("/live-sessions/{sessionID}/ws", app.LiveSessionHandler.HandleLiveSessionSocket)
+
+		r.Get("/webhooks", app.Middleware.RequireUser(app.WebhookHandler.HandleListWebhooks))
+		r.Post("/webhooks", app.Middleware.RequireUser(app.Idempotency.Idempotent(app.WebhookHandler.HandleCreateWebhook)))
+		r.Get("/webhooks/{webhookID}", app.Middleware.RequireUser(app.WebhookHandler.HandleGetWebhook))
+		r.Patch("/webhooks/{webhookID}", app.Middleware.RequireUser(app.WebhookHandler.HandleUpdateWebhook))
+		r.Delete("/webhooks/{webhookID}", app.Middleware.RequireUser(app.WebhookHandler.HandleDeleteWebhook))
+		r.Get("/webhooks/{webhookID}/deliveries", app.Middleware.RequireUser(app.WebhookHandler.HandleListDeliveries))
+		r.Get("/webhooks/{webhookID}/deliveries/{deliveryID}", app.Middleware.RequireUser(app.WebhookHandler.HandleGetDelivery))
+		r.Post("/webhooks/{webhookID}/deliveries/{deliveryID}/retry", app.Middleware.RequireUser(app.WebhookHandler.HandleRetryDelivery))
+
+		r.Get("/admin/jobs", app.Middleware.RequireAdmin(app.JobHandler.HandleListJobs))
+		r.Get("/admin/jobs/{jobID}", app.Middleware.RequireAdmin(app.JobHandler.HandleGetJob))
+		r.Post("/admin/jobs/{jobID}/retry", app.Middleware.RequireAdmin(app.JobHandler.HandleRetryJob))
+		r.Post("/admin/achievements/backfill", app.Middleware.RequireAdmin(app.JobHandler.HandleBackfillAchievements))
+
+		r.Post("/users/{id}/follow", app.Middleware.RequireUser(app.UserHandler.HandleFollowUser))
+		r.Delete("/users/{id}/follow", app.Middleware.RequireUser(app.UserHandler.HandleUnfollowUser))
+		r.Get("/users/me/followers", app.Middleware.RequireUser(app.UserHandler.HandleListFollowers))
+		r.Post("/users/me/followers/{id}/approve", app.Middleware.RequireUser(app.UserHandler.HandleApproveFollower))
+		r.Delete("/users/me/followers/{id}", app.Middleware.RequireUser(app.UserHandler.HandleRemoveFollower))
 	})
 
 	r.Get("/health", app.HealthCheck)
-	r.Post("/users", app.UserHandler.HandleRegisterUser)
+	r.Post("/users", app.Idempotency.Idempotent(app.UserHandler.HandleRegisterUser))
 	r.Post("/tokens/authentication", app.TokenHandler.HandleCreateToken)
-	return r
+	r.Get("/shared/{slug}", app.ShareHandler.HandleGetSharedWorkout)
+	r.Get("/calendar/{token}.ics", app.CalendarHandler.HandleGetCalendarFeed)
 }
@@ -0,0 +1,288 @@
+// Package scheduler runs periodic maintenance tasks on cron schedules. Every instance runs a
+// scheduler but only the one holding a postgres advisory lock runs the tasks, another one takes
+// over when its connection goes away.
+package scheduler
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/kodega2016/femapi/internal/store"
+)
+
+const (
+	// leaderLockID is the advisory lock the instances compete for, any number unique to the
+	// scheduler within the database
+	leaderLockID int64 = 0x6665_6d61_7069
+	// how often followers try to become the leader and the leader checks it still is
+	electionInterval = 15 * time.Second
+	taskTimeout      = 10 * time.Minute
+)
+
+// errLostLeadership cancels the tasks of an instance whose lock connection went away, another
+// instance may be running them already
+var errLostLeadership = errors.New("lost the leader lock")
+
+// Task does the work of a scheduled task, it logs what it did itself
+type Task func(ctx context.Context) error
+
+type task struct {
+	name     string
+	spec     string
+	schedule *Schedule
+	run      Task
+	next     time.Time
+}
+
+// TaskStatus is a scheduled task with its last run, the Last fields are nil until it ran
+type TaskStatus struct {
+	Name           string     `json:"name"`
+	Schedule       string     `json:"schedule"`
+	LastStartedAt  *time.Time `json:"last_started_at"`
+	LastFinishedAt *time.Time `json:"last_finished_at"`
+	LastError      *string    `json:"last_error"`
+	LastRanOn      *string    `json:"last_ran_on"`
+	NextRunAt      time.Time  `json:"next_run_at"`
+}
+
+type Scheduler struct {
+	db       *sql.DB
+	store    store.ScheduledTaskStore
+	logger   *log.Logger
+	instance string
+	tasks    []*task
+	leader   atomic.Bool
+}
+
+func New(db *sql.DB, taskStore store.ScheduledTaskStore, logger *log.Logger) *Scheduler {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return &Scheduler{
+		db:       db,
+		store:    taskStore,
+		logger:   logger,
+		instance: fmt.Sprintf("%s:%d", host, os.Getpid()),
+	}
+}
+
+// Add registers a task to run on the cron expression spec, in UTC. It must be called before Run.
+func (s *Scheduler) Add(name, spec string, run Task) error {
+	schedule, err := Parse(spec)
+	if err != nil {
+		return err
+	}
+	if schedule.Next(time.Now().UTC()).IsZero() {
+		return fmt.Errorf("%w: %q never runs", ErrInvalidSchedule, spec)
+	}
+	s.tasks = append(s.tasks, &task{name: name, spec: spec, schedule: schedule, run: run})
+	return nil
+}
+
+// IsLeader reports whether this instance runs the tasks right now
+func (s *Scheduler) IsLeader() bool {
+	return s.leader.Load()
+}
+
+// Run competes for leadership until ctx is done and runs the tasks while it leads
+func (s *Scheduler) Run(ctx context.Context) {
+	if len(s.tasks) == 0 {
+		return
+	}
+	for {
+		err := s.lead(ctx)
+		if err != nil && ctx.Err() == nil {
+			s.logger.Printf("ERROR: scheduler: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(electionInterval):
+		}
+	}
+}
+
+// lead takes the advisory lock and runs the tasks until ctx is done or the lock is lost. The
+// lock belongs to the session, it holds one connection for as long as it leads.
+func (s *Scheduler) lead(ctx context.Context) error {
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	var acquired bool
+	err = conn.QueryRowContext(ctx, `SELECT pg_try_advisory_lock($1)`, leaderLockID).Scan(&acquired)
+	if err != nil || !acquired {
+		return err
+	}
+	// the connection goes back to the pool, it must not keep the lock
+	defer conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, leaderLockID)
+
+	s.leader.Store(true)
+	defer s.leader.Store(false)
+	s.logger.Printf("scheduler: %s is the leader", s.instance)
+	s.plan(time.Now().UTC())
+
+	check := time.NewTicker(electionInterval)
+	defer check.Stop()
+	for {
+		timer := time.NewTimer(time.Until(s.nextRun()))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil
+		case <-check.C:
+			timer.Stop()
+			err := conn.PingContext(ctx)
+			if err != nil {
+				return fmt.Errorf("%w: %v", errLostLeadership, err)
+			}
+		case <-timer.C:
+			err := s.runLeading(ctx, conn)
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// runLeading runs the due tasks while it keeps checking the lock connection, a task still
+// running when the connection goes away is cancelled
+func (s *Scheduler) runLeading(ctx context.Context, conn *sql.Conn) error {
+	runCtx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	stillLeading := func() error {
+		err := conn.PingContext(runCtx)
+		if err != nil && ctx.Err() == nil {
+			err = fmt.Errorf("%w: %v", errLostLeadership, err)
+			cancel(err)
+			return err
+		}
+		return nil
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		check := time.NewTicker(electionInterval)
+		defer check.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-check.C:
+				if stillLeading() != nil {
+					return
+				}
+			}
+		}
+	}()
+
+	return s.runDue(runCtx, time.Now().UTC(), stillLeading)
+}
+
+// plan picks up where the previous leader left off: a run it missed happens right away,
+// tasks that never ran wait for their schedule
+func (s *Scheduler) plan(now time.Time) {
+	previous := make(map[string]store.ScheduledTaskRun)
+	runs, err := s.store.ListScheduledTaskRuns()
+	if err != nil {
+		s.logger.Printf("ERROR: listScheduledTaskRuns: %v", err)
+	}
+	for _, run := range runs {
+		previous[run.Name] = run
+	}
+
+	for _, t := range s.tasks {
+		t.next = t.schedule.Next(now)
+		if run, ok := previous[t.name]; ok && run.Schedule == t.spec && run.NextRunAt.Before(t.next) {
+			t.next = run.NextRunAt
+		}
+	}
+}
+
+func (s *Scheduler) nextRun() time.Time {
+	next := s.tasks[0].next
+	for _, t := range s.tasks[1:] {
+		if t.next.Before(next) {
+			next = t.next
+		}
+	}
+	return next
+}
+
+// runDue runs the tasks that are due one after the other. Runs missed while another task ran
+// are not made up, each task only runs once. Leadership is checked before every task, once it
+// is lost nothing else runs or is saved and the error is returned.
+func (s *Scheduler) runDue(ctx context.Context, now time.Time, stillLeading func() error) error {
+	for _, t := range s.tasks {
+		if t.next.After(now) || ctx.Err() != nil {
+			continue
+		}
+		err := stillLeading()
+		if err != nil {
+			return err
+		}
+
+		run := &store.ScheduledTaskRun{Name: t.name, Schedule: t.spec, StartedAt: time.Now().UTC(), RanOn: s.instance}
+		taskCtx, cancel := context.WithTimeout(ctx, taskTimeout)
+		err = t.run(taskCtx)
+		cancel()
+		if cause := context.Cause(ctx); errors.Is(cause, errLostLeadership) {
+			return cause
+		}
+		run.FinishedAt = time.Now().UTC()
+		if err != nil {
+			s.logger.Printf("ERROR: scheduled task %s: %v", t.name, err)
+			message := err.Error()
+			run.Error = &message
+		}
+
+		t.next = t.schedule.Next(run.FinishedAt)
+		run.NextRunAt = t.next
+		err = s.store.SaveScheduledTaskRun(run)
+		if err != nil {
+			s.logger.Printf("ERROR: saveScheduledTaskRun: %v", err)
+		}
+	}
+	return nil
+}
+
+// Status returns every task with its last run, whichever instance ran it
+func (s *Scheduler) Status() ([]TaskStatus, error) {
+	runs, err := s.store.ListScheduledTaskRuns()
+	if err != nil {
+		return nil, err
+	}
+	previous := make(map[string]store.ScheduledTaskRun)
+	for _, run := range runs {
+		previous[run.Name] = run
+	}
+
+	now := time.Now().UTC()
+	statuses := make([]TaskStatus, 0, len(s.tasks))
+	for _, t := range s.tasks {
+		status := TaskStatus{Name: t.name, Schedule: t.spec, NextRunAt: t.schedule.Next(now)}
+		if run, ok := previous[t.name]; ok {
+			status.LastStartedAt = &run.StartedAt
+			status.LastFinishedAt = &run.FinishedAt
+			status.LastError = run.Error
+			status.LastRanOn = &run.RanOn
+			if run.Schedule == t.spec {
+				status.NextRunAt = run.NextRunAt
+			}
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
@@ -0,0 +1,292 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kodega2016/femapi/internal/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeTaskStore keeps the last run of every task in memory
+type fakeTaskStore struct {
+	mu   sync.Mutex
+	runs map[string]store.ScheduledTaskRun
+}
+
+func newFakeTaskStore(runs ...store.ScheduledTaskRun) *fakeTaskStore {
+	fs := &fakeTaskStore{runs: make(map[string]store.ScheduledTaskRun)}
+	for _, run := range runs {
+		fs.runs[run.Name] = run
+	}
+	return fs
+}
+
+func (fs *fakeTaskStore) SaveScheduledTaskRun(run *store.ScheduledTaskRun) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.runs[run.Name] = *run
+	return nil
+}
+
+func (fs *fakeTaskStore) ListScheduledTaskRuns() ([]store.ScheduledTaskRun, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	runs := make([]store.ScheduledTaskRun, 0, len(fs.runs))
+	for _, run := range fs.runs {
+		runs = append(runs, run)
+	}
+	return runs, nil
+}
+
+func newTestScheduler(fs *fakeTaskStore) *Scheduler {
+	s := New(nil, fs, log.New(io.Discard, "", 0))
+	s.instance = "test:1"
+	return s
+}
+
+func noop(ctx context.Context) error {
+	return nil
+}
+
+func leading() error {
+	return nil
+}
+
+func taskNamed(s *Scheduler, name string) *task {
+	for _, t := range s.tasks {
+		if t.name == name {
+			return t
+		}
+	}
+	return nil
+}
+
+func TestPlanCatchesUpOnMissedRuns(t *testing.T) {
+	now := time.Date(2024, 5, 6, 10, 7, 30, 0, time.UTC)
+	fs := newFakeTaskStore(
+		// the previous leader went away before this was due
+		store.ScheduledTaskRun{Name: "missed", Schedule: "*/15 * * * *", NextRunAt: now.Add(-20 * time.Minute)},
+		// its run is still ahead, nothing to catch up on
+		store.ScheduledTaskRun{Name: "on-time", Schedule: "30 3 * * *", NextRunAt: time.Date(2024, 5, 7, 3, 30, 0, 0, time.UTC)},
+		// the schedule changed since the last run, the old next run no longer counts
+		store.ScheduledTaskRun{Name: "rescheduled", Schedule: "0 * * * *", NextRunAt: now.Add(-time.Hour)},
+	)
+	s := newTestScheduler(fs)
+	for _, name := range []string{"missed", "on-time", "rescheduled"} {
+		spec := fs.runs[name].Schedule
+		if name == "rescheduled" {
+			spec = "@daily"
+		}
+		require.NoError(t, s.Add(name, spec, noop))
+	}
+	require.NoError(t, s.Add("never-ran", "@hourly", noop))
+
+	s.plan(now)
+
+	assert.Equal(t, now.Add(-20*time.Minute), taskNamed(s, "missed").next)
+	assert.Equal(t, time.Date(2024, 5, 7, 3, 30, 0, 0, time.UTC), taskNamed(s, "on-time").next)
+	assert.Equal(t, time.Date(2024, 5, 7, 0, 0, 0, 0, time.UTC), taskNamed(s, "rescheduled").next)
+	assert.Equal(t, time.Date(2024, 5, 6, 11, 0, 0, 0, time.UTC), taskNamed(s, "never-ran").next)
+	assert.Equal(t, now.Add(-20*time.Minute), s.nextRun())
+}
+
+func TestRunDueRunsTheDueTasksAndSavesTheirNextRun(t *testing.T) {
+	fs := newFakeTaskStore()
+	s := newTestScheduler(fs)
+	var ran []string
+	require.NoError(t, s.Add("ok", "*/15 * * * *", func(ctx context.Context) error {
+		ran = append(ran, "ok")
+		return nil
+	}))
+	require.NoError(t, s.Add("failing", "@hourly", func(ctx context.Context) error {
+		ran = append(ran, "failing")
+		return errors.New("database went away")
+	}))
+	require.NoError(t, s.Add("later", "@daily", func(ctx context.Context) error {
+		ran = append(ran, "later")
+		return nil
+	}))
+
+	now := time.Now().UTC()
+	taskNamed(s, "ok").next = now.Add(-time.Minute)
+	taskNamed(s, "failing").next = now
+	taskNamed(s, "later").next = now.Add(time.Hour)
+
+	require.NoError(t, s.runDue(context.Background(), now, leading))
+
+	assert.Equal(t, []string{"ok", "failing"}, ran)
+	require.Len(t, fs.runs, 2)
+
+	ok := fs.runs["ok"]
+	assert.Equal(t, "*/15 * * * *", ok.Schedule)
+	assert.Equal(t, "test:1", ok.RanOn)
+	assert.Nil(t, ok.Error)
+	assert.False(t, ok.FinishedAt.Before(ok.StartedAt))
+	assert.Equal(t, taskNamed(s, "ok").schedule.Next(ok.FinishedAt), ok.NextRunAt)
+	assert.Equal(t, ok.NextRunAt, taskNamed(s, "ok").next)
+
+	failing := fs.runs["failing"]
+	require.NotNil(t, failing.Error)
+	assert.Equal(t, "database went away", *failing.Error)
+	// a failed run waits for its schedule like any other
+	assert.Equal(t, taskNamed(s, "failing").schedule.Next(failing.FinishedAt), failing.NextRunAt)
+	assert.True(t, taskNamed(s, "failing").next.After(now))
+
+	assert.Equal(t, now.Add(time.Hour), taskNamed(s, "later").next)
+}
+
+func TestRunDueStopsWhenTheContextIsDone(t *testing.T) {
+	fs := newFakeTaskStore()
+	s := newTestScheduler(fs)
+	ctx, cancel := context.WithCancel(context.Background())
+	var ran []string
+	require.NoError(t, s.Add("first", "@hourly", func(ctx context.Context) error {
+		ran = append(ran, "first")
+		cancel()
+		return nil
+	}))
+	require.NoError(t, s.Add("second", "@hourly", func(ctx context.Context) error {
+		ran = append(ran, "second")
+		return nil
+	}))
+
+	now := time.Now().UTC()
+	for _, scheduled := range s.tasks {
+		scheduled.next = now
+	}
+	require.NoError(t, s.runDue(ctx, now, leading))
+
+	assert.Equal(t, []string{"first"}, ran)
+	assert.Contains(t, fs.runs, "first")
+	assert.NotContains(t, fs.runs, "second")
+}
+
+func TestRunDueStopsWhenLeadershipIsLost(t *testing.T) {
+	fs := newFakeTaskStore()
+	s := newTestScheduler(fs)
+	ctx, cancel := context.WithCancelCause(context.Background())
+	defer cancel(nil)
+	lost := false
+	stillLeading := func() error {
+		if lost {
+			return errLostLeadership
+		}
+		return nil
+	}
+
+	var ran []string
+	require.NoError(t, s.Add("first", "@hourly", func(ctx context.Context) error {
+		ran = append(ran, "first")
+		return nil
+	}))
+	require.NoError(t, s.Add("interrupted", "@hourly", func(taskCtx context.Context) error {
+		ran = append(ran, "interrupted")
+		// the lock connection goes away while the task runs
+		lost = true
+		cancel(errLostLeadership)
+		<-taskCtx.Done()
+		return taskCtx.Err()
+	}))
+	require.NoError(t, s.Add("last", "@hourly", func(ctx context.Context) error {
+		ran = append(ran, "last")
+		return nil
+	}))
+
+	now := time.Now().UTC()
+	for _, scheduled := range s.tasks {
+		scheduled.next = now
+	}
+	err := s.runDue(ctx, now, stillLeading)
+
+	assert.ErrorIs(t, err, errLostLeadership)
+	assert.Equal(t, []string{"first", "interrupted"}, ran)
+	assert.Contains(t, fs.runs, "first")
+	// the new leader runs it again, this instance does not record a run it did not finish
+	assert.NotContains(t, fs.runs, "interrupted")
+	assert.NotContains(t, fs.runs, "last")
+}
+
+func TestRunDueChecksLeadershipBeforeEveryTask(t *testing.T) {
+	fs := newFakeTaskStore()
+	s := newTestScheduler(fs)
+	checks := 0
+	stillLeading := func() error {
+		checks++
+		if checks > 1 {
+			return errLostLeadership
+		}
+		return nil
+	}
+
+	var ran []string
+	for _, name := range []string{"first", "second"} {
+		require.NoError(t, s.Add(name, "@hourly", func(ctx context.Context) error {
+			ran = append(ran, name)
+			return nil
+		}))
+	}
+
+	now := time.Now().UTC()
+	for _, scheduled := range s.tasks {
+		scheduled.next = now
+	}
+	err := s.runDue(context.Background(), now, stillLeading)
+
+	assert.ErrorIs(t, err, errLostLeadership)
+	assert.Equal(t, []string{"first"}, ran)
+	assert.NotContains(t, fs.runs, "second")
+}
+
+func TestStatusMergesTheLastRuns(t *testing.T) {
+	started := time.Date(2024, 5, 6, 3, 30, 0, 0, time.UTC)
+	message := "database went away"
+	fs := newFakeTaskStore(
+		store.ScheduledTaskRun{Name: "ran", Schedule: "30 3 * * *", StartedAt: started, FinishedAt: started.Add(time.Second),
+			Error: &message, RanOn: "other:7", NextRunAt: started.Add(24 * time.Hour)},
+		store.ScheduledTaskRun{Name: "rescheduled", Schedule: "0 * * * *", StartedAt: started, FinishedAt: started,
+			RanOn: "other:7", NextRunAt: started.Add(time.Hour)},
+		// a task this instance no longer has
+		store.ScheduledTaskRun{Name: "removed", Schedule: "@daily", StartedAt: started, FinishedAt: started, RanOn: "other:7"},
+	)
+	s := newTestScheduler(fs)
+	require.NoError(t, s.Add("ran", "30 3 * * *", noop))
+	require.NoError(t, s.Add("rescheduled", "*/15 * * * *", noop))
+	require.NoError(t, s.Add("never-ran", "@hourly", noop))
+
+	before := time.Now().UTC()
+	statuses, err := s.Status()
+	require.NoError(t, err)
+	require.Len(t, statuses, 3)
+
+	ran := statuses[0]
+	assert.Equal(t, "ran", ran.Name)
+	assert.Equal(t, "30 3 * * *", ran.Schedule)
+	assert.Equal(t, started, *ran.LastStartedAt)
+	assert.Equal(t, started.Add(time.Second), *ran.LastFinishedAt)
+	assert.Equal(t, message, *ran.LastError)
+	assert.Equal(t, "other:7", *ran.LastRanOn)
+	assert.Equal(t, started.Add(24*time.Hour), ran.NextRunAt)
+
+	// the last run is shown but the next one follows the new schedule
+	rescheduled := statuses[1]
+	assert.Equal(t, "rescheduled", rescheduled.Name)
+	assert.Equal(t, "other:7", *rescheduled.LastRanOn)
+	assert.Nil(t, rescheduled.LastError)
+	assert.True(t, rescheduled.NextRunAt.After(before))
+	assert.Equal(t, 0, rescheduled.NextRunAt.Minute()%15)
+
+	neverRan := statuses[2]
+	assert.Equal(t, "never-ran", neverRan.Name)
+	assert.Nil(t, neverRan.LastStartedAt)
+	assert.Nil(t, neverRan.LastFinishedAt)
+	assert.Nil(t, neverRan.LastError)
+	assert.Nil(t, neverRan.LastRanOn)
+	assert.True(t, neverRan.NextRunAt.After(before))
+}
@@ -0,0 +1,158 @@
+package store
+
+import (
+	"time"
+)
+
+// WorkoutFact summarises one workout for the achievements engine
+type WorkoutFact struct {
+	WorkoutID       int64
+	PerformedAt     time.Time
+	Type            string
+	Volume          float64
+	DistanceMeters  float64
+	PersonalRecords int
+}
+
+type Award struct {
+	AchievementID string    `json:"id"`
+	AwardedAt     time.Time `json:"awarded_at"`
+}
+
+type PostgresAchievementStore struct {
+	db Querier
+}
+
+func NewPostgresAchievementStore(db Querier) *PostgresAchievementStore {
+	return &PostgresAchievementStore{db: db}
+}
+
+type AchievementStore interface {
+	ListWorkoutFacts(userID int) ([]WorkoutFact, error)
+	ListAwards(userID int) ([]Award, error)
+	GrantAward(userID int, achievementID string, awardedAt time.Time) (bool, error)
+	ListUserIDsWithWorkouts() ([]int, error)
+}
+
+// ListWorkoutFacts returns every workout of the user oldest first. Volume is the weight moved,
+// sets x reps x weight. A personal record is an exercise whose estimated one rep max beats the
+// best of every earlier workout, so the first time an exercise is logged does not count.
+func (pg *PostgresAchievementStore) ListWorkoutFacts(userID int) ([]WorkoutFact, error) {
+	query := `
+	WITH lifts AS (
+		SELECT w.id AS workout_id,w.performed_at,lower(e.exercise_name) AS exercise,
+			MAX(` + oneRepMaxSQL + `) AS one_rep_max
+		FROM workout_entries e
+		INNER JOIN workouts w ON w.id=e.workout_id
+		WHERE w.user_id=$1 AND w.deleted_at IS NULL AND ` + liftSetSQL + `
+		GROUP BY w.id,w.performed_at,lower(e.exercise_name)
+	), records AS (
+		SELECT workout_id,COUNT(*) AS personal_records
+		FROM (
+			SELECT workout_id,one_rep_max,MAX(one_rep_max) OVER (
+				PARTITION BY exercise ORDER BY performed_at,workout_id
+				ROWS BETWEEN UNBOUNDED PRECEDING AND 1 PRECEDING
+			) AS previous_best
+			FROM lifts
+		) ranked
+		WHERE one_rep_max>previous_best
+		GROUP BY workout_id
+	), volumes AS (
+		SELECT e.workout_id,SUM(e.exercise_sets*e.reps*e.weight) AS volume
+		FROM workout_entries e
+		INNER JOIN workouts w ON w.id=e.workout_id
+		WHERE w.user_id=$1 AND w.deleted_at IS NULL AND e.reps IS NOT NULL AND e.weight IS NOT NULL
+		GROUP BY e.workout_id
+	)
+	SELECT w.id,w.performed_at,w.type,
+		COALESCE(v.volume,0),COALESCE(a.distance_meters,0),COALESCE(r.personal_records,0)
+	FROM workouts w
+	LEFT JOIN workout_activities a ON a.workout_id=w.id
+	LEFT JOIN volumes v ON v.workout_id=w.id
+	LEFT JOIN records r ON r.workout_id=w.id
+	WHERE w.user_id=$1 AND w.deleted_at IS NULL
+	ORDER BY w.performed_at,w.id
+	`
+
+	rows, err := pg.db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	facts := []WorkoutFact{}
+	for rows.Next() {
+		var fact WorkoutFact
+		err := rows.Scan(&fact.WorkoutID, &fact.PerformedAt, &fact.Type, &fact.Volume, &fact.DistanceMeters, &fact.PersonalRecords)
+		if err != nil {
+			return nil, err
+		}
+		facts = append(facts, fact)
+	}
+	return facts, rows.Err()
+}
+
+func (pg *PostgresAchievementStore) ListAwards(userID int) ([]Award, error) {
+	query := `
+	SELECT achievement_id,awarded_at
+	FROM user_achievements
+	WHERE user_id=$1
+	ORDER BY awarded_at,achievement_id
+	`
+
+	rows, err := pg.db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	awards := []Award{}
+	for rows.Next() {
+		var award Award
+		err := rows.Scan(&award.AchievementID, &award.AwardedAt)
+		if err != nil {
+			return nil, err
+		}
+		awards = append(awards, award)
+	}
+	return awards, rows.Err()
+}
+
+// GrantAward records the achievement unless the user already has it, it reports whether the
+// award is new
+func (pg *PostgresAchievementStore) GrantAward(userID int, achievementID string, awardedAt time.Time) (bool, error) {
+	query := `
+	INSERT INTO user_achievements(user_id,achievement_id,awarded_at)
+	VALUES($1,$2,$3)
+	ON CONFLICT (user_id,achievement_id) DO NOTHING
+	`
+	result, err := pg.db.Exec(query, userID, achievementID, awardedAt)
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rowsAffected > 0, nil
+}
+
+func (pg *PostgresAchievementStore) ListUserIDsWithWorkouts() ([]int, error) {
+	rows, err := pg.db.Query(`SELECT DISTINCT user_id FROM workouts WHERE deleted_at IS NULL ORDER BY user_id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	userIDs := []int{}
+	for rows.Next() {
+		var userID int
+		err := rows.Scan(&userID)
+		if err != nil {
+			return nil, err
+		}
+		userIDs = append(userIDs, userID)
+	}
+	return userIDs, rows.Err()
+}
@@ -0,0 +1,202 @@
+package store
+
+import (
+	"database/sql"
+	"time"
+)
+
+const (
+	MeasurementWeight  = "weight"
+	MeasurementBodyFat = "body_fat"
+	MeasurementNeck    = "neck"
+	MeasurementChest   = "chest"
+	MeasurementWaist   = "waist"
+	MeasurementHips    = "hips"
+	MeasurementBiceps  = "biceps"
+	MeasurementForearm = "forearm"
+	MeasurementThigh   = "thigh"
+	MeasurementCalf    = "calf"
+
+	UnitKilograms   = "kg"
+	UnitPercent     = "%"
+	UnitCentimeters = "cm"
+)
+
+// MeasurementKinds lists every kind of body measurement in display order
+var MeasurementKinds = []string{
+	MeasurementWeight, MeasurementBodyFat, MeasurementNeck, MeasurementChest, MeasurementWaist,
+	MeasurementHips, MeasurementBiceps, MeasurementForearm, MeasurementThigh, MeasurementCalf,
+}
+
+func IsValidMeasurementKind(kind string) bool {
+	for _, k := range MeasurementKinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// MeasurementUnit is the unit a kind is stored in: kg for weight, percent for body fat and cm
+// for every circumference
+func MeasurementUnit(kind string) string {
+	switch kind {
+	case MeasurementWeight:
+		return UnitKilograms
+	case MeasurementBodyFat:
+		return UnitPercent
+	}
+	return UnitCentimeters
+}
+
+type BodyMeasurement struct {
+	ID         int       `json:"-"`
+	PublicID   string    `json:"id"`
+	UserID     int       `json:"-"`
+	Kind       string    `json:"kind"`
+	Value      float64   `json:"value"`
+	Unit       string    `json:"unit"`
+	Note       string    `json:"note"`
+	MeasuredAt time.Time `json:"measured_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+type PostgresBodyMeasurementStore struct {
+	db Querier
+}
+
+func NewPostgresBodyMeasurementStore(db Querier) *PostgresBodyMeasurementStore {
+	return &PostgresBodyMeasurementStore{db: db}
+}
+
+type BodyMeasurementStore interface {
+	CreateMeasurement(measurement *BodyMeasurement) error
+	GetMeasurement(userID int, publicID string) (*BodyMeasurement, error)
+	UpdateMeasurement(measurement *BodyMeasurement) error
+	DeleteMeasurement(userID int, publicID string) error
+	ListMeasurements(userID int, kind string, from, to time.Time) ([]BodyMeasurement, error)
+	LatestMeasurements(userID int) ([]BodyMeasurement, error)
+	GetWeightAt(userID int, at time.Time) (*BodyMeasurement, error)
+}
+
+const measurementColumns = `id,public_id,user_id,kind,value,note,measured_at,updated_at`
+
+func scanMeasurement(row interface{ Scan(dest ...any) error }) (*BodyMeasurement, error) {
+	measurement := &BodyMeasurement{}
+	err := row.Scan(&measurement.ID, &measurement.PublicID, &measurement.UserID, &measurement.Kind,
+		&measurement.Value, &measurement.Note, &measurement.MeasuredAt, &measurement.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	measurement.Unit = MeasurementUnit(measurement.Kind)
+	return measurement, nil
+}
+
+func (pg *PostgresBodyMeasurementStore) CreateMeasurement(measurement *BodyMeasurement) error {
+	query := `
+	INSERT INTO body_measurements(user_id,kind,value,note,measured_at)
+	VALUES($1,$2,$3,$4,$5)
+	RETURNING id,public_id,updated_at
+	`
+	err := pg.db.QueryRow(query, measurement.UserID, measurement.Kind, measurement.Value, measurement.Note, measurement.MeasuredAt).
+		Scan(&measurement.ID, &measurement.PublicID, &measurement.UpdatedAt)
+	if err != nil {
+		return err
+	}
+	measurement.Unit = MeasurementUnit(measurement.Kind)
+	return nil
+}
+
+// GetMeasurement returns sql.ErrNoRows when the measurement does not exist or belongs to someone else
+func (pg *PostgresBodyMeasurementStore) GetMeasurement(userID int, publicID string) (*BodyMeasurement, error) {
+	query := `SELECT ` + measurementColumns + ` FROM body_measurements WHERE user_id=$1 AND public_id=$2`
+	return scanMeasurement(pg.db.QueryRow(query, userID, publicID))
+}
+
+func (pg *PostgresBodyMeasurementStore) UpdateMeasurement(measurement *BodyMeasurement) error {
+	query := `
+	UPDATE body_measurements
+	SET value=$1,note=$2,measured_at=$3,updated_at=CURRENT_TIMESTAMP
+	WHERE id=$4 AND user_id=$5
+	RETURNING updated_at
+	`
+	return pg.db.QueryRow(query, measurement.Value, measurement.Note, measurement.MeasuredAt, measurement.ID, measurement.UserID).
+		Scan(&measurement.UpdatedAt)
+}
+
+func (pg *PostgresBodyMeasurementStore) DeleteMeasurement(userID int, publicID string) error {
+	result, err := pg.db.Exec(`DELETE FROM body_measurements WHERE user_id=$1 AND public_id=$2`, userID, publicID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// ListMeasurements returns the measurements taken in [from, to), newest first. An empty kind
+// lists every kind.
+func (pg *PostgresBodyMeasurementStore) ListMeasurements(userID int, kind string, from, to time.Time) ([]BodyMeasurement, error) {
+	query := `
+	SELECT ` + measurementColumns + `
+	FROM body_measurements
+	WHERE user_id=$1 AND ($2='' OR kind=$2) AND measured_at>=$3 AND measured_at<$4
+	ORDER BY measured_at DESC, id DESC
+	`
+	return pg.queryMeasurements(query, userID, kind, from, to)
+}
+
+// LatestMeasurements returns the most recent measurement of every kind the user has logged
+func (pg *PostgresBodyMeasurementStore) LatestMeasurements(userID int) ([]BodyMeasurement, error) {
+	query := `
+	SELECT DISTINCT ON (kind) ` + measurementColumns + `
+	FROM body_measurements
+	WHERE user_id=$1
+	ORDER BY kind, measured_at DESC, id DESC
+	`
+	return pg.queryMeasurements(query, userID)
+}
+
+func (pg *PostgresBodyMeasurementStore) queryMeasurements(query string, args ...any) ([]BodyMeasurement, error) {
+	rows, err := pg.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	measurements := []BodyMeasurement{}
+	for rows.Next() {
+		measurement, err := scanMeasurement(rows)
+		if err != nil {
+			return nil, err
+		}
+		measurements = append(measurements, *measurement)
+	}
+	return measurements, rows.Err()
+}
+
+// GetWeightAt returns the last weight measured at or before the given time, falling back to the
+// first measurement after it. It returns nil when the user never logged a weight.
+func (pg *PostgresBodyMeasurementStore) GetWeightAt(userID int, at time.Time) (*BodyMeasurement, error) {
+	query := `
+	SELECT ` + measurementColumns + `
+	FROM body_measurements
+	WHERE user_id=$1 AND kind='weight'
+	ORDER BY measured_at>$2, abs(extract(epoch FROM measured_at-$2))
+	LIMIT 1
+	`
+	weight, err := scanMeasurement(pg.db.QueryRow(query, userID, at))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return weight, nil
+}
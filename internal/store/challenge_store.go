@@ -0,0 +1,456 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+const (
+	ChallengeVolume   = "volume"
+	ChallengeDistance = "distance"
+	ChallengeDuration = "duration"
+	ChallengeWorkouts = "workouts"
+
+	BracketNone        = "none"
+	BracketWeightClass = "weight_class"
+	BracketAgeGroup    = "age_group"
+
+	ChallengeUpcoming = "upcoming"
+	ChallengeActive   = "active"
+	ChallengeFinished = "finished"
+
+	// anonymous participants are ranked without their name, hidden ones opt out of the
+	// leaderboard and only see their own score
+	ParticipantPublic    = "public"
+	ParticipantAnonymous = "anonymous"
+	ParticipantHidden    = "hidden"
+)
+
+var ErrAlreadyJoined = errors.New("already joined the challenge")
+
+func IsValidChallengeMetric(metric string) bool {
+	switch metric {
+	case ChallengeVolume, ChallengeDistance, ChallengeDuration, ChallengeWorkouts:
+		return true
+	}
+	return false
+}
+
+// ChallengeUnit is the unit scores and targets of a challenge metric are expressed in
+func ChallengeUnit(metric string) string {
+	switch metric {
+	case ChallengeVolume:
+		return UnitKilograms
+	case ChallengeDistance:
+		return "m"
+	case ChallengeDuration:
+		return "min"
+	}
+	return "workouts"
+}
+
+// Challenge is a time boxed competition, participants are ranked by the metric summed over
+// the workouts they performed between starts_at and ends_at. When there is a target, whoever
+// reached it first ranks highest.
+type Challenge struct {
+	ID           int64     `json:"-"`
+	PublicID     string    `json:"id"`
+	CreatedBy    int       `json:"-"`
+	Title        string    `json:"title"`
+	Description  string    `json:"description"`
+	Metric       string    `json:"metric"`
+	Unit         string    `json:"unit"`
+	WorkoutType  string    `json:"workout_type,omitempty"`
+	TargetValue  *float64  `json:"target_value"`
+	Bracket      string    `json:"bracket"`
+	StartsAt     time.Time `json:"starts_at"`
+	EndsAt       time.Time `json:"ends_at"`
+	Status       string    `json:"status"`
+	Participants int       `json:"participants"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// CurrentStatus tells whether the challenge has not started, is running or is over
+func (c *Challenge) CurrentStatus(now time.Time) string {
+	switch {
+	case now.Before(c.StartsAt):
+		return ChallengeUpcoming
+	case now.Before(c.EndsAt):
+		return ChallengeActive
+	}
+	return ChallengeFinished
+}
+
+// Participant is a user in a challenge together with their standing. The standing columns are
+// the maintained leaderboard, they are only written by SaveStanding and RankChallenge.
+type Participant struct {
+	ChallengeID     int64      `json:"-"`
+	UserID          int        `json:"-"`
+	UserPublicID    string     `json:"user_id,omitempty"`
+	Username        string     `json:"username,omitempty"`
+	Team            string     `json:"team,omitempty"`
+	Visibility      string     `json:"visibility,omitempty"`
+	Bracket         string     `json:"bracket,omitempty"`
+	JoinedAt        time.Time  `json:"joined_at"`
+	Score           float64    `json:"score"`
+	LastScoredAt    *time.Time `json:"last_scored_at"`
+	ReachedTargetAt *time.Time `json:"reached_target_at"`
+	Rank            *int       `json:"rank"`
+	BracketRank     *int       `json:"bracket_rank,omitempty"`
+}
+
+// TeamStanding sums the scores of the visible members of a team
+type TeamStanding struct {
+	Rank    int     `json:"rank"`
+	Team    string  `json:"team"`
+	Members int     `json:"members"`
+	Score   float64 `json:"score"`
+}
+
+type PostgresChallengeStore struct {
+	db Querier
+}
+
+func NewPostgresChallengeStore(db Querier) *PostgresChallengeStore {
+	return &PostgresChallengeStore{db: db}
+}
+
+type ChallengeStore interface {
+	CreateChallenge(challenge *Challenge) error
+	GetChallenge(publicID string) (*Challenge, error)
+	ListChallenges(status string, now time.Time) ([]Challenge, error)
+	DeleteChallenge(createdBy int, publicID string) error
+	ListJoinedChallenges(userID int, endedAfter time.Time) ([]Challenge, error)
+	JoinChallenge(participant *Participant) error
+	GetParticipant(challengeID int64, userID int) (*Participant, error)
+	UpdateParticipant(participant *Participant) error
+	LeaveChallenge(challengeID int64, userID int) error
+	ChallengeSamples(userID int, challenge *Challenge) ([]GoalSample, error)
+	SaveStanding(participant *Participant) error
+	RankChallenge(challengeID int64) error
+	ListStandings(challengeID int64, bracket string, limit, offset int) ([]Participant, error)
+	ListTeamStandings(challengeID int64) ([]TeamStanding, error)
+}
+
+const challengeColumns = `c.id,c.public_id,c.created_by,c.title,c.description,c.metric,COALESCE(c.workout_type,''),
+	c.target_value,c.bracket,c.starts_at,c.ends_at,c.created_at,
+	(SELECT COUNT(*) FROM challenge_participants p WHERE p.challenge_id=c.id)`
+
+func scanChallenge(row interface{ Scan(dest ...any) error }) (*Challenge, error) {
+	challenge := &Challenge{}
+	err := row.Scan(&challenge.ID, &challenge.PublicID, &challenge.CreatedBy, &challenge.Title, &challenge.Description,
+		&challenge.Metric, &challenge.WorkoutType, &challenge.TargetValue, &challenge.Bracket, &challenge.StartsAt,
+		&challenge.EndsAt, &challenge.CreatedAt, &challenge.Participants)
+	if err != nil {
+		return nil, err
+	}
+	challenge.Unit = ChallengeUnit(challenge.Metric)
+	challenge.Status = challenge.CurrentStatus(time.Now())
+	return challenge, nil
+}
+
+func (pg *PostgresChallengeStore) queryChallenges(query string, args ...any) ([]Challenge, error) {
+	rows, err := pg.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	challenges := []Challenge{}
+	for rows.Next() {
+		challenge, err := scanChallenge(rows)
+		if err != nil {
+			return nil, err
+		}
+		challenges = append(challenges, *challenge)
+	}
+	return challenges, rows.Err()
+}
+
+func (pg *PostgresChallengeStore) CreateChallenge(challenge *Challenge) error {
+	query := `
+	INSERT INTO challenges(created_by,title,description,metric,workout_type,target_value,bracket,starts_at,ends_at)
+	VALUES($1,$2,$3,$4,NULLIF($5,''),$6,$7,$8,$9)
+	RETURNING id,public_id,created_at
+	`
+	err := pg.db.QueryRow(query, challenge.CreatedBy, challenge.Title, challenge.Description, challenge.Metric,
+		challenge.WorkoutType, challenge.TargetValue, challenge.Bracket, challenge.StartsAt, challenge.EndsAt).
+		Scan(&challenge.ID, &challenge.PublicID, &challenge.CreatedAt)
+	if err != nil {
+		return err
+	}
+	challenge.Unit = ChallengeUnit(challenge.Metric)
+	challenge.Status = challenge.CurrentStatus(time.Now())
+	return nil
+}
+
+// GetChallenge returns sql.ErrNoRows when the challenge does not exist
+func (pg *PostgresChallengeStore) GetChallenge(publicID string) (*Challenge, error) {
+	query := `SELECT ` + challengeColumns + ` FROM challenges c WHERE c.public_id=$1`
+	return scanChallenge(pg.db.QueryRow(query, publicID))
+}
+
+// ListChallenges returns the challenges in the given status as of now, an empty status lists
+// every challenge. Running and upcoming challenges come first.
+func (pg *PostgresChallengeStore) ListChallenges(status string, now time.Time) ([]Challenge, error) {
+	query := `
+	SELECT ` + challengeColumns + `
+	FROM challenges c
+	WHERE $1=''
+		OR ($1='upcoming' AND c.starts_at>$2)
+		OR ($1='active' AND c.starts_at<=$2 AND c.ends_at>$2)
+		OR ($1='finished' AND c.ends_at<=$2)
+	ORDER BY c.ends_at<=$2, c.starts_at, c.id
+	`
+	return pg.queryChallenges(query, status, now)
+}
+
+// DeleteChallenge removes a challenge together with its leaderboard, only its creator can
+func (pg *PostgresChallengeStore) DeleteChallenge(createdBy int, publicID string) error {
+	result, err := pg.db.Exec(`DELETE FROM challenges WHERE created_by=$1 AND public_id=$2`, createdBy, publicID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// ListJoinedChallenges returns the challenges the user takes part in that ended after the given time
+func (pg *PostgresChallengeStore) ListJoinedChallenges(userID int, endedAfter time.Time) ([]Challenge, error) {
+	query := `
+	SELECT ` + challengeColumns + `
+	FROM challenges c
+	INNER JOIN challenge_participants me ON me.challenge_id=c.id
+	WHERE me.user_id=$1 AND c.ends_at>$2
+	ORDER BY c.starts_at, c.id
+	`
+	return pg.queryChallenges(query, userID, endedAfter)
+}
+
+// JoinChallenge adds the user to the challenge, it returns ErrAlreadyJoined when they take part already
+func (pg *PostgresChallengeStore) JoinChallenge(participant *Participant) error {
+	query := `
+	INSERT INTO challenge_participants(challenge_id,user_id,team,visibility,bracket)
+	VALUES($1,$2,$3,$4,$5)
+	ON CONFLICT (challenge_id,user_id) DO NOTHING
+	RETURNING joined_at
+	`
+	err := pg.db.QueryRow(query, participant.ChallengeID, participant.UserID, participant.Team,
+		participant.Visibility, participant.Bracket).Scan(&participant.JoinedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrAlreadyJoined
+	}
+	return err
+}
+
+const participantColumns = `p.challenge_id,p.user_id,u.public_id,u.username,p.team,p.visibility,p.bracket,
+	p.joined_at,p.score,p.last_scored_at,p.reached_target_at,p.rank,p.bracket_rank`
+
+func scanParticipant(row interface{ Scan(dest ...any) error }) (*Participant, error) {
+	participant := &Participant{}
+	err := row.Scan(&participant.ChallengeID, &participant.UserID, &participant.UserPublicID, &participant.Username,
+		&participant.Team, &participant.Visibility, &participant.Bracket, &participant.JoinedAt, &participant.Score,
+		&participant.LastScoredAt, &participant.ReachedTargetAt, &participant.Rank, &participant.BracketRank)
+	if err != nil {
+		return nil, err
+	}
+	return participant, nil
+}
+
+// GetParticipant returns sql.ErrNoRows when the user did not join the challenge
+func (pg *PostgresChallengeStore) GetParticipant(challengeID int64, userID int) (*Participant, error) {
+	query := `
+	SELECT ` + participantColumns + `
+	FROM challenge_participants p
+	INNER JOIN users u ON u.id=p.user_id
+	WHERE p.challenge_id=$1 AND p.user_id=$2
+	`
+	return scanParticipant(pg.db.QueryRow(query, challengeID, userID))
+}
+
+// UpdateParticipant saves the team and visibility the participant chose
+func (pg *PostgresChallengeStore) UpdateParticipant(participant *Participant) error {
+	query := `
+	UPDATE challenge_participants
+	SET team=$1,visibility=$2,updated_at=CURRENT_TIMESTAMP
+	WHERE challenge_id=$3 AND user_id=$4
+	`
+	result, err := pg.db.Exec(query, participant.Team, participant.Visibility, participant.ChallengeID, participant.UserID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (pg *PostgresChallengeStore) LeaveChallenge(challengeID int64, userID int) error {
+	result, err := pg.db.Exec(`DELETE FROM challenge_participants WHERE challenge_id=$1 AND user_id=$2`, challengeID, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// ChallengeSamples returns what every workout of the user in the challenge window scores,
+// oldest first. Volume is sets x reps x weight, duration is in minutes and every workout
+// scores one towards a workouts challenge.
+func (pg *PostgresChallengeStore) ChallengeSamples(userID int, challenge *Challenge) ([]GoalSample, error) {
+	query := `
+	SELECT performed_at,value
+	FROM (
+		SELECT w.id,w.performed_at,
+			CASE $5
+				WHEN 'volume' THEN (
+					SELECT COALESCE(SUM(e.exercise_sets*e.reps*e.weight),0)
+					FROM workout_entries e
+					WHERE e.workout_id=w.id AND e.reps IS NOT NULL AND e.weight IS NOT NULL
+				)
+				WHEN 'distance' THEN COALESCE(a.distance_meters,0)
+				WHEN 'duration' THEN w.duration
+				ELSE 1
+			END AS value
+		FROM workouts w
+		LEFT JOIN workout_activities a ON a.workout_id=w.id
+		WHERE w.user_id=$1 AND ($2='' OR w.type=$2) AND w.deleted_at IS NULL
+			AND w.performed_at>=$3 AND w.performed_at<$4
+	) scored
+	WHERE value>0
+	ORDER BY performed_at,id
+	`
+
+	rows, err := pg.db.Query(query, userID, challenge.WorkoutType, challenge.StartsAt, challenge.EndsAt, challenge.Metric)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	samples := []GoalSample{}
+	for rows.Next() {
+		var sample GoalSample
+		err := rows.Scan(&sample.Time, &sample.Value)
+		if err != nil {
+			return nil, err
+		}
+		samples = append(samples, sample)
+	}
+	return samples, rows.Err()
+}
+
+// SaveStanding stores the score of a participant, RankChallenge has to run afterwards
+func (pg *PostgresChallengeStore) SaveStanding(participant *Participant) error {
+	query := `
+	UPDATE challenge_participants
+	SET score=$1,last_scored_at=$2,reached_target_at=$3,updated_at=CURRENT_TIMESTAMP
+	WHERE challenge_id=$4 AND user_id=$5
+	`
+	_, err := pg.db.Exec(query, participant.Score, participant.LastScoredAt, participant.ReachedTargetAt,
+		participant.ChallengeID, participant.UserID)
+	return err
+}
+
+// RankChallenge renumbers the leaderboard. Whoever reached the target first wins, then the
+// highest score. Equal scores are broken by who got there first and then by who joined first,
+// so every rank is unique. Hidden participants are left out of the ranking.
+func (pg *PostgresChallengeStore) RankChallenge(challengeID int64) error {
+	query := `
+	UPDATE challenge_participants p
+	SET rank=ranked.rank,bracket_rank=ranked.bracket_rank
+	FROM (
+		SELECT user_id,
+			CASE WHEN visibility='hidden' THEN NULL ELSE ROW_NUMBER() OVER (
+				PARTITION BY visibility='hidden'
+				ORDER BY reached_target_at NULLS LAST,score DESC,last_scored_at NULLS LAST,joined_at,user_id
+			) END AS rank,
+			CASE WHEN visibility='hidden' OR bracket='' THEN NULL ELSE ROW_NUMBER() OVER (
+				PARTITION BY visibility='hidden',bracket
+				ORDER BY reached_target_at NULLS LAST,score DESC,last_scored_at NULLS LAST,joined_at,user_id
+			) END AS bracket_rank
+		FROM challenge_participants
+		WHERE challenge_id=$1
+	) ranked
+	WHERE p.challenge_id=$1 AND p.user_id=ranked.user_id
+		AND (p.rank IS DISTINCT FROM ranked.rank OR p.bracket_rank IS DISTINCT FROM ranked.bracket_rank)
+	`
+	_, err := pg.db.Exec(query, challengeID)
+	return err
+}
+
+// ListStandings returns a page of the leaderboard, an empty bracket lists every bracket.
+// Hidden participants are never listed.
+func (pg *PostgresChallengeStore) ListStandings(challengeID int64, bracket string, limit, offset int) ([]Participant, error) {
+	query := `
+	SELECT ` + participantColumns + `
+	FROM challenge_participants p
+	INNER JOIN users u ON u.id=p.user_id
+	WHERE p.challenge_id=$1 AND p.visibility<>'hidden' AND ($2='' OR p.bracket=$2)
+	ORDER BY CASE WHEN $2='' THEN p.rank ELSE p.bracket_rank END NULLS LAST,p.user_id
+	LIMIT $3 OFFSET $4
+	`
+
+	rows, err := pg.db.Query(query, challengeID, bracket, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	standings := []Participant{}
+	for rows.Next() {
+		participant, err := scanParticipant(rows)
+		if err != nil {
+			return nil, err
+		}
+		standings = append(standings, *participant)
+	}
+	return standings, rows.Err()
+}
+
+// ListTeamStandings ranks the teams by the summed score of their visible members
+func (pg *PostgresChallengeStore) ListTeamStandings(challengeID int64) ([]TeamStanding, error) {
+	query := `
+	SELECT ROW_NUMBER() OVER (ORDER BY SUM(score) DESC,MIN(joined_at),team),team,COUNT(*),SUM(score)
+	FROM challenge_participants
+	WHERE challenge_id=$1 AND team<>'' AND visibility<>'hidden'
+	GROUP BY team
+	ORDER BY 1
+	`
+
+	rows, err := pg.db.Query(query, challengeID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	standings := []TeamStanding{}
+	for rows.Next() {
+		var standing TeamStanding
+		err := rows.Scan(&standing.Rank, &standing.Team, &standing.Members, &standing.Score)
+		if err != nil {
+			return nil, err
+		}
+		standings = append(standings, standing)
+	}
+	return standings, rows.Err()
+}
@@ -2,11 +2,12 @@
 package store
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"io/fs"
 
-	_ "github.com/jackc/pgx/v4/stdlib"
+	"github.com/jackc/pgx/v4/stdlib"
 	"github.com/pressly/goose/v3"
 )
 
@@ -39,3 +40,37 @@ func Migrate(db *sql.DB, dir string) error {
 	}
 	return nil
 }
+
+// Listen holds a connection that LISTENs on channel and calls handle with the payload of every
+// notification until ctx is done or the connection fails. Notifications sent while no one
+// listens are lost, callers reconnect and resync from the tables.
+func Listen(ctx context.Context, db *sql.DB, channel string, handle func(payload string)) error {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	return conn.Raw(func(driverConn any) error {
+		stdlibConn, ok := driverConn.(*stdlib.Conn)
+		if !ok {
+			return fmt.Errorf("listen: unexpected driver connection %T", driverConn)
+		}
+		pgConn := stdlibConn.Conn()
+
+		_, err := pgConn.Exec(ctx, "LISTEN "+channel)
+		if err != nil {
+			return err
+		}
+		// the connection goes back to the pool, it must not keep receiving notifications
+		defer pgConn.Exec(context.Background(), "UNLISTEN *")
+
+		for {
+			notification, err := pgConn.WaitForNotification(ctx)
+			if err != nil {
+				return err
+			}
+			handle(notification.Payload)
+		}
+	})
+}
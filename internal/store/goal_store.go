@@ -0,0 +1,265 @@
+package store
+
+import (
+	"database/sql"
+	"time"
+)
+
+const (
+	GoalLift      = "lift"
+	GoalDistance  = "distance"
+	GoalFrequency = "frequency"
+
+	PeriodWeek  = "week"
+	PeriodMonth = "month"
+
+	GoalActive    = "active"
+	GoalCompleted = "completed"
+	GoalExpired   = "expired"
+)
+
+func IsValidGoalKind(kind string) bool {
+	switch kind {
+	case GoalLift, GoalDistance, GoalFrequency:
+		return true
+	}
+	return false
+}
+
+// GoalUnit is the unit the target and current value of a goal kind are expressed in
+func GoalUnit(kind string) string {
+	switch kind {
+	case GoalLift:
+		return UnitKilograms
+	case GoalDistance:
+		return "m"
+	}
+	return "workouts"
+}
+
+// Goal is a target the user works towards. Lift goals track the best estimated one rep max of
+// an exercise, distance goals the distance covered since starts_at and frequency goals the
+// number of workouts in the current week or month. The progress fields are derived from the
+// workouts and only written by SaveGoalProgress.
+type Goal struct {
+	ID           int        `json:"-"`
+	PublicID     string     `json:"id"`
+	UserID       int        `json:"-"`
+	Kind         string     `json:"kind"`
+	Title        string     `json:"title"`
+	ExerciseName string     `json:"exercise_name,omitempty"`
+	WorkoutType  string     `json:"workout_type,omitempty"`
+	Period       string     `json:"period,omitempty"`
+	TargetValue  float64    `json:"target_value"`
+	Unit         string     `json:"unit"`
+	StartsAt     time.Time  `json:"starts_at"`
+	Deadline     *time.Time `json:"deadline"`
+	Status       string     `json:"status"`
+	CurrentValue float64    `json:"current_value"`
+	Progress     float64    `json:"progress"`
+	ProjectedAt  *time.Time `json:"projected_completion"`
+	CompletedAt  *time.Time `json:"completed_at"`
+	EvaluatedAt  *time.Time `json:"evaluated_at"`
+}
+
+// GoalSample is a single data point a goal is evaluated against
+type GoalSample struct {
+	Time  time.Time
+	Value float64
+}
+
+type PostgresGoalStore struct {
+	db Querier
+}
+
+func NewPostgresGoalStore(db Querier) *PostgresGoalStore {
+	return &PostgresGoalStore{db: db}
+}
+
+type GoalStore interface {
+	CreateGoal(goal *Goal) error
+	GetGoal(userID int, publicID string) (*Goal, error)
+	ListGoals(userID int) ([]Goal, error)
+	UpdateGoal(goal *Goal) error
+	DeleteGoal(userID int, publicID string) error
+	SaveGoalProgress(goal *Goal) error
+	LiftSamples(userID int, exerciseName string) ([]GoalSample, error)
+	DistanceSamples(userID int, workoutType string, from, to time.Time) ([]GoalSample, error)
+	CountWorkouts(userID int, workoutType string, from, to time.Time) (int, error)
+}
+
+const goalColumns = `id,public_id,user_id,kind,title,COALESCE(exercise_name,''),COALESCE(workout_type,''),
+	COALESCE(period,''),target_value,starts_at,deadline,current_value,progress,projected_at,completed_at,evaluated_at`
+
+func scanGoal(row interface{ Scan(dest ...any) error }) (*Goal, error) {
+	goal := &Goal{}
+	err := row.Scan(&goal.ID, &goal.PublicID, &goal.UserID, &goal.Kind, &goal.Title, &goal.ExerciseName,
+		&goal.WorkoutType, &goal.Period, &goal.TargetValue, &goal.StartsAt, &goal.Deadline,
+		&goal.CurrentValue, &goal.Progress, &goal.ProjectedAt, &goal.CompletedAt, &goal.EvaluatedAt)
+	if err != nil {
+		return nil, err
+	}
+	goal.Unit = GoalUnit(goal.Kind)
+	goal.Status = goal.CurrentStatus(time.Now())
+	return goal, nil
+}
+
+// CurrentStatus is completed once the target was reached and expired when the deadline passed
+// without reaching it. Frequency goals recur, so they stay active until their deadline.
+func (g *Goal) CurrentStatus(now time.Time) string {
+	switch {
+	case g.CompletedAt != nil:
+		return GoalCompleted
+	case g.Deadline != nil && now.After(*g.Deadline):
+		return GoalExpired
+	}
+	return GoalActive
+}
+
+func (pg *PostgresGoalStore) CreateGoal(goal *Goal) error {
+	query := `
+	INSERT INTO goals(user_id,kind,title,exercise_name,workout_type,period,target_value,starts_at,deadline)
+	VALUES($1,$2,$3,NULLIF($4,''),NULLIF($5,''),NULLIF($6,''),$7,$8,$9)
+	RETURNING id,public_id
+	`
+	err := pg.db.QueryRow(query, goal.UserID, goal.Kind, goal.Title, goal.ExerciseName, goal.WorkoutType,
+		goal.Period, goal.TargetValue, goal.StartsAt, goal.Deadline).Scan(&goal.ID, &goal.PublicID)
+	if err != nil {
+		return err
+	}
+	goal.Unit = GoalUnit(goal.Kind)
+	goal.Status = goal.CurrentStatus(time.Now())
+	return nil
+}
+
+// GetGoal returns sql.ErrNoRows when the goal does not exist or belongs to someone else
+func (pg *PostgresGoalStore) GetGoal(userID int, publicID string) (*Goal, error) {
+	query := `SELECT ` + goalColumns + ` FROM goals WHERE user_id=$1 AND public_id=$2`
+	return scanGoal(pg.db.QueryRow(query, userID, publicID))
+}
+
+func (pg *PostgresGoalStore) ListGoals(userID int) ([]Goal, error) {
+	query := `SELECT ` + goalColumns + ` FROM goals WHERE user_id=$1 ORDER BY created_at, id`
+
+	rows, err := pg.db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	goals := []Goal{}
+	for rows.Next() {
+		goal, err := scanGoal(rows)
+		if err != nil {
+			return nil, err
+		}
+		goals = append(goals, *goal)
+	}
+	return goals, rows.Err()
+}
+
+// UpdateGoal saves the fields the user defines, the kind of a goal can not change
+func (pg *PostgresGoalStore) UpdateGoal(goal *Goal) error {
+	query := `
+	UPDATE goals
+	SET title=$1,exercise_name=NULLIF($2,''),workout_type=NULLIF($3,''),period=NULLIF($4,''),
+		target_value=$5,starts_at=$6,deadline=$7,updated_at=CURRENT_TIMESTAMP
+	WHERE id=$8 AND user_id=$9
+	`
+	result, err := pg.db.Exec(query, goal.Title, goal.ExerciseName, goal.WorkoutType, goal.Period,
+		goal.TargetValue, goal.StartsAt, goal.Deadline, goal.ID, goal.UserID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (pg *PostgresGoalStore) DeleteGoal(userID int, publicID string) error {
+	result, err := pg.db.Exec(`DELETE FROM goals WHERE user_id=$1 AND public_id=$2`, userID, publicID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (pg *PostgresGoalStore) SaveGoalProgress(goal *Goal) error {
+	query := `
+	UPDATE goals
+	SET current_value=$1,progress=$2,projected_at=$3,completed_at=$4,evaluated_at=$5
+	WHERE id=$6
+	`
+	_, err := pg.db.Exec(query, goal.CurrentValue, goal.Progress, goal.ProjectedAt, goal.CompletedAt, goal.EvaluatedAt, goal.ID)
+	return err
+}
+
+// LiftSamples returns the best estimated one rep max of the exercise in every workout, oldest first
+func (pg *PostgresGoalStore) LiftSamples(userID int, exerciseName string) ([]GoalSample, error) {
+	query := `
+	SELECT w.performed_at,MAX(` + oneRepMaxSQL + `)
+	FROM workout_entries e
+	INNER JOIN workouts w ON w.id=e.workout_id
+	WHERE w.user_id=$1 AND w.deleted_at IS NULL AND lower(e.exercise_name)=lower($2) AND ` + liftSetSQL + `
+	GROUP BY w.id,w.performed_at
+	ORDER BY w.performed_at
+	`
+	return pg.querySamples(query, userID, exerciseName)
+}
+
+// DistanceSamples returns the distance of every workout of the type done in [from, to), oldest first
+func (pg *PostgresGoalStore) DistanceSamples(userID int, workoutType string, from, to time.Time) ([]GoalSample, error) {
+	query := `
+	SELECT w.performed_at,a.distance_meters
+	FROM workouts w
+	INNER JOIN workout_activities a ON a.workout_id=w.id
+	WHERE w.user_id=$1 AND w.type=$2 AND w.deleted_at IS NULL AND w.performed_at>=$3 AND w.performed_at<$4
+	ORDER BY w.performed_at
+	`
+	return pg.querySamples(query, userID, workoutType, from, to)
+}
+
+func (pg *PostgresGoalStore) querySamples(query string, args ...any) ([]GoalSample, error) {
+	rows, err := pg.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	samples := []GoalSample{}
+	for rows.Next() {
+		var sample GoalSample
+		err := rows.Scan(&sample.Time, &sample.Value)
+		if err != nil {
+			return nil, err
+		}
+		samples = append(samples, sample)
+	}
+	return samples, rows.Err()
+}
+
+// CountWorkouts counts the workouts done in [from, to), an empty type counts every workout
+func (pg *PostgresGoalStore) CountWorkouts(userID int, workoutType string, from, to time.Time) (int, error) {
+	query := `
+	SELECT COUNT(*)
+	FROM workouts w
+	WHERE w.user_id=$1 AND ($2='' OR w.type=$2) AND w.deleted_at IS NULL AND w.performed_at>=$3 AND w.performed_at<$4
+	`
+	var count int
+	err := pg.db.QueryRow(query, userID, workoutType, from, to).Scan(&count)
+	return count, err
+}
@@ -0,0 +1,129 @@
+package store
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// IdempotencyRecord is a request made with an Idempotency-Key. StatusCode is nil while the
+// first request is still being handled.
+type IdempotencyRecord struct {
+	Fingerprint []byte
+	StatusCode  *int
+	Header      map[string]string
+	Body        []byte
+}
+
+type PostgresIdempotencyStore struct {
+	db Querier
+}
+
+func NewPostgresIdempotencyStore(db Querier) *PostgresIdempotencyStore {
+	return &PostgresIdempotencyStore{db: db}
+}
+
+type IdempotencyStore interface {
+	ClaimIdempotencyKey(userID int, key string, fingerprint []byte, lock, ttl time.Duration) (*IdempotencyRecord, bool, error)
+	ExtendIdempotencyKey(userID int, key string, lock time.Duration) error
+	CompleteIdempotencyKey(userID int, key string, record *IdempotencyRecord) error
+	ReleaseIdempotencyKey(userID int, key string) error
+	DeleteExpiredIdempotencyKeys() (int64, error)
+	DeleteOrphanedIdempotencyKeys() (int64, error)
+}
+
+// ClaimIdempotencyKey reserves the key for a request. It returns true when the caller claimed
+// it and must handle the request, otherwise the record of the request that holds the key.
+// Expired keys are claimed again, as are keys whose request has been in flight longer than
+// lock when the fingerprint matches: the instance handling it is assumed to be gone.
+func (pg *PostgresIdempotencyStore) ClaimIdempotencyKey(userID int, key string, fingerprint []byte, lock, ttl time.Duration) (*IdempotencyRecord, bool, error) {
+	now := time.Now()
+	query := `
+	INSERT INTO idempotency_keys(user_id,key,fingerprint,locked_until,expires_at)
+	VALUES($1,$2,$3,$4,$5)
+	ON CONFLICT (user_id,key) DO UPDATE
+	SET fingerprint=EXCLUDED.fingerprint,locked_until=EXCLUDED.locked_until,expires_at=EXCLUDED.expires_at,
+		status_code=NULL,header=NULL,body=NULL,created_at=CURRENT_TIMESTAMP
+	WHERE idempotency_keys.expires_at<$6
+		OR (idempotency_keys.status_code IS NULL AND idempotency_keys.locked_until<$6
+			AND idempotency_keys.fingerprint=EXCLUDED.fingerprint)
+	RETURNING true
+	`
+	var claimed bool
+	err := pg.db.QueryRow(query, userID, key, fingerprint, now.Add(lock), now.Add(ttl), now).Scan(&claimed)
+	if err == nil {
+		return nil, true, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, false, err
+	}
+
+	record := &IdempotencyRecord{}
+	var header []byte
+	err = pg.db.QueryRow(`SELECT fingerprint,status_code,header,body FROM idempotency_keys WHERE user_id=$1 AND key=$2`, userID, key).
+		Scan(&record.Fingerprint, &record.StatusCode, &header, &record.Body)
+	if err != nil {
+		return nil, false, err
+	}
+	if header != nil {
+		err = json.Unmarshal(header, &record.Header)
+		if err != nil {
+			return nil, false, err
+		}
+	}
+	return record, false, nil
+}
+
+// ExtendIdempotencyKey keeps the key locked for another lock while its request is handled
+func (pg *PostgresIdempotencyStore) ExtendIdempotencyKey(userID int, key string, lock time.Duration) error {
+	query := `
+	UPDATE idempotency_keys SET locked_until=$1
+	WHERE user_id=$2 AND key=$3 AND status_code IS NULL
+	`
+	_, err := pg.db.Exec(query, time.Now().Add(lock), userID, key)
+	return err
+}
+
+// CompleteIdempotencyKey stores the response to replay to the retries of the request
+func (pg *PostgresIdempotencyStore) CompleteIdempotencyKey(userID int, key string, record *IdempotencyRecord) error {
+	header, err := json.Marshal(record.Header)
+	if err != nil {
+		return err
+	}
+	query := `
+	UPDATE idempotency_keys
+	SET status_code=$1,header=$2,body=$3,locked_until=NULL
+	WHERE user_id=$4 AND key=$5
+	`
+	_, err = pg.db.Exec(query, record.StatusCode, header, record.Body, userID, key)
+	return err
+}
+
+// ReleaseIdempotencyKey drops the key so that a retry handles the request again
+func (pg *PostgresIdempotencyStore) ReleaseIdempotencyKey(userID int, key string) error {
+	_, err := pg.db.Exec(`DELETE FROM idempotency_keys WHERE user_id=$1 AND key=$2`, userID, key)
+	return err
+}
+
+func (pg *PostgresIdempotencyStore) DeleteExpiredIdempotencyKeys() (int64, error) {
+	result, err := pg.db.Exec(`DELETE FROM idempotency_keys WHERE expires_at<$1`, time.Now())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// DeleteOrphanedIdempotencyKeys removes the keys of deleted users, user_id has no foreign key
+// because anonymous requests are stored with 0
+func (pg *PostgresIdempotencyStore) DeleteOrphanedIdempotencyKeys() (int64, error) {
+	query := `
+	DELETE FROM idempotency_keys k
+	WHERE k.user_id<>0 AND NOT EXISTS (SELECT 1 FROM users u WHERE u.id=k.user_id)
+	`
+	result, err := pg.db.Exec(query)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,298 @@
+package store
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+)
+
+// ErrJobNotFailed is returned when retrying a job that did not run out of attempts
+var ErrJobNotFailed = errors.New("only failed jobs can be retried")
+
+// ErrJobDuplicate is returned when retrying a unique job while another job with its key is
+// queued or running
+var ErrJobDuplicate = errors.New("another job with the same unique key is queued or running")
+
+// ErrJobReclaimed is returned when a worker whose lease ran out settles its job, another
+// worker claimed the job again and owns it now
+var ErrJobReclaimed = errors.New("the job was claimed again after its lease ran out")
+
+// Job is a unit of background work of the given kind, Args holds what its handler needs
+type Job struct {
+	ID          int64           `json:"-"`
+	PublicID    string          `json:"id"`
+	Kind        string          `json:"kind"`
+	Args        json.RawMessage `json:"args"`
+	Status      string          `json:"status"`
+	Attempts    int             `json:"attempts"`
+	MaxAttempts int             `json:"max_attempts"`
+	RunAt       time.Time       `json:"run_at"`
+	LockedUntil *time.Time      `json:"locked_until"`
+	UniqueKey   *string         `json:"unique_key"`
+	LastError   *string         `json:"last_error"`
+	CreatedAt   time.Time       `json:"created_at"`
+	FinishedAt  *time.Time      `json:"finished_at"`
+}
+
+type PostgresJobStore struct {
+	db Querier
+}
+
+func NewPostgresJobStore(db Querier) *PostgresJobStore {
+	return &PostgresJobStore{db: db}
+}
+
+type JobStore interface {
+	EnqueueJob(job *Job) (bool, error)
+	ClaimJobs(kinds []string, limit int, lease time.Duration) ([]Job, error)
+	ExtendJobLease(id int64, attempt int, lease time.Duration) error
+	CompleteJob(id int64, attempt int) error
+	FailJob(id int64, attempt int, jobErr string, retryAt *time.Time) error
+	ReleaseJob(id int64, attempt int) error
+	GetJob(publicID string) (*Job, error)
+	ListJobs(status, kind string, limit, offset int) ([]Job, error)
+	RetryJob(publicID string) (*Job, error)
+	PurgeFinishedJobs(retention time.Duration) (int64, error)
+}
+
+const jobColumns = `id,public_id,kind,args,status,attempts,max_attempts,run_at,locked_until,unique_key,last_error,created_at,finished_at`
+
+func scanJob(row interface{ Scan(dest ...any) error }) (*Job, error) {
+	job := &Job{}
+	var args []byte
+	err := row.Scan(&job.ID, &job.PublicID, &job.Kind, &args, &job.Status, &job.Attempts, &job.MaxAttempts,
+		&job.RunAt, &job.LockedUntil, &job.UniqueKey, &job.LastError, &job.CreatedAt, &job.FinishedAt)
+	if err != nil {
+		return nil, err
+	}
+	job.Args = args
+	return job, nil
+}
+
+// EnqueueJob inserts the job and reports whether it did. A job with a unique key is not
+// inserted while another one with the key is queued or running, job is then that one.
+func (pg *PostgresJobStore) EnqueueJob(job *Job) (bool, error) {
+	query := `
+	INSERT INTO jobs(kind,args,max_attempts,run_at,unique_key)
+	VALUES($1,$2,$3,$4,$5)
+	ON CONFLICT (unique_key) WHERE status IN ('queued','running') DO NOTHING
+	RETURNING ` + jobColumns
+	inserted, err := scanJob(pg.db.QueryRow(query, job.Kind, []byte(job.Args), job.MaxAttempts, job.RunAt, job.UniqueKey))
+	if err == nil {
+		*job = *inserted
+		return true, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) || job.UniqueKey == nil {
+		return false, err
+	}
+
+	query = `SELECT ` + jobColumns + ` FROM jobs WHERE unique_key=$1 AND status IN ('queued','running')`
+	existing, err := scanJob(pg.db.QueryRow(query, *job.UniqueKey))
+	if errors.Is(err, sql.ErrNoRows) {
+		// the job finished in between, enqueue it again
+		return pg.EnqueueJob(job)
+	}
+	if err != nil {
+		return false, err
+	}
+	*job = *existing
+	return false, nil
+}
+
+// ClaimJobs locks up to limit due jobs of the given kinds for lease and counts the attempt.
+// Running jobs whose lease ran out belonged to a worker that is gone and are claimed again,
+// unless that was their last attempt: they are failed, a job that takes its worker down must
+// not take down one worker after the other. Workers on other instances skip the jobs locked
+// here.
+func (pg *PostgresJobStore) ClaimJobs(kinds []string, limit int, lease time.Duration) ([]Job, error) {
+	kindsJSON, err := json.Marshal(kinds)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+
+	query := `
+	UPDATE jobs
+	SET status='failed',locked_until=NULL,finished_at=CURRENT_TIMESTAMP,
+		last_error='the worker running the last attempt stopped before it finished'
+	WHERE id IN (
+		SELECT id FROM jobs
+		WHERE kind IN (SELECT jsonb_array_elements_text($1::jsonb))
+			AND status='running' AND locked_until<$2 AND attempts>=max_attempts
+		FOR UPDATE SKIP LOCKED
+	)
+	`
+	_, err = pg.db.Exec(query, string(kindsJSON), now)
+	if err != nil {
+		return nil, err
+	}
+
+	query = `
+	WITH due AS (
+		SELECT id FROM jobs
+		WHERE kind IN (SELECT jsonb_array_elements_text($1::jsonb))
+			AND ((status='queued' AND run_at<=$2)
+				OR (status='running' AND locked_until<$2 AND attempts<max_attempts))
+		ORDER BY run_at,id
+		LIMIT $3
+		FOR UPDATE SKIP LOCKED
+	)
+	UPDATE jobs j
+	SET status='running',attempts=j.attempts+1,locked_until=$4
+	FROM due
+	WHERE j.id=due.id
+	RETURNING j.id,j.public_id,j.kind,j.args,j.status,j.attempts,j.max_attempts,j.run_at,j.locked_until,
+		j.unique_key,j.last_error,j.created_at,j.finished_at
+	`
+
+	rows, err := pg.db.Query(query, string(kindsJSON), now, limit, now.Add(lease))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	jobs := []Job{}
+	for rows.Next() {
+		job, err := scanJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, *job)
+	}
+	return jobs, rows.Err()
+}
+
+// settleJob runs an update of a running job claimed for the given attempt, it returns
+// ErrJobReclaimed when the job was claimed again since
+func (pg *PostgresJobStore) settleJob(query string, args ...any) error {
+	result, err := pg.db.Exec(query, args...)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrJobReclaimed
+	}
+	return nil
+}
+
+// ExtendJobLease keeps a long running job locked by its worker
+func (pg *PostgresJobStore) ExtendJobLease(id int64, attempt int, lease time.Duration) error {
+	query := `UPDATE jobs SET locked_until=$3 WHERE id=$1 AND attempts=$2 AND status='running'`
+	return pg.settleJob(query, id, attempt, time.Now().Add(lease))
+}
+
+func (pg *PostgresJobStore) CompleteJob(id int64, attempt int) error {
+	query := `
+	UPDATE jobs
+	SET status='succeeded',locked_until=NULL,last_error=NULL,finished_at=CURRENT_TIMESTAMP
+	WHERE id=$1 AND attempts=$2 AND status='running'
+	`
+	return pg.settleJob(query, id, attempt)
+}
+
+// FailJob records the error of an attempt, the job runs again at retryAt or is failed for good
+// when retryAt is nil
+func (pg *PostgresJobStore) FailJob(id int64, attempt int, jobErr string, retryAt *time.Time) error {
+	query := `
+	UPDATE jobs
+	SET status=CASE WHEN $4::timestamptz IS NULL THEN 'failed' ELSE 'queued' END,
+		run_at=COALESCE($4,run_at),locked_until=NULL,last_error=$3,
+		finished_at=CASE WHEN $4::timestamptz IS NULL THEN CURRENT_TIMESTAMP END
+	WHERE id=$1 AND attempts=$2 AND status='running'
+	`
+	return pg.settleJob(query, id, attempt, jobErr, retryAt)
+}
+
+// ReleaseJob puts a job a worker gave up on back in the queue without counting the attempt
+func (pg *PostgresJobStore) ReleaseJob(id int64, attempt int) error {
+	query := `
+	UPDATE jobs
+	SET status='queued',attempts=GREATEST(attempts-1,0),locked_until=NULL
+	WHERE id=$1 AND attempts=$2 AND status='running'
+	`
+	return pg.settleJob(query, id, attempt)
+}
+
+func (pg *PostgresJobStore) GetJob(publicID string) (*Job, error) {
+	return scanJob(pg.db.QueryRow(`SELECT `+jobColumns+` FROM jobs WHERE public_id=$1`, publicID))
+}
+
+// ListJobs returns jobs newest first, status and kind filter them when they are not empty
+func (pg *PostgresJobStore) ListJobs(status, kind string, limit, offset int) ([]Job, error) {
+	query := `
+	SELECT ` + jobColumns + `
+	FROM jobs
+	WHERE ($1='' OR status=$1) AND ($2='' OR kind=$2)
+	ORDER BY id DESC
+	LIMIT $3 OFFSET $4
+	`
+
+	rows, err := pg.db.Query(query, status, kind, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	jobs := []Job{}
+	for rows.Next() {
+		job, err := scanJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, *job)
+	}
+	return jobs, rows.Err()
+}
+
+// RetryJob queues a failed job to run right away with a fresh set of attempts. It returns
+// ErrJobNotFailed for jobs in any other status, ErrJobDuplicate when a job with the same unique
+// key is queued or running and sql.ErrNoRows for unknown jobs.
+func (pg *PostgresJobStore) RetryJob(publicID string) (*Job, error) {
+	query := `
+	UPDATE jobs
+	SET status='queued',attempts=0,run_at=CURRENT_TIMESTAMP,finished_at=NULL
+	WHERE public_id=$1 AND status='failed'
+		AND NOT EXISTS (
+			SELECT 1 FROM jobs other
+			WHERE other.unique_key=jobs.unique_key AND other.status IN ('queued','running')
+		)
+	RETURNING ` + jobColumns
+	job, err := scanJob(pg.db.QueryRow(query, publicID))
+	if !errors.Is(err, sql.ErrNoRows) {
+		return job, err
+	}
+
+	job, err = pg.GetJob(publicID)
+	if err != nil {
+		return nil, err
+	}
+	if job.Status == JobFailed {
+		return nil, ErrJobDuplicate
+	}
+	return nil, ErrJobNotFailed
+}
+
+// PurgeFinishedJobs deletes the jobs that succeeded or failed for good longer than retention ago
+func (pg *PostgresJobStore) PurgeFinishedJobs(retention time.Duration) (int64, error) {
+	query := `
+	DELETE FROM jobs
+	WHERE status IN ('succeeded','failed') AND finished_at < $1
+	`
+	result, err := pg.db.Exec(query, time.Now().Add(-retention))
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
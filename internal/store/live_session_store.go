@@ -0,0 +1,279 @@
+package store
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+const (
+	LiveSessionActive    = "active"
+	LiveSessionFinished  = "finished"
+	LiveSessionAbandoned = "abandoned"
+)
+
+// ErrSessionActive is returned when the user starts a session while another one is running
+var ErrSessionActive = errors.New("a live session is already active")
+
+// ErrSessionEnded is returned when an event is recorded on, or an end requested for, a session
+// that is no longer active
+var ErrSessionEnded = errors.New("the live session has ended")
+
+// LiveSession is a workout in progress. WorkoutPublicID is set once it was finished.
+type LiveSession struct {
+	ID              int64      `json:"-"`
+	PublicID        string     `json:"id"`
+	UserID          int        `json:"-"`
+	UserPublicID    string     `json:"user_id"`
+	Title           string     `json:"title"`
+	Type            string     `json:"type"`
+	Status          string     `json:"status"`
+	StartedAt       time.Time  `json:"started_at"`
+	EndedAt         *time.Time `json:"ended_at"`
+	WorkoutPublicID *string    `json:"workout_id"`
+}
+
+// LiveEvent is one thing that happened during a session. Seq numbers the events of a session
+// one after the other, so subscribers can resume after the last one they saw and notice one
+// that has not reached them yet.
+type LiveEvent struct {
+	Seq       int64           `json:"seq"`
+	SessionID string          `json:"session_id"`
+	Type      string          `json:"type"`
+	Data      json.RawMessage `json:"data"`
+	At        time.Time       `json:"at"`
+}
+
+type PostgresLiveSessionStore struct {
+	db Querier
+}
+
+func NewPostgresLiveSessionStore(db Querier) *PostgresLiveSessionStore {
+	return &PostgresLiveSessionStore{db: db}
+}
+
+type LiveSessionStore interface {
+	CreateLiveSession(session *LiveSession) error
+	GetLiveSession(publicID string) (*LiveSession, error)
+	ListLiveSessions(userID int) ([]LiveSession, error)
+	AppendLiveEvent(session *LiveSession, eventType string, data json.RawMessage) (*LiveEvent, error)
+	ListLiveEvents(sessionID int64, afterSeq int64) ([]LiveEvent, error)
+	EndLiveSession(session *LiveSession, status string) error
+	ReopenLiveSession(session *LiveSession) error
+	CloseLiveSession(session *LiveSession, workoutID *int64, eventType string, data json.RawMessage) (*LiveEvent, error)
+	AddLiveSessionViewer(sessionID int64, userID int) error
+	RemoveLiveSessionViewer(sessionID int64, userID int) error
+	IsLiveSessionViewer(sessionID int64, userID int) (bool, error)
+}
+
+// CreateLiveSession starts a session, it returns ErrSessionActive when the user has one running
+func (pg *PostgresLiveSessionStore) CreateLiveSession(session *LiveSession) error {
+	query := `
+	INSERT INTO live_sessions(user_id,title,type)
+	VALUES($1,$2,$3)
+	ON CONFLICT (user_id) WHERE status='active' DO NOTHING
+	RETURNING id,public_id,status,started_at,(SELECT public_id FROM users WHERE id=$1)
+	`
+	err := pg.db.QueryRow(query, session.UserID, session.Title, session.Type).
+		Scan(&session.ID, &session.PublicID, &session.Status, &session.StartedAt, &session.UserPublicID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrSessionActive
+	}
+	return err
+}
+
+const liveSessionColumns = `s.id,s.public_id,s.user_id,u.public_id,s.title,s.type,s.status,s.started_at,s.ended_at,w.public_id`
+
+func scanLiveSession(row interface{ Scan(dest ...any) error }) (*LiveSession, error) {
+	session := &LiveSession{}
+	err := row.Scan(&session.ID, &session.PublicID, &session.UserID, &session.UserPublicID, &session.Title,
+		&session.Type, &session.Status, &session.StartedAt, &session.EndedAt, &session.WorkoutPublicID)
+	if err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+func (pg *PostgresLiveSessionStore) GetLiveSession(publicID string) (*LiveSession, error) {
+	query := `
+	SELECT ` + liveSessionColumns + `
+	FROM live_sessions s
+	JOIN users u ON u.id=s.user_id
+	LEFT JOIN workouts w ON w.id=s.workout_id
+	WHERE s.public_id=$1
+	`
+	return scanLiveSession(pg.db.QueryRow(query, publicID))
+}
+
+// ListLiveSessions returns the active sessions of the user and of the users they watch
+func (pg *PostgresLiveSessionStore) ListLiveSessions(userID int) ([]LiveSession, error) {
+	query := `
+	SELECT ` + liveSessionColumns + `
+	FROM live_sessions s
+	JOIN users u ON u.id=s.user_id
+	LEFT JOIN workouts w ON w.id=s.workout_id
+	WHERE s.status='active' AND (s.user_id=$1 OR EXISTS (
+		SELECT 1 FROM live_session_viewers v WHERE v.session_id=s.id AND v.user_id=$1
+	))
+	ORDER BY s.started_at DESC
+	`
+
+	rows, err := pg.db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []LiveSession{}
+	for rows.Next() {
+		session, err := scanLiveSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, *session)
+	}
+	return sessions, rows.Err()
+}
+
+// AppendLiveEvent records an event of an active session, it returns ErrSessionEnded otherwise.
+// Taking the next seq locks the session row, so concurrent events commit in seq order.
+func (pg *PostgresLiveSessionStore) AppendLiveEvent(session *LiveSession, eventType string, data json.RawMessage) (*LiveEvent, error) {
+	query := `
+	WITH session AS (
+		UPDATE live_sessions SET event_seq=event_seq+1
+		WHERE id=$1 AND status='active'
+		RETURNING id,event_seq
+	)
+	INSERT INTO live_session_events(session_id,seq,type,data)
+	SELECT id,event_seq,$2,$3 FROM session
+	RETURNING seq,created_at
+	`
+	event := &LiveEvent{SessionID: session.PublicID, Type: eventType, Data: data}
+	err := pg.db.QueryRow(query, session.ID, eventType, []byte(data)).Scan(&event.Seq, &event.At)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrSessionEnded
+	}
+	if err != nil {
+		return nil, err
+	}
+	return event, nil
+}
+
+// ListLiveEvents returns the events of the session after the given seq, oldest first
+func (pg *PostgresLiveSessionStore) ListLiveEvents(sessionID int64, afterSeq int64) ([]LiveEvent, error) {
+	query := `
+	SELECT e.seq,s.public_id,e.type,e.data,e.created_at
+	FROM live_session_events e
+	JOIN live_sessions s ON s.id=e.session_id
+	WHERE e.session_id=$1 AND e.seq>$2
+	ORDER BY e.seq
+	`
+
+	rows, err := pg.db.Query(query, sessionID, afterSeq)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []LiveEvent{}
+	for rows.Next() {
+		var event LiveEvent
+		var data []byte
+		err := rows.Scan(&event.Seq, &event.SessionID, &event.Type, &data, &event.At)
+		if err != nil {
+			return nil, err
+		}
+		event.Data = data
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
+
+// EndLiveSession moves an active session to the given status. It returns ErrSessionEnded when
+// the session already ended, so two devices finishing at once create a single workout.
+func (pg *PostgresLiveSessionStore) EndLiveSession(session *LiveSession, status string) error {
+	query := `
+	UPDATE live_sessions SET status=$1,ended_at=CURRENT_TIMESTAMP
+	WHERE id=$2 AND status='active'
+	RETURNING ended_at
+	`
+	err := pg.db.QueryRow(query, status, session.ID).Scan(&session.EndedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrSessionEnded
+	}
+	if err != nil {
+		return err
+	}
+	session.Status = status
+	return nil
+}
+
+// ReopenLiveSession makes an ended session active again, used when the workout it should have
+// produced could not be created
+func (pg *PostgresLiveSessionStore) ReopenLiveSession(session *LiveSession) error {
+	_, err := pg.db.Exec(`UPDATE live_sessions SET status='active',ended_at=NULL WHERE id=$1`, session.ID)
+	if err != nil {
+		return err
+	}
+	session.Status = LiveSessionActive
+	session.EndedAt = nil
+	return nil
+}
+
+// CloseLiveSession links an ended session to the workout it became, if any, and records the
+// last event of the session in the same transaction
+func (pg *PostgresLiveSessionStore) CloseLiveSession(session *LiveSession, workoutID *int64, eventType string, data json.RawMessage) (*LiveEvent, error) {
+	tx, err := begin(pg.db)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if workoutID != nil {
+		query := `
+		UPDATE live_sessions SET workout_id=$1 WHERE id=$2
+		RETURNING (SELECT public_id FROM workouts WHERE id=$1)
+		`
+		err = tx.QueryRow(query, *workoutID, session.ID).Scan(&session.WorkoutPublicID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	event := &LiveEvent{SessionID: session.PublicID, Type: eventType, Data: data}
+	query := `
+	WITH session AS (
+		UPDATE live_sessions SET event_seq=event_seq+1 WHERE id=$1 RETURNING id,event_seq
+	)
+	INSERT INTO live_session_events(session_id,seq,type,data)
+	SELECT id,event_seq,$2,$3 FROM session
+	RETURNING seq,created_at
+	`
+	err = tx.QueryRow(query, session.ID, eventType, []byte(data)).Scan(&event.Seq, &event.At)
+	if err != nil {
+		return nil, err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return event, nil
+}
+
+func (pg *PostgresLiveSessionStore) AddLiveSessionViewer(sessionID int64, userID int) error {
+	_, err := pg.db.Exec(`INSERT INTO live_session_viewers(session_id,user_id) VALUES($1,$2) ON CONFLICT DO NOTHING`, sessionID, userID)
+	return err
+}
+
+func (pg *PostgresLiveSessionStore) RemoveLiveSessionViewer(sessionID int64, userID int) error {
+	_, err := pg.db.Exec(`DELETE FROM live_session_viewers WHERE session_id=$1 AND user_id=$2`, sessionID, userID)
+	return err
+}
+
+func (pg *PostgresLiveSessionStore) IsLiveSessionViewer(sessionID int64, userID int) (bool, error) {
+	var exists bool
+	err := pg.db.QueryRow(`SELECT EXISTS (SELECT 1 FROM live_session_viewers WHERE session_id=$1 AND user_id=$2)`,
+		sessionID, userID).Scan(&exists)
+	return exists, err
+}
@@ -0,0 +1,116 @@
+package store
+
+import (
+	"database/sql"
+	"time"
+)
+
+// PlannedWorkout is a session the user intends to do, calendars show it as tentative
+type PlannedWorkout struct {
+	ID                int64     `json:"-"`
+	PublicID          string    `json:"id"`
+	UserID            int       `json:"-"`
+	Title             string    `json:"title"`
+	Type              string    `json:"type"`
+	Notes             string    `json:"notes"`
+	ScheduledAt       time.Time `json:"scheduled_at"`
+	DurationInMinutes int       `json:"duration"`
+	UpdatedAt         time.Time `json:"updated_at"`
+}
+
+type PostgresPlannedWorkoutStore struct {
+	db Querier
+}
+
+func NewPostgresPlannedWorkoutStore(db Querier) *PostgresPlannedWorkoutStore {
+	return &PostgresPlannedWorkoutStore{db: db}
+}
+
+type PlannedWorkoutStore interface {
+	CreatePlannedWorkout(planned *PlannedWorkout) error
+	GetPlannedWorkout(userID int, publicID string) (*PlannedWorkout, error)
+	ListPlannedWorkouts(userID int, from, to time.Time) ([]PlannedWorkout, error)
+	UpdatePlannedWorkout(planned *PlannedWorkout) error
+	DeletePlannedWorkout(userID int, publicID string) error
+}
+
+const plannedWorkoutColumns = `id,public_id,user_id,title,type,notes,scheduled_at,duration,updated_at`
+
+func scanPlannedWorkout(row interface{ Scan(dest ...any) error }) (*PlannedWorkout, error) {
+	planned := &PlannedWorkout{}
+	err := row.Scan(&planned.ID, &planned.PublicID, &planned.UserID, &planned.Title, &planned.Type, &planned.Notes,
+		&planned.ScheduledAt, &planned.DurationInMinutes, &planned.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return planned, nil
+}
+
+func (pg *PostgresPlannedWorkoutStore) CreatePlannedWorkout(planned *PlannedWorkout) error {
+	query := `
+	INSERT INTO planned_workouts(user_id,title,type,notes,scheduled_at,duration)
+	VALUES($1,$2,$3,$4,$5,$6)
+	RETURNING id,public_id,updated_at
+	`
+	return pg.db.QueryRow(query, planned.UserID, planned.Title, planned.Type, planned.Notes, planned.ScheduledAt,
+		planned.DurationInMinutes).Scan(&planned.ID, &planned.PublicID, &planned.UpdatedAt)
+}
+
+// GetPlannedWorkout returns sql.ErrNoRows when the session does not exist or belongs to someone else
+func (pg *PostgresPlannedWorkoutStore) GetPlannedWorkout(userID int, publicID string) (*PlannedWorkout, error) {
+	query := `SELECT ` + plannedWorkoutColumns + ` FROM planned_workouts WHERE user_id=$1 AND public_id=$2`
+	return scanPlannedWorkout(pg.db.QueryRow(query, userID, publicID))
+}
+
+// ListPlannedWorkouts returns the sessions scheduled in [from, to), soonest first
+func (pg *PostgresPlannedWorkoutStore) ListPlannedWorkouts(userID int, from, to time.Time) ([]PlannedWorkout, error) {
+	query := `
+	SELECT ` + plannedWorkoutColumns + `
+	FROM planned_workouts
+	WHERE user_id=$1 AND scheduled_at>=$2 AND scheduled_at<$3
+	ORDER BY scheduled_at,id
+	`
+
+	rows, err := pg.db.Query(query, userID, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	plans := []PlannedWorkout{}
+	for rows.Next() {
+		planned, err := scanPlannedWorkout(rows)
+		if err != nil {
+			return nil, err
+		}
+		plans = append(plans, *planned)
+	}
+	return plans, rows.Err()
+}
+
+func (pg *PostgresPlannedWorkoutStore) UpdatePlannedWorkout(planned *PlannedWorkout) error {
+	query := `
+	UPDATE planned_workouts
+	SET title=$1,type=$2,notes=$3,scheduled_at=$4,duration=$5,updated_at=CURRENT_TIMESTAMP
+	WHERE id=$6 AND user_id=$7
+	RETURNING updated_at
+	`
+	return pg.db.QueryRow(query, planned.Title, planned.Type, planned.Notes, planned.ScheduledAt, planned.DurationInMinutes,
+		planned.ID, planned.UserID).Scan(&planned.UpdatedAt)
+}
+
+func (pg *PostgresPlannedWorkoutStore) DeletePlannedWorkout(userID int, publicID string) error {
+	result, err := pg.db.Exec(`DELETE FROM planned_workouts WHERE user_id=$1 AND public_id=$2`, userID, publicID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
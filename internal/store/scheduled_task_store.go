@@ -0,0 +1,67 @@
+package store
+
+import (
+	"time"
+)
+
+// ScheduledTaskRun is the last run of a scheduled task, Error is nil when it succeeded
+type ScheduledTaskRun struct {
+	Name       string    `json:"name"`
+	Schedule   string    `json:"schedule"`
+	StartedAt  time.Time `json:"last_started_at"`
+	FinishedAt time.Time `json:"last_finished_at"`
+	Error      *string   `json:"last_error"`
+	NextRunAt  time.Time `json:"next_run_at"`
+	RanOn      string    `json:"ran_on"`
+}
+
+type PostgresScheduledTaskStore struct {
+	db Querier
+}
+
+func NewPostgresScheduledTaskStore(db Querier) *PostgresScheduledTaskStore {
+	return &PostgresScheduledTaskStore{db: db}
+}
+
+type ScheduledTaskStore interface {
+	SaveScheduledTaskRun(run *ScheduledTaskRun) error
+	ListScheduledTaskRuns() ([]ScheduledTaskRun, error)
+}
+
+// SaveScheduledTaskRun replaces the last run of the task
+func (pg *PostgresScheduledTaskStore) SaveScheduledTaskRun(run *ScheduledTaskRun) error {
+	query := `
+	INSERT INTO scheduled_task_runs(name,schedule,started_at,finished_at,error,next_run_at,ran_on)
+	VALUES($1,$2,$3,$4,$5,$6,$7)
+	ON CONFLICT (name) DO UPDATE
+	SET schedule=EXCLUDED.schedule,started_at=EXCLUDED.started_at,finished_at=EXCLUDED.finished_at,
+		error=EXCLUDED.error,next_run_at=EXCLUDED.next_run_at,ran_on=EXCLUDED.ran_on
+	`
+	_, err := pg.db.Exec(query, run.Name, run.Schedule, run.StartedAt, run.FinishedAt, run.Error, run.NextRunAt, run.RanOn)
+	return err
+}
+
+func (pg *PostgresScheduledTaskStore) ListScheduledTaskRuns() ([]ScheduledTaskRun, error) {
+	query := `
+	SELECT name,schedule,started_at,finished_at,error,next_run_at,ran_on
+	FROM scheduled_task_runs
+	ORDER BY name
+	`
+
+	rows, err := pg.db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	runs := []ScheduledTaskRun{}
+	for rows.Next() {
+		var run ScheduledTaskRun
+		err := rows.Scan(&run.Name, &run.Schedule, &run.StartedAt, &run.FinishedAt, &run.Error, &run.NextRunAt, &run.RanOn)
+		if err != nil {
+			return nil, err
+		}
+		runs = append(runs, run)
+	}
+	return runs, rows.Err()
+}
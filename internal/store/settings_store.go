@@ -0,0 +1,151 @@
+package store
+
+import (
+	"time"
+)
+
+const (
+	UnitsMetric   = "metric"
+	UnitsImperial = "imperial"
+
+	WeekStartMonday   = "monday"
+	WeekStartSunday   = "sunday"
+	WeekStartSaturday = "saturday"
+)
+
+var weekStartDays = map[string]time.Weekday{
+	WeekStartMonday:   time.Monday,
+	WeekStartSunday:   time.Sunday,
+	WeekStartSaturday: time.Saturday,
+}
+
+func IsValidWeekStart(weekStart string) bool {
+	_, ok := weekStartDays[weekStart]
+	return ok
+}
+
+// Settings are the preferences of a user, every day, week and month the API buckets by
+// follows Timezone and WeekStart
+type Settings struct {
+	UserID                     int       `json:"-"`
+	Timezone                   string    `json:"timezone"`
+	Locale                     string    `json:"locale"`
+	Units                      string    `json:"units"`
+	WeekStart                  string    `json:"week_start"`
+	DefaultWorkoutVisibility   string    `json:"default_workout_visibility"`
+	DefaultChallengeVisibility string    `json:"default_challenge_visibility"`
+	UpdatedAt                  time.Time `json:"updated_at"`
+}
+
+// DefaultSettings are the settings of a user who never changed them
+func DefaultSettings(userID int) *Settings {
+	return &Settings{
+		UserID:                     userID,
+		Timezone:                   "UTC",
+		Locale:                     "en-US",
+		Units:                      UnitsMetric,
+		WeekStart:                  WeekStartMonday,
+		DefaultWorkoutVisibility:   VisibilityPrivate,
+		DefaultChallengeVisibility: ParticipantPublic,
+	}
+}
+
+// Location returns the timezone, falling back to UTC for unknown names
+func (s *Settings) Location() *time.Location {
+	loc, err := time.LoadLocation(s.Timezone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+// FirstWeekday is the day weeks start on, monday unless set otherwise
+func (s *Settings) FirstWeekday() time.Weekday {
+	day, ok := weekStartDays[s.WeekStart]
+	if !ok {
+		return time.Monday
+	}
+	return day
+}
+
+// StartOfWeek returns midnight of the first day of the week containing t, in the user's timezone
+func (s *Settings) StartOfWeek(t time.Time) time.Time {
+	local := t.In(s.Location())
+	day := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, local.Location())
+	return day.AddDate(0, 0, -((int(day.Weekday()) - int(s.FirstWeekday()) + 7) % 7))
+}
+
+type PostgresSettingsStore struct {
+	db Querier
+}
+
+func NewPostgresSettingsStore(db Querier) *PostgresSettingsStore {
+	return &PostgresSettingsStore{db: db}
+}
+
+type SettingsStore interface {
+	GetSettings(userID int) (*Settings, error)
+	UpdateSettings(settings *Settings) error
+}
+
+// GetSettings returns the settings of the user, with the defaults for anything never set.
+// It returns sql.ErrNoRows when the user does not exist.
+func (pg *PostgresSettingsStore) GetSettings(userID int) (*Settings, error) {
+	defaults := DefaultSettings(userID)
+	query := `
+	SELECT u.timezone,
+		COALESCE(s.locale,$2),COALESCE(s.units,$3),COALESCE(s.week_start,$4),
+		COALESCE(s.default_workout_visibility,$5),COALESCE(s.default_challenge_visibility,$6),
+		COALESCE(s.updated_at,u.updated_at)
+	FROM users u
+	LEFT JOIN user_settings s ON s.user_id=u.id
+	WHERE u.id=$1
+	`
+
+	settings := &Settings{UserID: userID}
+	err := pg.db.QueryRow(query, userID, defaults.Locale, defaults.Units, defaults.WeekStart,
+		defaults.DefaultWorkoutVisibility, defaults.DefaultChallengeVisibility).Scan(
+		&settings.Timezone, &settings.Locale, &settings.Units, &settings.WeekStart,
+		&settings.DefaultWorkoutVisibility, &settings.DefaultChallengeVisibility, &settings.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return settings, nil
+}
+
+// UpdateSettings saves every setting, the timezone on the user and the rest in user_settings
+func (pg *PostgresSettingsStore) UpdateSettings(settings *Settings) error {
+	tx, err := begin(pg.db)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`UPDATE users SET timezone=$1,updated_at=CURRENT_TIMESTAMP WHERE id=$2`, settings.Timezone, settings.UserID)
+	if err != nil {
+		return err
+	}
+
+	query := `
+	INSERT INTO user_settings(user_id,locale,units,week_start,default_workout_visibility,default_challenge_visibility)
+	VALUES($1,$2,$3,$4,$5,$6)
+	ON CONFLICT (user_id) DO UPDATE
+	SET locale=EXCLUDED.locale,units=EXCLUDED.units,week_start=EXCLUDED.week_start,
+		default_workout_visibility=EXCLUDED.default_workout_visibility,
+		default_challenge_visibility=EXCLUDED.default_challenge_visibility,
+		updated_at=CURRENT_TIMESTAMP
+	RETURNING updated_at
+	`
+	err = tx.QueryRow(query, settings.UserID, settings.Locale, settings.Units, settings.WeekStart,
+		settings.DefaultWorkoutVisibility, settings.DefaultChallengeVisibility).Scan(&settings.UpdatedAt)
+	if err != nil {
+		return err
+	}
+
+	// the timezone is part of the user
+	err = queueUserWebhook(tx, settings.UserID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
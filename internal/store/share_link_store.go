@@ -0,0 +1,132 @@
+package store
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"strings"
+	"time"
+)
+
+type ShareLink struct {
+	ID        int        `json:"-"`
+	Slug      string     `json:"slug"`
+	WorkoutID int        `json:"-"`
+	CreatedBy int        `json:"-"`
+	CreatedAt time.Time  `json:"created_at"`
+	RevokedAt *time.Time `json:"revoked_at"`
+}
+
+type PostgresShareLinkStore struct {
+	db Querier
+}
+
+func NewPostgresShareLinkStore(db Querier) *PostgresShareLinkStore {
+	return &PostgresShareLinkStore{db: db}
+}
+
+type ShareLinkStore interface {
+	CreateShareLink(workoutID int64, userID int) (*ShareLink, error)
+	ListShareLinks(workoutID int64) ([]ShareLink, error)
+	RevokeShareLink(workoutID int64, slug string) error
+	GetWorkoutIDBySlug(slug string) (int64, error)
+}
+
+// generateSlug returns a random, url safe identifier that cannot be guessed from the workout id
+func generateSlug() (string, error) {
+	randomBytes := make([]byte, 16)
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return "", err
+	}
+	slug := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes)
+	return strings.ToLower(slug), nil
+}
+
+func (pg *PostgresShareLinkStore) CreateShareLink(workoutID int64, userID int) (*ShareLink, error) {
+	slug, err := generateSlug()
+	if err != nil {
+		return nil, err
+	}
+
+	link := &ShareLink{
+		Slug:      slug,
+		WorkoutID: int(workoutID),
+		CreatedBy: userID,
+	}
+
+	query := `
+	INSERT INTO workout_share_links(slug,workout_id,created_by)
+	VALUES($1,$2,$3)
+	RETURNING id,created_at
+	`
+	err = pg.db.QueryRow(query, link.Slug, workoutID, userID).Scan(&link.ID, &link.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return link, nil
+}
+
+func (pg *PostgresShareLinkStore) ListShareLinks(workoutID int64) ([]ShareLink, error) {
+	query := `
+	SELECT id,slug,workout_id,created_by,created_at,revoked_at
+	FROM workout_share_links
+	WHERE workout_id=$1
+	ORDER BY created_at DESC
+	`
+
+	rows, err := pg.db.Query(query, workoutID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	links := []ShareLink{}
+	for rows.Next() {
+		var link ShareLink
+		err := rows.Scan(&link.ID, &link.Slug, &link.WorkoutID, &link.CreatedBy, &link.CreatedAt, &link.RevokedAt)
+		if err != nil {
+			return nil, err
+		}
+		links = append(links, link)
+	}
+	return links, rows.Err()
+}
+
+func (pg *PostgresShareLinkStore) RevokeShareLink(workoutID int64, slug string) error {
+	query := `
+	UPDATE workout_share_links
+	SET revoked_at=CURRENT_TIMESTAMP
+	WHERE workout_id=$1 AND slug=$2 AND revoked_at IS NULL
+	`
+
+	result, err := pg.db.Exec(query, workoutID, slug)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (pg *PostgresShareLinkStore) GetWorkoutIDBySlug(slug string) (int64, error) {
+	var workoutID int64
+	query := `
+	SELECT workout_id
+	FROM workout_share_links
+	WHERE slug=$1 AND revoked_at IS NULL
+	`
+
+	err := pg.db.QueryRow(query, slug).Scan(&workoutID)
+	if err != nil {
+		return 0, err
+	}
+	return workoutID, nil
+}
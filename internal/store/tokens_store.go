@@ -1,17 +1,16 @@
 package store
 
 import (
-	"database/sql"
 	"time"
 
 	"github.com/kodega2016/femapi/internal/tokens"
 )
 
 type PostgresTokenStore struct {
-	db *sql.DB
+	db Querier
 }
 
-func NewPostgresTokenStore(db *sql.DB) *PostgresTokenStore {
+func NewPostgresTokenStore(db Querier) *PostgresTokenStore {
 	return &PostgresTokenStore{
 		db: db,
 	}
@@ -21,6 +20,7 @@ type TokenStore interface {
 	Insert(*tokens.Token) error
 	CreateNewToken(userID int, ttl time.Duration, scope string) (*tokens.Token, error)
 	DeleteAllTokensForUser(userID int, scope string) error
+	DeleteExpiredTokens() (int64, error)
 }
 
 func (t *PostgresTokenStore) CreateNewToken(userID int, ttl time.Duration, scope string) (*tokens.Token, error) {
@@ -52,3 +52,12 @@ func (t *PostgresTokenStore) DeleteAllTokensForUser(userID int, scope string) er
 	_, err := t.db.Exec(query, scope, userID)
 	return err
 }
+
+// DeleteExpiredTokens removes the tokens past their expiry, GetUserToken ignores them already
+func (t *PostgresTokenStore) DeleteExpiredTokens() (int64, error) {
+	result, err := t.db.Exec(`DELETE FROM tokens WHERE expiry<$1`, time.Now())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,95 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"sync/atomic"
+)
+
+// Querier is what the stores run their statements on: the *sql.DB of the pool, or a *sql.Tx
+// when everything they write has to commit or roll back together
+type Querier interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+// tx is a transaction a store began on its Querier
+type tx interface {
+	Querier
+	Commit() error
+	Rollback() error
+}
+
+// savepoints numbers the savepoints, their names only have to be unique within a transaction
+var savepoints atomic.Int64
+
+// begin starts a transaction on db. When db already is a transaction a savepoint is started
+// instead, committing it releases the savepoint and rolling it back undoes only what was
+// written since.
+func begin(db Querier) (tx, error) {
+	switch db := db.(type) {
+	case *sql.DB:
+		sqlTx, err := db.Begin()
+		if err != nil {
+			return nil, err
+		}
+		return sqlTx, nil
+	case *sql.Tx:
+		name := fmt.Sprintf("sp_%d", savepoints.Add(1))
+		_, err := db.Exec("SAVEPOINT " + name)
+		if err != nil {
+			return nil, err
+		}
+		return &savepoint{tx: db, name: name}, nil
+	default:
+		return nil, fmt.Errorf("begin: cannot start a transaction on %T", db)
+	}
+}
+
+type savepoint struct {
+	tx   *sql.Tx
+	name string
+	done bool
+}
+
+func (s *savepoint) Exec(query string, args ...any) (sql.Result, error) {
+	return s.tx.Exec(query, args...)
+}
+
+func (s *savepoint) Query(query string, args ...any) (*sql.Rows, error) {
+	return s.tx.Query(query, args...)
+}
+
+func (s *savepoint) QueryRow(query string, args ...any) *sql.Row {
+	return s.tx.QueryRow(query, args...)
+}
+
+// Commit releases the savepoint. Releasing fails when a statement since the savepoint failed,
+// the savepoint is then still there to be rolled back.
+func (s *savepoint) Commit() error {
+	if s.done {
+		return sql.ErrTxDone
+	}
+	_, err := s.tx.Exec("RELEASE SAVEPOINT " + s.name)
+	if err != nil {
+		return err
+	}
+	s.done = true
+	return nil
+}
+
+// Rollback undoes the savepoint, like sql.Tx it is a no-op error after Commit so it can be
+// deferred
+func (s *savepoint) Rollback() error {
+	if s.done {
+		return sql.ErrTxDone
+	}
+	s.done = true
+	_, err := s.tx.Exec("ROLLBACK TO SAVEPOINT " + s.name)
+	if err != nil {
+		return err
+	}
+	_, err = s.tx.Exec("RELEASE SAVEPOINT " + s.name)
+	return err
+}
@@ -0,0 +1,125 @@
+package store
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func countWorkouts(t *testing.T, q Querier, userID int) int {
+	t.Helper()
+	var count int
+	require.NoError(t, q.QueryRow(`SELECT COUNT(*) FROM workouts WHERE user_id=$1`, userID).Scan(&count))
+	return count
+}
+
+func TestStoresOnATransactionUseSavepoints(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	_, err := db.Exec("TRUNCATE users CASCADE")
+	require.NoError(t, err)
+	user := createTestUser(t, db, "tx_user")
+
+	tx, err := db.Begin()
+	require.NoError(t, err)
+	defer tx.Rollback()
+	workoutStore := NewPostgresWorkoutStore(tx)
+
+	_, err = workoutStore.CreateWorkout(&Workout{UserID: user.ID, Title: "leg day", DurationInMinutes: 45, CaloriesBurned: 300})
+	require.NoError(t, err)
+
+	// the entry breaks a constraint halfway through: only this workout is undone and the
+	// transaction stays usable
+	_, err = workoutStore.CreateWorkout(&Workout{
+		UserID:            user.ID,
+		Title:             "broken",
+		DurationInMinutes: 30,
+		CaloriesBurned:    100,
+		Entries:           []WorkoutEntry{{ExerciseName: "Squat", ExerciseSets: 5, OrderIndex: 1}},
+	})
+	require.Error(t, err)
+	assert.Equal(t, 1, countWorkouts(t, tx, user.ID))
+
+	_, err = workoutStore.CreateWorkout(&Workout{UserID: user.ID, Title: "push day", DurationInMinutes: 60, CaloriesBurned: 250})
+	require.NoError(t, err)
+	assert.Equal(t, 2, countWorkouts(t, tx, user.ID))
+	assert.Zero(t, countWorkouts(t, db, user.ID), "nothing is visible before the transaction commits")
+
+	require.NoError(t, tx.Commit())
+	assert.Equal(t, 2, countWorkouts(t, db, user.ID))
+}
+
+func TestStoresOnARolledBackTransactionWriteNothing(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	_, err := db.Exec("TRUNCATE users CASCADE")
+	require.NoError(t, err)
+	user := createTestUser(t, db, "tx_user")
+
+	tx, err := db.Begin()
+	require.NoError(t, err)
+	_, err = NewPostgresWorkoutStore(tx).CreateWorkout(&Workout{UserID: user.ID, Title: "leg day", DurationInMinutes: 45, CaloriesBurned: 300})
+	require.NoError(t, err)
+	require.NoError(t, tx.Rollback())
+
+	assert.Zero(t, countWorkouts(t, db, user.ID))
+}
+
+func TestSavepointRollbackAfterCommitIsANoOp(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	tx, err := db.Begin()
+	require.NoError(t, err)
+	defer tx.Rollback()
+
+	sp, err := begin(tx)
+	require.NoError(t, err)
+	require.NoError(t, sp.Commit())
+	assert.ErrorIs(t, sp.Rollback(), sql.ErrTxDone)
+	assert.ErrorIs(t, sp.Commit(), sql.ErrTxDone)
+
+	// the transaction is still usable
+	var one int
+	require.NoError(t, tx.QueryRow(`SELECT 1`).Scan(&one))
+}
+
+// brokenListener runs a failing statement on the transaction of the change
+type brokenListener struct {
+	db    Querier
+	calls int
+}
+
+func (l *brokenListener) WorkoutChanged(event WorkoutEvent) {
+	l.calls++
+	_, _ = l.db.Exec(`INSERT INTO workouts(user_id,title) VALUES(-1,'nobody')`)
+}
+
+func TestAFailingListenerDoesNotAbortTheTransaction(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	_, err := db.Exec("TRUNCATE users CASCADE")
+	require.NoError(t, err)
+	user := createTestUser(t, db, "tx_user")
+
+	tx, err := db.Begin()
+	require.NoError(t, err)
+	defer tx.Rollback()
+	listener := &brokenListener{db: tx}
+	workoutStore := NewPostgresWorkoutStore(tx)
+	workoutStore.AddListener(listener)
+
+	_, err = workoutStore.CreateWorkout(&Workout{UserID: user.ID, Title: "leg day", DurationInMinutes: 45, CaloriesBurned: 300})
+	require.NoError(t, err)
+	_, err = workoutStore.CreateWorkout(&Workout{UserID: user.ID, Title: "push day", DurationInMinutes: 60, CaloriesBurned: 250})
+	require.NoError(t, err)
+	assert.Equal(t, 2, listener.calls)
+
+	require.NoError(t, tx.Commit())
+	assert.Equal(t, 2, countWorkouts(t, db, user.ID))
+}
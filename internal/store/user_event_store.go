@@ -0,0 +1,92 @@
+package store
+
+import (
+	"time"
+)
+
+type PostgresUserEventStore struct {
+	db Querier
+}
+
+func NewPostgresUserEventStore(db Querier) *PostgresUserEventStore {
+	return &PostgresUserEventStore{db: db}
+}
+
+type UserEventStore interface {
+	ListUserEvents(userID int, afterSeq int64, limit int) ([]UserEvent, error)
+	LatestUserEventSeq(userID int) (int64, error)
+	UserEventsPurgedAfter(userID int, seq int64) (bool, error)
+	PurgeUserEvents(retention time.Duration) (int64, error)
+}
+
+// ListUserEvents returns up to limit events of the user logged after afterSeq, oldest first.
+// An event still to commit always has a higher seq than the committed ones, so resuming after
+// the last seq read never skips it.
+func (pg *PostgresUserEventStore) ListUserEvents(userID int, afterSeq int64, limit int) ([]UserEvent, error) {
+	query := `
+	SELECT seq,type,workout_id,version,created_at
+	FROM user_events
+	WHERE user_id=$1 AND seq>$2
+	ORDER BY seq
+	LIMIT $3
+	`
+
+	rows, err := pg.db.Query(query, userID, afterSeq, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []UserEvent{}
+	for rows.Next() {
+		var event UserEvent
+		err := rows.Scan(&event.Seq, &event.Type, &event.WorkoutID, &event.Version, &event.At)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
+
+// LatestUserEventSeq is where a new stream starts, 0 when nothing was logged for the user yet.
+// It is read from the counter on the user row, the event itself may have been purged.
+func (pg *PostgresUserEventStore) LatestUserEventSeq(userID int) (int64, error) {
+	return latestUserEventSeq(pg.db, userID)
+}
+
+func latestUserEventSeq(q Querier, userID int) (int64, error) {
+	var seq int64
+	err := q.QueryRow(`SELECT event_seq FROM users WHERE id=$1`, userID).Scan(&seq)
+	return seq, err
+}
+
+// UserEventsPurgedAfter reports whether events logged after seq were purged, a reader resuming
+// there would miss them
+func (pg *PostgresUserEventStore) UserEventsPurgedAfter(userID int, seq int64) (bool, error) {
+	return userEventsPurgedAfter(pg.db, userID, seq)
+}
+
+// userEventsPurgedAfter relies on the seqs of a user having no gaps: when later events were
+// logged but the next one is gone, it was purged
+func userEventsPurgedAfter(q Querier, userID int, seq int64) (bool, error) {
+	query := `
+	SELECT u.event_seq>$2 AND NOT EXISTS(SELECT 1 FROM user_events e WHERE e.user_id=u.id AND e.seq=$2+1)
+	FROM users u
+	WHERE u.id=$1
+	`
+
+	var purged bool
+	err := q.QueryRow(query, userID, seq).Scan(&purged)
+	return purged, err
+}
+
+// PurgeUserEvents deletes the events logged longer than retention ago. Streams and sync tokens
+// that still point before them have to start over.
+func (pg *PostgresUserEventStore) PurgeUserEvents(retention time.Duration) (int64, error) {
+	result, err := pg.db.Exec(`DELETE FROM user_events WHERE created_at < $1`, time.Now().Add(-retention))
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,125 @@
+package store
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUserEventsCommitInSeqOrder(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	_, err := db.Exec("TRUNCATE users CASCADE")
+	require.NoError(t, err)
+
+	user := createTestUser(t, db, "events_user")
+	workoutStore := NewPostgresWorkoutStore(db)
+	eventStore := NewPostgresUserEventStore(db)
+
+	workout, err := workoutStore.CreateWorkout(&Workout{
+		UserID:            user.ID,
+		Title:             "leg day",
+		DurationInMinutes: 45,
+		CaloriesBurned:    300,
+	})
+	require.NoError(t, err)
+
+	last, err := eventStore.LatestUserEventSeq(user.ID)
+	require.NoError(t, err)
+	require.Equal(t, int64(1), last)
+
+	// two devices change the workouts of the user at the same time
+	first, err := db.Begin()
+	require.NoError(t, err)
+	defer first.Rollback()
+	require.NoError(t, logWorkoutEvent(first, WorkoutUpdated, int64(workout.ID)))
+
+	secondDone := make(chan error, 1)
+	go func() {
+		second, err := db.Begin()
+		if err != nil {
+			secondDone <- err
+			return
+		}
+		defer second.Rollback()
+		err = logWorkoutEvent(second, WorkoutDeleted, int64(workout.ID))
+		if err == nil {
+			err = second.Commit()
+		}
+		secondDone <- err
+	}()
+
+	// the second change waits for the first instead of committing a later seq before it
+	select {
+	case err := <-secondDone:
+		t.Fatalf("second transaction committed before the first: %v", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+	events, err := eventStore.ListUserEvents(user.ID, last, 10)
+	require.NoError(t, err)
+	assert.Empty(t, events)
+
+	require.NoError(t, first.Commit())
+	require.NoError(t, <-secondDone)
+
+	events, err = eventStore.ListUserEvents(user.ID, last, 10)
+	require.NoError(t, err)
+	require.Len(t, events, 2)
+	assert.Equal(t, int64(2), events[0].Seq)
+	assert.Equal(t, WorkoutUpdated, events[0].Type)
+	assert.Equal(t, int64(3), events[1].Seq)
+	assert.Equal(t, WorkoutDeleted, events[1].Type)
+}
+
+func TestPurgedUserEventsExpireStreamsAndSyncTokens(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	_, err := db.Exec("TRUNCATE users CASCADE")
+	require.NoError(t, err)
+
+	user := createTestUser(t, db, "events_user")
+	workoutStore := NewPostgresWorkoutStore(db)
+	eventStore := NewPostgresUserEventStore(db)
+
+	for _, title := range []string{"leg day", "push day"} {
+		_, err = workoutStore.CreateWorkout(&Workout{UserID: user.ID, Title: title, DurationInMinutes: 45, CaloriesBurned: 300})
+		require.NoError(t, err)
+	}
+	purged, err := eventStore.UserEventsPurgedAfter(user.ID, 0)
+	require.NoError(t, err)
+	assert.False(t, purged)
+
+	// the first event outlived its retention
+	_, err = db.Exec(`UPDATE user_events SET created_at=created_at-INTERVAL '2 days' WHERE user_id=$1 AND seq=1`, user.ID)
+	require.NoError(t, err)
+	count, err := eventStore.PurgeUserEvents(24 * time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+
+	purged, err = eventStore.UserEventsPurgedAfter(user.ID, 0)
+	require.NoError(t, err)
+	assert.True(t, purged, "the reader never saw the purged event")
+	purged, err = eventStore.UserEventsPurgedAfter(user.ID, 1)
+	require.NoError(t, err)
+	assert.False(t, purged)
+	purged, err = eventStore.UserEventsPurgedAfter(user.ID, 2)
+	require.NoError(t, err)
+	assert.False(t, purged, "nothing was logged after the last seq")
+
+	_, _, _, err = workoutStore.ListSyncChanges(user.ID, SyncToken{Seq: 0})
+	assert.ErrorIs(t, err, ErrSyncTokenExpired)
+	changes, _, _, err := workoutStore.ListSyncChanges(user.ID, SyncToken{Seq: 1})
+	require.NoError(t, err)
+	assert.Len(t, changes, 1)
+
+	// the latest seq is kept on the user even once every event is purged
+	_, err = db.Exec(`DELETE FROM user_events WHERE user_id=$1`, user.ID)
+	require.NoError(t, err)
+	latest, err := eventStore.LatestUserEventSeq(user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), latest)
+}
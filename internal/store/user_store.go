@@ -6,6 +6,7 @@ import (
 	"errors"
 	"time"
 
+	"github.com/kodega2016/femapi/internal/publicid"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -41,15 +42,27 @@ func (p *password) Matches(plaintextPassword string) (bool, error) {
 }
 
 type User struct {
-	ID           int       `json:"id"`
+	ID           int       `json:"-"`
+	PublicID     string    `json:"id"`
 	Username     string    `json:"username"`
 	Email        string    `json:"email"`
 	PasswordHash password  `json:"-"`
 	Bio          string    `json:"bio"`
+	Timezone     string    `json:"timezone"`
+	IsAdmin      bool      `json:"-"`
 	CreatedAt    time.Time `json:"created_at"`
 	UpdatedAt    time.Time `json:"updated_at"`
 }
 
+// Follower is someone who asked to follow a user, the request is pending until ApprovedAt is set
+type Follower struct {
+	UserID      int        `json:"-"`
+	PublicID    string     `json:"id"`
+	Username    string     `json:"username"`
+	RequestedAt time.Time  `json:"requested_at"`
+	ApprovedAt  *time.Time `json:"approved_at"`
+}
+
 var AnonymousUser = &User{}
 
 func (u *User) IsAnonymous() bool {
@@ -57,10 +70,10 @@ func (u *User) IsAnonymous() bool {
 }
 
 type PostgresUserStrore struct {
-	db *sql.DB
+	db Querier
 }
 
-func NewPostgresUserStore(db *sql.DB) *PostgresUserStrore {
+func NewPostgresUserStore(db Querier) *PostgresUserStrore {
 	return &PostgresUserStrore{
 		db: db,
 	}
@@ -71,16 +84,26 @@ type UserStore interface {
 	GetUserByUsername(username string) (*User, error)
 	UpdateUser(*User) error
 	GetUserToken(scope, tokenPlainText string) (*User, error)
+	GetUserByID(id int) (*User, error)
+	FollowUser(followerID, followeeID int) (approved bool, err error)
+	UnfollowUser(followerID, followeeID int) error
+	IsFollowing(followerID, followeeID int) (bool, error)
+	ListFollowers(followeeID int, pending bool) ([]Follower, error)
+	ApproveFollower(followeeID, followerID int) error
+	ResolveUserID(ref publicid.Ref) (int, error)
 }
 
 func (s *PostgresUserStrore) CreateUser(user *User) error {
 	query := `
-	INSERT INTO users(username,email,password_hash,bio)
-	VALUES($1,$2,$3,$4)
-	RETURNING id,created_at,updated_at
+	INSERT INTO users(username,email,password_hash,bio,timezone)
+	VALUES($1,$2,$3,$4,$5)
+	RETURNING id,public_id,created_at,updated_at
 	`
 
-	err := s.db.QueryRow(query, user.Username, user.Email, user.PasswordHash.hash, user.Bio).Scan(&user.ID, &user.CreatedAt, &user.UpdatedAt)
+	if user.Timezone == "" {
+		user.Timezone = "UTC"
+	}
+	err := s.db.QueryRow(query, user.Username, user.Email, user.PasswordHash.hash, user.Bio, user.Timezone).Scan(&user.ID, &user.PublicID, &user.CreatedAt, &user.UpdatedAt)
 	if err != nil {
 		return err
 	}
@@ -94,12 +117,12 @@ func (s *PostgresUserStrore) GetUserByUsername(username string) (*User, error) {
 	}
 
 	query := `
-	SELECT id,username,email,password_hash,bio,created_at,updated_at
+	SELECT id,public_id,username,email,password_hash,bio,timezone,created_at,updated_at
 	FROM users
 	WHERE username=$1
 	`
 
-	err := s.db.QueryRow(query, username).Scan(&user.ID, &user.Username, &user.Email, &user.PasswordHash.hash, &user.Bio, &user.CreatedAt, &user.UpdatedAt)
+	err := s.db.QueryRow(query, username).Scan(&user.ID, &user.PublicID, &user.Username, &user.Email, &user.PasswordHash.hash, &user.Bio, &user.Timezone, &user.CreatedAt, &user.UpdatedAt)
 
 	if err == sql.ErrNoRows {
 		return nil, nil
@@ -113,33 +136,35 @@ func (s *PostgresUserStrore) GetUserByUsername(username string) (*User, error) {
 }
 
 func (s *PostgresUserStrore) UpdateUser(user *User) error {
+	tx, err := begin(s.db)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
 	query := `
 	UPDATE users
 	SET username=$1, email=$2,bio=$3,updated_at=CURRENT_TIMESTAMP
+	WHERE id=$4
 	RETURNING updated_at
 	`
 
-	result, err := s.db.Exec(query, user.Username, user.Email, user.Bio)
+	err = tx.QueryRow(query, user.Username, user.Email, user.Bio, user.ID).Scan(&user.UpdatedAt)
 	if err != nil {
 		return err
 	}
 
-	rowsEffected, err := result.RowsAffected()
-	if rowsEffected == 0 {
-		return sql.ErrNoRows
-	}
-
+	err = queueUserWebhook(tx, user.ID)
 	if err != nil {
 		return err
 	}
-
-	return nil
+	return tx.Commit()
 }
 
 func (s *PostgresUserStrore) GetUserToken(scope, plainText string) (*User, error) {
 	tokenHash := sha256.Sum256([]byte(plainText))
 	query := `
-	SELECT u.id,u.username,u.email,u.password_hash,u.bio,u.created_at,u.updated_at
+	SELECT u.id,u.public_id,u.username,u.email,u.password_hash,u.bio,u.timezone,u.is_admin,u.created_at,u.updated_at
 	FROM users u
 	INNER JOIN tokens t ON t.user_id=u.id
 	WHERE t.hash=$1 AND t.scope=$2 AND t.expiry > $3
@@ -150,10 +175,13 @@ func (s *PostgresUserStrore) GetUserToken(scope, plainText string) (*User, error
 	}
 	err := s.db.QueryRow(query, tokenHash[:], scope, time.Now()).Scan(
 		&user.ID,
+		&user.PublicID,
 		&user.Username,
 		&user.Email,
 		&user.PasswordHash.hash,
 		&user.Bio,
+		&user.Timezone,
+		&user.IsAdmin,
 		&user.CreatedAt,
 		&user.UpdatedAt,
 	)
@@ -166,3 +194,131 @@ func (s *PostgresUserStrore) GetUserToken(scope, plainText string) (*User, error
 	}
 	return user, nil
 }
+
+func (s *PostgresUserStrore) GetUserByID(id int) (*User, error) {
+	user := &User{
+		PasswordHash: password{},
+	}
+
+	query := `
+	SELECT id,public_id,username,email,password_hash,bio,timezone,created_at,updated_at
+	FROM users
+	WHERE id=$1
+	`
+
+	err := s.db.QueryRow(query, id).Scan(&user.ID, &user.PublicID, &user.Username, &user.Email, &user.PasswordHash.hash, &user.Bio, &user.Timezone, &user.CreatedAt, &user.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// FollowUser asks to follow a user, asking again keeps the request as it is. It reports
+// whether the followee already approved it.
+func (s *PostgresUserStrore) FollowUser(followerID, followeeID int) (bool, error) {
+	query := `
+	INSERT INTO follows(follower_id,followee_id)
+	VALUES($1,$2)
+	ON CONFLICT (follower_id,followee_id) DO UPDATE SET follower_id=EXCLUDED.follower_id
+	RETURNING approved_at IS NOT NULL
+	`
+
+	var approved bool
+	err := s.db.QueryRow(query, followerID, followeeID).Scan(&approved)
+	return approved, err
+}
+
+// UnfollowUser drops the follow or the pending request, the followee removes a follower with it too
+func (s *PostgresUserStrore) UnfollowUser(followerID, followeeID int) error {
+	query := `
+	DELETE FROM follows
+	WHERE follower_id=$1 AND followee_id=$2
+	`
+
+	_, err := s.db.Exec(query, followerID, followeeID)
+	return err
+}
+
+// IsFollowing reports whether the follower is approved, a pending request does not count
+func (s *PostgresUserStrore) IsFollowing(followerID, followeeID int) (bool, error) {
+	var exists bool
+	query := `
+	SELECT EXISTS(
+		SELECT 1 FROM follows WHERE follower_id=$1 AND followee_id=$2 AND approved_at IS NOT NULL
+	)
+	`
+
+	err := s.db.QueryRow(query, followerID, followeeID).Scan(&exists)
+	if err != nil {
+		return false, err
+	}
+	return exists, nil
+}
+
+// ListFollowers lists the approved followers of a user, or the pending requests, oldest first
+func (s *PostgresUserStrore) ListFollowers(followeeID int, pending bool) ([]Follower, error) {
+	query := `
+	SELECT u.id,u.public_id,u.username,f.created_at,f.approved_at
+	FROM follows f
+	JOIN users u ON u.id=f.follower_id
+	WHERE f.followee_id=$1 AND (f.approved_at IS NULL)=$2
+	ORDER BY f.created_at,u.id
+	`
+
+	rows, err := s.db.Query(query, followeeID, pending)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	followers := []Follower{}
+	for rows.Next() {
+		var follower Follower
+		err = rows.Scan(&follower.UserID, &follower.PublicID, &follower.Username, &follower.RequestedAt, &follower.ApprovedAt)
+		if err != nil {
+			return nil, err
+		}
+		followers = append(followers, follower)
+	}
+	return followers, rows.Err()
+}
+
+// ApproveFollower approves a follow request, approving it twice keeps the first approval.
+// Without a request it returns sql.ErrNoRows.
+func (s *PostgresUserStrore) ApproveFollower(followeeID, followerID int) error {
+	query := `
+	UPDATE follows
+	SET approved_at=COALESCE(approved_at,CURRENT_TIMESTAMP)
+	WHERE followee_id=$1 AND follower_id=$2
+	`
+
+	result, err := s.db.Exec(query, followeeID, followerID)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// ResolveUserID maps a public (or legacy numeric) reference to the internal user id
+func (s *PostgresUserStrore) ResolveUserID(ref publicid.Ref) (int, error) {
+	var id int
+	if ref.IsLegacy() {
+		err := s.db.QueryRow(`SELECT id FROM users WHERE id=$1`, ref.LegacyID).Scan(&id)
+		return id, err
+	}
+
+	err := s.db.QueryRow(`SELECT id FROM users WHERE public_id=$1`, ref.PublicID).Scan(&id)
+	return id, err
+}
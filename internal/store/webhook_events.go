@@ -0,0 +1,91 @@
+package store
+
+const (
+	PersonalRecordAchieved = "pr.achieved"
+	UserUpdated            = "user.updated"
+)
+
+// WebhookEventTypes are the events an endpoint can subscribe to
+var WebhookEventTypes = []string{
+	WorkoutCreated, WorkoutUpdated, WorkoutDeleted, WorkoutRestored, PersonalRecordAchieved, UserUpdated,
+}
+
+func IsValidWebhookEvent(eventType string) bool {
+	for _, t := range WebhookEventTypes {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// subscribedSQL holds for the users u with an active endpoint listening to the event type $2,
+// events nobody listens to are not written to the outbox
+const subscribedSQL = `EXISTS (SELECT 1 FROM webhooks h WHERE h.user_id=u.user_id AND h.active AND h.events ? $2)`
+
+// queueWorkoutWebhook writes a workout event to the outbox in the transaction of the change.
+// The payload only names the workout and its version, receivers fetch what they need.
+func queueWorkoutWebhook(tx Querier, eventType string, workoutID int64) error {
+	query := `
+	INSERT INTO webhook_outbox(user_id,type,payload)
+	SELECT u.user_id,$2,jsonb_build_object('workout_id',u.public_id,'version',u.version)
+	FROM workouts u
+	WHERE u.id=$1 AND ` + subscribedSQL + `
+	`
+	_, err := tx.Exec(query, workoutID, eventType)
+	return err
+}
+
+// queuePersonalRecordWebhook writes a pr.achieved event when the workout beats the best
+// estimated one rep max of an exercise over every earlier workout, as the achievements count
+// personal records. An update announces the records the workout still holds again.
+func queuePersonalRecordWebhook(tx Querier, workoutID int64) error {
+	query := `
+	WITH u AS (
+		SELECT id,user_id,public_id,performed_at FROM workouts WHERE id=$1 AND deleted_at IS NULL
+	), lifts AS (
+		SELECT lower(e.exercise_name) AS exercise,MIN(e.exercise_name) AS exercise_name,
+			MAX(` + oneRepMaxSQL + `) AS one_rep_max
+		FROM workout_entries e
+		WHERE e.workout_id=$1 AND ` + liftSetSQL + `
+		GROUP BY lower(e.exercise_name)
+	), records AS (
+		SELECT l.exercise_name,l.one_rep_max,previous.best
+		FROM lifts l
+		CROSS JOIN u
+		CROSS JOIN LATERAL (
+			SELECT MAX(` + oneRepMaxSQL + `) AS best
+			FROM workout_entries e
+			INNER JOIN workouts w ON w.id=e.workout_id
+			WHERE w.user_id=u.user_id AND w.deleted_at IS NULL AND lower(e.exercise_name)=l.exercise
+				AND (w.performed_at,w.id)<(u.performed_at,u.id) AND ` + liftSetSQL + `
+		) previous
+		WHERE l.one_rep_max>previous.best
+	)
+	INSERT INTO webhook_outbox(user_id,type,payload)
+	SELECT u.user_id,$2,jsonb_build_object('workout_id',u.public_id,'records',jsonb_agg(jsonb_build_object(
+		'exercise_name',r.exercise_name,
+		'one_rep_max',round(r.one_rep_max::numeric,2),
+		'previous_best',round(r.best::numeric,2),
+		'unit',$3::text
+	) ORDER BY r.exercise_name))
+	FROM u
+	CROSS JOIN records r
+	WHERE ` + subscribedSQL + `
+	GROUP BY u.user_id,u.public_id
+	`
+	_, err := tx.Exec(query, workoutID, PersonalRecordAchieved, UnitKilograms)
+	return err
+}
+
+// queueUserWebhook writes a user.updated event to the outbox in the transaction of the change
+func queueUserWebhook(tx Querier, userID int) error {
+	query := `
+	INSERT INTO webhook_outbox(user_id,type,payload)
+	SELECT u.user_id,$2,jsonb_build_object('user_id',u.public_id)
+	FROM (SELECT id AS user_id,public_id FROM users WHERE id=$1) u
+	WHERE ` + subscribedSQL + `
+	`
+	_, err := tx.Exec(query, userID, UserUpdated)
+	return err
+}
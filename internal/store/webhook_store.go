@@ -0,0 +1,459 @@
+package store
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+const (
+	DeliveryPending   = "pending"
+	DeliverySucceeded = "succeeded"
+	DeliveryDead      = "dead"
+)
+
+// ErrDeliveryReclaimed is returned when the attempt of a dispatcher whose lease ran out is
+// recorded, another one claimed the delivery again and owns it now
+var ErrDeliveryReclaimed = errors.New("the delivery was claimed again after its lease ran out")
+
+// Webhook is an endpoint events of its user are posted to. The secret is only read when the
+// endpoint is created, it is never listed.
+type Webhook struct {
+	ID        int64     `json:"-"`
+	PublicID  string    `json:"id"`
+	UserID    int       `json:"-"`
+	URL       string    `json:"url"`
+	Secret    string    `json:"secret,omitempty"`
+	Events    []string  `json:"events"`
+	Active    bool      `json:"active"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// WebhookDelivery is one event sent to one endpoint, Log holds every attempt and is only
+// loaded for a single delivery
+type WebhookDelivery struct {
+	ID             int64            `json:"-"`
+	PublicID       string           `json:"id"`
+	EventID        string           `json:"event_id"`
+	EventType      string           `json:"event_type"`
+	Payload        json.RawMessage  `json:"payload,omitempty"`
+	Status         string           `json:"status"`
+	Attempts       int              `json:"attempts"`
+	NextAttemptAt  *time.Time       `json:"next_attempt_at"`
+	ResponseStatus *int             `json:"response_status"`
+	LastError      *string          `json:"last_error"`
+	CreatedAt      time.Time        `json:"created_at"`
+	DeliveredAt    *time.Time       `json:"delivered_at"`
+	Log            []WebhookAttempt `json:"log,omitempty"`
+}
+
+type WebhookAttempt struct {
+	StatusCode  *int      `json:"status_code"`
+	Error       *string   `json:"error"`
+	DurationMS  int       `json:"duration_ms"`
+	AttemptedAt time.Time `json:"attempted_at"`
+}
+
+// DueDelivery is a delivery claimed by a dispatcher with everything needed to send it.
+// Attempt counts this attempt, together with LockedUntil it identifies the claim.
+type DueDelivery struct {
+	ID          int64
+	Attempt     int
+	LockedUntil time.Time
+	URL         string
+	Secret      string
+	EventID     string
+	EventType   string
+	Payload     json.RawMessage
+	CreatedAt   time.Time
+}
+
+// DeliveryResult is the outcome of sending a delivery, StatusCode is 0 when no response came
+// back. RetryAt is nil when the delivery is done, successfully or not.
+type DeliveryResult struct {
+	StatusCode int
+	Error      string
+	Duration   time.Duration
+	Succeeded  bool
+	RetryAt    *time.Time
+}
+
+type PostgresWebhookStore struct {
+	db Querier
+}
+
+func NewPostgresWebhookStore(db Querier) *PostgresWebhookStore {
+	return &PostgresWebhookStore{db: db}
+}
+
+type WebhookStore interface {
+	CreateWebhook(webhook *Webhook) error
+	GetWebhook(userID int, publicID string) (*Webhook, error)
+	ListWebhooks(userID int) ([]Webhook, error)
+	UpdateWebhook(webhook *Webhook) error
+	DeleteWebhook(userID int, publicID string) error
+	ListWebhookDeliveries(webhookID int64, status string, limit, offset int) ([]WebhookDelivery, error)
+	GetWebhookDelivery(webhookID int64, publicID string) (*WebhookDelivery, error)
+	RetryWebhookDelivery(webhookID int64, publicID string) error
+	QueueWebhookDeliveries(limit int) (int64, error)
+	ClaimDueDeliveries(limit int, lease time.Duration) ([]DueDelivery, error)
+	RecordDeliveryAttempt(delivery DueDelivery, result DeliveryResult) error
+	DeleteOrphanedWebhookEvents() (int64, error)
+	PurgeWebhookDeliveries(retention time.Duration) (int64, error)
+}
+
+const webhookColumns = `id,public_id,user_id,url,events,active,created_at,updated_at`
+
+func scanWebhook(row interface{ Scan(dest ...any) error }) (*Webhook, error) {
+	webhook := &Webhook{}
+	var events []byte
+	err := row.Scan(&webhook.ID, &webhook.PublicID, &webhook.UserID, &webhook.URL, &events,
+		&webhook.Active, &webhook.CreatedAt, &webhook.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(events, &webhook.Events)
+	if err != nil {
+		return nil, err
+	}
+	return webhook, nil
+}
+
+func (pg *PostgresWebhookStore) CreateWebhook(webhook *Webhook) error {
+	events, err := json.Marshal(webhook.Events)
+	if err != nil {
+		return err
+	}
+	query := `
+	INSERT INTO webhooks(user_id,url,secret,events,active)
+	VALUES($1,$2,$3,$4,$5)
+	RETURNING id,public_id,created_at,updated_at
+	`
+	return pg.db.QueryRow(query, webhook.UserID, webhook.URL, webhook.Secret, events, webhook.Active).
+		Scan(&webhook.ID, &webhook.PublicID, &webhook.CreatedAt, &webhook.UpdatedAt)
+}
+
+// GetWebhook returns sql.ErrNoRows when the endpoint does not exist or belongs to someone else
+func (pg *PostgresWebhookStore) GetWebhook(userID int, publicID string) (*Webhook, error) {
+	query := `SELECT ` + webhookColumns + ` FROM webhooks WHERE user_id=$1 AND public_id=$2`
+	return scanWebhook(pg.db.QueryRow(query, userID, publicID))
+}
+
+func (pg *PostgresWebhookStore) ListWebhooks(userID int) ([]Webhook, error) {
+	query := `SELECT ` + webhookColumns + ` FROM webhooks WHERE user_id=$1 ORDER BY id`
+
+	rows, err := pg.db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	webhooks := []Webhook{}
+	for rows.Next() {
+		webhook, err := scanWebhook(rows)
+		if err != nil {
+			return nil, err
+		}
+		webhooks = append(webhooks, *webhook)
+	}
+	return webhooks, rows.Err()
+}
+
+// UpdateWebhook saves the url, events and active flag, the secret never changes
+func (pg *PostgresWebhookStore) UpdateWebhook(webhook *Webhook) error {
+	events, err := json.Marshal(webhook.Events)
+	if err != nil {
+		return err
+	}
+	query := `
+	UPDATE webhooks
+	SET url=$1,events=$2,active=$3,updated_at=CURRENT_TIMESTAMP
+	WHERE id=$4 AND user_id=$5
+	RETURNING updated_at
+	`
+	return pg.db.QueryRow(query, webhook.URL, events, webhook.Active, webhook.ID, webhook.UserID).Scan(&webhook.UpdatedAt)
+}
+
+// DeleteWebhook removes the endpoint with its delivery log
+func (pg *PostgresWebhookStore) DeleteWebhook(userID int, publicID string) error {
+	result, err := pg.db.Exec(`DELETE FROM webhooks WHERE user_id=$1 AND public_id=$2`, userID, publicID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+const deliveryColumns = `d.id,d.public_id,o.public_id,o.type,d.status,d.attempts,
+	CASE WHEN d.status='pending' THEN d.next_attempt_at END,d.response_status,d.last_error,d.created_at,d.delivered_at`
+
+func scanDelivery(row interface{ Scan(dest ...any) error }, extra ...any) (*WebhookDelivery, error) {
+	delivery := &WebhookDelivery{}
+	dest := []any{&delivery.ID, &delivery.PublicID, &delivery.EventID, &delivery.EventType, &delivery.Status,
+		&delivery.Attempts, &delivery.NextAttemptAt, &delivery.ResponseStatus, &delivery.LastError,
+		&delivery.CreatedAt, &delivery.DeliveredAt}
+	err := row.Scan(append(dest, extra...)...)
+	if err != nil {
+		return nil, err
+	}
+	return delivery, nil
+}
+
+// ListWebhookDeliveries returns the deliveries of an endpoint newest first, status filters
+// them when it is not empty
+func (pg *PostgresWebhookStore) ListWebhookDeliveries(webhookID int64, status string, limit, offset int) ([]WebhookDelivery, error) {
+	query := `
+	SELECT ` + deliveryColumns + `
+	FROM webhook_deliveries d
+	INNER JOIN webhook_outbox o ON o.id=d.outbox_id
+	WHERE d.webhook_id=$1 AND ($2='' OR d.status=$2)
+	ORDER BY d.id DESC
+	LIMIT $3 OFFSET $4
+	`
+
+	rows, err := pg.db.Query(query, webhookID, status, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := []WebhookDelivery{}
+	for rows.Next() {
+		delivery, err := scanDelivery(rows)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, *delivery)
+	}
+	return deliveries, rows.Err()
+}
+
+// GetWebhookDelivery returns a delivery of the endpoint with its payload and every attempt
+func (pg *PostgresWebhookStore) GetWebhookDelivery(webhookID int64, publicID string) (*WebhookDelivery, error) {
+	query := `
+	SELECT ` + deliveryColumns + `,o.payload
+	FROM webhook_deliveries d
+	INNER JOIN webhook_outbox o ON o.id=d.outbox_id
+	WHERE d.webhook_id=$1 AND d.public_id=$2
+	`
+	var payload []byte
+	delivery, err := scanDelivery(pg.db.QueryRow(query, webhookID, publicID), &payload)
+	if err != nil {
+		return nil, err
+	}
+	delivery.Payload = payload
+
+	rows, err := pg.db.Query(`
+	SELECT status_code,error,duration_ms,attempted_at
+	FROM webhook_delivery_attempts
+	WHERE delivery_id=$1
+	ORDER BY id
+	`, delivery.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	delivery.Log = []WebhookAttempt{}
+	for rows.Next() {
+		var attempt WebhookAttempt
+		err := rows.Scan(&attempt.StatusCode, &attempt.Error, &attempt.DurationMS, &attempt.AttemptedAt)
+		if err != nil {
+			return nil, err
+		}
+		delivery.Log = append(delivery.Log, attempt)
+	}
+	return delivery, rows.Err()
+}
+
+// RetryWebhookDelivery sends a delivery again right away with a fresh set of attempts, a
+// delivery that already succeeded is sent once more
+func (pg *PostgresWebhookStore) RetryWebhookDelivery(webhookID int64, publicID string) error {
+	query := `
+	UPDATE webhook_deliveries
+	SET status='pending',attempts=0,next_attempt_at=CURRENT_TIMESTAMP,locked_until=NULL
+	WHERE webhook_id=$1 AND public_id=$2
+	`
+	result, err := pg.db.Exec(query, webhookID, publicID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// QueueWebhookDeliveries turns up to limit unprocessed outbox events into a delivery for every
+// active endpoint of their user listening to them. Dispatchers on other instances skip the
+// events locked here.
+func (pg *PostgresWebhookStore) QueueWebhookDeliveries(limit int) (int64, error) {
+	query := `
+	WITH events AS (
+		SELECT id FROM webhook_outbox
+		WHERE processed_at IS NULL
+		ORDER BY id
+		LIMIT $1
+		FOR UPDATE SKIP LOCKED
+	), processed AS (
+		UPDATE webhook_outbox o
+		SET processed_at=CURRENT_TIMESTAMP
+		FROM events
+		WHERE o.id=events.id
+		RETURNING o.id,o.user_id,o.type
+	)
+	INSERT INTO webhook_deliveries(webhook_id,outbox_id)
+	SELECT h.id,p.id
+	FROM processed p
+	INNER JOIN webhooks h ON h.user_id=p.user_id AND h.active AND h.events ? p.type
+	ON CONFLICT (webhook_id,outbox_id) DO NOTHING
+	`
+	result, err := pg.db.Exec(query, limit)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// ClaimDueDeliveries locks up to limit pending deliveries that are due for lease and counts the
+// attempt. A dispatcher that dies while sending leaves the lock to run out, the delivery is
+// then claimed again. Deliveries of disabled endpoints wait until they are enabled again.
+func (pg *PostgresWebhookStore) ClaimDueDeliveries(limit int, lease time.Duration) ([]DueDelivery, error) {
+	now := time.Now()
+	query := `
+	WITH due AS (
+		SELECT d.id
+		FROM webhook_deliveries d
+		INNER JOIN webhooks h ON h.id=d.webhook_id
+		WHERE d.status='pending' AND d.next_attempt_at<=$2 AND (d.locked_until IS NULL OR d.locked_until<$2)
+			AND h.active
+		ORDER BY d.next_attempt_at
+		LIMIT $1
+		FOR UPDATE OF d SKIP LOCKED
+	)
+	UPDATE webhook_deliveries d
+	SET attempts=d.attempts+1,locked_until=$3
+	FROM due,webhooks h,webhook_outbox o
+	WHERE d.id=due.id AND h.id=d.webhook_id AND o.id=d.outbox_id
+	RETURNING d.id,d.attempts,d.locked_until,h.url,h.secret,o.public_id,o.type,o.payload,o.created_at
+	`
+
+	rows, err := pg.db.Query(query, limit, now, now.Add(lease))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := []DueDelivery{}
+	for rows.Next() {
+		var delivery DueDelivery
+		var payload []byte
+		err := rows.Scan(&delivery.ID, &delivery.Attempt, &delivery.LockedUntil, &delivery.URL, &delivery.Secret, &delivery.EventID,
+			&delivery.EventType, &payload, &delivery.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		delivery.Payload = payload
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries, rows.Err()
+}
+
+// RecordDeliveryAttempt logs an attempt and settles the delivery: it succeeded, is retried at
+// result.RetryAt or is dead. It returns ErrDeliveryReclaimed and records nothing when the
+// delivery was claimed again since, the attempt of the new claim settles it.
+func (pg *PostgresWebhookStore) RecordDeliveryAttempt(delivery DueDelivery, result DeliveryResult) error {
+	tx, err := begin(pg.db)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	status := DeliveryDead
+	switch {
+	case result.Succeeded:
+		status = DeliverySucceeded
+	case result.RetryAt != nil:
+		status = DeliveryPending
+	}
+	var statusCode *int
+	if result.StatusCode != 0 {
+		statusCode = &result.StatusCode
+	}
+	var lastError *string
+	if result.Error != "" {
+		lastError = &result.Error
+	}
+
+	query := `
+	UPDATE webhook_deliveries
+	SET status=$2,response_status=$3,last_error=$4,locked_until=NULL,
+		next_attempt_at=COALESCE($5,next_attempt_at),
+		delivered_at=CASE WHEN $2='succeeded' THEN CURRENT_TIMESTAMP END
+	WHERE id=$1 AND status='pending' AND attempts=$6 AND locked_until=$7
+	`
+	updated, err := tx.Exec(query, delivery.ID, status, statusCode, lastError, result.RetryAt, delivery.Attempt, delivery.LockedUntil)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := updated.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrDeliveryReclaimed
+	}
+
+	_, err = tx.Exec(`
+	INSERT INTO webhook_delivery_attempts(delivery_id,status_code,error,duration_ms)
+	VALUES($1,$2,$3,$4)
+	`, delivery.ID, statusCode, lastError, result.Duration.Milliseconds())
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// PurgeWebhookDeliveries deletes the succeeded and dead deliveries created longer than
+// retention ago with their attempts. The events left without a delivery go with
+// DeleteOrphanedWebhookEvents.
+func (pg *PostgresWebhookStore) PurgeWebhookDeliveries(retention time.Duration) (int64, error) {
+	query := `
+	DELETE FROM webhook_deliveries
+	WHERE status<>'pending' AND created_at < $1
+	`
+	result, err := pg.db.Exec(query, time.Now().Add(-retention))
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// DeleteOrphanedWebhookEvents removes the processed outbox events no delivery refers to: no
+// endpoint listened to them anymore or their endpoints were deleted
+func (pg *PostgresWebhookStore) DeleteOrphanedWebhookEvents() (int64, error) {
+	query := `
+	DELETE FROM webhook_outbox o
+	WHERE o.processed_at IS NOT NULL
+		AND NOT EXISTS (SELECT 1 FROM webhook_deliveries d WHERE d.outbox_id=o.id)
+	`
+	result, err := pg.db.Exec(query)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
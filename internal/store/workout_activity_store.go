@@ -0,0 +1,306 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+const (
+	SportRunning  = "running"
+	SportCycling  = "cycling"
+	SportWalking  = "walking"
+	SportHiking   = "hiking"
+	SportSwimming = "swimming"
+	SportRowing   = "rowing"
+	SportOther    = "other"
+)
+
+// WorkoutTypeForSport maps a recorded sport onto a workout type, walks and hikes are logged as runs
+func WorkoutTypeForSport(sport string) string {
+	switch sport {
+	case SportCycling:
+		return WorkoutTypeRide
+	case SportSwimming:
+		return WorkoutTypeSwim
+	case SportRowing:
+		return WorkoutTypeRow
+	}
+	return WorkoutTypeRun
+}
+
+// SportForWorkoutType is the sport stored for activities entered by hand
+func SportForWorkoutType(workoutType string) string {
+	switch workoutType {
+	case WorkoutTypeRun:
+		return SportRunning
+	case WorkoutTypeRide:
+		return SportCycling
+	case WorkoutTypeSwim:
+		return SportSwimming
+	case WorkoutTypeRow:
+		return SportRowing
+	}
+	return SportOther
+}
+
+// Activity holds the cardio details of a workout, either entered by hand or summarized from
+// a recording of a gps watch or bike computer
+type Activity struct {
+	Sport               string       `json:"sport"`
+	StartedAt           *time.Time   `json:"started_at"`
+	DistanceMeters      float64      `json:"distance_meters"`
+	DurationSeconds     int          `json:"duration_seconds"`
+	MovingTimeSeconds   int          `json:"moving_time_seconds"`
+	PaceSecondsPerKm    *float64     `json:"pace_seconds_per_km"`
+	AvgSpeedKph         *float64     `json:"avg_speed_kph"`
+	ElevationGainMeters float64      `json:"elevation_gain_meters"`
+	AvgHeartRate        *int         `json:"avg_heart_rate"`
+	MaxHeartRate        *int         `json:"max_heart_rate"`
+	Splits              []Split      `json:"splits"`
+	Track               []TrackPoint `json:"-"`
+}
+
+// Split is one lap of a cardio workout, usually a kilometre
+type Split struct {
+	Number           int      `json:"number"`
+	DistanceMeters   float64  `json:"distance_meters"`
+	DurationSeconds  int      `json:"duration_seconds"`
+	PaceSecondsPerKm *float64 `json:"pace_seconds_per_km"`
+	AvgHeartRate     *int     `json:"avg_heart_rate"`
+}
+
+// TrackPoint is a single recorded sample, position and sensor values are optional
+type TrackPoint struct {
+	Time      time.Time `json:"time"`
+	Latitude  *float64  `json:"latitude"`
+	Longitude *float64  `json:"longitude"`
+	Elevation *float64  `json:"elevation"`
+	Distance  *float64  `json:"distance"`
+	HeartRate *int      `json:"heart_rate"`
+}
+
+func (p TrackPoint) HasPosition() bool {
+	return p.Latitude != nil && p.Longitude != nil
+}
+
+func paceSecondsPerKm(meters float64, seconds int) *float64 {
+	if meters <= 0 || seconds <= 0 {
+		return nil
+	}
+	pace := math.Round(float64(seconds)/meters*1000*10) / 10
+	return &pace
+}
+
+// ComputeDerived fills pace and speed from distance and moving time, and numbers the splits.
+// Moving time defaults to the elapsed duration when it was not recorded.
+func (a *Activity) ComputeDerived() {
+	if a.MovingTimeSeconds <= 0 {
+		a.MovingTimeSeconds = a.DurationSeconds
+	}
+	if a.DurationSeconds < a.MovingTimeSeconds {
+		a.DurationSeconds = a.MovingTimeSeconds
+	}
+
+	a.PaceSecondsPerKm = paceSecondsPerKm(a.DistanceMeters, a.MovingTimeSeconds)
+	a.AvgSpeedKph = nil
+	if a.DistanceMeters > 0 && a.MovingTimeSeconds > 0 {
+		speed := math.Round(a.DistanceMeters/float64(a.MovingTimeSeconds)*3.6*100) / 100
+		a.AvgSpeedKph = &speed
+	}
+
+	if a.Splits == nil {
+		a.Splits = []Split{}
+	}
+	for i := range a.Splits {
+		a.Splits[i].Number = i + 1
+		a.Splits[i].PaceSecondsPerKm = paceSecondsPerKm(a.Splits[i].DistanceMeters, a.Splits[i].DurationSeconds)
+	}
+}
+
+// trackPointBatchSize keeps a single insert well below the postgres parameter limit
+const trackPointBatchSize = 1000
+
+// saveActivity inserts or replaces the activity summary and its splits
+func saveActivity(tx Querier, workoutID int, activity *Activity) error {
+	activity.ComputeDerived()
+
+	query := `
+	INSERT INTO workout_activities(workout_id,sport,started_at,distance_meters,duration_seconds,moving_time_seconds,pace_seconds_per_km,avg_speed_kph,elevation_gain_meters,avg_heart_rate,max_heart_rate)
+	VALUES($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11)
+	ON CONFLICT (workout_id) DO UPDATE
+	SET sport=EXCLUDED.sport,started_at=EXCLUDED.started_at,distance_meters=EXCLUDED.distance_meters,
+		duration_seconds=EXCLUDED.duration_seconds,moving_time_seconds=EXCLUDED.moving_time_seconds,
+		pace_seconds_per_km=EXCLUDED.pace_seconds_per_km,avg_speed_kph=EXCLUDED.avg_speed_kph,
+		elevation_gain_meters=EXCLUDED.elevation_gain_meters,avg_heart_rate=EXCLUDED.avg_heart_rate,max_heart_rate=EXCLUDED.max_heart_rate
+	`
+	_, err := tx.Exec(query, workoutID, activity.Sport, activity.StartedAt, activity.DistanceMeters, activity.DurationSeconds, activity.MovingTimeSeconds,
+		activity.PaceSecondsPerKm, activity.AvgSpeedKph, activity.ElevationGainMeters, activity.AvgHeartRate, activity.MaxHeartRate)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`DELETE FROM workout_splits WHERE workout_id=$1`, workoutID)
+	if err != nil {
+		return err
+	}
+	for _, split := range activity.Splits {
+		_, err = tx.Exec(`
+		INSERT INTO workout_splits(workout_id,split_number,distance_meters,duration_seconds,avg_heart_rate)
+		VALUES($1,$2,$3,$4,$5)
+		`, workoutID, split.Number, split.DistanceMeters, split.DurationSeconds, split.AvgHeartRate)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteActivity drops the cardio details, used when a workout stops being a cardio workout
+func deleteActivity(tx Querier, workoutID int) error {
+	for _, table := range []string{"workout_splits", "workout_track_points", "workout_activities"} {
+		_, err := tx.Exec(`DELETE FROM `+table+` WHERE workout_id=$1`, workoutID)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func insertTrackPoints(tx Querier, workoutID int, track []TrackPoint) error {
+	for start := 0; start < len(track); start += trackPointBatchSize {
+		end := min(start+trackPointBatchSize, len(track))
+
+		values := make([]string, 0, end-start)
+		args := make([]interface{}, 0, (end-start)*8)
+		for i, point := range track[start:end] {
+			n := len(args)
+			values = append(values, fmt.Sprintf("($%d,$%d,$%d,$%d,$%d,$%d,$%d,$%d)", n+1, n+2, n+3, n+4, n+5, n+6, n+7, n+8))
+			args = append(args, workoutID, start+i, point.Time, point.Latitude, point.Longitude, point.Elevation, point.Distance, point.HeartRate)
+		}
+
+		query := `INSERT INTO workout_track_points(workout_id,seq,recorded_at,latitude,longitude,elevation_meters,distance_meters,heart_rate)
+		VALUES ` + strings.Join(values, ",")
+		_, err := tx.Exec(query, args...)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getActivity returns nil for workouts without cardio details
+func getActivity(q Querier, workoutID int64) (*Activity, error) {
+	activity := &Activity{}
+
+	query := `
+	SELECT sport,started_at,distance_meters,duration_seconds,moving_time_seconds,pace_seconds_per_km,avg_speed_kph,elevation_gain_meters,avg_heart_rate,max_heart_rate
+	FROM workout_activities
+	WHERE workout_id=$1
+	`
+	err := q.QueryRow(query, workoutID).Scan(&activity.Sport, &activity.StartedAt, &activity.DistanceMeters, &activity.DurationSeconds, &activity.MovingTimeSeconds,
+		&activity.PaceSecondsPerKm, &activity.AvgSpeedKph, &activity.ElevationGainMeters, &activity.AvgHeartRate, &activity.MaxHeartRate)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := q.Query(`
+	SELECT split_number,distance_meters,duration_seconds,avg_heart_rate
+	FROM workout_splits
+	WHERE workout_id=$1
+	ORDER BY split_number
+	`, workoutID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	activity.Splits = []Split{}
+	for rows.Next() {
+		var split Split
+		err := rows.Scan(&split.Number, &split.DistanceMeters, &split.DurationSeconds, &split.AvgHeartRate)
+		if err != nil {
+			return nil, err
+		}
+		split.PaceSecondsPerKm = paceSecondsPerKm(split.DistanceMeters, split.DurationSeconds)
+		activity.Splits = append(activity.Splits, split)
+	}
+	return activity, rows.Err()
+}
+
+func (pg *PostgresWorkoutStore) GetWorkoutTrack(workoutID int64) ([]TrackPoint, error) {
+	query := `
+	SELECT recorded_at,latitude,longitude,elevation_meters,distance_meters,heart_rate
+	FROM workout_track_points
+	WHERE workout_id=$1
+	ORDER BY seq
+	`
+
+	rows, err := pg.db.Query(query, workoutID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	points := []TrackPoint{}
+	for rows.Next() {
+		var point TrackPoint
+		err := rows.Scan(&point.Time, &point.Latitude, &point.Longitude, &point.Elevation, &point.Distance, &point.HeartRate)
+		if err != nil {
+			return nil, err
+		}
+		points = append(points, point)
+	}
+	return points, rows.Err()
+}
+
+// WeeklyTotal sums the workouts of one type in the week starting on WeekStart, the local
+// midnight of the first day of the week
+type WeeklyTotal struct {
+	WeekStart         time.Time `json:"week_start"`
+	Workouts          int       `json:"workouts"`
+	DistanceMeters    float64   `json:"distance_meters"`
+	MovingTimeSeconds int       `json:"moving_time_seconds"`
+	DurationMinutes   int       `json:"duration"`
+}
+
+// WeeklyTotals returns the totals per week since the given time, oldest first. Workouts count
+// in the week they were performed, weeks start on the first weekday of the settings at local
+// midnight.
+func (pg *PostgresWorkoutStore) WeeklyTotals(userID int, workoutType string, since time.Time, settings *Settings) ([]WeeklyTotal, error) {
+	// date_trunc weeks start on monday, shifting by the days from the first weekday to the
+	// next monday moves the boundary
+	shift := (8 - int(settings.FirstWeekday())) % 7
+	query := `
+	SELECT (date_trunc('week',(w.performed_at AT TIME ZONE $4)+make_interval(days=>$5))-make_interval(days=>$5)) AT TIME ZONE $4 AS week,
+		COUNT(*),COALESCE(SUM(a.distance_meters),0),COALESCE(SUM(a.moving_time_seconds),0),SUM(w.duration)
+	FROM workouts w
+	LEFT JOIN workout_activities a ON a.workout_id=w.id
+	WHERE w.user_id=$1 AND w.type=$2 AND w.deleted_at IS NULL AND w.performed_at>=$3
+	GROUP BY week
+	ORDER BY week
+	`
+
+	rows, err := pg.db.Query(query, userID, workoutType, since, settings.Location().String(), shift)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	totals := []WeeklyTotal{}
+	for rows.Next() {
+		var total WeeklyTotal
+		err := rows.Scan(&total.WeekStart, &total.Workouts, &total.DistanceMeters, &total.MovingTimeSeconds, &total.DurationMinutes)
+		if err != nil {
+			return nil, err
+		}
+		totals = append(totals, total)
+	}
+	return totals, rows.Err()
+}
@@ -0,0 +1,134 @@
+package store
+
+// FieldChange describes a single field that differs between two revisions
+type FieldChange struct {
+	Field string `json:"field"`
+	From  any    `json:"from"`
+	To    any    `json:"to"`
+}
+
+type EntryChange struct {
+	ID           string        `json:"id"`
+	ExerciseName string        `json:"exercise_name"`
+	Changes      []FieldChange `json:"changes"`
+}
+
+// WorkoutDiff is the structured difference between two workout revisions
+type WorkoutDiff struct {
+	FromRevision   int            `json:"from_revision"`
+	ToRevision     int            `json:"to_revision"`
+	Changes        []FieldChange  `json:"changes"`
+	EntriesAdded   []WorkoutEntry `json:"entries_added"`
+	EntriesRemoved []WorkoutEntry `json:"entries_removed"`
+	EntriesChanged []EntryChange  `json:"entries_changed"`
+}
+
+func intPtrValue(p *int) any {
+	if p == nil {
+		return nil
+	}
+	return *p
+}
+
+func floatPtrValue(p *float32) any {
+	if p == nil {
+		return nil
+	}
+	return *p
+}
+
+func appendChange(changes []FieldChange, field string, from, to any) []FieldChange {
+	if from == to {
+		return changes
+	}
+	return append(changes, FieldChange{Field: field, From: from, To: to})
+}
+
+// diffEntry lists the fields of an entry that differ, ignoring its ids
+func diffEntry(from, to WorkoutEntry) []FieldChange {
+	changes := []FieldChange{}
+	changes = appendChange(changes, "exercise_name", from.ExerciseName, to.ExerciseName)
+	changes = appendChange(changes, "exercise_sets", from.ExerciseSets, to.ExerciseSets)
+	changes = appendChange(changes, "reps", intPtrValue(from.Reps), intPtrValue(to.Reps))
+	changes = appendChange(changes, "duration_seconds", intPtrValue(from.DurationSeconds), intPtrValue(to.DurationSeconds))
+	changes = appendChange(changes, "weight", floatPtrValue(from.Weight), floatPtrValue(to.Weight))
+	changes = appendChange(changes, "notes", from.Notes, to.Notes)
+	changes = appendChange(changes, "order_index", from.OrderIndex, to.OrderIndex)
+	return changes
+}
+
+// DiffWorkouts compares two workout snapshots. Entries are matched by their id first and
+// then by their position, so entries that were re-created on update are still compared.
+func DiffWorkouts(from, to *Workout) WorkoutDiff {
+	diff := WorkoutDiff{
+		Changes:        []FieldChange{},
+		EntriesAdded:   []WorkoutEntry{},
+		EntriesRemoved: []WorkoutEntry{},
+		EntriesChanged: []EntryChange{},
+	}
+
+	diff.Changes = appendChange(diff.Changes, "title", from.Title, to.Title)
+	diff.Changes = appendChange(diff.Changes, "description", from.Description, to.Description)
+	diff.Changes = appendChange(diff.Changes, "duration", from.DurationInMinutes, to.DurationInMinutes)
+	diff.Changes = appendChange(diff.Changes, "calories_burned", from.CaloriesBurned, to.CaloriesBurned)
+	diff.Changes = appendChange(diff.Changes, "visibility", from.Visibility, to.Visibility)
+	// snapshots taken before workout types existed carry no type
+	if from.Type != "" && to.Type != "" {
+		diff.Changes = appendChange(diff.Changes, "type", from.Type, to.Type)
+	}
+	// nor do they carry a time when taken before performed_at existed
+	if !from.PerformedAt.IsZero() && !to.PerformedAt.IsZero() && !from.PerformedAt.Equal(to.PerformedAt) {
+		diff.Changes = append(diff.Changes, FieldChange{Field: "performed_at", From: from.PerformedAt, To: to.PerformedAt})
+	}
+
+	matched := make(map[int]int) // index in to.Entries -> index in from.Entries
+	usedFrom := make(map[int]bool)
+
+	for toIdx, toEntry := range to.Entries {
+		for fromIdx, fromEntry := range from.Entries {
+			if !usedFrom[fromIdx] && toEntry.PublicID != "" && toEntry.PublicID == fromEntry.PublicID {
+				matched[toIdx] = fromIdx
+				usedFrom[fromIdx] = true
+				break
+			}
+		}
+	}
+
+	for toIdx, toEntry := range to.Entries {
+		if _, ok := matched[toIdx]; ok {
+			continue
+		}
+		for fromIdx, fromEntry := range from.Entries {
+			if !usedFrom[fromIdx] && toEntry.OrderIndex == fromEntry.OrderIndex {
+				matched[toIdx] = fromIdx
+				usedFrom[fromIdx] = true
+				break
+			}
+		}
+	}
+
+	for toIdx, toEntry := range to.Entries {
+		fromIdx, ok := matched[toIdx]
+		if !ok {
+			diff.EntriesAdded = append(diff.EntriesAdded, toEntry)
+			continue
+		}
+
+		changes := diffEntry(from.Entries[fromIdx], toEntry)
+		if len(changes) > 0 {
+			diff.EntriesChanged = append(diff.EntriesChanged, EntryChange{
+				ID:           toEntry.PublicID,
+				ExerciseName: toEntry.ExerciseName,
+				Changes:      changes,
+			})
+		}
+	}
+
+	for fromIdx, fromEntry := range from.Entries {
+		if !usedFrom[fromIdx] {
+			diff.EntriesRemoved = append(diff.EntriesRemoved, fromEntry)
+		}
+	}
+
+	return diff
+}
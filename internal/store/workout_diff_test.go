@@ -0,0 +1,66 @@
+package store
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiffWorkouts(t *testing.T) {
+	from := &Workout{
+		Title:             "push day",
+		DurationInMinutes: 60,
+		CaloriesBurned:    300,
+		Visibility:        VisibilityPrivate,
+		Entries: []WorkoutEntry{
+			{PublicID: "a", ExerciseName: "Bench Press", ExerciseSets: 3, Reps: IntPtr(10), Weight: FloatPtr(80), OrderIndex: 1},
+			{PublicID: "b", ExerciseName: "Dips", ExerciseSets: 3, Reps: IntPtr(12), OrderIndex: 2},
+		},
+	}
+	to := &Workout{
+		Title:             "push day",
+		DurationInMinutes: 75,
+		CaloriesBurned:    300,
+		Visibility:        VisibilityPublic,
+		Entries: []WorkoutEntry{
+			{PublicID: "a", ExerciseName: "Bench Press", ExerciseSets: 3, Reps: IntPtr(8), Weight: FloatPtr(85), OrderIndex: 1},
+			{PublicID: "c", ExerciseName: "Push Ups", ExerciseSets: 2, Reps: IntPtr(20), OrderIndex: 3},
+		},
+	}
+
+	diff := DiffWorkouts(from, to)
+
+	assert.Equal(t, []FieldChange{
+		{Field: "duration", From: 60, To: 75},
+		{Field: "visibility", From: VisibilityPrivate, To: VisibilityPublic},
+	}, diff.Changes)
+
+	require.Len(t, diff.EntriesChanged, 1)
+	assert.Equal(t, "a", diff.EntriesChanged[0].ID)
+	assert.Equal(t, []FieldChange{
+		{Field: "reps", From: 10, To: 8},
+		{Field: "weight", From: float32(80), To: float32(85)},
+	}, diff.EntriesChanged[0].Changes)
+
+	require.Len(t, diff.EntriesAdded, 1)
+	assert.Equal(t, "Push Ups", diff.EntriesAdded[0].ExerciseName)
+	require.Len(t, diff.EntriesRemoved, 1)
+	assert.Equal(t, "Dips", diff.EntriesRemoved[0].ExerciseName)
+}
+
+func TestDiffWorkoutsMatchesRecreatedEntriesByPosition(t *testing.T) {
+	from := &Workout{Entries: []WorkoutEntry{
+		{PublicID: "old", ExerciseName: "Squat", ExerciseSets: 5, Reps: IntPtr(5), OrderIndex: 1},
+	}}
+	to := &Workout{Entries: []WorkoutEntry{
+		{PublicID: "new", ExerciseName: "Squat", ExerciseSets: 5, Reps: IntPtr(5), OrderIndex: 1},
+	}}
+
+	diff := DiffWorkouts(from, to)
+
+	assert.Empty(t, diff.Changes)
+	assert.Empty(t, diff.EntriesAdded)
+	assert.Empty(t, diff.EntriesRemoved)
+	assert.Empty(t, diff.EntriesChanged)
+}
@@ -0,0 +1,109 @@
+package store
+
+import (
+	"database/sql"
+	"time"
+)
+
+const (
+	WorkoutCreated  = "workout.created"
+	WorkoutUpdated  = "workout.updated"
+	WorkoutDeleted  = "workout.deleted"
+	WorkoutRestored = "workout.restored"
+)
+
+// WorkoutEvent describes a committed change to the workouts of one user. Imports create many
+// workouts at once and report them in a single event.
+type WorkoutEvent struct {
+	Type       string
+	UserID     int
+	WorkoutIDs []int64
+}
+
+// WorkoutListener is told about every change after it is committed, so whatever is derived
+// from the workouts can be recomputed. Listeners run synchronously on the request goroutine
+// and can not fail the change, they log their own errors. When the store is on a transaction
+// every listener runs in a savepoint of it, what a failing listener wrote is rolled back
+// without aborting the transaction.
+type WorkoutListener interface {
+	WorkoutChanged(event WorkoutEvent)
+}
+
+// AddListener registers a listener, it must be called before the store is used
+func (pg *PostgresWorkoutStore) AddListener(listener WorkoutListener) {
+	pg.listeners = append(pg.listeners, listener)
+}
+
+func (pg *PostgresWorkoutStore) notify(eventType string, userID int, workoutIDs ...int64) {
+	event := WorkoutEvent{Type: eventType, UserID: userID, WorkoutIDs: workoutIDs}
+	for _, listener := range pg.listeners {
+		pg.notifyListener(listener, event)
+	}
+}
+
+func (pg *PostgresWorkoutStore) notifyListener(listener WorkoutListener, event WorkoutEvent) {
+	if _, ok := pg.db.(*sql.Tx); !ok {
+		listener.WorkoutChanged(event)
+		return
+	}
+
+	// the transaction is already aborted, the change it belongs to is going to fail anyway
+	sp, err := begin(pg.db)
+	if err != nil {
+		return
+	}
+	listener.WorkoutChanged(event)
+	// releasing fails once a statement of the listener failed, rolling back to the savepoint
+	// makes the transaction usable again
+	if sp.Commit() != nil {
+		_ = sp.Rollback()
+	}
+}
+
+// UserEventsChannel is notified with the id of the user whenever a change was logged for them
+const UserEventsChannel = "user_events"
+
+// UserEvent is an entry of the log of workout changes a user's clients sync from. Seq numbers
+// the events of one user in the order they committed. Version is the version of the workout
+// after the change.
+type UserEvent struct {
+	Seq       int64     `json:"-"`
+	Type      string    `json:"type"`
+	WorkoutID string    `json:"workout_id"`
+	Version   int       `json:"version"`
+	At        time.Time `json:"at"`
+}
+
+// logWorkoutEvent appends a change to the event log of the workout owner and queues it for
+// their webhooks. It runs in the transaction of the change: a rolled back change is never
+// logged and the NOTIFY is only delivered once the change is committed. Taking the next seq
+// locks the owner's row until then, so concurrent changes of one user log their events one
+// after the other and commit in seq order.
+func logWorkoutEvent(tx Querier, eventType string, workoutID int64) error {
+	query := `
+	WITH owner AS (
+		UPDATE users SET event_seq=event_seq+1
+		WHERE id=(SELECT user_id FROM workouts WHERE id=$1)
+		RETURNING id,event_seq
+	), logged AS (
+		INSERT INTO user_events(user_id,seq,type,workout_id,version)
+		SELECT owner.id,owner.event_seq,$2,w.public_id,w.version
+		FROM owner INNER JOIN workouts w ON w.id=$1
+		RETURNING user_id
+	)
+	SELECT pg_notify($3,user_id::text) FROM logged
+	`
+	_, err := tx.Exec(query, workoutID, eventType, UserEventsChannel)
+	if err != nil {
+		return err
+	}
+
+	err = queueWorkoutWebhook(tx, eventType, workoutID)
+	if err != nil {
+		return err
+	}
+	if eventType == WorkoutCreated || eventType == WorkoutUpdated {
+		return queuePersonalRecordWebhook(tx, workoutID)
+	}
+	return nil
+}
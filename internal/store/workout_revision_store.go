@@ -0,0 +1,110 @@
+package store
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// WorkoutRevision is an immutable snapshot of a workout taken after every change
+type WorkoutRevision struct {
+	Revision  int       `json:"revision"`
+	ChangedBy *string   `json:"changed_by"`
+	CreatedAt time.Time `json:"created_at"`
+	Workout   *Workout  `json:"workout,omitempty"`
+}
+
+// ensureBaselineRevision records the current state of a workout that has no revisions yet.
+// The workout row is locked first so concurrent updates cannot race on the revision number.
+func ensureBaselineRevision(tx Querier, workoutID int64) error {
+	var id int64
+	err := tx.QueryRow(`SELECT id FROM workouts WHERE id=$1 AND deleted_at IS NULL FOR UPDATE`, workoutID).Scan(&id)
+	if err != nil {
+		return err
+	}
+
+	var exists bool
+	err = tx.QueryRow(`SELECT EXISTS(SELECT 1 FROM workout_revisions WHERE workout_id=$1)`, workoutID).Scan(&exists)
+	if err != nil || exists {
+		return err
+	}
+
+	workout, err := getWorkout(tx, workoutID)
+	if err != nil {
+		return err
+	}
+	return insertRevision(tx, workout, workout.UserID)
+}
+
+// recordRevision snapshots the workout as it currently is inside tx
+func recordRevision(tx Querier, workoutID int64, changedBy int) error {
+	workout, err := getWorkout(tx, workoutID)
+	if err != nil {
+		return err
+	}
+	return insertRevision(tx, workout, changedBy)
+}
+
+func insertRevision(tx Querier, workout *Workout, changedBy int) error {
+	snapshot, err := json.Marshal(workout)
+	if err != nil {
+		return err
+	}
+
+	query := `
+	INSERT INTO workout_revisions(workout_id,revision,snapshot,changed_by)
+	SELECT $1,COALESCE(MAX(revision),0)+1,$2,$3
+	FROM workout_revisions
+	WHERE workout_id=$1
+	`
+	_, err = tx.Exec(query, workout.ID, string(snapshot), changedBy)
+	return err
+}
+
+func (pg *PostgresWorkoutStore) ListWorkoutRevisions(workoutID int64) ([]WorkoutRevision, error) {
+	query := `
+	SELECT r.revision,u.public_id,r.created_at
+	FROM workout_revisions r
+	LEFT JOIN users u ON u.id=r.changed_by
+	WHERE r.workout_id=$1
+	ORDER BY r.revision DESC
+	`
+
+	rows, err := pg.db.Query(query, workoutID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	revisions := []WorkoutRevision{}
+	for rows.Next() {
+		var revision WorkoutRevision
+		err := rows.Scan(&revision.Revision, &revision.ChangedBy, &revision.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, revision)
+	}
+	return revisions, rows.Err()
+}
+
+func (pg *PostgresWorkoutStore) GetWorkoutRevision(workoutID int64, revision int) (*WorkoutRevision, error) {
+	query := `
+	SELECT r.revision,u.public_id,r.created_at,r.snapshot
+	FROM workout_revisions r
+	LEFT JOIN users u ON u.id=r.changed_by
+	WHERE r.workout_id=$1 AND r.revision=$2
+	`
+
+	result := &WorkoutRevision{}
+	var snapshot []byte
+	err := pg.db.QueryRow(query, workoutID, revision).Scan(&result.Revision, &result.ChangedBy, &result.CreatedAt, &snapshot)
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(snapshot, &result.Workout)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
@@ -2,20 +2,84 @@ package store
 
 import (
 	"database/sql"
+	"errors"
+	"math"
+	"time"
+
+	"github.com/kodega2016/femapi/internal/publicid"
+)
+
+const (
+	VisibilityPrivate   = "private"
+	VisibilityFollowers = "followers"
+	VisibilityPublic    = "public"
+	VisibilityUnlisted  = "unlisted"
+)
+
+// IsValidVisibility reports whether v is one of the supported workout visibility levels
+func IsValidVisibility(v string) bool {
+	switch v {
+	case VisibilityPrivate, VisibilityFollowers, VisibilityPublic, VisibilityUnlisted:
+		return true
+	}
+	return false
+}
+
+const (
+	WorkoutTypeStrength = "strength"
+	WorkoutTypeRun      = "run"
+	WorkoutTypeRide     = "ride"
+	WorkoutTypeSwim     = "swim"
+	WorkoutTypeRow      = "row"
+	WorkoutTypeHIIT     = "hiit"
+	WorkoutTypeYoga     = "yoga"
 )
 
+func IsValidWorkoutType(t string) bool {
+	switch t {
+	case WorkoutTypeStrength, WorkoutTypeRun, WorkoutTypeRide, WorkoutTypeSwim, WorkoutTypeRow, WorkoutTypeHIIT, WorkoutTypeYoga:
+		return true
+	}
+	return false
+}
+
+// IsCardioType reports whether workouts of type t are distance based and carry activity details
+func IsCardioType(t string) bool {
+	switch t {
+	case WorkoutTypeRun, WorkoutTypeRide, WorkoutTypeSwim, WorkoutTypeRow:
+		return true
+	}
+	return false
+}
+
+// ErrEntryNotFound is returned when an update references an entry id that is not part of the workout
+var ErrEntryNotFound = errors.New("workout entry not found")
+
+// ErrEditConflict is returned when a workout was changed since the version the caller read
+var ErrEditConflict = errors.New("edit conflict")
+
 type Workout struct {
-	ID                int            `json:"id"`
+	ID                int            `json:"-"`
+	PublicID          string         `json:"id"`
 	Title             string         `json:"title"`
-	UserID            int            `json:"user_id"`
+	UserID            int            `json:"-"`
+	UserPublicID      string         `json:"user_id"`
 	Description       string         `json:"description"`
 	CaloriesBurned    int            `json:"calories_burned"`
+	CaloriesEstimated bool           `json:"calories_estimated"`
 	DurationInMinutes int            `json:"duration"`
+	Type              string         `json:"type"`
+	Visibility        string         `json:"visibility"`
+	PerformedAt       time.Time      `json:"performed_at"`
+	Version           int            `json:"version"`
+	DeletedAt         *time.Time     `json:"deleted_at,omitempty"`
+	Activity          *Activity      `json:"activity,omitempty"`
 	Entries           []WorkoutEntry `json:"entries"`
 }
 
 type WorkoutEntry struct {
-	ID              int      `json:"id"`
+	ID              int      `json:"-"`
+	PublicID        string   `json:"id"`
 	ExerciseName    string   `json:"exercise_name"`
 	ExerciseSets    int      `json:"exercise_sets"`
 	Reps            *int     `json:"reps"`
@@ -26,85 +90,419 @@ type WorkoutEntry struct {
 }
 
 type PostgresWorkoutStore struct {
-	db *sql.DB
+	db        Querier
+	listeners []WorkoutListener
 }
 
-func NewPostgresWorkoutStore(db *sql.DB) *PostgresWorkoutStore {
+func NewPostgresWorkoutStore(db Querier) *PostgresWorkoutStore {
 	return &PostgresWorkoutStore{db: db}
 }
 
 type WorkoutStore interface {
 	CreateWorkout(*Workout) (*Workout, error)
 	GetWorkoutByID(id int64) (*Workout, error)
-	UpdateWorkout(*Workout) error
-	DeleteWorkout(id int64) error
+	UpdateWorkout(workout *Workout, changedBy int) error
+	DeleteWorkout(id int64, version int) error
 	GetWorkoutOwner(id int64) (int, error)
+	ResolveWorkoutID(ref publicid.Ref) (int64, error)
+	ListTrashedWorkouts(userID int) ([]Workout, error)
+	RestoreWorkout(ref publicid.Ref, userID int) (int64, error)
+	PurgeTrashedWorkouts(retention time.Duration) (int64, error)
+	ListWorkoutRevisions(workoutID int64) ([]WorkoutRevision, error)
+	GetWorkoutRevision(workoutID int64, revision int) (*WorkoutRevision, error)
+	CreateWorkouts(workouts []*Workout) error
+	StreamWorkoutsForUser(userID int, fn func(*Workout) error) error
+	ListExerciseNames(userID int) ([]string, error)
+	GetWorkoutTrack(workoutID int64) ([]TrackPoint, error)
+	WeeklyTotals(userID int, workoutType string, since time.Time, settings *Settings) ([]WeeklyTotal, error)
+	BestLifts(userID int, since time.Time) ([]Lift, error)
+	ListWorkoutSummaries(userID int, from, to time.Time) ([]WorkoutSummary, error)
+	ApplySyncChange(change SyncChange, base *Workout, validate func(*Workout) error) (bool, error)
+	ListSyncChanges(userID int, token SyncToken) ([]SyncedWorkout, SyncToken, bool, error)
+	PurgeSyncMutations(retention time.Duration) (int64, error)
 }
 
 func (pg *PostgresWorkoutStore) CreateWorkout(workout *Workout) (*Workout, error) {
-	tx, err := pg.db.Begin()
+	tx, err := begin(pg.db)
 	if err != nil {
 		return nil, err
 	}
 
 	defer tx.Rollback()
 
-	query := `INSERT INTO workouts(user_id,title,description,duration,calories_burned)
-		VALUES($1,$2,$3,$4,$5)
-		RETURNING id
-	`
-	err = tx.QueryRow(query, workout.UserID, workout.Title, workout.Description, workout.DurationInMinutes, workout.CaloriesBurned).Scan(&workout.ID)
+	err = insertWorkout(tx, workout)
 	if err != nil {
 		return nil, err
 	}
+	err = logWorkoutEvent(tx, WorkoutCreated, int64(workout.ID))
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	pg.notify(WorkoutCreated, workout.UserID, int64(workout.ID))
+	return workout, nil
+}
+
+// CreateWorkouts inserts all workouts in a single transaction, either all of them are created or none
+func (pg *PostgresWorkoutStore) CreateWorkouts(workouts []*Workout) error {
+	tx, err := begin(pg.db)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	for _, workout := range workouts {
+		err = insertWorkout(tx, workout)
+		if err != nil {
+			return err
+		}
+		err = logWorkoutEvent(tx, WorkoutCreated, int64(workout.ID))
+		if err != nil {
+			return err
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	// one event per user, imports only ever hold workouts of the uploader
+	created := make(map[int][]int64)
+	var users []int
+	for _, workout := range workouts {
+		if _, ok := created[workout.UserID]; !ok {
+			users = append(users, workout.UserID)
+		}
+		created[workout.UserID] = append(created[workout.UserID], int64(workout.ID))
+	}
+	for _, userID := range users {
+		pg.notify(WorkoutCreated, userID, created[userID]...)
+	}
+	return nil
+}
+
+// defaultPerformedAt is when a workout logged without a time was done: the start of its
+// recorded activity, otherwise now
+func defaultPerformedAt(workout *Workout) time.Time {
+	if workout.Activity != nil && workout.Activity.StartedAt != nil {
+		return workout.Activity.StartedAt.UTC()
+	}
+	return time.Now().UTC()
+}
+
+func insertWorkout(tx Querier, workout *Workout) error {
+	return insertWorkoutRow(tx, workout, false)
+}
+
+// insertWorkoutRow inserts the workout, its entries and activity. With clientIDs the public ids
+// of the workout and its entries are the ones generated by an offline device, otherwise they
+// are generated here.
+func insertWorkoutRow(tx Querier, workout *Workout, clientIDs bool) error {
+	if workout.Visibility == "" {
+		workout.Visibility = VisibilityPrivate
+	}
+	if workout.Type == "" {
+		workout.Type = WorkoutTypeStrength
+	}
+	if workout.PerformedAt.IsZero() {
+		workout.PerformedAt = defaultPerformedAt(workout)
+	}
+
+	query := `INSERT INTO workouts(user_id,title,description,duration,calories_burned,calories_estimated,type,visibility,performed_at,public_id)
+		VALUES($1,$2,$3,$4,$5,$6,$7,$8,$9,COALESCE($10::uuid,uuid_generate_v7(CURRENT_TIMESTAMP)))
+		RETURNING id,public_id,version,(SELECT public_id FROM users WHERE id=$1)
+	`
+	err := tx.QueryRow(query, workout.UserID, workout.Title, workout.Description, workout.DurationInMinutes, workout.CaloriesBurned, workout.CaloriesEstimated, workout.Type, workout.Visibility, workout.PerformedAt, clientID(clientIDs, workout.PublicID)).Scan(&workout.ID, &workout.PublicID, &workout.Version, &workout.UserPublicID)
+	if err != nil {
+		return err
+	}
 
 	// we also need to insert the entries
-	for _, entry := range workout.Entries {
+	for i := range workout.Entries {
+		entry := &workout.Entries[i]
 		query := `
-			INSERT INTO workout_entries(workout_id, exercise_name, exercise_sets, reps, duration_seconds, weight, notes, order_index)
-			VALUES($1, $2, $3, $4, $5, $6, $7, $8)
-			RETURNING id
+			INSERT INTO workout_entries(workout_id, exercise_name, exercise_sets, reps, duration_seconds, weight, notes, order_index, public_id)
+			VALUES($1, $2, $3, $4, $5, $6, $7, $8, COALESCE($9::uuid, uuid_generate_v7(CURRENT_TIMESTAMP)))
+			RETURNING id,public_id
 			`
-		err = tx.QueryRow(query, workout.ID, entry.ExerciseName, entry.ExerciseSets, entry.Reps, entry.DurationSeconds, entry.Weight, entry.Notes, entry.OrderIndex).Scan(&entry.ID)
+		err = tx.QueryRow(query, workout.ID, entry.ExerciseName, entry.ExerciseSets, entry.Reps, entry.DurationSeconds, entry.Weight, entry.Notes, entry.OrderIndex, clientID(clientIDs, entry.PublicID)).Scan(&entry.ID, &entry.PublicID)
+		if err != nil {
+			return err
+		}
+	}
+
+	if workout.Activity != nil {
+		err = saveActivity(tx, workout.ID, workout.Activity)
+		if err != nil {
+			return err
+		}
+		err = insertTrackPoints(tx, workout.ID, workout.Activity.Track)
+		if err != nil {
+			return err
+		}
+	}
+
+	return recordRevision(tx, int64(workout.ID), workout.UserID)
+}
+
+// clientID is the public id to insert, nil lets the database generate one
+func clientID(clientIDs bool, publicID string) any {
+	if !clientIDs || publicID == "" {
+		return nil
+	}
+	return publicID
+}
+
+// streamPageSize is how many workouts StreamWorkoutsForUser reads at once
+const streamPageSize = 100
+
+// StreamWorkoutsForUser calls fn for every workout of the user, entries and cardio details
+// with the recorded track included. Workouts are read a page at a time so that exports never
+// hold the whole history in memory.
+func (pg *PostgresWorkoutStore) StreamWorkoutsForUser(userID int, fn func(*Workout) error) error {
+	var after int
+	for {
+		page, err := pg.readWorkoutPage(userID, after)
+		if err != nil {
+			return err
+		}
+		if len(page) == 0 {
+			return nil
+		}
+
+		// the page rows are closed by now, on a transaction no other query could run before
+		for _, workout := range page {
+			workout.Activity, err = getActivity(pg.db, int64(workout.ID))
+			if err != nil {
+				return err
+			}
+			if workout.Activity != nil {
+				workout.Activity.Track, err = pg.GetWorkoutTrack(int64(workout.ID))
+				if err != nil {
+					return err
+				}
+			}
+
+			err = fn(workout)
+			if err != nil {
+				return err
+			}
+		}
+		after = page[len(page)-1].ID
+	}
+}
+
+// readWorkoutPage reads the next streamPageSize workouts of the user after the given id,
+// entries included
+func (pg *PostgresWorkoutStore) readWorkoutPage(userID, after int) ([]*Workout, error) {
+	query := `
+	SELECT w.id,w.public_id,w.user_id,u.public_id,w.title,w.description,w.duration,w.calories_burned,w.calories_estimated,w.type,w.visibility,w.performed_at,w.version,
+		e.id,e.public_id,e.exercise_name,e.exercise_sets,e.reps,e.duration_seconds,e.weight,e.notes,e.order_index
+	FROM workouts w
+	INNER JOIN users u ON u.id=w.user_id
+	LEFT JOIN workout_entries e ON e.workout_id=w.id
+	WHERE w.id IN (
+		SELECT id FROM workouts
+		WHERE user_id=$1 AND deleted_at IS NULL AND id>$2
+		ORDER BY id
+		LIMIT $3
+	)
+	ORDER BY w.id,e.order_index,e.id
+	`
+
+	rows, err := pg.db.Query(query, userID, after, streamPageSize)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	page := []*Workout{}
+	var current *Workout
+	for rows.Next() {
+		var workout Workout
+		var entryID, entrySets, entryOrder *int
+		var entryPublicID, entryName, entryNotes *string
+		var entry WorkoutEntry
+
+		err := rows.Scan(&workout.ID, &workout.PublicID, &workout.UserID, &workout.UserPublicID, &workout.Title, &workout.Description, &workout.DurationInMinutes, &workout.CaloriesBurned, &workout.CaloriesEstimated, &workout.Type, &workout.Visibility, &workout.PerformedAt, &workout.Version,
+			&entryID, &entryPublicID, &entryName, &entrySets, &entry.Reps, &entry.DurationSeconds, &entry.Weight, &entryNotes, &entryOrder)
 		if err != nil {
 			return nil, err
 		}
+
+		if current == nil || current.ID != workout.ID {
+			current = &workout
+			page = append(page, current)
+		}
+
+		// workouts without entries come back with a single all NULL entry row
+		if entryID == nil {
+			continue
+		}
+		entry.ID = *entryID
+		entry.PublicID = *entryPublicID
+		entry.ExerciseName = *entryName
+		entry.ExerciseSets = *entrySets
+		entry.OrderIndex = *entryOrder
+		if entryNotes != nil {
+			entry.Notes = *entryNotes
+		}
+		current.Entries = append(current.Entries, entry)
 	}
+	return page, rows.Err()
+}
 
-	err = tx.Commit()
+// ListExerciseNames returns the distinct exercise names the user has logged, most used first
+func (pg *PostgresWorkoutStore) ListExerciseNames(userID int) ([]string, error) {
+	query := `
+	SELECT e.exercise_name
+	FROM workout_entries e
+	INNER JOIN workouts w ON w.id=e.workout_id
+	WHERE w.user_id=$1 AND w.deleted_at IS NULL
+	GROUP BY e.exercise_name
+	ORDER BY COUNT(*) DESC,e.exercise_name
+	`
+
+	rows, err := pg.db.Query(query, userID)
 	if err != nil {
 		return nil, err
 	}
-	return workout, nil
+	defer rows.Close()
+
+	names := []string{}
+	for rows.Next() {
+		var name string
+		err := rows.Scan(&name)
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
 }
 
-func (pg *PostgresWorkoutStore) GetWorkoutByID(id int64) (*Workout, error) {
-	workout := &Workout{}
+// Lift is the heaviest set of an exercise, ranked by its estimated one rep max
+type Lift struct {
+	ExerciseName string    `json:"exercise_name"`
+	Weight       float64   `json:"weight"`
+	Reps         int       `json:"reps"`
+	OneRepMax    float64   `json:"one_rep_max"`
+	PerformedAt  time.Time `json:"performed_at"`
+}
+
+// The one rep max of an entry e uses the Epley formula, weight x (1 + reps/30), which is
+// unreliable past 12 reps, so liftSetSQL leaves those sets out
+const (
+	oneRepMaxSQL = `CASE WHEN e.reps=1 THEN e.weight ELSE e.weight*(1+e.reps/30.0) END`
+	liftSetSQL   = `e.weight>0 AND e.reps BETWEEN 1 AND 12`
+)
 
+// BestLifts returns the best set of every weighted exercise since the given time, ranked by
+// the estimated one rep max. Exercise names are compared case insensitively.
+func (pg *PostgresWorkoutStore) BestLifts(userID int, since time.Time) ([]Lift, error) {
 	query := `
-	SELECT id,user_id,title,description,duration,calories_burned
-	FROM workouts
-	WHERE id=$1
+	SELECT DISTINCT ON (lower(e.exercise_name))
+		e.exercise_name,e.weight,e.reps,` + oneRepMaxSQL + ` AS one_rep_max,w.performed_at
+	FROM workout_entries e
+	INNER JOIN workouts w ON w.id=e.workout_id
+	WHERE w.user_id=$1 AND w.deleted_at IS NULL AND w.performed_at>=$2 AND ` + liftSetSQL + `
+	ORDER BY lower(e.exercise_name),one_rep_max DESC,w.performed_at DESC
 	`
 
-	err := pg.db.QueryRow(query, id).Scan(&workout.ID, &workout.UserID, &workout.Title, &workout.Description, &workout.DurationInMinutes, &workout.CaloriesBurned)
+	rows, err := pg.db.Query(query, userID, since)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
-	if err == sql.ErrNoRows {
-		return nil, err // No workout found with the given ID
+	lifts := []Lift{}
+	for rows.Next() {
+		var lift Lift
+		err := rows.Scan(&lift.ExerciseName, &lift.Weight, &lift.Reps, &lift.OneRepMax, &lift.PerformedAt)
+		if err != nil {
+			return nil, err
+		}
+		lift.OneRepMax = math.Round(lift.OneRepMax*10) / 10
+		lifts = append(lifts, lift)
+	}
+	return lifts, rows.Err()
+}
+
+// WorkoutSummary is what calendars show of a workout
+type WorkoutSummary struct {
+	PublicID          string    `json:"id"`
+	Title             string    `json:"title"`
+	Type              string    `json:"type"`
+	PerformedAt       time.Time `json:"performed_at"`
+	DurationInMinutes int       `json:"duration"`
+	CaloriesBurned    int       `json:"calories_burned"`
+	DistanceMeters    *float64  `json:"distance_meters,omitempty"`
+	UpdatedAt         time.Time `json:"-"`
+}
+
+// ListWorkoutSummaries returns the workouts performed in [from, to), oldest first
+func (pg *PostgresWorkoutStore) ListWorkoutSummaries(userID int, from, to time.Time) ([]WorkoutSummary, error) {
+	query := `
+	SELECT w.public_id,w.title,w.type,w.performed_at,w.duration,w.calories_burned,a.distance_meters,
+		COALESCE(w.updated_at,w.created_at)
+	FROM workouts w
+	LEFT JOIN workout_activities a ON a.workout_id=w.id
+	WHERE w.user_id=$1 AND w.deleted_at IS NULL AND w.performed_at>=$2 AND w.performed_at<$3
+	ORDER BY w.performed_at,w.id
+	`
+
+	rows, err := pg.db.Query(query, userID, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	summaries := []WorkoutSummary{}
+	for rows.Next() {
+		var summary WorkoutSummary
+		err := rows.Scan(&summary.PublicID, &summary.Title, &summary.Type, &summary.PerformedAt, &summary.DurationInMinutes,
+			&summary.CaloriesBurned, &summary.DistanceMeters, &summary.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+		summaries = append(summaries, summary)
+	}
+	return summaries, rows.Err()
+}
+
+func (pg *PostgresWorkoutStore) GetWorkoutByID(id int64) (*Workout, error) {
+	return getWorkout(pg.db, id)
+}
+
+func getWorkout(q Querier, id int64) (*Workout, error) {
+	workout := &Workout{}
+
+	query := `
+	SELECT w.id,w.public_id,w.user_id,u.public_id,w.title,w.description,w.duration,w.calories_burned,w.calories_estimated,w.type,w.visibility,w.performed_at,w.version
+	FROM workouts w
+	INNER JOIN users u ON u.id=w.user_id
+	WHERE w.id=$1 AND w.deleted_at IS NULL
+	`
+
+	err := q.QueryRow(query, id).Scan(&workout.ID, &workout.PublicID, &workout.UserID, &workout.UserPublicID, &workout.Title, &workout.Description, &workout.DurationInMinutes, &workout.CaloriesBurned, &workout.CaloriesEstimated, &workout.Type, &workout.Visibility, &workout.PerformedAt, &workout.Version)
+	if err != nil {
+		return nil, err
 	}
 
 	// lets get the entries for this workout
 	entryQuery := `
-	SELECT id,exercise_name,exercise_sets,reps,duration_seconds,weight,notes,order_index
+	SELECT id,public_id,exercise_name,exercise_sets,reps,duration_seconds,weight,notes,order_index
 	FROM workout_entries
 	WHERE workout_id=$1
 	ORDER BY order_index
 	`
 
-	rows, err := pg.db.Query(entryQuery, id)
+	rows, err := q.Query(entryQuery, id)
 	if err != nil {
 		return nil, err
 	}
@@ -112,82 +510,214 @@ func (pg *PostgresWorkoutStore) GetWorkoutByID(id int64) (*Workout, error) {
 	defer rows.Close()
 	for rows.Next() {
 		var entry WorkoutEntry
-		err := rows.Scan(&entry.ID, &entry.ExerciseName, &entry.ExerciseSets, &entry.Reps, &entry.DurationSeconds, &entry.Weight, &entry.Notes, &entry.OrderIndex)
+		err := rows.Scan(&entry.ID, &entry.PublicID, &entry.ExerciseName, &entry.ExerciseSets, &entry.Reps, &entry.DurationSeconds, &entry.Weight, &entry.Notes, &entry.OrderIndex)
 		if err != nil {
 			return nil, err
 		}
 		workout.Entries = append(workout.Entries, entry)
 	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
 
+	workout.Activity, err = getActivity(q, id)
+	if err != nil {
+		return nil, err
+	}
 	return workout, nil
 }
 
-func (pg *PostgresWorkoutStore) UpdateWorkout(workout *Workout) error {
-	tx, err := pg.db.Begin()
+func (pg *PostgresWorkoutStore) UpdateWorkout(workout *Workout, changedBy int) error {
+	tx, err := begin(pg.db)
 	if err != nil {
 		return err
 	}
 	defer tx.Rollback()
 
+	// workouts created before revisions existed get their current state recorded as the baseline
+	err = ensureBaselineRevision(tx, int64(workout.ID))
+	if err != nil {
+		return err
+	}
+
+	// the version check makes the update fail instead of silently overwriting a concurrent edit
 	query := `
 	UPDATE workouts
-	SET title=$1,description=$2,duration=$3,calories_burned=$4
-	WHERE id=$5
+	SET title=$1,description=$2,duration=$3,calories_burned=$4,calories_estimated=$5,type=$6,visibility=$7,performed_at=$8,version=version+1,updated_at=CURRENT_TIMESTAMP,field_clocks='{}'
+	WHERE id=$9 AND version=$10 AND deleted_at IS NULL
+	RETURNING version
 	`
 
-	result, err := tx.Exec(query, workout.Title, workout.Description, workout.DurationInMinutes, workout.CaloriesBurned, workout.ID)
+	if workout.PerformedAt.IsZero() {
+		workout.PerformedAt = defaultPerformedAt(workout)
+	}
+	err = tx.QueryRow(query, workout.Title, workout.Description, workout.DurationInMinutes, workout.CaloriesBurned, workout.CaloriesEstimated, workout.Type, workout.Visibility, workout.PerformedAt, workout.ID, workout.Version).Scan(&workout.Version)
+	if err == sql.ErrNoRows {
+		return ErrEditConflict
+	}
+	if err != nil {
+		return err
+	}
+
+	err = syncWorkoutEntries(tx, workout)
+	if err != nil {
+		return err
+	}
+
+	// the recorded track is kept, only the summary and splits follow the edit
+	if workout.Activity != nil {
+		err = saveActivity(tx, workout.ID, workout.Activity)
+	} else {
+		err = deleteActivity(tx, workout.ID)
+	}
+	if err != nil {
+		return err
+	}
+
+	err = recordRevision(tx, int64(workout.ID), changedBy)
 	if err != nil {
 		return err
 	}
-	rowsAffected, err := result.RowsAffected()
+	err = logWorkoutEvent(tx, WorkoutUpdated, int64(workout.ID))
 	if err != nil {
 		return err
 	}
 
-	if rowsAffected == 0 {
-		return sql.ErrNoRows
+	err = tx.Commit()
+	if err != nil {
+		return err
 	}
+	pg.notify(WorkoutUpdated, workout.UserID, int64(workout.ID))
+	return nil
+}
 
-	_, err = tx.Exec("DELETE FROM workout_entries WHERE workout_id=$1", workout.ID)
+// syncWorkoutEntries writes only the entry changes: entries with a known id are updated in place
+// when they differ, entries without an id are inserted and missing ones are deleted. Entry ids
+// therefore survive edits.
+func syncWorkoutEntries(tx Querier, workout *Workout) error {
+	rows, err := tx.Query(`
+	SELECT id,public_id,exercise_name,exercise_sets,reps,duration_seconds,weight,notes,order_index
+	FROM workout_entries
+	WHERE workout_id=$1
+	`, workout.ID)
 	if err != nil {
 		return err
 	}
 
-	for _, entry := range workout.Entries {
-		query := `
-		INSERT INTO workout_entries(workout_id,exercise_name,exercise_sets,reps,duration_seconds,notes,order_index)
-		VALUES($1,$2,$3,$4,$5,$6,$7)
-		`
+	existing := make(map[string]WorkoutEntry)
+	for rows.Next() {
+		var entry WorkoutEntry
+		err := rows.Scan(&entry.ID, &entry.PublicID, &entry.ExerciseName, &entry.ExerciseSets, &entry.Reps, &entry.DurationSeconds, &entry.Weight, &entry.Notes, &entry.OrderIndex)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		existing[entry.PublicID] = entry
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	kept := make(map[string]bool)
+	for i := range workout.Entries {
+		entry := &workout.Entries[i]
+		if entry.PublicID == "" {
+			continue
+		}
+		current, ok := existing[entry.PublicID]
+		if !ok || kept[entry.PublicID] {
+			return ErrEntryNotFound
+		}
+		kept[entry.PublicID] = true
+		entry.ID = current.ID
+	}
 
-		_, err := tx.Exec(query, workout.ID, entry.ExerciseName, entry.ExerciseSets, entry.Reps, entry.DurationSeconds, entry.Notes, entry.OrderIndex)
+	for publicID, entry := range existing {
+		if kept[publicID] {
+			continue
+		}
+		err := deleteWorkoutEntry(tx, workout.ID, entry)
 		if err != nil {
 			return err
 		}
 	}
 
-	return tx.Commit()
+	for i := range workout.Entries {
+		entry := &workout.Entries[i]
+		if entry.PublicID == "" {
+			query := `
+			INSERT INTO workout_entries(workout_id,exercise_name,exercise_sets,reps,duration_seconds,weight,notes,order_index)
+			VALUES($1,$2,$3,$4,$5,$6,$7,$8)
+			RETURNING id,public_id
+			`
+			err := tx.QueryRow(query, workout.ID, entry.ExerciseName, entry.ExerciseSets, entry.Reps, entry.DurationSeconds, entry.Weight, entry.Notes, entry.OrderIndex).Scan(&entry.ID, &entry.PublicID)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		if len(diffEntry(existing[entry.PublicID], *entry)) == 0 {
+			continue
+		}
+
+		query := `
+		UPDATE workout_entries
+		SET exercise_name=$1,exercise_sets=$2,reps=$3,duration_seconds=$4,weight=$5,notes=$6,order_index=$7,updated_at=CURRENT_TIMESTAMP,field_clocks='{}'
+		WHERE id=$8
+		`
+		_, err := tx.Exec(query, entry.ExerciseName, entry.ExerciseSets, entry.Reps, entry.DurationSeconds, entry.Weight, entry.Notes, entry.OrderIndex, entry.ID)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-func (pg *PostgresWorkoutStore) DeleteWorkout(id int64) error {
+func (pg *PostgresWorkoutStore) DeleteWorkout(id int64, version int) error {
+	tx, err := begin(pg.db)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// workouts are moved to the trash and only purged once the retention period is over
 	query := `
-	DELETE FROM workouts
-	WHERE id=$1
+	UPDATE workouts
+	SET deleted_at=CURRENT_TIMESTAMP,version=version+1
+	WHERE id=$1 AND version=$2 AND deleted_at IS NULL
+	RETURNING user_id
 	`
-	result, err := pg.db.Exec(query, id)
-	if err != nil {
+	var userID int
+	err = tx.QueryRow(query, id, version).Scan(&userID)
+	if err == nil {
+		err = logWorkoutEvent(tx, WorkoutDeleted, id)
+		if err != nil {
+			return err
+		}
+		err = tx.Commit()
+		if err != nil {
+			return err
+		}
+		pg.notify(WorkoutDeleted, userID, id)
+		return nil
+	}
+	if err != sql.ErrNoRows {
 		return err
 	}
 
-	rowsEffected, err := result.RowsAffected()
+	// nothing matched, tell a missing workout apart from a stale version
+	var exists bool
+	err = pg.db.QueryRow(`SELECT EXISTS(SELECT 1 FROM workouts WHERE id=$1 AND deleted_at IS NULL)`, id).Scan(&exists)
 	if err != nil {
 		return err
 	}
-
-	if rowsEffected == 0 {
-		return sql.ErrNoRows
+	if exists {
+		return ErrEditConflict
 	}
-
-	return nil
+	return sql.ErrNoRows
 }
 
 func (pg *PostgresWorkoutStore) GetWorkoutOwner(workoutID int64) (int, error) {
@@ -195,7 +725,7 @@ func (pg *PostgresWorkoutStore) GetWorkoutOwner(workoutID int64) (int, error) {
 	query := `
 	SELECT user_id
 	FROM workouts
-	WHERE id=$1
+	WHERE id=$1 AND deleted_at IS NULL
 	`
 	err := pg.db.QueryRow(query, workoutID).Scan(&userID)
 	if err != nil {
@@ -203,3 +733,105 @@ func (pg *PostgresWorkoutStore) GetWorkoutOwner(workoutID int64) (int, error) {
 	}
 	return userID, nil
 }
+
+// ResolveWorkoutID maps a public (or legacy numeric) reference to the internal workout id
+func (pg *PostgresWorkoutStore) ResolveWorkoutID(ref publicid.Ref) (int64, error) {
+	var id int64
+	if ref.IsLegacy() {
+		err := pg.db.QueryRow(`SELECT id FROM workouts WHERE id=$1 AND deleted_at IS NULL`, ref.LegacyID).Scan(&id)
+		return id, err
+	}
+
+	err := pg.db.QueryRow(`SELECT id FROM workouts WHERE public_id=$1 AND deleted_at IS NULL`, ref.PublicID).Scan(&id)
+	return id, err
+}
+
+func (pg *PostgresWorkoutStore) ListTrashedWorkouts(userID int) ([]Workout, error) {
+	query := `
+	SELECT w.id,w.public_id,w.user_id,u.public_id,w.title,w.description,w.duration,w.calories_burned,w.calories_estimated,w.type,w.visibility,w.performed_at,w.deleted_at
+	FROM workouts w
+	INNER JOIN users u ON u.id=w.user_id
+	WHERE w.user_id=$1 AND w.deleted_at IS NOT NULL
+	ORDER BY w.deleted_at DESC
+	`
+
+	rows, err := pg.db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	workouts := []Workout{}
+	for rows.Next() {
+		var workout Workout
+		err := rows.Scan(&workout.ID, &workout.PublicID, &workout.UserID, &workout.UserPublicID, &workout.Title, &workout.Description, &workout.DurationInMinutes, &workout.CaloriesBurned, &workout.CaloriesEstimated, &workout.Type, &workout.Visibility, &workout.PerformedAt, &workout.DeletedAt)
+		if err != nil {
+			return nil, err
+		}
+		workouts = append(workouts, workout)
+	}
+	return workouts, rows.Err()
+}
+
+// RestoreWorkout moves a trashed workout owned by userID back out of the trash
+func (pg *PostgresWorkoutStore) RestoreWorkout(ref publicid.Ref, userID int) (int64, error) {
+	var id int64
+	query := `
+	UPDATE workouts
+	SET deleted_at=NULL,version=version+1
+	WHERE public_id=$1 AND user_id=$2 AND deleted_at IS NOT NULL
+	RETURNING id
+	`
+	var key any = ref.PublicID
+	if ref.IsLegacy() {
+		query = `
+		UPDATE workouts
+		SET deleted_at=NULL,version=version+1
+		WHERE id=$1 AND user_id=$2 AND deleted_at IS NOT NULL
+		RETURNING id
+		`
+		key = ref.LegacyID
+	}
+
+	tx, err := begin(pg.db)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRow(query, key, userID).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+	err = logWorkoutEvent(tx, WorkoutRestored, id)
+	if err != nil {
+		return 0, err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+	pg.notify(WorkoutRestored, userID, id)
+	return id, nil
+}
+
+// PurgeTrashedWorkouts permanently deletes workouts that have been trashed for longer than retention.
+// A tombstone keeps their id so offline devices cannot sync them back.
+func (pg *PostgresWorkoutStore) PurgeTrashedWorkouts(retention time.Duration) (int64, error) {
+	query := `
+	WITH purged AS (
+		DELETE FROM workouts
+		WHERE deleted_at IS NOT NULL AND deleted_at < $1
+		RETURNING public_id,user_id
+	)
+	INSERT INTO workout_tombstones(public_id,user_id)
+	SELECT public_id,user_id FROM purged
+	ON CONFLICT DO NOTHING
+	`
+
+	result, err := pg.db.Exec(query, time.Now().Add(-retention))
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
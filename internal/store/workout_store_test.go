@@ -5,6 +5,7 @@ import (
 	"testing"
 
 	_ "github.com/jackc/pgx/v4/stdlib"
+	"github.com/kodega2016/femapi/internal/publicid"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -117,3 +118,142 @@ func IntPtr(i int) *int {
 func FloatPtr(f float32) *float32 {
 	return &f
 }
+
+func createTestUser(t *testing.T, db *sql.DB, username string) *User {
+	userStore := NewPostgresUserStore(db)
+	user := &User{
+		Username: username,
+		Email:    username + "@example.com",
+	}
+	require.NoError(t, user.PasswordHash.Set("password"))
+	require.NoError(t, userStore.CreateUser(user))
+	return user
+}
+
+func TestDeleteWorkoutMovesToTrash(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	_, err := db.Exec("TRUNCATE users CASCADE")
+	require.NoError(t, err)
+
+	user := createTestUser(t, db, "trash_user")
+	store := NewPostgresWorkoutStore(db)
+
+	workout, err := store.CreateWorkout(&Workout{
+		UserID:            user.ID,
+		Title:             "leg day",
+		DurationInMinutes: 45,
+		CaloriesBurned:    300,
+		Entries: []WorkoutEntry{
+			{ExerciseName: "Squat", ExerciseSets: 5, Reps: IntPtr(5), OrderIndex: 1},
+		},
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, store.DeleteWorkout(int64(workout.ID), workout.Version))
+
+	_, err = store.GetWorkoutByID(int64(workout.ID))
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+
+	trashed, err := store.ListTrashedWorkouts(user.ID)
+	require.NoError(t, err)
+	require.Len(t, trashed, 1)
+	assert.NotNil(t, trashed[0].DeletedAt)
+
+	restoredID, err := store.RestoreWorkout(publicid.Ref{PublicID: workout.PublicID}, user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, int64(workout.ID), restoredID)
+
+	restored, err := store.GetWorkoutByID(restoredID)
+	require.NoError(t, err)
+	assert.Len(t, restored.Entries, 1)
+}
+
+func TestUpdateWorkoutPreservesEntryIDs(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	_, err := db.Exec("TRUNCATE users CASCADE")
+	require.NoError(t, err)
+
+	user := createTestUser(t, db, "entries_user")
+	store := NewPostgresWorkoutStore(db)
+
+	workout, err := store.CreateWorkout(&Workout{
+		UserID:            user.ID,
+		Title:             "push day",
+		DurationInMinutes: 60,
+		CaloriesBurned:    250,
+		Entries: []WorkoutEntry{
+			{ExerciseName: "Bench Press", ExerciseSets: 3, Reps: IntPtr(10), Weight: FloatPtr(80), OrderIndex: 1},
+			{ExerciseName: "Dips", ExerciseSets: 3, Reps: IntPtr(12), OrderIndex: 2},
+		},
+	})
+	require.NoError(t, err)
+	benchID := workout.Entries[0].PublicID
+
+	workout.Entries[0].Weight = FloatPtr(85)
+	workout.Entries = workout.Entries[:1]
+	require.NoError(t, store.UpdateWorkout(workout, user.ID))
+
+	updated, err := store.GetWorkoutByID(int64(workout.ID))
+	require.NoError(t, err)
+	require.Len(t, updated.Entries, 1)
+	assert.Equal(t, benchID, updated.Entries[0].PublicID)
+	require.NotNil(t, updated.Entries[0].Weight)
+	assert.Equal(t, float32(85), *updated.Entries[0].Weight)
+	assert.Equal(t, 2, updated.Version)
+
+	stale := *updated
+	stale.Version = 1
+	assert.ErrorIs(t, store.UpdateWorkout(&stale, user.ID), ErrEditConflict)
+}
+
+func TestCardioWorkoutKeepsActivityAndSplits(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	_, err := db.Exec("TRUNCATE users CASCADE")
+	require.NoError(t, err)
+
+	store := NewPostgresWorkoutStore(db)
+	user := createTestUser(t, db, "cardio_runner")
+
+	workout := &Workout{
+		Title:             "tempo run",
+		UserID:            user.ID,
+		DurationInMinutes: 25,
+		Type:              WorkoutTypeRun,
+		Activity: &Activity{
+			Sport:             SportRunning,
+			DistanceMeters:    5000,
+			MovingTimeSeconds: 1500,
+			Splits: []Split{
+				{DistanceMeters: 2500, DurationSeconds: 760},
+				{DistanceMeters: 2500, DurationSeconds: 740},
+			},
+		},
+	}
+	_, err = store.CreateWorkout(workout)
+	require.NoError(t, err)
+
+	retrieved, err := store.GetWorkoutByID(int64(workout.ID))
+	require.NoError(t, err)
+	assert.Equal(t, WorkoutTypeRun, retrieved.Type)
+	require.NotNil(t, retrieved.Activity)
+	assert.Equal(t, 300.0, *retrieved.Activity.PaceSecondsPerKm)
+	assert.Equal(t, 12.0, *retrieved.Activity.AvgSpeedKph)
+	require.Len(t, retrieved.Activity.Splits, 2)
+	assert.Equal(t, 2, retrieved.Activity.Splits[1].Number)
+	assert.Equal(t, 296.0, *retrieved.Activity.Splits[1].PaceSecondsPerKm)
+
+	// switching to strength drops the cardio details
+	retrieved.Type = WorkoutTypeStrength
+	retrieved.Activity = nil
+	require.NoError(t, store.UpdateWorkout(retrieved, user.ID))
+
+	updated, err := store.GetWorkoutByID(int64(workout.ID))
+	require.NoError(t, err)
+	assert.Nil(t, updated.Activity)
+}
@@ -0,0 +1,280 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kodega2016/femapi/internal/publicid"
+)
+
+const (
+	SyncOpUpsert = "upsert"
+	SyncOpDelete = "delete"
+)
+
+// SyncError is returned for a client change that can never be applied, the client drops it
+// while the other changes of the sync go on
+type SyncError struct {
+	Reason string
+}
+
+func (e *SyncError) Error() string {
+	return e.Reason
+}
+
+// SyncChange is a mutation made on a device while offline. Fields only holds the fields the
+// device changed, each one is applied unless the server holds a later write of that field.
+type SyncChange struct {
+	MutationID string                     `json:"mutation_id"`
+	Op         string                     `json:"op"`
+	WorkoutID  string                     `json:"workout_id"`
+	ModifiedAt time.Time                  `json:"modified_at"`
+	Fields     map[string]json.RawMessage `json:"fields"`
+	Entries    []SyncEntryChange          `json:"entries"`
+}
+
+// SyncEntryChange changes or deletes one entry of the workout, at the time of its change
+type SyncEntryChange struct {
+	ID      string                     `json:"id"`
+	Deleted bool                       `json:"deleted"`
+	Fields  map[string]json.RawMessage `json:"fields"`
+}
+
+// SyncedWorkout is a server change: the current state of a workout, or its tombstone
+type SyncedWorkout struct {
+	ID      string   `json:"id"`
+	Deleted bool     `json:"deleted"`
+	Workout *Workout `json:"workout,omitempty"`
+}
+
+// SyncToken is where a device resumes. Deltas are read from the event log after Seq; a first
+// sync pages through a snapshot of the workouts taken at Seq first.
+type SyncToken struct {
+	Seq      int64
+	Snapshot bool
+	After    string // the last workout of the snapshot already sent
+}
+
+func (t SyncToken) String() string {
+	if t.Snapshot {
+		return fmt.Sprintf("%d:%s", t.Seq, t.After)
+	}
+	return strconv.FormatInt(t.Seq, 10)
+}
+
+var ErrInvalidSyncToken = errors.New("invalid sync token")
+
+// ParseSyncToken reads a token returned by an earlier sync, an empty one starts a snapshot
+func ParseSyncToken(s string) (SyncToken, error) {
+	if s == "" {
+		return SyncToken{Snapshot: true}, nil
+	}
+	seqPart, after, snapshot := strings.Cut(s, ":")
+	seq, err := strconv.ParseInt(seqPart, 10, 64)
+	if err != nil || seq < 0 {
+		return SyncToken{}, ErrInvalidSyncToken
+	}
+	if snapshot && after != "" {
+		ref, err := publicid.Parse(after)
+		if err != nil || ref.IsLegacy() {
+			return SyncToken{}, ErrInvalidSyncToken
+		}
+		after = ref.PublicID
+	}
+	return SyncToken{Seq: seq, Snapshot: snapshot, After: after}, nil
+}
+
+var (
+	workoutSyncFields = []string{"title", "description", "duration", "calories_burned", "type", "visibility", "performed_at", "activity"}
+	entrySyncFields   = []string{"exercise_name", "exercise_sets", "reps", "duration_seconds", "weight", "notes", "order_index"}
+)
+
+// FieldClocks is the time of the last write of each field
+type FieldClocks map[string]time.Time
+
+// readFieldClocks decodes stored clocks. Fields without a clock were last written by a plain
+// update, which writes every field, so they get the time of that update.
+func readFieldClocks(data []byte, fields []string, updatedAt time.Time) (FieldClocks, error) {
+	clocks := FieldClocks{}
+	if len(data) > 0 {
+		err := json.Unmarshal(data, &clocks)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, field := range fields {
+		if _, ok := clocks[field]; !ok {
+			clocks[field] = updatedAt
+		}
+	}
+	return clocks, nil
+}
+
+type syncEntry struct {
+	entry  WorkoutEntry
+	clocks FieldClocks
+	isNew  bool
+	dirty  bool
+}
+
+// syncState is a workout being merged with a client change
+type syncState struct {
+	workout    *Workout
+	clocks     FieldClocks
+	entries    map[string]*syncEntry
+	tombstones map[string]bool // entries deleted before
+	removed    []WorkoutEntry  // entries deleted by the change
+	dirty      bool
+	// the activity is written apart from the workout row, only when the change won it
+	activityChanged bool
+}
+
+func newSyncState(workout *Workout, clocks FieldClocks) *syncState {
+	return &syncState{
+		workout:    workout,
+		clocks:     clocks,
+		entries:    make(map[string]*syncEntry),
+		tombstones: make(map[string]bool),
+	}
+}
+
+// merge applies the change field by field: a field is written when the change is later than
+// its last write. Deleted entries stay deleted, whatever the time of the change.
+func (s *syncState) merge(change SyncChange) error {
+	at := change.ModifiedAt
+	for field, value := range change.Fields {
+		if !slices.Contains(workoutSyncFields, field) {
+			return &SyncError{Reason: "unknown workout field " + field}
+		}
+		if !at.After(s.clocks[field]) {
+			continue
+		}
+		err := setWorkoutField(s.workout, field, value)
+		if err != nil {
+			return err
+		}
+		s.clocks[field] = at
+		s.dirty = true
+		s.activityChanged = s.activityChanged || field == "activity"
+	}
+
+	for _, entryChange := range change.Entries {
+		ref, err := publicid.Parse(entryChange.ID)
+		if err != nil || ref.IsLegacy() {
+			return &SyncError{Reason: "entry ids must be UUIDs"}
+		}
+		id := ref.PublicID
+		if s.tombstones[id] {
+			continue
+		}
+
+		entry := s.entries[id]
+		if entryChange.Deleted {
+			if entry == nil {
+				continue
+			}
+			delete(s.entries, id)
+			if !entry.isNew {
+				s.removed = append(s.removed, entry.entry)
+			}
+			s.dirty = true
+			continue
+		}
+
+		if entry == nil {
+			entry = &syncEntry{entry: WorkoutEntry{PublicID: id}, clocks: FieldClocks{}, isNew: true}
+			s.entries[id] = entry
+			s.dirty = true
+		}
+		for field, value := range entryChange.Fields {
+			if !slices.Contains(entrySyncFields, field) {
+				return &SyncError{Reason: "unknown entry field " + field}
+			}
+			if !at.After(entry.clocks[field]) {
+				continue
+			}
+			err := setEntryField(&entry.entry, field, value)
+			if err != nil {
+				return err
+			}
+			entry.clocks[field] = at
+			entry.dirty = true
+			s.dirty = true
+		}
+	}
+
+	s.workout.Entries = s.workout.Entries[:0]
+	for _, entry := range s.entries {
+		s.workout.Entries = append(s.workout.Entries, entry.entry)
+	}
+	sort.Slice(s.workout.Entries, func(i, j int) bool {
+		a, b := s.workout.Entries[i], s.workout.Entries[j]
+		if a.OrderIndex != b.OrderIndex {
+			return a.OrderIndex < b.OrderIndex
+		}
+		return a.PublicID < b.PublicID
+	})
+	return nil
+}
+
+func setWorkoutField(workout *Workout, field string, value json.RawMessage) error {
+	var err error
+	switch field {
+	case "title":
+		err = json.Unmarshal(value, &workout.Title)
+	case "description":
+		err = json.Unmarshal(value, &workout.Description)
+	case "duration":
+		err = json.Unmarshal(value, &workout.DurationInMinutes)
+	case "calories_burned":
+		err = json.Unmarshal(value, &workout.CaloriesBurned)
+		workout.CaloriesEstimated = false
+	case "type":
+		err = json.Unmarshal(value, &workout.Type)
+	case "visibility":
+		err = json.Unmarshal(value, &workout.Visibility)
+	case "performed_at":
+		err = json.Unmarshal(value, &workout.PerformedAt)
+	case "activity":
+		var activity *Activity
+		err = json.Unmarshal(value, &activity)
+		workout.Activity = activity
+	}
+	if err != nil {
+		return &SyncError{Reason: "invalid " + field}
+	}
+	return nil
+}
+
+func setEntryField(entry *WorkoutEntry, field string, value json.RawMessage) error {
+	var err error
+	switch field {
+	case "exercise_name":
+		err = json.Unmarshal(value, &entry.ExerciseName)
+	case "exercise_sets":
+		err = json.Unmarshal(value, &entry.ExerciseSets)
+	case "reps":
+		entry.Reps = nil
+		err = json.Unmarshal(value, &entry.Reps)
+	case "duration_seconds":
+		entry.DurationSeconds = nil
+		err = json.Unmarshal(value, &entry.DurationSeconds)
+	case "weight":
+		entry.Weight = nil
+		err = json.Unmarshal(value, &entry.Weight)
+	case "notes":
+		err = json.Unmarshal(value, &entry.Notes)
+	case "order_index":
+		err = json.Unmarshal(value, &entry.OrderIndex)
+	}
+	if err != nil {
+		return &SyncError{Reason: "invalid entry " + field}
+	}
+	return nil
+}
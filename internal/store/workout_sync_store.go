@@ -0,0 +1,482 @@
+package store
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+const (
+	syncSnapshotPage = 100
+	syncDeltaPage    = 500
+)
+
+// ErrSyncTokenExpired is returned for a sync token older than the events still kept, the
+// changes made since can not be told apart anymore
+var ErrSyncTokenExpired = errors.New("sync token expired")
+
+// ApplySyncChange applies a client change in its own transaction. It returns false without
+// doing anything when the mutation was applied before. A change creating a workout starts
+// from base, which carries the owner and their defaults; validate sees the merged workout
+// before it is written and its error is returned as is. Changes that can never be applied
+// return a *SyncError.
+func (pg *PostgresWorkoutStore) ApplySyncChange(change SyncChange, base *Workout, validate func(*Workout) error) (bool, error) {
+	tx, err := begin(pg.db)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	// retried syncs wait here for the first attempt and then skip the mutation
+	result, err := tx.Exec(`INSERT INTO sync_mutations(user_id,mutation_id) VALUES($1,$2) ON CONFLICT DO NOTHING`,
+		base.UserID, change.MutationID)
+	if err != nil {
+		return false, err
+	}
+	inserted, err := result.RowsAffected()
+	if err != nil || inserted == 0 {
+		return false, err
+	}
+
+	var purged bool
+	err = tx.QueryRow(`SELECT EXISTS(SELECT 1 FROM workout_tombstones WHERE public_id=$1 AND user_id=$2)`,
+		change.WorkoutID, base.UserID).Scan(&purged)
+	if err != nil {
+		return false, err
+	}
+
+	var id int64
+	var userID int
+	var deleted bool
+	var clockData []byte
+	var updatedAt time.Time
+	if !purged {
+		query := `
+		SELECT id,user_id,deleted_at IS NOT NULL,field_clocks,COALESCE(updated_at,created_at,'epoch')
+		FROM workouts
+		WHERE public_id=$1
+		FOR UPDATE
+		`
+		err = tx.QueryRow(query, change.WorkoutID).Scan(&id, &userID, &deleted, &clockData, &updatedAt)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return false, err
+		}
+		if err == nil && userID != base.UserID {
+			return false, &SyncError{Reason: "workout not found"}
+		}
+	}
+	exists := !purged && err == nil
+
+	var eventType string
+	switch {
+	case change.Op == SyncOpDelete:
+		// deleting a workout the server never saw, or already deleted, leaves nothing to do
+		if !exists || deleted {
+			return true, tx.Commit()
+		}
+		eventType = WorkoutDeleted
+		err = syncDeleteWorkout(tx, id)
+	case purged || deleted:
+		return false, &SyncError{Reason: "the workout was deleted"}
+	case !exists:
+		eventType = WorkoutCreated
+		id, err = syncCreateWorkout(tx, change, base, validate)
+	default:
+		eventType = WorkoutUpdated
+		var changed bool
+		changed, err = syncUpdateWorkout(tx, id, clockData, updatedAt, change, validate)
+		if err == nil && !changed {
+			return true, tx.Commit()
+		}
+	}
+	if err != nil {
+		return false, err
+	}
+
+	err = logWorkoutEvent(tx, eventType, id)
+	if err != nil {
+		return false, err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return false, err
+	}
+	pg.notify(eventType, base.UserID, id)
+	return true, nil
+}
+
+func syncDeleteWorkout(tx Querier, id int64) error {
+	_, err := tx.Exec(`UPDATE workouts SET deleted_at=CURRENT_TIMESTAMP,version=version+1 WHERE id=$1`, id)
+	return err
+}
+
+func syncCreateWorkout(tx Querier, change SyncChange, base *Workout, validate func(*Workout) error) (int64, error) {
+	workout := *base
+	workout.PublicID = change.WorkoutID
+	state := newSyncState(&workout, FieldClocks{})
+	err := state.merge(change)
+	if err != nil {
+		return 0, err
+	}
+	err = checkNewEntryIDs(tx, state)
+	if err != nil {
+		return 0, err
+	}
+	err = validate(&workout)
+	if err != nil {
+		return 0, err
+	}
+
+	err = insertWorkoutRow(tx, &workout, true)
+	if err != nil {
+		return 0, err
+	}
+	err = writeFieldClocks(tx, state)
+	if err != nil {
+		return 0, err
+	}
+	return int64(workout.ID), nil
+}
+
+func syncUpdateWorkout(tx Querier, id int64, clockData []byte, updatedAt time.Time, change SyncChange, validate func(*Workout) error) (bool, error) {
+	err := ensureBaselineRevision(tx, id)
+	if err != nil {
+		return false, err
+	}
+
+	state, err := loadSyncState(tx, id, clockData, updatedAt)
+	if err != nil {
+		return false, err
+	}
+	err = state.merge(change)
+	if err != nil || !state.dirty {
+		return false, err
+	}
+	err = checkNewEntryIDs(tx, state)
+	if err != nil {
+		return false, err
+	}
+	workout := state.workout
+	err = validate(workout)
+	if err != nil {
+		return false, err
+	}
+
+	query := `
+	UPDATE workouts
+	SET title=$1,description=$2,duration=$3,calories_burned=$4,calories_estimated=$5,type=$6,visibility=$7,performed_at=$8,version=version+1,updated_at=CURRENT_TIMESTAMP
+	WHERE id=$9
+	RETURNING version
+	`
+	err = tx.QueryRow(query, workout.Title, workout.Description, workout.DurationInMinutes, workout.CaloriesBurned, workout.CaloriesEstimated,
+		workout.Type, workout.Visibility, workout.PerformedAt, workout.ID).Scan(&workout.Version)
+	if err != nil {
+		return false, err
+	}
+
+	for _, entry := range state.removed {
+		err = deleteWorkoutEntry(tx, workout.ID, entry)
+		if err != nil {
+			return false, err
+		}
+	}
+	for i := range workout.Entries {
+		entry := &workout.Entries[i]
+		synced := state.entries[entry.PublicID]
+		switch {
+		case synced.isNew:
+			query := `
+			INSERT INTO workout_entries(workout_id,public_id,exercise_name,exercise_sets,reps,duration_seconds,weight,notes,order_index)
+			VALUES($1,$2,$3,$4,$5,$6,$7,$8,$9)
+			RETURNING id
+			`
+			err = tx.QueryRow(query, workout.ID, entry.PublicID, entry.ExerciseName, entry.ExerciseSets, entry.Reps, entry.DurationSeconds, entry.Weight, entry.Notes, entry.OrderIndex).Scan(&entry.ID)
+		case synced.dirty:
+			query := `
+			UPDATE workout_entries
+			SET exercise_name=$1,exercise_sets=$2,reps=$3,duration_seconds=$4,weight=$5,notes=$6,order_index=$7,updated_at=CURRENT_TIMESTAMP
+			WHERE id=$8
+			`
+			_, err = tx.Exec(query, entry.ExerciseName, entry.ExerciseSets, entry.Reps, entry.DurationSeconds, entry.Weight, entry.Notes, entry.OrderIndex, entry.ID)
+		}
+		if err != nil {
+			return false, err
+		}
+	}
+
+	if state.activityChanged {
+		if workout.Activity != nil {
+			err = saveActivity(tx, workout.ID, workout.Activity)
+		} else {
+			err = deleteActivity(tx, workout.ID)
+		}
+		if err != nil {
+			return false, err
+		}
+	}
+
+	err = writeFieldClocks(tx, state)
+	if err != nil {
+		return false, err
+	}
+	return true, recordRevision(tx, id, workout.UserID)
+}
+
+// loadSyncState reads the workout with the clocks of its fields and entries
+func loadSyncState(tx Querier, id int64, clockData []byte, updatedAt time.Time) (*syncState, error) {
+	workout, err := getWorkout(tx, id)
+	if err != nil {
+		return nil, err
+	}
+	clocks, err := readFieldClocks(clockData, workoutSyncFields, updatedAt)
+	if err != nil {
+		return nil, err
+	}
+	state := newSyncState(workout, clocks)
+
+	rows, err := tx.Query(`SELECT public_id,field_clocks,COALESCE(updated_at,created_at,'epoch') FROM workout_entries WHERE workout_id=$1`, id)
+	if err != nil {
+		return nil, err
+	}
+	entryClocks := make(map[string]FieldClocks)
+	for rows.Next() {
+		var publicID string
+		var data []byte
+		var entryUpdatedAt time.Time
+		err := rows.Scan(&publicID, &data, &entryUpdatedAt)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		entryClocks[publicID], err = readFieldClocks(data, entrySyncFields, entryUpdatedAt)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for _, entry := range workout.Entries {
+		state.entries[entry.PublicID] = &syncEntry{entry: entry, clocks: entryClocks[entry.PublicID]}
+	}
+
+	rows, err = tx.Query(`SELECT public_id FROM workout_entry_tombstones WHERE workout_id=$1`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var publicID string
+		err := rows.Scan(&publicID)
+		if err != nil {
+			return nil, err
+		}
+		state.tombstones[publicID] = true
+	}
+	return state, rows.Err()
+}
+
+// checkNewEntryIDs rejects entry ids the client generated that are already taken elsewhere
+func checkNewEntryIDs(tx Querier, state *syncState) error {
+	for id, entry := range state.entries {
+		if !entry.isNew {
+			continue
+		}
+		var taken bool
+		err := tx.QueryRow(`SELECT EXISTS(SELECT 1 FROM workout_entries WHERE public_id=$1)
+			OR EXISTS(SELECT 1 FROM workout_entry_tombstones WHERE public_id=$1)`, id).Scan(&taken)
+		if err != nil {
+			return err
+		}
+		if taken {
+			return &SyncError{Reason: "entry id " + id + " is already used"}
+		}
+	}
+	return nil
+}
+
+func writeFieldClocks(tx Querier, state *syncState) error {
+	data, err := json.Marshal(state.clocks)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`UPDATE workouts SET field_clocks=$1 WHERE id=$2`, data, state.workout.ID)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range state.entries {
+		if !entry.isNew && !entry.dirty {
+			continue
+		}
+		data, err := json.Marshal(entry.clocks)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`UPDATE workout_entries SET field_clocks=$1 WHERE workout_id=$2 AND public_id=$3`, data, state.workout.ID, entry.entry.PublicID)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteWorkoutEntry deletes the entry and leaves a tombstone behind
+func deleteWorkoutEntry(tx Querier, workoutID int, entry WorkoutEntry) error {
+	_, err := tx.Exec(`DELETE FROM workout_entries WHERE id=$1`, entry.ID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`INSERT INTO workout_entry_tombstones(public_id,workout_id) VALUES($1,$2) ON CONFLICT DO NOTHING`, entry.PublicID, workoutID)
+	return err
+}
+
+// ListSyncChanges returns the workouts that changed after the token, and the token to resume
+// from. A snapshot token pages through every workout of the user instead, once the snapshot
+// is complete the changes made while it was read follow as deltas. A token from before the
+// purged events returns ErrSyncTokenExpired.
+func (pg *PostgresWorkoutStore) ListSyncChanges(userID int, token SyncToken) ([]SyncedWorkout, SyncToken, bool, error) {
+	if token.Snapshot {
+		return pg.listSyncSnapshot(userID, token)
+	}
+
+	purged, err := userEventsPurgedAfter(pg.db, userID, token.Seq)
+	if err != nil {
+		return nil, token, false, err
+	}
+	if purged {
+		return nil, token, false, ErrSyncTokenExpired
+	}
+
+	rows, err := pg.db.Query(`SELECT seq,workout_id FROM user_events WHERE user_id=$1 AND seq>$2 ORDER BY seq LIMIT $3`,
+		userID, token.Seq, syncDeltaPage)
+	if err != nil {
+		return nil, token, false, err
+	}
+	var workoutIDs []string
+	seen := make(map[string]bool)
+	count := 0
+	for rows.Next() {
+		var workoutID string
+		err := rows.Scan(&token.Seq, &workoutID)
+		if err != nil {
+			rows.Close()
+			return nil, token, false, err
+		}
+		count++
+		if !seen[workoutID] {
+			seen[workoutID] = true
+			workoutIDs = append(workoutIDs, workoutID)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, token, false, err
+	}
+
+	changes := []SyncedWorkout{}
+	for _, publicID := range workoutIDs {
+		change, err := pg.getSyncedWorkout(userID, publicID)
+		if err != nil {
+			return nil, token, false, err
+		}
+		changes = append(changes, change)
+	}
+	return changes, token, count == syncDeltaPage, nil
+}
+
+func (pg *PostgresWorkoutStore) listSyncSnapshot(userID int, token SyncToken) ([]SyncedWorkout, SyncToken, bool, error) {
+	// the snapshot starts at the current end of the log, changes made while it is paged through
+	// are read as deltas afterwards. A change still to commit gets a seq after the end.
+	if token.After == "" && token.Seq == 0 {
+		var err error
+		token.Seq, err = latestUserEventSeq(pg.db, userID)
+		if err != nil {
+			return nil, token, false, err
+		}
+	}
+
+	query := `
+	SELECT id,public_id FROM workouts
+	WHERE user_id=$1 AND deleted_at IS NULL
+		AND public_id>COALESCE(NULLIF($2,'')::uuid,'00000000-0000-0000-0000-000000000000')
+	ORDER BY public_id
+	LIMIT $3
+	`
+	rows, err := pg.db.Query(query, userID, token.After, syncSnapshotPage)
+	if err != nil {
+		return nil, token, false, err
+	}
+	type page struct {
+		id       int64
+		publicID string
+	}
+	var workouts []page
+	for rows.Next() {
+		var p page
+		err := rows.Scan(&p.id, &p.publicID)
+		if err != nil {
+			rows.Close()
+			return nil, token, false, err
+		}
+		workouts = append(workouts, p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, token, false, err
+	}
+
+	changes := []SyncedWorkout{}
+	for _, p := range workouts {
+		workout, err := getWorkout(pg.db, p.id)
+		if errors.Is(err, sql.ErrNoRows) {
+			// deleted since the page was read, the delta will carry the tombstone
+			continue
+		}
+		if err != nil {
+			return nil, token, false, err
+		}
+		changes = append(changes, SyncedWorkout{ID: p.publicID, Workout: workout})
+	}
+
+	if len(workouts) < syncSnapshotPage {
+		return changes, SyncToken{Seq: token.Seq}, false, nil
+	}
+	token.After = workouts[len(workouts)-1].publicID
+	return changes, token, true, nil
+}
+
+// getSyncedWorkout returns the current state of the workout, or its tombstone once it was
+// deleted
+func (pg *PostgresWorkoutStore) getSyncedWorkout(userID int, publicID string) (SyncedWorkout, error) {
+	var id int64
+	err := pg.db.QueryRow(`SELECT id FROM workouts WHERE public_id=$1 AND user_id=$2 AND deleted_at IS NULL`, publicID, userID).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return SyncedWorkout{ID: publicID, Deleted: true}, nil
+	}
+	if err != nil {
+		return SyncedWorkout{}, err
+	}
+
+	workout, err := getWorkout(pg.db, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return SyncedWorkout{ID: publicID, Deleted: true}, nil
+	}
+	if err != nil {
+		return SyncedWorkout{}, err
+	}
+	return SyncedWorkout{ID: publicID, Workout: workout}, nil
+}
+
+// PurgeSyncMutations forgets the mutations applied longer than retention ago, a retry coming
+// after that applies them again
+func (pg *PostgresWorkoutStore) PurgeSyncMutations(retention time.Duration) (int64, error) {
+	result, err := pg.db.Exec(`DELETE FROM sync_mutations WHERE applied_at < $1`, time.Now().Add(-retention))
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
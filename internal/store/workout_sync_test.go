@@ -0,0 +1,124 @@
+package store
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	entryA = "0190b6a4-0000-7000-8000-00000000000a"
+	entryB = "0190b6a4-0000-7000-8000-00000000000b"
+	entryC = "0190b6a4-0000-7000-8000-00000000000c"
+)
+
+func syncFields(fields map[string]any) map[string]json.RawMessage {
+	raw := make(map[string]json.RawMessage)
+	for field, value := range fields {
+		data, _ := json.Marshal(value)
+		raw[field] = data
+	}
+	return raw
+}
+
+func testSyncState(t *testing.T, updatedAt time.Time) *syncState {
+	clocks, err := readFieldClocks([]byte(`{"title":"2024-05-06T12:00:00Z"}`), workoutSyncFields, updatedAt)
+	require.NoError(t, err)
+	state := newSyncState(&Workout{Title: "Legs", DurationInMinutes: 60}, clocks)
+
+	entryClocks, err := readFieldClocks(nil, entrySyncFields, updatedAt)
+	require.NoError(t, err)
+	state.entries[entryA] = &syncEntry{
+		entry:  WorkoutEntry{PublicID: entryA, ExerciseName: "Squat", ExerciseSets: 3, Reps: IntPtr(5), OrderIndex: 1},
+		clocks: entryClocks,
+	}
+	state.tombstones[entryB] = true
+	return state
+}
+
+func TestSyncMergeIsLastWriterWinsPerField(t *testing.T) {
+	updatedAt := time.Date(2024, 5, 6, 10, 0, 0, 0, time.UTC)
+	state := testSyncState(t, updatedAt)
+
+	err := state.merge(SyncChange{
+		ModifiedAt: updatedAt.Add(time.Hour),
+		Fields:     syncFields(map[string]any{"title": "Leg day", "duration": 75}),
+		Entries: []SyncEntryChange{
+			{ID: entryA, Fields: syncFields(map[string]any{"reps": 8})},
+			{ID: entryC, Fields: syncFields(map[string]any{"exercise_name": "Lunge", "exercise_sets": 2, "reps": 10, "order_index": 0})},
+		},
+	})
+	require.NoError(t, err)
+
+	assert.True(t, state.dirty)
+	assert.Equal(t, "Legs", state.workout.Title, "the title was written later on the server")
+	assert.Equal(t, 75, state.workout.DurationInMinutes)
+	require.Len(t, state.workout.Entries, 2)
+	assert.Equal(t, "Lunge", state.workout.Entries[0].ExerciseName, "entries follow order_index")
+	assert.True(t, state.entries[entryC].isNew)
+	assert.Equal(t, 8, *state.entries[entryA].entry.Reps)
+	assert.Equal(t, "Squat", state.entries[entryA].entry.ExerciseName)
+	assert.Equal(t, updatedAt.Add(time.Hour), state.entries[entryA].clocks["reps"])
+}
+
+func TestSyncMergeKeepsDeletes(t *testing.T) {
+	updatedAt := time.Date(2024, 5, 6, 10, 0, 0, 0, time.UTC)
+	state := testSyncState(t, updatedAt)
+
+	err := state.merge(SyncChange{
+		ModifiedAt: updatedAt.Add(time.Hour),
+		Entries: []SyncEntryChange{
+			{ID: entryA, Deleted: true},
+			{ID: entryB, Fields: syncFields(map[string]any{"reps": 3})},
+		},
+	})
+	require.NoError(t, err)
+
+	assert.Empty(t, state.workout.Entries, "a deleted entry cannot be edited back")
+	require.Len(t, state.removed, 1)
+	assert.Equal(t, entryA, state.removed[0].PublicID)
+}
+
+func TestSyncMergeIgnoresStaleChanges(t *testing.T) {
+	updatedAt := time.Date(2024, 5, 6, 10, 0, 0, 0, time.UTC)
+	state := testSyncState(t, updatedAt)
+
+	err := state.merge(SyncChange{
+		ModifiedAt: updatedAt.Add(-time.Hour),
+		Fields:     syncFields(map[string]any{"duration": 30}),
+	})
+	require.NoError(t, err)
+	assert.False(t, state.dirty)
+	assert.Equal(t, 60, state.workout.DurationInMinutes)
+}
+
+func TestSyncMergeRejectsUnknownFields(t *testing.T) {
+	state := testSyncState(t, time.Now())
+
+	err := state.merge(SyncChange{ModifiedAt: time.Now(), Fields: syncFields(map[string]any{"version": 3})})
+	var syncErr *SyncError
+	assert.ErrorAs(t, err, &syncErr)
+
+	err = state.merge(SyncChange{ModifiedAt: time.Now(), Fields: syncFields(map[string]any{"duration": "long"})})
+	assert.ErrorAs(t, err, &syncErr)
+}
+
+func TestSyncToken(t *testing.T) {
+	token, err := ParseSyncToken("")
+	require.NoError(t, err)
+	assert.True(t, token.Snapshot)
+
+	for _, want := range []SyncToken{{Seq: 42}, {Seq: 7, Snapshot: true, After: entryA}} {
+		token, err := ParseSyncToken(want.String())
+		require.NoError(t, err)
+		assert.Equal(t, want, token)
+	}
+
+	for _, invalid := range []string{"x", "-1", "3:not-a-uuid"} {
+		_, err := ParseSyncToken(invalid)
+		assert.ErrorIs(t, err, ErrInvalidSyncToken, invalid)
+	}
+}
@@ -10,6 +10,8 @@ import (
 
 const (
 	ScopeAuth = "authentication"
+	// calendar tokens only grant read access to the .ics feed of their user
+	ScopeCalendar = "calendar"
 )
 
 type Token struct {
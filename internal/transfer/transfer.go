@@ -0,0 +1,443 @@
+// Package transfer encodes and decodes workouts for bulk export and import
+package transfer
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kodega2016/femapi/internal/store"
+)
+
+const (
+	FormatCSV    = "csv"
+	FormatJSON   = "json"
+	FormatNDJSON = "ndjson"
+)
+
+var ErrUnknownFormat = errors.New("unknown format, expected csv, json or ndjson")
+
+// csvHeader has one row per entry, workout columns are repeated and grouped by workout_id
+var csvHeader = []string{
+	"workout_id", "title", "description", "duration", "calories_burned", "type", "visibility", "performed_at",
+	"distance_meters", "moving_time_seconds", "exercise_name", "exercise_sets", "reps", "duration_seconds", "weight", "notes", "order_index",
+}
+
+func ContentType(format string) string {
+	switch format {
+	case FormatCSV:
+		return "text/csv"
+	case FormatNDJSON:
+		return "application/x-ndjson"
+	default:
+		return "application/json"
+	}
+}
+
+// FormatFromContentType maps an upload Content-Type back to a format
+func FormatFromContentType(contentType string) string {
+	switch {
+	case strings.HasPrefix(contentType, "text/csv"):
+		return FormatCSV
+	case strings.HasPrefix(contentType, "application/x-ndjson"), strings.HasPrefix(contentType, "application/ndjson"):
+		return FormatNDJSON
+	case strings.HasPrefix(contentType, "application/json"):
+		return FormatJSON
+	}
+	return ""
+}
+
+// Encoder writes workouts one at a time, Close must be called to finish the document
+type Encoder interface {
+	Encode(workout *store.Workout) error
+	Close() error
+}
+
+func NewEncoder(w io.Writer, format string) (Encoder, error) {
+	switch format {
+	case FormatCSV:
+		cw := csv.NewWriter(w)
+		return &csvEncoder{w: cw}, cw.Write(csvHeader)
+	case FormatJSON:
+		_, err := io.WriteString(w, `{"workouts":[`)
+		return &jsonEncoder{w: w}, err
+	case FormatNDJSON:
+		return &ndjsonEncoder{enc: json.NewEncoder(w)}, nil
+	}
+	return nil, ErrUnknownFormat
+}
+
+type csvEncoder struct {
+	w *csv.Writer
+}
+
+func optionalInt(v *int) string {
+	if v == nil {
+		return ""
+	}
+	return strconv.Itoa(*v)
+}
+
+func (e *csvEncoder) Encode(workout *store.Workout) error {
+	distance, movingTime, performedAt := "", "", ""
+	if !workout.PerformedAt.IsZero() {
+		performedAt = workout.PerformedAt.UTC().Format(time.RFC3339)
+	}
+	if workout.Activity != nil {
+		distance = strconv.FormatFloat(workout.Activity.DistanceMeters, 'f', -1, 64)
+		movingTime = strconv.Itoa(workout.Activity.MovingTimeSeconds)
+	}
+	base := []string{
+		workout.PublicID, workout.Title, workout.Description,
+		strconv.Itoa(workout.DurationInMinutes), strconv.Itoa(workout.CaloriesBurned), workout.Type, workout.Visibility,
+		performedAt, distance, movingTime,
+	}
+
+	if len(workout.Entries) == 0 {
+		err := e.w.Write(append(base, "", "", "", "", "", "", ""))
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, entry := range workout.Entries {
+		weight := ""
+		if entry.Weight != nil {
+			weight = strconv.FormatFloat(float64(*entry.Weight), 'f', -1, 32)
+		}
+		row := append(append([]string{}, base...),
+			entry.ExerciseName, strconv.Itoa(entry.ExerciseSets), optionalInt(entry.Reps),
+			optionalInt(entry.DurationSeconds), weight, entry.Notes, strconv.Itoa(entry.OrderIndex),
+		)
+		err := e.w.Write(row)
+		if err != nil {
+			return err
+		}
+	}
+
+	// flush per workout so the response streams instead of buffering the whole export
+	e.w.Flush()
+	return e.w.Error()
+}
+
+func (e *csvEncoder) Close() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+// exportedWorkout is the json of a workout in an export. Unlike the api responses it carries
+// the recorded track, so importing the export restores the workout as it was.
+type exportedWorkout struct {
+	*store.Workout
+	Activity *exportedActivity `json:"activity,omitempty"`
+}
+
+type exportedActivity struct {
+	*store.Activity
+	Track []store.TrackPoint `json:"track,omitempty"`
+}
+
+func exportWorkout(workout *store.Workout) exportedWorkout {
+	exported := exportedWorkout{Workout: workout}
+	if workout.Activity != nil {
+		exported.Activity = &exportedActivity{Activity: workout.Activity, Track: workout.Activity.Track}
+	}
+	return exported
+}
+
+func importWorkout(raw []byte) (*store.Workout, error) {
+	exported := exportedWorkout{Workout: &store.Workout{}}
+	err := json.Unmarshal(raw, &exported)
+	if err != nil {
+		return nil, err
+	}
+	if exported.Activity != nil && exported.Activity.Activity != nil {
+		exported.Workout.Activity = exported.Activity.Activity
+		exported.Workout.Activity.Track = exported.Activity.Track
+	}
+	return exported.Workout, nil
+}
+
+type jsonEncoder struct {
+	w     io.Writer
+	count int
+}
+
+func (e *jsonEncoder) Encode(workout *store.Workout) error {
+	js, err := json.Marshal(exportWorkout(workout))
+	if err != nil {
+		return err
+	}
+	if e.count > 0 {
+		_, err = io.WriteString(e.w, ",")
+		if err != nil {
+			return err
+		}
+	}
+	e.count++
+	_, err = e.w.Write(js)
+	return err
+}
+
+func (e *jsonEncoder) Close() error {
+	_, err := io.WriteString(e.w, "]}\n")
+	return err
+}
+
+type ndjsonEncoder struct {
+	enc *json.Encoder
+}
+
+func (e *ndjsonEncoder) Encode(workout *store.Workout) error {
+	return e.enc.Encode(exportWorkout(workout))
+}
+
+func (e *ndjsonEncoder) Close() error {
+	return nil
+}
+
+// Record is one decoded workout, Row points at where it started in the upload
+// (the line for csv and ndjson, the 1 based array position for json)
+type Record struct {
+	Row     int
+	Workout *store.Workout
+	Err     error
+}
+
+// Decode reads workouts from r and calls fn for each of them. Row level problems are
+// reported through Record.Err, only unreadable documents make Decode itself fail.
+func Decode(r io.Reader, format string, fn func(Record) error) error {
+	switch format {
+	case FormatCSV:
+		return decodeCSV(r, fn)
+	case FormatJSON:
+		return decodeJSON(r, fn)
+	case FormatNDJSON:
+		return decodeNDJSON(r, fn)
+	}
+	return ErrUnknownFormat
+}
+
+func decodeJSON(r io.Reader, fn func(Record) error) error {
+	var doc struct {
+		Workouts []json.RawMessage `json:"workouts"`
+	}
+	err := json.NewDecoder(r).Decode(&doc)
+	if err != nil {
+		return err
+	}
+
+	for i, raw := range doc.Workouts {
+		record := Record{Row: i + 1}
+		record.Workout, record.Err = importWorkout(raw)
+		err = fn(record)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func decodeNDJSON(r io.Reader, fn func(Record) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+
+		record := Record{Row: line}
+		record.Workout, record.Err = importWorkout([]byte(text))
+		err := fn(record)
+		if err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+func decodeCSV(r io.Reader, fn func(Record) error) error {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err != nil {
+		return err
+	}
+
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.TrimSpace(strings.ToLower(name))] = i
+	}
+	for _, required := range []string{"title", "exercise_name"} {
+		if _, ok := columns[required]; !ok {
+			return fmt.Errorf("missing required column %q", required)
+		}
+	}
+
+	var current *Record
+	currentKey := ""
+	line := 1
+	for {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		line++
+		if err != nil {
+			return err
+		}
+
+		field := func(name string) string {
+			i, ok := columns[name]
+			if !ok || i >= len(row) {
+				return ""
+			}
+			return strings.TrimSpace(row[i])
+		}
+
+		// consecutive rows sharing a workout_id belong to the same workout
+		key := field("workout_id")
+		if current == nil || key == "" || key != currentKey {
+			if current != nil {
+				err = fn(*current)
+				if err != nil {
+					return err
+				}
+			}
+			current = &Record{Row: line, Workout: &store.Workout{}}
+			currentKey = key
+			err = parseCSVWorkout(current.Workout, field)
+			if err != nil {
+				current.Err = fmt.Errorf("row %d: %w", line, err)
+			}
+		}
+
+		if current.Err != nil || field("exercise_name") == "" {
+			continue
+		}
+		entry, err := parseCSVEntry(field)
+		if err != nil {
+			current.Err = fmt.Errorf("row %d: %w", line, err)
+			continue
+		}
+		current.Workout.Entries = append(current.Workout.Entries, entry)
+	}
+
+	if current != nil {
+		return fn(*current)
+	}
+	return nil
+}
+
+func parseCSVWorkout(workout *store.Workout, field func(string) string) error {
+	var err error
+	workout.Title = field("title")
+	workout.Description = field("description")
+	workout.Type = field("type")
+	workout.Visibility = field("visibility")
+
+	if v := field("duration"); v != "" {
+		workout.DurationInMinutes, err = strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q", v)
+		}
+	}
+	if v := field("calories_burned"); v != "" {
+		workout.CaloriesBurned, err = strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("invalid calories_burned %q", v)
+		}
+	}
+	if v := field("performed_at"); v != "" {
+		workout.PerformedAt, err = time.Parse(time.RFC3339, v)
+		if err != nil {
+			return fmt.Errorf("invalid performed_at %q", v)
+		}
+	}
+
+	// cardio workouts carry their distance and moving time on every row
+	distance, movingTime := field("distance_meters"), field("moving_time_seconds")
+	if distance == "" && movingTime == "" {
+		return nil
+	}
+	workout.Activity = &store.Activity{}
+	if distance != "" {
+		workout.Activity.DistanceMeters, err = strconv.ParseFloat(distance, 64)
+		if err != nil {
+			return fmt.Errorf("invalid distance_meters %q", distance)
+		}
+	}
+	if movingTime != "" {
+		workout.Activity.MovingTimeSeconds, err = strconv.Atoi(movingTime)
+		if err != nil {
+			return fmt.Errorf("invalid moving_time_seconds %q", movingTime)
+		}
+	}
+	return nil
+}
+
+func parseOptionalInt(field func(string) string, name string) (*int, error) {
+	v := field(name)
+	if v == "" {
+		return nil, nil
+	}
+	i, err := strconv.Atoi(v)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s %q", name, v)
+	}
+	return &i, nil
+}
+
+func parseCSVEntry(field func(string) string) (store.WorkoutEntry, error) {
+	entry := store.WorkoutEntry{
+		ExerciseName: field("exercise_name"),
+		Notes:        field("notes"),
+	}
+
+	sets, err := parseOptionalInt(field, "exercise_sets")
+	if err != nil {
+		return entry, err
+	}
+	if sets != nil {
+		entry.ExerciseSets = *sets
+	}
+
+	entry.Reps, err = parseOptionalInt(field, "reps")
+	if err != nil {
+		return entry, err
+	}
+
+	entry.DurationSeconds, err = parseOptionalInt(field, "duration_seconds")
+	if err != nil {
+		return entry, err
+	}
+
+	if v := field("weight"); v != "" {
+		weight, err := strconv.ParseFloat(v, 32)
+		if err != nil {
+			return entry, fmt.Errorf("invalid weight %q", v)
+		}
+		w := float32(weight)
+		entry.Weight = &w
+	}
+
+	order, err := parseOptionalInt(field, "order_index")
+	if err != nil {
+		return entry, err
+	}
+	if order != nil {
+		entry.OrderIndex = *order
+	}
+	return entry, nil
+}
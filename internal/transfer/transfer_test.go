@@ -0,0 +1,137 @@
+package transfer
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kodega2016/femapi/internal/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func intPtr(i int) *int {
+	return &i
+}
+
+func floatPtr(f float32) *float32 {
+	return &f
+}
+
+func sampleWorkouts() []*store.Workout {
+	return []*store.Workout{
+		{
+			PublicID:          "0190a1b2-c3d4-7e5f-8a6b-7c8d9e0f1a2b",
+			Title:             "push day",
+			Description:       "chest, shoulders",
+			DurationInMinutes: 60,
+			CaloriesBurned:    300,
+			Type:              store.WorkoutTypeStrength,
+			Visibility:        store.VisibilityPrivate,
+			PerformedAt:       time.Date(2024, 5, 6, 18, 30, 0, 0, time.UTC),
+			Entries: []store.WorkoutEntry{
+				{ExerciseName: "Bench Press", ExerciseSets: 3, Reps: intPtr(10), Weight: floatPtr(82.5), Notes: "felt strong", OrderIndex: 1},
+				{ExerciseName: "Plank", ExerciseSets: 2, DurationSeconds: intPtr(60), OrderIndex: 2},
+			},
+		},
+		{
+			PublicID:          "0190a1b2-c3d4-7e5f-8a6b-7c8d9e0f1a2c",
+			Title:             "rest day walk",
+			DurationInMinutes: 30,
+			CaloriesBurned:    120,
+			Type:              store.WorkoutTypeRun,
+			Visibility:        store.VisibilityPublic,
+			Activity: &store.Activity{DistanceMeters: 3200.5, MovingTimeSeconds: 1740, Track: []store.TrackPoint{
+				{Time: time.Date(2024, 5, 7, 8, 0, 0, 0, time.UTC), HeartRate: intPtr(96)},
+				{Time: time.Date(2024, 5, 7, 8, 29, 0, 0, time.UTC), HeartRate: intPtr(104)},
+			}},
+		},
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, format := range []string{FormatCSV, FormatJSON, FormatNDJSON} {
+		t.Run(format, func(t *testing.T) {
+			var buf bytes.Buffer
+			enc, err := NewEncoder(&buf, format)
+			require.NoError(t, err)
+			for _, workout := range sampleWorkouts() {
+				require.NoError(t, enc.Encode(workout))
+			}
+			require.NoError(t, enc.Close())
+
+			var records []Record
+			err = Decode(&buf, format, func(record Record) error {
+				records = append(records, record)
+				return nil
+			})
+			require.NoError(t, err)
+			require.Len(t, records, 2)
+
+			for i, want := range sampleWorkouts() {
+				require.NoError(t, records[i].Err)
+				got := records[i].Workout
+				assert.Equal(t, want.Title, got.Title)
+				assert.Equal(t, want.Description, got.Description)
+				assert.Equal(t, want.DurationInMinutes, got.DurationInMinutes)
+				assert.Equal(t, want.CaloriesBurned, got.CaloriesBurned)
+				assert.Equal(t, want.Type, got.Type)
+				assert.Equal(t, want.Visibility, got.Visibility)
+				assert.True(t, want.PerformedAt.Equal(got.PerformedAt))
+				if want.Activity != nil {
+					require.NotNil(t, got.Activity)
+					assert.Equal(t, want.Activity.DistanceMeters, got.Activity.DistanceMeters)
+					assert.Equal(t, want.Activity.MovingTimeSeconds, got.Activity.MovingTimeSeconds)
+					// csv has a row per entry, the recorded track only travels in json
+					if format != FormatCSV {
+						assert.Equal(t, want.Activity.Track, got.Activity.Track)
+					}
+				} else {
+					assert.Nil(t, got.Activity)
+				}
+				require.Len(t, got.Entries, len(want.Entries))
+				for j := range want.Entries {
+					assert.Equal(t, want.Entries[j].ExerciseName, got.Entries[j].ExerciseName)
+					assert.Equal(t, want.Entries[j].Reps, got.Entries[j].Reps)
+					assert.Equal(t, want.Entries[j].DurationSeconds, got.Entries[j].DurationSeconds)
+					assert.Equal(t, want.Entries[j].Weight, got.Entries[j].Weight)
+					assert.Equal(t, want.Entries[j].Notes, got.Entries[j].Notes)
+				}
+			}
+		})
+	}
+}
+
+func TestDecodeCSVReportsRowErrors(t *testing.T) {
+	input := strings.Join([]string{
+		"workout_id,title,duration,calories_burned,exercise_name,exercise_sets,reps",
+		"a,legs,45,300,Squat,5,5",
+		"a,legs,45,300,Lunge,3,ten",
+		"b,arms,abc,100,Curl,3,12",
+		",core,20,80,Crunch,3,20",
+	}, "\n")
+
+	var records []Record
+	err := Decode(strings.NewReader(input), FormatCSV, func(record Record) error {
+		records = append(records, record)
+		return nil
+	})
+	require.NoError(t, err)
+	require.Len(t, records, 3)
+
+	assert.Equal(t, 2, records[0].Row)
+	assert.ErrorContains(t, records[0].Err, "row 3: invalid reps")
+
+	assert.Equal(t, 4, records[1].Row)
+	assert.ErrorContains(t, records[1].Err, "invalid duration")
+
+	require.NoError(t, records[2].Err)
+	assert.Equal(t, "core", records[2].Workout.Title)
+	assert.Len(t, records[2].Workout.Entries, 1)
+}
+
+func TestDecodeCSVRequiresColumns(t *testing.T) {
+	err := Decode(strings.NewReader("name,reps\nsquat,5\n"), FormatCSV, func(Record) error { return nil })
+	assert.ErrorContains(t, err, `missing required column "title"`)
+}
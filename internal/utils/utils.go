@@ -6,8 +6,10 @@ import (
 	"errors"
 	"net/http"
 	"strconv"
+	"strings"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/kodega2016/femapi/internal/publicid"
 )
 
 type Envelope map[string]interface{}
@@ -25,14 +27,61 @@ func WriteJSON(w http.ResponseWriter, status int, data Envelope) error {
 	return nil
 }
 
-func ReadIDParams(r *http.Request) (int64, error) {
-	idParam := chi.URLParam(r, "id")
+// ReadIDParams reads the {id} url param as a public id, or as a legacy numeric id in compatibility mode
+func ReadIDParams(r *http.Request) (publicid.Ref, error) {
+	return ReadRefParam(r, "id")
+}
+
+func ReadRefParam(r *http.Request, name string) (publicid.Ref, error) {
+	idParam := chi.URLParam(r, name)
 	if idParam == "" {
-		return 0, errors.New("invalid id params")
+		return publicid.Ref{}, errors.New("invalid id params")
 	}
-	id, err := strconv.ParseInt(idParam, 10, 64)
+	ref, err := publicid.Parse(idParam)
 	if err != nil {
-		return 0, errors.New("invalid id params type")
+		return publicid.Ref{}, errors.New("invalid id params type")
+	}
+	return ref, nil
+}
+
+// ReadIntParam reads a positive integer url param such as a revision number
+func ReadIntParam(r *http.Request, name string) (int, error) {
+	param := chi.URLParam(r, name)
+	value, err := strconv.Atoi(param)
+	if err != nil || value <= 0 {
+		return 0, errors.New("invalid " + name + " params")
+	}
+	return value, nil
+}
+
+// MatchETag reports whether an If-None-Match header value matches etag using the weak
+// comparison. The header may hold a comma separated list, and "*" matches any current
+// representation.
+func MatchETag(header, etag string) bool {
+	return matchETag(header, etag, false)
+}
+
+// MatchStrongETag is MatchETag with the strong comparison If-Match requires (RFC 9110
+// section 13.1.1): weak validators never match.
+func MatchStrongETag(header, etag string) bool {
+	return matchETag(header, etag, true)
+}
+
+func matchETag(header, etag string, strong bool) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return true
+		}
+		if strong {
+			if !strings.HasPrefix(candidate, "W/") && !strings.HasPrefix(etag, "W/") && candidate == etag {
+				return true
+			}
+			continue
+		}
+		if strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
 	}
-	return id, nil
+	return false
 }
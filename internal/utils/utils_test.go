@@ -0,0 +1,51 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatchETag(t *testing.T) {
+	etag := `"0190a1b2-c3d4-7e5f-8a6b-7c8d9e0f1a2b-3"`
+
+	tests := []struct {
+		name   string
+		header string
+		want   bool
+	}{
+		{name: "exact match", header: etag, want: true},
+		{name: "weak validator", header: "W/" + etag, want: true},
+		{name: "wildcard", header: "*", want: true},
+		{name: "list containing the etag", header: `"other", ` + etag, want: true},
+		{name: "stale version", header: `"0190a1b2-c3d4-7e5f-8a6b-7c8d9e0f1a2b-2"`, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, MatchETag(tt.header, etag))
+		})
+	}
+}
+
+func TestMatchStrongETag(t *testing.T) {
+	etag := `"0190a1b2-c3d4-7e5f-8a6b-7c8d9e0f1a2b-3"`
+
+	tests := []struct {
+		name   string
+		header string
+		want   bool
+	}{
+		{name: "exact match", header: etag, want: true},
+		{name: "weak validator", header: "W/" + etag, want: false},
+		{name: "wildcard", header: "*", want: true},
+		{name: "list containing the etag", header: "W/" + etag + ", " + etag, want: true},
+		{name: "stale version", header: `"0190a1b2-c3d4-7e5f-8a6b-7c8d9e0f1a2b-2"`, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, MatchStrongETag(tt.header, etag))
+		})
+	}
+}
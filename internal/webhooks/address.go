@@ -0,0 +1,48 @@
+package webhooks
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"syscall"
+)
+
+// ErrForbiddenAddress is returned when an endpoint resolves to an address of the server's own
+// network, deliveries are never sent there
+var ErrForbiddenAddress = errors.New("webhook endpoints must resolve to a public address")
+
+// sharedAddressSpace is the carrier-grade NAT range, it is not routed on the internet either
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// AllowedAddr reports whether deliveries may be sent to addr. Loopback, private, link-local
+// (which holds the cloud metadata endpoints), unspecified and multicast addresses are
+// refused. allowLoopback lets loopback through for local development.
+func AllowedAddr(addr netip.Addr, allowLoopback bool) bool {
+	addr = addr.Unmap()
+	if addr.IsLoopback() {
+		return allowLoopback
+	}
+	return addr.IsValid() && !addr.IsPrivate() && !addr.IsLinkLocalUnicast() && !addr.IsLinkLocalMulticast() &&
+		!addr.IsInterfaceLocalMulticast() && !addr.IsMulticast() && !addr.IsUnspecified() &&
+		!sharedAddressSpace.Contains(addr)
+}
+
+// dialControl checks the address every connection is about to be made to, after the name was
+// resolved, so a name pointing at an internal address is refused too
+func dialControl(allowLoopback func() bool) func(network, address string, _ syscall.RawConn) error {
+	return func(network, address string, _ syscall.RawConn) error {
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			return err
+		}
+		addr, err := netip.ParseAddr(host)
+		if err != nil {
+			return err
+		}
+		if !AllowedAddr(addr, allowLoopback()) {
+			return fmt.Errorf("%w: %s", ErrForbiddenAddress, addr)
+		}
+		return nil
+	}
+}
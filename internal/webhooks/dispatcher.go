@@ -0,0 +1,201 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/kodega2016/femapi/internal/store"
+)
+
+const (
+	// MaxAttempts is how often a delivery is sent before it is dead, about ten hours of retries
+	MaxAttempts = 10
+
+	firstRetry = 30 * time.Second
+	maxRetry   = 6 * time.Hour
+
+	requestTimeout = 10 * time.Second
+	batchSize      = 100
+	senders        = 8
+	// a claimed delivery is left alone until the whole batch could have been sent one request
+	// timeout after the other by the senders, with a minute to spare
+	deliveryLease = (batchSize+senders-1)/senders*requestTimeout + time.Minute
+)
+
+// Backoff is the wait after the given failed attempt: 30s doubling on every attempt up to 6h
+func Backoff(attempt int) time.Duration {
+	if attempt < 1 {
+		attempt = 1
+	}
+	wait := firstRetry
+	for i := 1; i < attempt; i++ {
+		wait *= 2
+		if wait >= maxRetry {
+			return maxRetry
+		}
+	}
+	return wait
+}
+
+// Event is the body of every delivery, ID is the same for every attempt and endpoint so
+// receivers can drop duplicates
+type Event struct {
+	ID        string          `json:"id"`
+	Type      string          `json:"type"`
+	CreatedAt time.Time       `json:"created_at"`
+	Data      json.RawMessage `json:"data"`
+}
+
+// Dispatcher moves the events of the outbox to the endpoints. Any number of instances can run
+// one, a delivery is only claimed by one of them at a time. AllowLoopback lets deliveries reach
+// loopback addresses for local development, it can be changed before Run.
+type Dispatcher struct {
+	AllowLoopback bool
+
+	store  store.WebhookStore
+	client *http.Client
+	logger *log.Logger
+}
+
+func NewDispatcher(webhookStore store.WebhookStore, logger *log.Logger) *Dispatcher {
+	d := &Dispatcher{store: webhookStore, logger: logger}
+
+	dialer := &net.Dialer{
+		Timeout: requestTimeout,
+		Control: dialControl(func() bool { return d.AllowLoopback }),
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = dialer.DialContext
+	// a proxy would make the connection for us, past the address check
+	transport.Proxy = nil
+	d.client = &http.Client{
+		Timeout:   requestTimeout,
+		Transport: transport,
+		// a redirect is a misconfigured endpoint, the signed body is not sent elsewhere
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	return d
+}
+
+// Run dispatches on every interval until ctx is done. A full batch is followed by the next one
+// right away.
+func (d *Dispatcher) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		sent, err := d.RunOnce(ctx)
+		if err != nil {
+			d.logger.Printf("ERROR: dispatching webhooks: %v", err)
+		}
+		if err == nil && sent == batchSize {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce queues the deliveries of new outbox events and sends a batch of the due ones. It
+// returns how many deliveries were sent.
+func (d *Dispatcher) RunOnce(ctx context.Context) (int, error) {
+	_, err := d.store.QueueWebhookDeliveries(batchSize)
+	if err != nil {
+		return 0, fmt.Errorf("queueWebhookDeliveries: %w", err)
+	}
+	deliveries, err := d.store.ClaimDueDeliveries(batchSize, deliveryLease)
+	if err != nil {
+		return 0, fmt.Errorf("claimDueDeliveries: %w", err)
+	}
+
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, senders)
+	for _, delivery := range deliveries {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(delivery store.DueDelivery) {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			result := d.send(ctx, delivery, time.Now())
+			err := d.store.RecordDeliveryAttempt(delivery, result)
+			if errors.Is(err, store.ErrDeliveryReclaimed) {
+				d.logger.Printf("webhook delivery %d was claimed again while it was sent, dropping the result", delivery.ID)
+				return
+			}
+			if err != nil {
+				// the lease runs out and the delivery is sent again
+				d.logger.Printf("ERROR: recordDeliveryAttempt: %v", err)
+			}
+		}(delivery)
+	}
+	wg.Wait()
+	return len(deliveries), nil
+}
+
+// send posts the delivery and decides what happens next: any 2xx response is a success,
+// everything else is retried until the last attempt
+func (d *Dispatcher) send(ctx context.Context, delivery store.DueDelivery, now time.Time) store.DeliveryResult {
+	result := d.post(ctx, delivery, now)
+	result.Succeeded = result.Error == "" && result.StatusCode >= 200 && result.StatusCode < 300
+	if !result.Succeeded && delivery.Attempt < MaxAttempts {
+		retryAt := now.Add(Backoff(delivery.Attempt))
+		result.RetryAt = &retryAt
+	}
+	return result
+}
+
+func (d *Dispatcher) post(ctx context.Context, delivery store.DueDelivery, now time.Time) store.DeliveryResult {
+	body, err := json.Marshal(Event{
+		ID:        delivery.EventID,
+		Type:      delivery.EventType,
+		CreatedAt: delivery.CreatedAt,
+		Data:      delivery.Payload,
+	})
+	if err != nil {
+		return store.DeliveryResult{Error: err.Error()}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.URL, bytes.NewReader(body))
+	if err != nil {
+		return store.DeliveryResult{Error: err.Error()}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "femapi-webhooks/1")
+	req.Header.Set(EventIDHeader, delivery.EventID)
+	req.Header.Set(EventTypeHeader, delivery.EventType)
+	req.Header.Set(SignatureHeader, Sign(delivery.Secret, now, body))
+
+	start := time.Now()
+	resp, err := d.client.Do(req)
+	duration := time.Since(start)
+	if err != nil {
+		return store.DeliveryResult{Error: err.Error(), Duration: duration}
+	}
+	defer resp.Body.Close()
+
+	result := store.DeliveryResult{StatusCode: resp.StatusCode, Duration: duration}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		// the body is not kept, the delivery log would hand whatever the endpoint returned to
+		// its owner
+		result.Error = fmt.Sprintf("endpoint responded %s", resp.Status)
+	}
+	// drain what is left so the connection can be reused
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	return result
+}
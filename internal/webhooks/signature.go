@@ -0,0 +1,89 @@
+// Package webhooks delivers the events of the outbox to the endpoints users registered. Every
+// request is signed with the secret of its endpoint, failed deliveries are retried with an
+// exponential backoff until they run out of attempts.
+package webhooks
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	SignatureHeader = "Webhook-Signature"
+	EventIDHeader   = "Webhook-Id"
+	EventTypeHeader = "Webhook-Event"
+
+	secretPrefix = "whsec_"
+)
+
+var (
+	ErrInvalidSignature = errors.New("webhook signature does not match")
+	ErrSignatureExpired = errors.New("webhook signature timestamp is outside the tolerance")
+)
+
+// NewSecret returns a random secret to sign the deliveries of an endpoint with
+func NewSecret() (string, error) {
+	randomBytes := make([]byte, 24)
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return "", err
+	}
+	return secretPrefix + hex.EncodeToString(randomBytes), nil
+}
+
+func mac(secret string, timestamp int64, body []byte) string {
+	hash := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(hash, "%d.", timestamp)
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// Sign returns the Webhook-Signature header of a body sent at t: "t=<unix seconds>,v1=<hex>"
+// where v1 is the HMAC-SHA256 of "<unix seconds>.<body>". The timestamp is signed too, so a
+// captured request can not be replayed once it is older than the receiver's tolerance.
+func Sign(secret string, t time.Time, body []byte) string {
+	timestamp := t.Unix()
+	return fmt.Sprintf("t=%d,v1=%s", timestamp, mac(secret, timestamp, body))
+}
+
+// Verify checks a Webhook-Signature header the way receivers should: the signature matches the
+// body and was made less than tolerance before now
+func Verify(secret, header string, body []byte, tolerance time.Duration, now time.Time) error {
+	var timestamp int64
+	var signatures []string
+	for _, part := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch key {
+		case "t":
+			parsed, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return ErrInvalidSignature
+			}
+			timestamp = parsed
+		case "v1":
+			signatures = append(signatures, value)
+		}
+	}
+	if timestamp == 0 || len(signatures) == 0 {
+		return ErrInvalidSignature
+	}
+
+	signedAt := time.Unix(timestamp, 0)
+	if now.Sub(signedAt) > tolerance || signedAt.Sub(now) > tolerance {
+		return ErrSignatureExpired
+	}
+	expected := mac(secret, timestamp, body)
+	for _, signature := range signatures {
+		if hmac.Equal([]byte(signature), []byte(expected)) {
+			return nil
+		}
+	}
+	return ErrInvalidSignature
+}
@@ -0,0 +1,198 @@
+package webhooks
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/kodega2016/femapi/internal/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testSecret = "whsec_test"
+
+func TestSignAndVerify(t *testing.T) {
+	now := time.Unix(1715000000, 0)
+	body := []byte(`{"id":"1"}`)
+	header := Sign(testSecret, now, body)
+
+	assert.NoError(t, Verify(testSecret, header, body, 5*time.Minute, now.Add(time.Minute)))
+	assert.ErrorIs(t, Verify("whsec_other", header, body, 5*time.Minute, now), ErrInvalidSignature)
+	assert.ErrorIs(t, Verify(testSecret, header, []byte(`{"id":"2"}`), 5*time.Minute, now), ErrInvalidSignature)
+	assert.ErrorIs(t, Verify(testSecret, header, body, 5*time.Minute, now.Add(time.Hour)), ErrSignatureExpired)
+	assert.ErrorIs(t, Verify(testSecret, "v1=abc", body, 5*time.Minute, now), ErrInvalidSignature)
+}
+
+func TestBackoff(t *testing.T) {
+	assert.Equal(t, 30*time.Second, Backoff(1))
+	assert.Equal(t, time.Minute, Backoff(2))
+	assert.Equal(t, 4*time.Minute, Backoff(4))
+	assert.Equal(t, 6*time.Hour, Backoff(20))
+}
+
+// fakeStore hands out the given deliveries once and records the attempts
+type fakeStore struct {
+	store.WebhookStore
+	due []store.DueDelivery
+
+	mu      sync.Mutex
+	results map[int64]store.DeliveryResult
+}
+
+func (fs *fakeStore) QueueWebhookDeliveries(int) (int64, error) {
+	return 0, nil
+}
+
+func (fs *fakeStore) ClaimDueDeliveries(int, time.Duration) ([]store.DueDelivery, error) {
+	due := fs.due
+	fs.due = nil
+	return due, nil
+}
+
+func (fs *fakeStore) RecordDeliveryAttempt(delivery store.DueDelivery, result store.DeliveryResult) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.results[delivery.ID] = result
+	return nil
+}
+
+func TestDispatcherSignsAndRetries(t *testing.T) {
+	var received []Event
+	var mu sync.Mutex
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if Verify(testSecret, r.Header.Get(SignatureHeader), body, time.Minute, time.Now()) != nil {
+			http.Error(w, "bad signature", http.StatusUnauthorized)
+			return
+		}
+		// require would call t.FailNow off the test goroutine
+		var event Event
+		if !assert.NoError(t, json.Unmarshal(body, &event)) {
+			http.Error(w, "bad body", http.StatusBadRequest)
+			return
+		}
+		assert.Equal(t, event.ID, r.Header.Get(EventIDHeader))
+		assert.Equal(t, event.Type, r.Header.Get(EventTypeHeader))
+		mu.Lock()
+		received = append(received, event)
+		mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer receiver.Close()
+
+	delivery := store.DueDelivery{
+		URL:       receiver.URL,
+		Secret:    testSecret,
+		EventID:   "0190b6a4-0000-7000-8000-000000000001",
+		EventType: store.WorkoutCreated,
+		Payload:   json.RawMessage(`{"workout_id":"0190b6a4-0000-7000-8000-000000000002","version":1}`),
+		CreatedAt: time.Now(),
+	}
+	signed, wrongSecret, lastAttempt := delivery, delivery, delivery
+	signed.ID, signed.Attempt = 1, 1
+	wrongSecret.ID, wrongSecret.Attempt, wrongSecret.Secret = 2, 3, "whsec_rotated"
+	lastAttempt.ID, lastAttempt.Attempt, lastAttempt.Secret = 3, MaxAttempts, "whsec_rotated"
+
+	fs := &fakeStore{due: []store.DueDelivery{signed, wrongSecret, lastAttempt}, results: make(map[int64]store.DeliveryResult)}
+	dispatcher := NewDispatcher(fs, log.New(io.Discard, "", 0))
+	dispatcher.AllowLoopback = true
+	start := time.Now()
+	sent, err := dispatcher.RunOnce(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 3, sent)
+
+	require.Len(t, received, 1)
+	assert.Equal(t, delivery.EventID, received[0].ID)
+	assert.JSONEq(t, string(delivery.Payload), string(received[0].Data))
+
+	ok := fs.results[1]
+	assert.True(t, ok.Succeeded)
+	assert.Equal(t, http.StatusNoContent, ok.StatusCode)
+	assert.Nil(t, ok.RetryAt)
+
+	retried := fs.results[2]
+	assert.False(t, retried.Succeeded)
+	assert.Equal(t, http.StatusUnauthorized, retried.StatusCode)
+	assert.Equal(t, "endpoint responded 401 Unauthorized", retried.Error, "the response body is not kept")
+	require.NotNil(t, retried.RetryAt)
+	assert.WithinDuration(t, start.Add(Backoff(3)), *retried.RetryAt, 5*time.Second)
+
+	dead := fs.results[3]
+	assert.False(t, dead.Succeeded)
+	assert.Nil(t, dead.RetryAt, "the last attempt is not retried")
+}
+
+func TestDispatcherRetriesUnreachableEndpoints(t *testing.T) {
+	receiver := httptest.NewServer(http.NotFoundHandler())
+	url := receiver.URL
+	receiver.Close()
+
+	fs := &fakeStore{
+		due:     []store.DueDelivery{{ID: 1, Attempt: 1, URL: url, Secret: testSecret, Payload: json.RawMessage(`{}`)}},
+		results: make(map[int64]store.DeliveryResult),
+	}
+	dispatcher := NewDispatcher(fs, log.New(io.Discard, "", 0))
+	dispatcher.AllowLoopback = true
+	_, err := dispatcher.RunOnce(context.Background())
+	require.NoError(t, err)
+
+	result := fs.results[1]
+	assert.Zero(t, result.StatusCode)
+	assert.NotEmpty(t, result.Error)
+	assert.NotNil(t, result.RetryAt)
+}
+
+func TestDispatcherRefusesInternalAddresses(t *testing.T) {
+	var hits atomic.Int32
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+	}))
+	defer receiver.Close()
+
+	fs := &fakeStore{
+		due:     []store.DueDelivery{{ID: 1, Attempt: 1, URL: receiver.URL, Secret: testSecret, Payload: json.RawMessage(`{}`)}},
+		results: make(map[int64]store.DeliveryResult),
+	}
+	_, err := NewDispatcher(fs, log.New(io.Discard, "", 0)).RunOnce(context.Background())
+	require.NoError(t, err)
+
+	assert.Zero(t, hits.Load())
+	assert.Contains(t, fs.results[1].Error, ErrForbiddenAddress.Error())
+}
+
+func TestAllowedAddr(t *testing.T) {
+	tests := []struct {
+		addr          string
+		allowLoopback bool
+		want          bool
+	}{
+		{"93.184.216.34", false, true},
+		{"2606:2800:220:1:248:1893:25c8:1946", false, true},
+		{"127.0.0.1", false, false},
+		{"127.0.0.1", true, true},
+		{"::1", false, false},
+		{"::ffff:127.0.0.1", false, false},
+		{"10.0.0.8", true, false},
+		{"172.16.4.1", false, false},
+		{"192.168.1.10", false, false},
+		{"169.254.169.254", false, false},
+		{"fd00:ec2::254", false, false},
+		{"fe80::1", false, false},
+		{"100.100.100.200", false, false},
+		{"0.0.0.0", false, false},
+		{"::", false, false},
+		{"224.0.0.1", false, false},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, AllowedAddr(netip.MustParseAddr(tt.addr), tt.allowLoopback), tt.addr)
+	}
+}
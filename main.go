@@ -1,12 +1,17 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
+	_ "time/tzdata" // user timezones must resolve on hosts without a zoneinfo database
 
 	"github.com/joho/godotenv"
 	"github.com/kodega2016/femapi/internal/app"
@@ -16,7 +21,9 @@ import (
 
 func main() {
 	var port int
+	var backfillAchievements bool
 	flag.IntVar(&port, "port", 8080, "This is the default port on which the server will run")
+	flag.BoolVar(&backfillAchievements, "backfill-achievements", false, "Queue a job evaluating the achievements of every user from their workout history and exit")
 	flag.Parse()
 
 	app, err := app.NewApplication()
@@ -24,12 +31,46 @@ func main() {
 		panic(err)
 	}
 
+	if backfillAchievements {
+		job, inserted, err := app.BackfillAchievements()
+		app.DB.Close()
+		if err != nil {
+			app.Logger.Fatal(err)
+		}
+		if !inserted {
+			app.Logger.Printf("an achievements backfill is already %s as job %s\n", job.Status, job.PublicID)
+			return
+		}
+		app.Logger.Printf("queued the achievements backfill as job %s\n", job.PublicID)
+		return
+	}
+
 	app.Logger.Printf("we are running our application on port %d\n", port)
 	http.HandleFunc("/health", app.HealthCheck)
 
 	// close the database
 	defer app.DB.Close()
 
+	// SIGINT and SIGTERM stop the server and let the running background jobs finish
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// deliver live session events published by any instance to the sockets of this one
+	go app.RunLiveHub(ctx)
+	// push the workout changes of every instance to the event streams of this one
+	go app.RunEventNotifier(ctx)
+	// post the events users subscribed to to their webhooks, retrying failed deliveries
+	go app.RunWebhookDispatcher(ctx, 5*time.Second)
+	// purge expired tokens, trashed workouts, expired idempotency keys and orphaned rows on the
+	// instance elected to run scheduled tasks
+	go app.RunScheduler(ctx)
+	// run the background jobs enqueued by any instance
+	workerDone := make(chan struct{})
+	go func() {
+		app.RunJobWorker(ctx)
+		close(workerDone)
+	}()
+
 	r := routes.SetupRoutes(app)
 	server := &http.Server{
 		Handler:      r,
@@ -39,10 +80,25 @@ func main() {
 		WriteTimeout: 30 * time.Second,
 	}
 
+	serverDone := make(chan struct{})
+	go func() {
+		defer close(serverDone)
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		err := server.Shutdown(shutdownCtx)
+		if err != nil {
+			app.Logger.Printf("ERROR: shutting down: %v", err)
+		}
+	}()
+
 	err = server.ListenAndServe()
-	if err != nil {
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
 		app.Logger.Fatal(err)
 	}
+	// in flight requests and jobs finish before the database is closed
+	<-serverDone
+	<-workerDone
 }
 
 func init() {
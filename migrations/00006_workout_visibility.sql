@@ -0,0 +1,37 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE workouts
+ADD COLUMN visibility VARCHAR(20) NOT NULL DEFAULT 'private',
+ADD CONSTRAINT valid_workout_visibility CHECK (
+    visibility IN ('private', 'followers', 'public', 'unlisted')
+);
+
+-- a follow is a request until the followee approves it, only approved follows see
+-- followers-only workouts
+CREATE TABLE IF NOT EXISTS follows (
+    follower_id BIGINT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    followee_id BIGINT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    approved_at TIMESTAMP WITH TIME ZONE,
+    PRIMARY KEY (follower_id, followee_id),
+    CONSTRAINT no_self_follow CHECK (follower_id <> followee_id)
+);
+
+CREATE TABLE IF NOT EXISTS workout_share_links (
+    id BIGSERIAL PRIMARY KEY,
+    slug VARCHAR(64) NOT NULL UNIQUE,
+    workout_id BIGINT NOT NULL REFERENCES workouts (id) ON DELETE CASCADE,
+    created_by BIGINT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    revoked_at TIMESTAMP WITH TIME ZONE
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS workout_share_links;
+DROP TABLE IF EXISTS follows;
+ALTER TABLE workouts
+DROP CONSTRAINT IF EXISTS valid_workout_visibility,
+DROP COLUMN IF EXISTS visibility;
+-- +goose StatementEnd
@@ -0,0 +1,53 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE EXTENSION IF NOT EXISTS pgcrypto;
+
+-- uuid_generate_v7 builds a time ordered UUIDv7 from the given timestamp so
+-- backfilled rows keep the same ordering as their original ids
+CREATE OR REPLACE FUNCTION uuid_generate_v7(ts TIMESTAMP WITH TIME ZONE)
+RETURNS UUID AS $$
+    SELECT encode(
+        set_bit(
+            set_bit(
+                overlay(
+                    uuid_send(gen_random_uuid())
+                    PLACING substring(int8send(floor(extract(epoch FROM ts) * 1000)::BIGINT) FROM 3)
+                    FROM 1 FOR 6
+                ),
+                52, 1
+            ),
+            53, 1
+        ),
+        'hex'
+    )::UUID;
+$$ LANGUAGE SQL VOLATILE;
+
+ALTER TABLE users ADD COLUMN public_id UUID;
+UPDATE users SET public_id = uuid_generate_v7(COALESCE(created_at, CURRENT_TIMESTAMP));
+ALTER TABLE users
+ALTER COLUMN public_id SET NOT NULL,
+ALTER COLUMN public_id SET DEFAULT uuid_generate_v7(CURRENT_TIMESTAMP),
+ADD CONSTRAINT users_public_id_key UNIQUE (public_id);
+
+ALTER TABLE workouts ADD COLUMN public_id UUID;
+UPDATE workouts SET public_id = uuid_generate_v7(COALESCE(created_at, CURRENT_TIMESTAMP));
+ALTER TABLE workouts
+ALTER COLUMN public_id SET NOT NULL,
+ALTER COLUMN public_id SET DEFAULT uuid_generate_v7(CURRENT_TIMESTAMP),
+ADD CONSTRAINT workouts_public_id_key UNIQUE (public_id);
+
+ALTER TABLE workout_entries ADD COLUMN public_id UUID;
+UPDATE workout_entries SET public_id = uuid_generate_v7(COALESCE(created_at, CURRENT_TIMESTAMP));
+ALTER TABLE workout_entries
+ALTER COLUMN public_id SET NOT NULL,
+ALTER COLUMN public_id SET DEFAULT uuid_generate_v7(CURRENT_TIMESTAMP),
+ADD CONSTRAINT workout_entries_public_id_key UNIQUE (public_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE workout_entries DROP COLUMN IF EXISTS public_id;
+ALTER TABLE workouts DROP COLUMN IF EXISTS public_id;
+ALTER TABLE users DROP COLUMN IF EXISTS public_id;
+DROP FUNCTION IF EXISTS uuid_generate_v7(TIMESTAMP WITH TIME ZONE);
+-- +goose StatementEnd
@@ -0,0 +1,30 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS workout_revisions (
+    id BIGSERIAL PRIMARY KEY,
+    workout_id BIGINT NOT NULL REFERENCES workouts (id) ON DELETE CASCADE,
+    revision INT NOT NULL,
+    snapshot JSONB NOT NULL,
+    changed_by BIGINT REFERENCES users (id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (workout_id, revision)
+);
+
+-- revisions are an audit trail, so they can be added or cascaded away but never edited
+CREATE OR REPLACE FUNCTION prevent_workout_revision_update()
+RETURNS TRIGGER AS $$
+BEGIN
+    RAISE EXCEPTION 'workout revisions are immutable';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER workout_revisions_immutable
+BEFORE UPDATE ON workout_revisions
+FOR EACH ROW EXECUTE PROCEDURE prevent_workout_revision_update();
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS workout_revisions;
+DROP FUNCTION IF EXISTS prevent_workout_revision_update();
+-- +goose StatementEnd
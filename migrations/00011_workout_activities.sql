@@ -0,0 +1,35 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS workout_activities (
+    workout_id BIGINT PRIMARY KEY REFERENCES workouts(id) ON DELETE CASCADE,
+    sport VARCHAR(50) NOT NULL,
+    started_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    distance_meters DOUBLE PRECISION NOT NULL DEFAULT 0,
+    duration_seconds INTEGER NOT NULL DEFAULT 0,
+    pace_seconds_per_km DOUBLE PRECISION,
+    elevation_gain_meters DOUBLE PRECISION NOT NULL DEFAULT 0,
+    avg_heart_rate INTEGER,
+    max_heart_rate INTEGER,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- one row per recorded sample, indoor sessions only carry time and heart rate
+CREATE TABLE IF NOT EXISTS workout_track_points (
+    id BIGSERIAL PRIMARY KEY,
+    workout_id BIGINT NOT NULL REFERENCES workouts(id) ON DELETE CASCADE,
+    seq INTEGER NOT NULL,
+    recorded_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    latitude DOUBLE PRECISION,
+    longitude DOUBLE PRECISION,
+    elevation_meters DOUBLE PRECISION,
+    distance_meters DOUBLE PRECISION,
+    heart_rate INTEGER,
+    UNIQUE (workout_id, seq)
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS workout_track_points;
+DROP TABLE IF EXISTS workout_activities;
+-- +goose StatementEnd
@@ -0,0 +1,60 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE workouts
+ADD COLUMN type VARCHAR(20) NOT NULL DEFAULT 'strength'
+CHECK (type IN ('strength', 'run', 'ride', 'swim', 'row', 'hiit', 'yoga'));
+
+-- recordings imported before types existed are cardio
+UPDATE workouts w
+SET type = CASE a.sport
+    WHEN 'cycling' THEN 'ride'
+    WHEN 'swimming' THEN 'swim'
+    WHEN 'rowing' THEN 'row'
+    ELSE 'run'
+END
+FROM workout_activities a
+WHERE a.workout_id = w.id;
+
+CREATE INDEX IF NOT EXISTS idx_workouts_user_type ON workouts(user_id, type) WHERE deleted_at IS NULL;
+
+-- cardio details can now be entered by hand, without a recording
+ALTER TABLE workout_activities
+ALTER COLUMN started_at DROP NOT NULL,
+ADD COLUMN moving_time_seconds INTEGER NOT NULL DEFAULT 0,
+ADD COLUMN avg_speed_kph DOUBLE PRECISION;
+
+UPDATE workout_activities
+SET moving_time_seconds = duration_seconds,
+        avg_speed_kph = CASE WHEN duration_seconds > 0 THEN distance_meters / duration_seconds * 3.6 END;
+
+CREATE TABLE IF NOT EXISTS workout_splits (
+    workout_id BIGINT NOT NULL REFERENCES workouts(id) ON DELETE CASCADE,
+    split_number INTEGER NOT NULL,
+    distance_meters DOUBLE PRECISION NOT NULL,
+    duration_seconds INTEGER NOT NULL,
+    avg_heart_rate INTEGER,
+    PRIMARY KEY (workout_id, split_number)
+);
+
+-- reps or duration is only required for strength entries, which the handlers check knowing the
+-- workout type. An entry still cannot have both.
+ALTER TABLE workout_entries DROP CONSTRAINT IF EXISTS valid_workout_entry;
+ALTER TABLE workout_entries
+ADD CONSTRAINT valid_workout_entry CHECK (reps IS NULL OR duration_seconds IS NULL);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE workout_entries DROP CONSTRAINT IF EXISTS valid_workout_entry;
+ALTER TABLE workout_entries
+ADD CONSTRAINT valid_workout_entry CHECK (
+    (reps IS NOT NULL OR duration_seconds IS NOT NULL) AND
+    (reps IS NULL OR duration_seconds IS NULL)
+);
+DROP TABLE IF EXISTS workout_splits;
+ALTER TABLE workout_activities
+DROP COLUMN IF EXISTS avg_speed_kph,
+DROP COLUMN IF EXISTS moving_time_seconds;
+DROP INDEX IF EXISTS idx_workouts_user_type;
+ALTER TABLE workouts DROP COLUMN IF EXISTS type;
+-- +goose StatementEnd
@@ -0,0 +1,23 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS body_weights (
+    id BIGSERIAL PRIMARY KEY,
+    public_id UUID NOT NULL UNIQUE DEFAULT uuid_generate_v7(CURRENT_TIMESTAMP),
+    user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    weight_kg DECIMAL(5, 2) NOT NULL CHECK (weight_kg > 0),
+    measured_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_body_weights_user_measured ON body_weights(user_id, measured_at DESC);
+
+-- existing values were typed in by the users
+ALTER TABLE workouts
+ADD COLUMN calories_estimated BOOLEAN NOT NULL DEFAULT FALSE;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE workouts DROP COLUMN IF EXISTS calories_estimated;
+DROP TABLE IF EXISTS body_weights;
+-- +goose StatementEnd
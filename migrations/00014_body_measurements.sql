@@ -0,0 +1,49 @@
+-- +goose Up
+-- +goose StatementBegin
+-- values are stored in kg for weight, percent for body fat and cm for circumferences
+CREATE TABLE IF NOT EXISTS body_measurements (
+    id BIGSERIAL PRIMARY KEY,
+    public_id UUID NOT NULL UNIQUE DEFAULT uuid_generate_v7(CURRENT_TIMESTAMP),
+    user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    kind VARCHAR(20) NOT NULL,
+    value DECIMAL(6, 2) NOT NULL CHECK (value > 0),
+    note TEXT NOT NULL DEFAULT '',
+    measured_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT valid_measurement_kind CHECK (
+        kind IN ('weight', 'body_fat', 'neck', 'chest', 'waist', 'hips', 'biceps', 'forearm', 'thigh', 'calf')
+    ),
+    CONSTRAINT valid_body_fat CHECK (kind <> 'body_fat' OR value < 100)
+);
+
+CREATE INDEX IF NOT EXISTS idx_body_measurements_user_kind ON body_measurements(user_id, kind, measured_at DESC);
+
+-- weigh-ins logged so far become weight measurements and keep their public ids
+INSERT INTO body_measurements(public_id, user_id, kind, value, measured_at, created_at, updated_at)
+SELECT public_id, user_id, 'weight', weight_kg, measured_at, created_at, created_at
+FROM body_weights;
+
+DROP TABLE IF EXISTS body_weights;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS body_weights (
+    id BIGSERIAL PRIMARY KEY,
+    public_id UUID NOT NULL UNIQUE DEFAULT uuid_generate_v7(CURRENT_TIMESTAMP),
+    user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    weight_kg DECIMAL(5, 2) NOT NULL CHECK (weight_kg > 0),
+    measured_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_body_weights_user_measured ON body_weights(user_id, measured_at DESC);
+
+INSERT INTO body_weights(public_id, user_id, weight_kg, measured_at, created_at)
+SELECT public_id, user_id, value, measured_at, created_at
+FROM body_measurements
+WHERE kind = 'weight' AND value < 1000;
+
+DROP TABLE IF EXISTS body_measurements;
+-- +goose StatementEnd
@@ -0,0 +1,37 @@
+-- +goose Up
+-- +goose StatementBegin
+-- targets are stored in kg for lift goals, meters for distance goals and workouts per
+-- period for frequency goals. The progress columns are recomputed whenever a workout changes.
+CREATE TABLE IF NOT EXISTS goals (
+    id BIGSERIAL PRIMARY KEY,
+    public_id UUID NOT NULL UNIQUE DEFAULT uuid_generate_v7(CURRENT_TIMESTAMP),
+    user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    kind VARCHAR(20) NOT NULL,
+    title VARCHAR(255) NOT NULL,
+    exercise_name VARCHAR(255),
+    workout_type VARCHAR(20),
+    period VARCHAR(10),
+    target_value DECIMAL(10, 2) NOT NULL CHECK (target_value > 0),
+    starts_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    deadline TIMESTAMP WITH TIME ZONE,
+    current_value DECIMAL(10, 2) NOT NULL DEFAULT 0,
+    progress DECIMAL(5, 2) NOT NULL DEFAULT 0,
+    projected_at TIMESTAMP WITH TIME ZONE,
+    completed_at TIMESTAMP WITH TIME ZONE,
+    evaluated_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT valid_goal_kind CHECK (kind IN ('lift', 'distance', 'frequency')),
+    CONSTRAINT valid_lift_goal CHECK (kind <> 'lift' OR exercise_name IS NOT NULL),
+    CONSTRAINT valid_distance_goal CHECK (kind <> 'distance' OR workout_type IS NOT NULL),
+    CONSTRAINT valid_frequency_goal CHECK (kind <> 'frequency' OR period IN ('week', 'month')),
+    CONSTRAINT valid_goal_deadline CHECK (deadline IS NULL OR deadline > starts_at)
+);
+
+CREATE INDEX IF NOT EXISTS idx_goals_user ON goals(user_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS goals;
+-- +goose StatementEnd
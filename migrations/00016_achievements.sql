@@ -0,0 +1,21 @@
+-- +goose Up
+-- +goose StatementBegin
+-- streaks are counted in calendar days of the user, so the user needs a timezone
+ALTER TABLE users
+ADD COLUMN timezone VARCHAR(64) NOT NULL DEFAULT 'UTC';
+
+-- achievement_id refers to a rule of the embedded achievements config, awards are never revoked
+CREATE TABLE IF NOT EXISTS user_achievements (
+    user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    achievement_id VARCHAR(64) NOT NULL,
+    awarded_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, achievement_id)
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS user_achievements;
+ALTER TABLE users DROP COLUMN IF EXISTS timezone;
+-- +goose StatementEnd
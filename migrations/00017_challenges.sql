@@ -0,0 +1,53 @@
+-- +goose Up
+-- +goose StatementBegin
+-- metric is what participants are ranked by: kg of volume, meters of distance, minutes of
+-- duration or the number of workouts performed between starts_at and ends_at
+CREATE TABLE IF NOT EXISTS challenges (
+    id BIGSERIAL PRIMARY KEY,
+    public_id UUID NOT NULL UNIQUE DEFAULT uuid_generate_v7(CURRENT_TIMESTAMP),
+    created_by BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    title VARCHAR(255) NOT NULL,
+    description TEXT NOT NULL DEFAULT '',
+    metric VARCHAR(20) NOT NULL,
+    workout_type VARCHAR(20),
+    target_value DECIMAL(12, 2),
+    bracket VARCHAR(20) NOT NULL DEFAULT 'none',
+    starts_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    ends_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT valid_challenge_metric CHECK (metric IN ('volume', 'distance', 'duration', 'workouts')),
+    CONSTRAINT valid_challenge_bracket CHECK (bracket IN ('none', 'weight_class', 'age_group')),
+    CONSTRAINT valid_challenge_window CHECK (ends_at > starts_at),
+    CONSTRAINT valid_challenge_target CHECK (target_value IS NULL OR target_value > 0)
+);
+
+CREATE INDEX IF NOT EXISTS idx_challenges_window ON challenges(ends_at, starts_at);
+
+-- the standing columns are the maintained leaderboard, they are recomputed when a participant's
+-- workouts change instead of on every read
+CREATE TABLE IF NOT EXISTS challenge_participants (
+    challenge_id BIGINT NOT NULL REFERENCES challenges(id) ON DELETE CASCADE,
+    user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    team VARCHAR(50) NOT NULL DEFAULT '',
+    visibility VARCHAR(20) NOT NULL DEFAULT 'public',
+    bracket VARCHAR(20) NOT NULL DEFAULT '',
+    joined_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    score DECIMAL(14, 2) NOT NULL DEFAULT 0,
+    last_scored_at TIMESTAMP WITH TIME ZONE,
+    reached_target_at TIMESTAMP WITH TIME ZONE,
+    rank INTEGER,
+    bracket_rank INTEGER,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (challenge_id, user_id),
+    CONSTRAINT valid_participant_visibility CHECK (visibility IN ('public', 'anonymous', 'hidden'))
+);
+
+CREATE INDEX IF NOT EXISTS idx_challenge_participants_user ON challenge_participants(user_id);
+CREATE INDEX IF NOT EXISTS idx_challenge_participants_rank ON challenge_participants(challenge_id, rank);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS challenge_participants;
+DROP TABLE IF EXISTS challenges;
+-- +goose StatementEnd
@@ -0,0 +1,40 @@
+-- +goose Up
+-- +goose StatementBegin
+-- until now the time a workout was done was the start of its recorded activity, or when it
+-- was logged
+ALTER TABLE workouts ADD COLUMN IF NOT EXISTS performed_at TIMESTAMP WITH TIME ZONE;
+
+UPDATE workouts w
+SET performed_at=COALESCE((SELECT a.started_at FROM workout_activities a WHERE a.workout_id=w.id), w.created_at, CURRENT_TIMESTAMP)
+WHERE performed_at IS NULL;
+
+ALTER TABLE workouts ALTER COLUMN performed_at SET DEFAULT CURRENT_TIMESTAMP;
+ALTER TABLE workouts ALTER COLUMN performed_at SET NOT NULL;
+
+CREATE INDEX IF NOT EXISTS idx_workouts_user_performed_at ON workouts(user_id, performed_at) WHERE deleted_at IS NULL;
+
+-- planned sessions show up as tentative events in the calendar
+CREATE TABLE IF NOT EXISTS planned_workouts (
+    id BIGSERIAL PRIMARY KEY,
+    public_id UUID NOT NULL UNIQUE DEFAULT uuid_generate_v7(CURRENT_TIMESTAMP),
+    user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    title VARCHAR(100) NOT NULL,
+    type VARCHAR(20) NOT NULL DEFAULT 'strength',
+    notes TEXT NOT NULL DEFAULT '',
+    scheduled_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    duration INTEGER NOT NULL DEFAULT 60,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT valid_planned_workout_type CHECK (type IN ('strength', 'run', 'ride', 'swim', 'row', 'hiit', 'yoga')),
+    CONSTRAINT valid_planned_workout_duration CHECK (duration > 0)
+);
+
+CREATE INDEX IF NOT EXISTS idx_planned_workouts_user_scheduled_at ON planned_workouts(user_id, scheduled_at);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS planned_workouts;
+DROP INDEX IF EXISTS idx_workouts_user_performed_at;
+ALTER TABLE workouts DROP COLUMN IF EXISTS performed_at;
+-- +goose StatementEnd
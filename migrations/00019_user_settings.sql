@@ -0,0 +1,19 @@
+-- +goose Up
+-- +goose StatementBegin
+-- the timezone stays on users, the other preferences live here. Users without a row use the
+-- column defaults.
+CREATE TABLE IF NOT EXISTS user_settings (
+    user_id BIGINT PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    locale VARCHAR(35) NOT NULL DEFAULT 'en-US',
+    units VARCHAR(16) NOT NULL DEFAULT 'metric' CHECK (units IN ('metric', 'imperial')),
+    week_start VARCHAR(16) NOT NULL DEFAULT 'monday' CHECK (week_start IN ('monday', 'sunday', 'saturday')),
+    default_workout_visibility VARCHAR(16) NOT NULL DEFAULT 'private',
+    default_challenge_visibility VARCHAR(16) NOT NULL DEFAULT 'public',
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS user_settings;
+-- +goose StatementEnd
@@ -0,0 +1,49 @@
+-- +goose Up
+-- +goose StatementBegin
+-- a live session is a workout in progress, finishing it creates the workout from its events
+CREATE TABLE IF NOT EXISTS live_sessions (
+    id BIGSERIAL PRIMARY KEY,
+    public_id UUID NOT NULL UNIQUE DEFAULT uuid_generate_v7(CURRENT_TIMESTAMP),
+    user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    title VARCHAR(100) NOT NULL,
+    type VARCHAR(20) NOT NULL DEFAULT 'strength',
+    status VARCHAR(20) NOT NULL DEFAULT 'active',
+    started_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    ended_at TIMESTAMP WITH TIME ZONE,
+    workout_id BIGINT REFERENCES workouts(id) ON DELETE SET NULL,
+    event_seq BIGINT NOT NULL DEFAULT 0,
+    CONSTRAINT valid_live_session_status CHECK (status IN ('active', 'finished', 'abandoned'))
+);
+
+-- a user runs one session at a time, every device of theirs joins the same one
+CREATE UNIQUE INDEX IF NOT EXISTS idx_live_sessions_one_active ON live_sessions(user_id) WHERE status = 'active';
+
+-- events are numbered per session. The counter is bumped on the session row when an event is
+-- appended, so the events of a session commit in seq order without gaps and a subscriber that
+-- receives a seq past the next one knows to read the missing ones from the table.
+CREATE TABLE IF NOT EXISTS live_session_events (
+    id BIGSERIAL PRIMARY KEY,
+    session_id BIGINT NOT NULL REFERENCES live_sessions(id) ON DELETE CASCADE,
+    seq BIGINT NOT NULL,
+    type VARCHAR(32) NOT NULL,
+    data JSONB NOT NULL DEFAULT '{}',
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_live_session_events_session_seq ON live_session_events(session_id, seq);
+
+-- viewers, e.g. a coach, can follow a session of someone else
+CREATE TABLE IF NOT EXISTS live_session_viewers (
+    session_id BIGINT NOT NULL REFERENCES live_sessions(id) ON DELETE CASCADE,
+    user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (session_id, user_id)
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS live_session_viewers;
+DROP TABLE IF EXISTS live_session_events;
+DROP TABLE IF EXISTS live_sessions;
+-- +goose StatementEnd
@@ -0,0 +1,27 @@
+-- +goose Up
+-- +goose StatementBegin
+-- the log of workout changes clients sync from, rows are written in the transaction of the
+-- change so a rolled back change never shows up. The seq is the SSE event id.
+CREATE TABLE IF NOT EXISTS user_events (
+    id BIGSERIAL PRIMARY KEY,
+    user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    seq BIGINT NOT NULL,
+    type VARCHAR(32) NOT NULL,
+    workout_id UUID NOT NULL,
+    version INTEGER NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_user_events_user_seq ON user_events(user_id, seq);
+
+-- events are numbered per user. The counter is bumped on the user row in the transaction of
+-- the change, which holds the row lock until the change commits, so the events of a user
+-- commit in seq order and a reader resuming after a seq never skips one committed late.
+ALTER TABLE users ADD COLUMN event_seq BIGINT NOT NULL DEFAULT 0;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE users DROP COLUMN IF EXISTS event_seq;
+DROP TABLE IF EXISTS user_events;
+-- +goose StatementEnd
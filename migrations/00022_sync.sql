@@ -0,0 +1,40 @@
+-- +goose Up
+-- +goose StatementBegin
+-- field_clocks holds, per field, the client time of the last synced write that won. Fields
+-- without a clock were last written by a plain update, at updated_at.
+ALTER TABLE workouts ADD COLUMN field_clocks JSONB NOT NULL DEFAULT '{}';
+ALTER TABLE workout_entries ADD COLUMN field_clocks JSONB NOT NULL DEFAULT '{}';
+
+-- tombstones keep deleted ids around so an offline device cannot bring them back. Trashed
+-- workouts are their own tombstone until they are purged.
+CREATE TABLE IF NOT EXISTS workout_tombstones (
+    public_id UUID PRIMARY KEY,
+    user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    deleted_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS workout_entry_tombstones (
+    public_id UUID PRIMARY KEY,
+    workout_id BIGINT NOT NULL REFERENCES workouts(id) ON DELETE CASCADE,
+    deleted_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_workout_entry_tombstones_workout ON workout_entry_tombstones(workout_id);
+
+-- the client mutations already applied, a retried sync skips them
+CREATE TABLE IF NOT EXISTS sync_mutations (
+    user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    mutation_id UUID NOT NULL,
+    applied_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, mutation_id)
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS sync_mutations;
+DROP TABLE IF EXISTS workout_entry_tombstones;
+DROP TABLE IF EXISTS workout_tombstones;
+ALTER TABLE workout_entries DROP COLUMN IF EXISTS field_clocks;
+ALTER TABLE workouts DROP COLUMN IF EXISTS field_clocks;
+-- +goose StatementEnd
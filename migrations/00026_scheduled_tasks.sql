@@ -0,0 +1,25 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE INDEX IF NOT EXISTS idx_tokens_expiry ON tokens(expiry);
+-- outbox events are looked up by delivery when orphaned ones are cleaned up
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_outbox ON webhook_deliveries(outbox_id);
+
+-- the last run of every scheduled task, written by whichever instance leads the scheduler so
+-- every instance can report it. next_run_at lets a new leader catch up on a missed run.
+CREATE TABLE IF NOT EXISTS scheduled_task_runs (
+    name VARCHAR(64) PRIMARY KEY,
+    schedule VARCHAR(64) NOT NULL,
+    started_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    finished_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    error TEXT,
+    next_run_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    ran_on VARCHAR(255) NOT NULL
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS scheduled_task_runs;
+DROP INDEX IF EXISTS idx_webhook_deliveries_outbox;
+DROP INDEX IF EXISTS idx_tokens_expiry;
+-- +goose StatementEnd